@@ -0,0 +1,329 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// stackLimit bounds how far asl stack walks when no trunk branch is found
+const stackLimit = 20
+
+// trunkBranches are the branch names asl stack treats as the base of a stack
+var trunkBranches = []string{"main", "master", "trunk"}
+
+func newBranchCmd() *cobra.Command {
+	var verbose, del, forceDelete, move bool
+
+	cmd := &cobra.Command{
+		Use:   "branch [name] | --delete <name> | --move [old] <new>",
+		Short: "Create, list, delete or rename branches",
+		Long: `Create, list, delete or rename branches.
+
+Branch names may contain slashes (feature/login) but no "..", spaces,
+control characters or any of ~^:?*[\, and no component may start with a
+dot or end in .lock.
+
+--delete refuses to delete a branch that is not merged into HEAD or its
+upstream; -D deletes it anyway. --move renames a branch, or the current
+branch when only the new name is given.`,
+		Args: argsValidator(cobra.MaximumNArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if forceDelete {
+				del = true
+			}
+			if del && move {
+				return usageError{errors.New("--delete and --move cannot be used together")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case del:
+				if len(args) == 0 {
+					return usageError{errors.New("--delete requires a branch name")}
+				}
+				for _, name := range args {
+					tip, err := repo.DeleteBranch(name, forceDelete)
+					if errors.Is(err, core.ErrBranchNotMerged) {
+						return fmt.Errorf("%w: %s (use -D to delete it anyway)", core.ErrBranchNotMerged, name)
+					}
+					if err != nil {
+						return err
+					}
+					printSuccess("Deleted branch %s (was %s)", refColor(name), hashColor(tip.Short()))
+				}
+				return nil
+
+			case move:
+				if len(args) == 0 {
+					return usageError{errors.New("--move requires a new branch name")}
+				}
+				oldName, newName := "", args[len(args)-1]
+				if len(args) == 2 {
+					oldName = args[0]
+				} else if oldName, err = repo.GetCurrentBranch(); err != nil {
+					return err
+				}
+				if err := repo.RenameBranch(oldName, newName); err != nil {
+					return err
+				}
+				printSuccess("Renamed branch %s to %s", refColor(oldName), refColor(newName))
+				return nil
+
+			case len(args) == 2:
+				return usageError{errors.New("branch takes one name unless --delete or --move is given")}
+
+			case len(args) == 1:
+				if err := repo.CreateBranch(args[0]); err != nil {
+					return err
+				}
+				printSuccess("Created branch %s", refColor(args[0]))
+				return nil
+			}
+
+			return listBranches(repo, verbose)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "show the tip, subject and ahead/behind counts of each branch")
+	cmd.Flags().BoolVarP(&del, "delete", "d", false, "delete fully merged branches")
+	cmd.Flags().BoolVarP(&forceDelete, "force-delete", "D", false, "delete branches even if they are not merged")
+	cmd.Flags().BoolVarP(&move, "move", "m", false, "rename a branch")
+	return cmd
+}
+
+// listBranches prints every branch, marking the current one
+func listBranches(repo *repository.Repository, verbose bool) error {
+	infos, err := repo.BranchDetails()
+	if err != nil {
+		return err
+	}
+
+	current, err := repo.GetCurrentBranch()
+	if errors.Is(err, core.ErrDetachedHead) {
+		if head, err := repo.GetCurrentCommit(); err == nil {
+			fmt.Printf("* %s\n", headColor(fmt.Sprintf("(HEAD detached at %s)", head.Short())))
+		}
+	}
+
+	width := 0
+	for _, info := range infos {
+		width = max(width, len(info.Name))
+	}
+	for _, info := range infos {
+		mark, name := " ", info.Name
+		if info.Name == current {
+			mark, name = "*", headColor(info.Name)
+		}
+		if !verbose {
+			fmt.Printf("%s %s\n", mark, name)
+			continue
+		}
+
+		padding := strings.Repeat(" ", width-len(info.Name))
+		fmt.Printf("%s %s%s %s %s%s\n", mark, name, padding, hashColor(info.Tip.Short()), trackingSummary(info), info.Subject)
+	}
+	return nil
+}
+
+// trackingSummary describes how a branch compares with its base, e.g.
+// "[origin/main: ahead 1, behind 2] "
+func trackingSummary(info repository.BranchInfo) string {
+	if info.Base == "" {
+		return ""
+	}
+	var counts []string
+	if info.Ahead > 0 {
+		counts = append(counts, fmt.Sprintf("ahead %d", info.Ahead))
+	}
+	if info.Behind > 0 {
+		counts = append(counts, fmt.Sprintf("behind %d", info.Behind))
+	}
+	if len(counts) == 0 {
+		return fmt.Sprintf("[%s] ", refColor(info.Base))
+	}
+	return fmt.Sprintf("[%s: %s] ", refColor(info.Base), strings.Join(counts, ", "))
+}
+
+func newSwitchCmd() *cobra.Command {
+	var opts repository.SwitchOptions
+	var detach bool
+
+	cmd := &cobra.Command{
+		Use:   "switch <branch> | --detach <rev>",
+		Short: "Switch to a branch or commit",
+		Long: `Switch to a branch and check out its files.
+
+Files that differ between the current and target commits are updated and
+files that only exist in the current commit are deleted; everything else,
+including uncommitted changes to other files, is left alone. If an
+uncommitted change would be overwritten, nothing is changed unless
+--force (discard those changes) or --carry (merge them into the target
+version) is given.
+
+With --detach, HEAD points directly at the given commit instead of a
+branch. Commits saved there are only reachable from HEAD until a branch
+is created with asl branch; switching away from them prints a warning.`,
+		Args: argsValidator(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Force && opts.Carry {
+				return usageError{fmt.Errorf("--force and --carry cannot be used together")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			// Remember a detached HEAD so commits left behind can be reported
+			var previous core.Hash
+			if _, err := repo.GetCurrentBranch(); errors.Is(err, core.ErrDetachedHead) {
+				previous, _ = repo.GetCurrentCommit()
+			}
+
+			if detach {
+				target, err := resolveCommit(repo, args[0])
+				if err != nil {
+					return err
+				}
+				if err := repo.Detach(target, opts); err != nil {
+					return fmt.Errorf("cannot switch to %s: %w", args[0], err)
+				}
+				warnUnreachable(repo, previous)
+				printSuccess("HEAD is now detached at %s", hashColor(target.Short()))
+				return nil
+			}
+
+			if err := repo.Switch(args[0], opts); err != nil {
+				return fmt.Errorf("cannot switch to %s: %w", args[0], err)
+			}
+
+			warnUnreachable(repo, previous)
+			printSuccess("Switched to branch %s", refColor(args[0]))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&detach, "detach", false, "check out a commit without switching to a branch")
+	cmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "discard uncommitted changes that would be overwritten")
+	cmd.Flags().BoolVar(&opts.Carry, "carry", false, "merge uncommitted changes into the target branch's files")
+	return cmd
+}
+
+// warnUnreachable warns about commits that were only reachable from a
+// detached HEAD the user has just switched away from
+func warnUnreachable(repo *repository.Repository, previous core.Hash) {
+	if previous.IsZero() {
+		return
+	}
+	if head, err := repo.GetCurrentCommit(); err == nil && head == previous {
+		return
+	}
+
+	lost, err := repo.Unreachable(previous)
+	if err != nil || len(lost) == 0 {
+		return
+	}
+
+	printWarning("leaving %d commit(s) behind, not reachable from any branch:", len(lost))
+	for _, hash := range lost {
+		subject := ""
+		if commit, err := repo.Store().GetCommit(hash); err == nil {
+			subject = firstLine(commit.Message)
+		}
+		fmt.Fprintf(os.Stderr, "  %s %s\n", hashColor(hash.Short()), subject)
+	}
+	fmt.Fprintf(os.Stderr, "Keep them with: asl switch --detach %s && asl branch <name>\n", previous)
+}
+
+func newStackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stack",
+		Short: "Visualize the commit stack of the current branch",
+		Args:  argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			head, err := repo.GetCurrentCommit()
+			if err != nil {
+				if err == core.ErrBranchNotFound {
+					return core.ErrNoCommits
+				}
+				return err
+			}
+
+			labels, err := refLabels(repo)
+			if err != nil {
+				return err
+			}
+
+			base := stackBase(repo, head)
+
+			commits, hashes, err := repo.GetCommitHistory(head, 0)
+			if err != nil {
+				return err
+			}
+
+			for i, commit := range commits {
+				hash := hashes[i]
+				marker := "◉"
+				if i == 0 {
+					marker = headColor("◉")
+				}
+
+				line := fmt.Sprintf("%s %s %s", marker, hashColor(hash.Short()), firstLine(commit.Message))
+				if names, ok := labels[hash]; ok {
+					line += " (" + strings.Join(names, ", ") + ")"
+				}
+				fmt.Println(line)
+
+				// Stop at the trunk the stack is built on, or after a
+				// reasonable number of commits when there is none
+				if hash == base || (base.IsZero() && i+1 >= stackLimit) {
+					break
+				}
+				if i+1 < len(commits) {
+					fmt.Println("│")
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// stackBase returns the commit where the current stack leaves its trunk
+// branch, or a zero hash when HEAD is the trunk or no trunk branch exists
+func stackBase(repo *repository.Repository, head core.Hash) core.Hash {
+	current, _ := repo.GetCurrentBranch()
+
+	for _, name := range trunkBranches {
+		if name == current {
+			continue
+		}
+		hash, err := repo.GetRef("refs/heads/" + name)
+		if err != nil || hash.IsZero() || hash == head {
+			continue
+		}
+		base, err := merge.FindLCA(repo.Store(), head, hash)
+		if err != nil {
+			continue
+		}
+		return base
+	}
+
+	return core.Hash{}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/repository"
+)
+
+func newInitCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init [directory]",
+		Short: "Initialize a new repository",
+		Args:  argsValidator(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", dir, err)
+			}
+
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return err
+			}
+
+			if _, err := repository.Init(abs); err != nil {
+				return err
+			}
+
+			printSuccess("Initialized empty Astral repository in %s", filepath.Join(abs, ".asl"))
+			return nil
+		},
+	}
+}
+
+func newSaveCmd() *cobra.Command {
+	var message string
+
+	cmd := &cobra.Command{
+		Use:   "save [files...]",
+		Short: "Commit changes (no staging area)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if message == "" {
+				return usageError{errors.New("a commit message is required (-m)")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			hash, err := repo.Save(toRepoPaths(repo, args), message)
+			if err != nil {
+				return err
+			}
+
+			branch, err := repo.GetCurrentBranch()
+			if err != nil {
+				branch = "detached HEAD"
+			}
+			printSuccess("[%s %s] %s", refColor(branch), hashColor(hash.Short()), firstLine(message))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&message, "message", "m", "", "commit message")
+	return cmd
+}
+
+func newUndoCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "undo",
+		Short: "Revert the last commit (keeps working changes)",
+		Args:  argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			if err := repo.Undo(); err != nil {
+				return err
+			}
+
+			current, err := repo.GetCurrentCommit()
+			if err != nil || current.IsZero() {
+				printSuccess("Undid the only commit; the branch is now empty")
+				return nil
+			}
+
+			printSuccess("Undid last commit, now at %s", hashColor(current.Short()))
+			return nil
+		},
+	}
+}
+
+func newAmendCmd() *cobra.Command {
+	var message string
+
+	cmd := &cobra.Command{
+		Use:   "amend [files...]",
+		Short: "Modify the last commit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			hash, err := repo.Amend(toRepoPaths(repo, args), message)
+			if err != nil {
+				return err
+			}
+
+			printSuccess("Amended commit, now %s", hashColor(hash.Short()))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&message, "message", "m", "", "new commit message (defaults to the old one)")
+	return cmd
+}
+
+func newRestoreCmd() *cobra.Command {
+	var source string
+
+	cmd := &cobra.Command{
+		Use:   "restore <paths...>",
+		Short: "Restore files from a commit without moving HEAD",
+		Long: `Restore files or directories in the working directory from a commit,
+discarding uncommitted changes to them. Tracked files inside a restored
+directory that do not exist in the commit are deleted. HEAD and the
+current branch are not changed.`,
+		Args: argsValidator(cobra.MinimumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			hash, err := resolveCommit(repo, source)
+			if err != nil {
+				return err
+			}
+
+			if err := repo.Restore(toRepoPaths(repo, args), hash); err != nil {
+				return err
+			}
+
+			printSuccess("Restored %d path(s) from %s", len(args), hashColor(hash.Short()))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&source, "source", "s", "HEAD", "commit to restore from")
+	return cmd
+}
+
+// toRepoPaths converts command-line paths, relative to the working
+// directory, into paths relative to the repository root
+func toRepoPaths(repo *repository.Repository, args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			paths = append(paths, arg)
+			continue
+		}
+		rel, err := filepath.Rel(repo.Root, abs)
+		if err != nil {
+			paths = append(paths, arg)
+			continue
+		}
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	return paths
+}
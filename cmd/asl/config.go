@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/config"
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// configFlags selects the file a config command reads or writes
+type configFlags struct {
+	system, global, local bool
+}
+
+// scope returns the selected scope, or the repository's and false if
+// none was selected
+func (f configFlags) scope() (config.Scope, bool, error) {
+	switch n := btoi(f.system) + btoi(f.global) + btoi(f.local); {
+	case n > 1:
+		return 0, false, usageError{errors.New("only one of --system, --global and --local can be used")}
+	case f.system:
+		return config.ScopeSystem, true, nil
+	case f.global:
+		return config.ScopeGlobal, true, nil
+	case f.local:
+		return config.ScopeRepo, true, nil
+	}
+	return config.ScopeRepo, false, nil
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func newConfigCmd() *cobra.Command {
+	var flags configFlags
+
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Get and set configuration",
+		Long: `Get and set configuration.
+
+Settings are read from, in increasing order of precedence:
+
+  system   $ASL_CONFIG_SYSTEM, or /etc/aslconfig
+  global   $ASL_CONFIG_GLOBAL, or ~/.aslconfig
+  repo     .asl/config/config in the current repository
+  env      ASL_AUTHOR_NAME and ASL_AUTHOR_EMAIL override user.name and
+           user.email
+
+Keys are written section.name, or section.subsection.name for sections such
+as [remote "origin"]. Changes go to the repository's file unless --global or
+--system is given.`,
+		Args: argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+	cmd.PersistentFlags().BoolVar(&flags.system, "system", false, "use the system-wide config file")
+	cmd.PersistentFlags().BoolVar(&flags.global, "global", false, "use the user's config file")
+	cmd.PersistentFlags().BoolVar(&flags.local, "local", false, "use the repository's config file")
+
+	var all bool
+	getCmd := &cobra.Command{
+		Use:   "get <key>",
+		Short: "Print the value of a setting",
+		Args:  argsValidator(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			values, err := configValues(flags, args[0])
+			if err != nil {
+				return err
+			}
+			if len(values) == 0 {
+				return fmt.Errorf("%w: %s", core.ErrConfigKeyNotFound, args[0])
+			}
+			if !all {
+				values = values[len(values)-1:]
+			}
+			for _, value := range values {
+				fmt.Println(value)
+			}
+			return nil
+		},
+	}
+	getCmd.Flags().BoolVar(&all, "all", false, "print every value of a multi-valued setting")
+
+	var showScope bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List every setting",
+		Args:  argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := configEntries(flags)
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				if showScope {
+					fmt.Printf("%s\t", e.Scope)
+				}
+				fmt.Printf("%s=%s\n", e.Key, e.Value)
+			}
+			return nil
+		},
+	}
+	listCmd.Flags().BoolVar(&showScope, "show-scope", false, "show where each setting comes from")
+
+	cmd.AddCommand(
+		getCmd,
+		&cobra.Command{
+			Use:   "set <key> <value>",
+			Short: "Set a setting, replacing any existing values",
+			Args:  argsValidator(cobra.ExactArgs(2)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				f, err := configFile(flags)
+				if err != nil {
+					return err
+				}
+				if err := f.Set(args[0], args[1]); err != nil {
+					return err
+				}
+				return f.Save()
+			},
+		},
+		&cobra.Command{
+			Use:   "unset <key>",
+			Short: "Remove every value of a setting",
+			Args:  argsValidator(cobra.ExactArgs(1)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				f, err := configFile(flags)
+				if err != nil {
+					return err
+				}
+				removed, err := f.Unset(args[0])
+				if err != nil {
+					return err
+				}
+				if !removed {
+					return fmt.Errorf("%w: %s", core.ErrConfigKeyNotFound, args[0])
+				}
+				return f.Save()
+			},
+		},
+		listCmd,
+	)
+	return cmd
+}
+
+// loadConfig loads the configuration of the current repository, or only
+// the system and global files outside a repository
+func loadConfig() (*config.Config, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	root, err := repository.FindRoot(cwd)
+	if err != nil && !errors.Is(err, core.ErrNotARepository) {
+		return nil, err
+	}
+	return config.Load(root)
+}
+
+// configFile reads the file that config set and unset change: the
+// selected one, or the repository's by default
+func configFile(flags configFlags) (*config.File, error) {
+	scope, _, err := flags.scope()
+	if err != nil {
+		return nil, err
+	}
+
+	var path string
+	switch scope {
+	case config.ScopeSystem:
+		path = config.SystemPath()
+	case config.ScopeGlobal:
+		if path = config.GlobalPath(); path == "" {
+			return nil, errors.New("no global config file: the home directory is unknown")
+		}
+	default:
+		repo, err := openRepo()
+		if err != nil {
+			return nil, err
+		}
+		path = config.RepoPath(repo.Root)
+	}
+	return config.ReadFile(path)
+}
+
+// configValues returns every value of key in the selected file, or in all
+// of them, lowest precedence first
+func configValues(flags configFlags, key string) ([]string, error) {
+	if _, err := config.CanonicalKey(key); err != nil {
+		return nil, err
+	}
+	_, ok, err := flags.scope()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		f, err := configFile(flags)
+		if err != nil {
+			return nil, err
+		}
+		return f.GetAll(key)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.GetAll(key), nil
+}
+
+// configEntries returns the settings of the selected file, or of all of
+// them
+func configEntries(flags configFlags) ([]config.Entry, error) {
+	scope, ok, err := flags.scope()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		cfg, err := loadConfig()
+		if err != nil {
+			return nil, err
+		}
+		return cfg.Entries(), nil
+	}
+
+	f, err := configFile(flags)
+	if err != nil {
+		return nil, err
+	}
+	entries := f.Entries()
+	for i := range entries {
+		entries[i].Scope = scope
+	}
+	return entries, nil
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/diff"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+var (
+	addedColor   = color.New(color.FgGreen).SprintFunc()
+	deletedColor = color.New(color.FgRed).SprintFunc()
+	hunkColor    = color.New(color.FgCyan).SprintFunc()
+)
+
+func newLogCmd() *cobra.Command {
+	var limit int
+	var oneline bool
+
+	cmd := &cobra.Command{
+		Use:   "log [revision | A..B | A...B]",
+		Short: "Show commit history",
+		Long: `Show commit history.
+
+With a single revision, follows first parents back from it (default HEAD).
+A..B shows every commit reachable from B but not from A, and A...B every
+commit reachable from either side but not both.`,
+		Args: argsValidator(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			var commits []*core.Commit
+			var hashes []core.Hash
+			if arg := argOrEmpty(args, 0); strings.Contains(arg, "..") {
+				commits, hashes, err = rangeHistory(repo, arg, limit)
+			} else {
+				var start core.Hash
+				start, err = resolveCommit(repo, arg)
+				if err == core.ErrBranchNotFound {
+					return core.ErrNoCommits
+				}
+				if err == nil {
+					commits, hashes, err = repo.GetCommitHistory(start, limit)
+				}
+			}
+			if err != nil {
+				return err
+			}
+
+			labels, err := refLabels(repo)
+			if err != nil {
+				return err
+			}
+
+			for i, commit := range commits {
+				decoration := ""
+				if names, ok := labels[hashes[i]]; ok {
+					decoration = " (" + strings.Join(names, ", ") + ")"
+				}
+
+				if oneline {
+					fmt.Printf("%s%s %s\n", hashColor(hashes[i].Short()), decoration, firstLine(commit.Message))
+					continue
+				}
+
+				if i > 0 {
+					fmt.Println()
+				}
+				printCommitHeader(hashes[i], commit, decoration)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVarP(&limit, "number", "n", 0, "limit the number of commits shown")
+	cmd.Flags().BoolVar(&oneline, "oneline", false, "show each commit on a single line")
+	return cmd
+}
+
+func newReflogCmd() *cobra.Command {
+	var limit int
+
+	cmd := &cobra.Command{
+		Use:   "reflog [ref]",
+		Short: "Show where a ref has pointed",
+		Long: `Show the recorded updates of a ref (default HEAD), newest first.
+
+Each line shows the commit the ref moved to and the operation that moved
+it. The commit shown as <ref>@{n} can be used wherever a revision is
+expected, e.g. to recover a commit lost by undo or amend.`,
+		Args: argsValidator(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			name := argOrEmpty(args, 0)
+			if name == "" {
+				name = "HEAD"
+			}
+
+			entries, err := repo.Reflog(name)
+			if err != nil {
+				return err
+			}
+			for i, entry := range entries {
+				if limit > 0 && i >= limit {
+					break
+				}
+				fmt.Printf("%s %s: %s\n", hashColor(entry.New.Short()), refColor(fmt.Sprintf("%s@{%d}", name, i)), entry.Message)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVarP(&limit, "number", "n", 0, "limit the number of entries shown")
+	return cmd
+}
+
+func newShowCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show [commit]",
+		Short: "Show commit details",
+		Args:  argsValidator(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			hash, err := resolveCommit(repo, argOrEmpty(args, 0))
+			if err != nil {
+				if err == core.ErrBranchNotFound {
+					return core.ErrNoCommits
+				}
+				return err
+			}
+
+			commit, err := repo.Store().GetCommit(hash)
+			if err != nil {
+				return err
+			}
+
+			labels, err := refLabels(repo)
+			if err != nil {
+				return err
+			}
+			decoration := ""
+			if names, ok := labels[hash]; ok {
+				decoration = " (" + strings.Join(names, ", ") + ")"
+			}
+			printCommitHeader(hash, commit, decoration)
+
+			var parent core.Hash
+			if len(commit.Parents) > 0 {
+				parent = commit.Parents[0]
+			}
+
+			changes, err := repo.Diff(parent, hash)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println()
+			return printCommitDiff(repo, parent, hash, changes)
+		},
+	}
+}
+
+func newDiffCmd() *cobra.Command {
+	var nameOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "diff [commit1] [commit2]",
+		Short: "Show differences between commits or the working directory",
+		Long: `Show differences.
+
+With no arguments, compares the working directory against HEAD.
+With one commit, compares the working directory against that commit.
+With two commits or A..B, compares the first commit against the second.
+A...B compares the merge base of A and B against B.`,
+		Args: argsValidator(cobra.MaximumNArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 2 {
+				oldHash, err := resolveCommit(repo, args[0])
+				if err != nil {
+					return err
+				}
+				newHash, err := resolveCommit(repo, args[1])
+				if err != nil {
+					return err
+				}
+
+				changes, err := repo.Diff(oldHash, newHash)
+				if err != nil {
+					return err
+				}
+				if nameOnly {
+					printNameStatus(changes)
+					return nil
+				}
+				return printCommitDiff(repo, oldHash, newHash, changes)
+			}
+
+			if len(args) == 1 && strings.Contains(args[0], "..") {
+				oldHash, newHash, err := rangeEndpoints(repo, args[0])
+				if err != nil {
+					return err
+				}
+
+				changes, err := repo.Diff(oldHash, newHash)
+				if err != nil {
+					return err
+				}
+				if nameOnly {
+					printNameStatus(changes)
+					return nil
+				}
+				return printCommitDiff(repo, oldHash, newHash, changes)
+			}
+
+			oldHash, err := resolveCommit(repo, argOrEmpty(args, 0))
+			if err != nil && err != core.ErrBranchNotFound {
+				return err
+			}
+
+			changes, err := repo.DiffWorkingDir(oldHash)
+			if err != nil {
+				return err
+			}
+			if nameOnly {
+				printNameStatus(changes)
+				return nil
+			}
+
+			for _, path := range sortedPaths(changes) {
+				oldContent, err := contentAt(repo, oldHash, path)
+				if err != nil {
+					return err
+				}
+				newContent, err := os.ReadFile(filepath.Join(repo.Root, path))
+				if err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				printFileDiff(path, changes[path], string(oldContent), string(newContent))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&nameOnly, "name-status", false, "show only the names and status of changed files")
+	return cmd
+}
+
+// rangeHistory returns the commits selected by a range expression,
+// newest first, up to limit commits when limit is positive
+func rangeHistory(repo *repository.Repository, spec string, limit int) ([]*core.Commit, []core.Hash, error) {
+	rng, err := repo.ResolveRange(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes, err := repo.RangeCommits(rng)
+	if err != nil {
+		return nil, nil, err
+	}
+	if limit > 0 && len(hashes) > limit {
+		hashes = hashes[:limit]
+	}
+
+	commits := make([]*core.Commit, len(hashes))
+	for i, hash := range hashes {
+		if commits[i], err = repo.Store().GetCommit(hash); err != nil {
+			return nil, nil, err
+		}
+	}
+	return commits, hashes, nil
+}
+
+// rangeEndpoints returns the commits a range expression compares: A and B
+// for A..B, the merge base of A and B and B for A...B
+func rangeEndpoints(repo *repository.Repository, spec string) (core.Hash, core.Hash, error) {
+	rng, err := repo.ResolveRange(spec)
+	if err != nil {
+		return core.Hash{}, core.Hash{}, err
+	}
+	if !rng.Symmetric {
+		return rng.From, rng.To, nil
+	}
+	base, err := merge.FindLCA(repo.Store(), rng.From, rng.To)
+	if err != nil {
+		return core.Hash{}, core.Hash{}, err
+	}
+	return base, rng.To, nil
+}
+
+// printCommitHeader prints the hash, author, date and message of a commit
+func printCommitHeader(hash core.Hash, commit *core.Commit, decoration string) {
+	fmt.Printf("%s %s%s\n", hashColor("commit"), hashColor(hash.String()), decoration)
+	if len(commit.Parents) > 1 {
+		parents := make([]string, len(commit.Parents))
+		for i, p := range commit.Parents {
+			parents[i] = p.Short()
+		}
+		fmt.Printf("Merge:  %s\n", strings.Join(parents, " "))
+	}
+	fmt.Printf("Author: %s <%s>\n", commit.Author, commit.Email)
+	fmt.Printf("Date:   %s\n", commit.Timestamp.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	fmt.Println()
+	for _, line := range strings.Split(commit.Message, "\n") {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// printCommitDiff prints line diffs for every changed file between two commits
+func printCommitDiff(repo *repository.Repository, oldHash, newHash core.Hash, changes map[string]string) error {
+	for _, path := range sortedPaths(changes) {
+		oldContent, err := contentAt(repo, oldHash, path)
+		if err != nil {
+			return err
+		}
+		newContent, err := contentAt(repo, newHash, path)
+		if err != nil {
+			return err
+		}
+		printFileDiff(path, changes[path], string(oldContent), string(newContent))
+	}
+	return nil
+}
+
+// printFileDiff prints a unified diff for a single file
+func printFileDiff(path, status, oldContent, newContent string) {
+	fmt.Println(color.New(color.Bold).Sprintf("diff %s (%s)", path, status))
+
+	if isBinaryContent(oldContent) || isBinaryContent(newContent) {
+		fmt.Println("Binary files differ")
+		return
+	}
+
+	oldLabel, newLabel := "a/"+path, "b/"+path
+	if status == "added" {
+		oldLabel = "/dev/null"
+	}
+	if status == "deleted" {
+		newLabel = "/dev/null"
+	}
+	fmt.Println(color.New(color.Bold).Sprintf("--- %s", oldLabel))
+	fmt.Println(color.New(color.Bold).Sprintf("+++ %s", newLabel))
+
+	for _, hunk := range diff.MyersDiff(oldContent, newContent).Hunks {
+		fmt.Println(hunkColor(fmt.Sprintf("@@ -%s +%s @@",
+			hunkRange(hunk.OldStart, hunk.OldCount), hunkRange(hunk.NewStart, hunk.NewCount))))
+		for _, edit := range hunk.Edits {
+			switch edit.Type {
+			case diff.EditInsert:
+				fmt.Println(addedColor("+" + edit.Text))
+			case diff.EditDelete:
+				fmt.Println(deletedColor("-" + edit.Text))
+			default:
+				fmt.Println(" " + edit.Text)
+			}
+		}
+	}
+}
+
+// printNameStatus prints one line per changed file
+func printNameStatus(changes map[string]string) {
+	for _, path := range sortedPaths(changes) {
+		status := changes[path]
+		switch status {
+		case "added":
+			fmt.Printf("%s\t%s\n", addedColor("A"), path)
+		case "deleted":
+			fmt.Printf("%s\t%s\n", deletedColor("D"), path)
+		default:
+			fmt.Printf("%s\t%s\n", warnMark("M"), path)
+		}
+	}
+}
+
+// hunkRange formats a unified diff line range
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// contentAt returns a file's content at a commit, or nothing if it is absent
+func contentAt(repo *repository.Repository, commit core.Hash, path string) ([]byte, error) {
+	if commit.IsZero() {
+		return nil, nil
+	}
+	data, err := repo.GetFileContent(commit, path)
+	if err == core.ErrFileNotFound {
+		return nil, nil
+	}
+	return data, err
+}
+
+// isBinaryContent reports whether content looks binary
+func isBinaryContent(content string) bool {
+	return strings.IndexByte(content, 0) >= 0
+}
+
+// sortedPaths returns the keys of a change map in sorted order
+func sortedPaths(changes map[string]string) []string {
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// argOrEmpty returns args[i] or an empty string when absent
+func argOrEmpty(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+// Command asl is the Astral version control command-line interface.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// Exit codes returned by asl
+const (
+	exitOK       = 0
+	exitFailure  = 1   // The command failed
+	exitUsage    = 2   // Invalid arguments or flags
+	exitConflict = 3   // The operation stopped on conflicts that need resolution
+	exitNotRepo  = 128 // Not inside an Astral repository
+)
+
+// version is overridden at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// usageError marks errors caused by invalid command-line usage
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// errMergeConflicts is returned by commands that stopped on merge conflicts
+var errMergeConflicts = errors.New("merge stopped with conflicts")
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run executes the CLI with the given arguments and returns the exit code
+func run(args []string) int {
+	root := newRootCmd()
+	root.SetArgs(args)
+
+	err := root.Execute()
+	if err == nil {
+		return exitOK
+	}
+
+	code := exitCode(err)
+	if !errors.Is(err, errMergeConflicts) {
+		fmt.Fprintf(os.Stderr, "%s %v\n", color.RedString("error:"), err)
+	}
+	if code == exitUsage {
+		fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", root.Name())
+	}
+	return code
+}
+
+// exitCode maps an error to the process exit code
+func exitCode(err error) int {
+	var uerr usageError
+	switch {
+	case errors.As(err, &uerr):
+		return exitUsage
+	case strings.HasPrefix(err.Error(), "unknown command"),
+		strings.HasPrefix(err.Error(), "unknown flag"),
+		strings.HasPrefix(err.Error(), "unknown shorthand flag"):
+		return exitUsage
+	case errors.Is(err, core.ErrNotARepository):
+		return exitNotRepo
+	case errors.Is(err, errMergeConflicts), errors.Is(err, core.ErrConflictsExist):
+		return exitConflict
+	default:
+		return exitFailure
+	}
+}
+
+// newRootCmd builds the asl command tree
+func newRootCmd() *cobra.Command {
+	root := &cobra.Command{
+		Use:           "asl",
+		Short:         "Astral - a fast, simple version control system",
+		Version:       version,
+		SilenceUsage:  true,
+		SilenceErrors: true,
+	}
+
+	root.PersistentFlags().Bool("no-color", false, "disable colored output")
+	root.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if noColor, _ := cmd.Flags().GetBool("no-color"); noColor {
+			color.NoColor = true
+		}
+	}
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
+
+	root.AddCommand(
+		newInitCmd(),
+		newSaveCmd(),
+		newUndoCmd(),
+		newAmendCmd(),
+		newRestoreCmd(),
+		newBranchCmd(),
+		newSwitchCmd(),
+		newStackCmd(),
+		newTagCmd(),
+		newLogCmd(),
+		newReflogCmd(),
+		newOpCmd(),
+		newShowCmd(),
+		newDiffCmd(),
+		newMergeCmd(),
+		newResolveCmd(),
+		newStatusCmd(),
+		newRemoteCmd(),
+		newConfigCmd(),
+		newCloneCmd(),
+		newFetchCmd(),
+		newPullCmd(),
+		newPushCmd(),
+		newServeCmd(),
+		newRepackCmd(),
+		newGCCmd(),
+		newFsckCmd(),
+	)
+
+	return root
+}
+
+// argsValidator wraps a cobra positional argument check so failures
+// are reported as usage errors
+func argsValidator(fn cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := fn(cmd, args); err != nil {
+			return usageError{err}
+		}
+		return nil
+	}
+}
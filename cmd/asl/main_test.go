@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"usage", usageError{errors.New("bad args")}, exitUsage},
+		{"unknown command", errors.New(`unknown command "foo" for "asl"`), exitUsage},
+		{"not a repository", fmt.Errorf("open: %w", core.ErrNotARepository), exitNotRepo},
+		{"merge conflicts", errMergeConflicts, exitConflict},
+		{"unresolved conflicts", core.ErrConflictsExist, exitConflict},
+		{"generic", errors.New("boom"), exitFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// chdirTemp switches into a fresh temporary directory for the test
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	oldwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(oldwd) })
+
+	color.NoColor = true
+	return dir
+}
+
+func TestRun_Workflow(t *testing.T) {
+	dir := chdirTemp(t)
+	t.Setenv("ASL_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "aslconfig"))
+
+	if code := run([]string{"status"}); code != exitNotRepo {
+		t.Fatalf("status outside a repository: exit %d, want %d", code, exitNotRepo)
+	}
+
+	if code := run([]string{"init"}); code != exitOK {
+		t.Fatalf("init: exit %d", code)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("base\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := run([]string{"save"}); code != exitUsage {
+		t.Errorf("save without message: exit %d, want %d", code, exitUsage)
+	}
+
+	steps := [][]string{
+		{"save", "-m", "Initial commit"},
+		{"branch", "feature"},
+		{"switch", "feature"},
+		{"show", "HEAD^0"},
+		{"log", "main..feature"},
+		{"diff", "main...feature"},
+		{"reflog"},
+		{"show", "HEAD@{1}"},
+		{"log", "--oneline"},
+		{"show"},
+		{"diff"},
+		{"stack"},
+		{"status"},
+		{"status", "--porcelain", "--ignored"},
+		{"restore", "file.txt"},
+		{"restore", "--source", "HEAD", "."},
+		{"switch", "--detach", "feature"},
+		{"branch"},
+		{"switch", "feature"},
+		{"repack"},
+		{"show"},
+		{"repack"},
+		{"gc", "--dry-run"},
+		{"gc"},
+		{"fsck"},
+		{"fsck", "--json"},
+	}
+	for _, args := range steps {
+		if code := run(args); code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+	}
+}
+
+// runOutput runs asl with args and returns its exit code and what it
+// printed to stdout and stderr
+func runOutput(t *testing.T, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+
+	files := [2]*os.File{}
+	for i := range files {
+		f, err := os.CreateTemp(t.TempDir(), "out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+	oldStdout, oldStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = files[0], files[1]
+	code = run(args)
+	os.Stdout, os.Stderr = oldStdout, oldStderr
+
+	var out [2]string
+	for i, f := range files {
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		out[i] = string(data)
+	}
+	return code, out[0], out[1]
+}
+
+// newCLIRepo initializes a repository in a fresh directory, with file.txt
+// committed as "Base", and returns the directory
+func newCLIRepo(t *testing.T) string {
+	t.Helper()
+
+	dir := chdirTemp(t)
+	t.Setenv("ASL_CONFIG_GLOBAL", filepath.Join(t.TempDir(), "aslconfig"))
+	mustRun(t, "init")
+	writeFile(t, dir, "file.txt", "base\n")
+	mustRun(t, "save", "-m", "Base")
+	return dir
+}
+
+// mustRun runs asl with args, failing the test unless it succeeds
+func mustRun(t *testing.T, args ...string) {
+	t.Helper()
+	if code, _, stderr := runOutput(t, args...); code != exitOK {
+		t.Fatalf("%v: exit %d: %s", args, code, stderr)
+	}
+}
+
+// writeFile writes content to name in dir
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRun_BranchVerbose(t *testing.T) {
+	dir := newCLIRepo(t)
+	mustRun(t, "branch", "topic/feature")
+	mustRun(t, "switch", "topic/feature")
+	writeFile(t, dir, "file.txt", "feature\n")
+	mustRun(t, "save", "-m", "Feature work\n\nMore detail")
+	mustRun(t, "switch", "main")
+
+	code, stdout, stderr := runOutput(t, "branch", "-v")
+	if code != exitOK || stderr != "" {
+		t.Fatalf("branch -v: exit %d: %s", code, stderr)
+	}
+	want := regexp.MustCompile(`^\* main          [0-9a-f]{7} Base\n` +
+		`  topic/feature [0-9a-f]{7} \[main: ahead 1\] Feature work\n$`)
+	if !want.MatchString(stdout) {
+		t.Errorf("branch -v printed:\n%s", stdout)
+	}
+
+	code, stdout, _ = runOutput(t, "branch", "-m", "topic/feature", "topic/done")
+	if code != exitOK || stdout != "✓ Renamed branch topic/feature to topic/done\n" {
+		t.Errorf("branch -m: exit %d, printed %q", code, stdout)
+	}
+
+	code, _, stderr = runOutput(t, "branch", "-d", "topic/done")
+	if code != exitFailure || !strings.HasPrefix(stderr, "error: ") || !strings.Contains(stderr, "topic/done") {
+		t.Errorf("deleting an unmerged branch: exit %d, stderr %q", code, stderr)
+	}
+	code, stdout, _ = runOutput(t, "branch", "-D", "topic/done")
+	if code != exitOK || !strings.HasPrefix(stdout, "✓ Deleted branch topic/done (was ") {
+		t.Errorf("branch -D: exit %d, printed %q", code, stdout)
+	}
+
+	code, _, stderr = runOutput(t, "branch", "-d")
+	if code != exitUsage || !strings.Contains(stderr, "Run 'asl --help' for usage.") {
+		t.Errorf("branch -d without a name: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestRun_MergeConflictOutput(t *testing.T) {
+	dir := newCLIRepo(t)
+	mustRun(t, "branch", "feature")
+	mustRun(t, "switch", "feature")
+	writeFile(t, dir, "file.txt", "feature\n")
+	mustRun(t, "save", "-m", "Feature")
+	mustRun(t, "switch", "main")
+	writeFile(t, dir, "file.txt", "main\n")
+	mustRun(t, "save", "-m", "Main")
+
+	code, stdout, stderr := runOutput(t, "merge", "feature")
+	if code != exitConflict {
+		t.Fatalf("conflicting merge: exit %d, want %d", code, exitConflict)
+	}
+	if stderr != "" {
+		t.Errorf("conflicts are reported on stdout only, stderr %q", stderr)
+	}
+	for _, want := range []string{"✗ Merge conflict detected\n", "Conflicted files:\n  ✗ file.txt\n", "Or abort with: asl merge --abort\n"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("merge output lacks %q:\n%s", want, stdout)
+		}
+	}
+
+	code, stdout, stderr = runOutput(t, "merge", "--continue")
+	if code != exitConflict || !strings.Contains(stdout, "Unresolved conflicts:\n  ✗ file.txt (content)\n") {
+		t.Errorf("continue with unresolved conflicts: exit %d, printed %q", code, stdout)
+	}
+	if !strings.HasPrefix(stderr, "error: ") {
+		t.Errorf("continue with unresolved conflicts: stderr %q", stderr)
+	}
+
+	code, stdout, _ = runOutput(t, "merge", "--abort")
+	if code != exitOK || stdout != "✓ Merge aborted\n" {
+		t.Errorf("merge --abort: exit %d, printed %q", code, stdout)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	if err != nil || string(content) != "main\n" {
+		t.Errorf("file.txt = %q, %v after abort, want our version", content, err)
+	}
+}
+
+func TestRun_OpUndoRedo(t *testing.T) {
+	newCLIRepo(t)
+	mustRun(t, "branch", "feature")
+
+	code, stdout, _ := runOutput(t, "op", "undo")
+	if code != exitOK || !regexp.MustCompile(`^✓ Undid operation \d+: branch feature\n$`).MatchString(stdout) {
+		t.Errorf("op undo: exit %d, printed %q", code, stdout)
+	}
+	if _, stdout, _ := runOutput(t, "branch"); stdout != "* main\n" {
+		t.Errorf("branches after undo:\n%s", stdout)
+	}
+
+	_, stdout, _ = runOutput(t, "op", "log", "-n", "2")
+	lines := strings.Split(strings.TrimSuffix(stdout, "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "op undo "+strings.Fields(lines[1])[0]) || !strings.HasSuffix(lines[1], "branch feature (undone)") {
+		t.Errorf("op log -n 2 printed:\n%s", stdout)
+	}
+
+	code, stdout, _ = runOutput(t, "op", "redo")
+	if code != exitOK || !regexp.MustCompile(`^✓ Redid operation \d+: branch feature\n$`).MatchString(stdout) {
+		t.Errorf("op redo: exit %d, printed %q", code, stdout)
+	}
+	if _, stdout, _ := runOutput(t, "branch"); stdout != "  feature\n* main\n" {
+		t.Errorf("branches after redo:\n%s", stdout)
+	}
+
+	code, _, stderr := runOutput(t, "op", "redo")
+	if code != exitFailure || !strings.HasPrefix(stderr, "error: ") {
+		t.Errorf("redo with nothing undone: exit %d, stderr %q", code, stderr)
+	}
+}
+
+func TestRun_TagAndConfigOutput(t *testing.T) {
+	newCLIRepo(t)
+	mustRun(t, "tag", "v0.1")
+	mustRun(t, "tag", "-m", "First release\n\nNotes", "v1.0", "HEAD")
+
+	_, stdout, _ := runOutput(t, "tag", "-v")
+	want := regexp.MustCompile(`^v0\.1 [0-9a-f]{7} Base\nv1\.0 [0-9a-f]{7} First release\n$`)
+	if !want.MatchString(stdout) {
+		t.Errorf("tag -v printed:\n%s", stdout)
+	}
+
+	mustRun(t, "config", "set", "user.name", "CLI Tester")
+	if code, stdout, _ := runOutput(t, "config", "get", "user.name"); code != exitOK || stdout != "CLI Tester\n" {
+		t.Errorf("config get: exit %d, printed %q", code, stdout)
+	}
+	mustRun(t, "config", "unset", "user.name")
+	code, stdout, stderr := runOutput(t, "config", "get", "user.name")
+	if code != exitFailure || stdout != "" || !strings.Contains(stderr, "user.name") {
+		t.Errorf("config get of an unset key: exit %d, stdout %q, stderr %q", code, stdout, stderr)
+	}
+	if code, _, _ := runOutput(t, "config", "list", "--global", "--local"); code != exitUsage {
+		t.Errorf("config with two scopes: exit %d, want %d", code, exitUsage)
+	}
+}
+
+func TestRun_MergeConflictExitCode(t *testing.T) {
+	dir := chdirTemp(t)
+	file := filepath.Join(dir, "file.txt")
+
+	mustRun := func(args ...string) {
+		t.Helper()
+		if code := run(args); code != exitOK {
+			t.Fatalf("%v: exit %d", args, code)
+		}
+	}
+
+	mustRun("init")
+	os.WriteFile(file, []byte("base\n"), 0644)
+	mustRun("save", "-m", "base")
+	mustRun("branch", "feature")
+	mustRun("switch", "feature")
+	os.WriteFile(file, []byte("feature\n"), 0644)
+	mustRun("save", "-m", "feature")
+	mustRun("switch", "main")
+	os.WriteFile(file, []byte("main\n"), 0644)
+	mustRun("save", "-m", "main")
+
+	if code := run([]string{"merge", "-X", "both", "feature"}); code != exitUsage {
+		t.Errorf("merge with an unknown -X option: exit %d, want %d", code, exitUsage)
+	}
+	if code := run([]string{"merge", "--strategy", "octopus", "feature"}); code != exitUsage {
+		t.Errorf("merge with an unknown strategy: exit %d, want %d", code, exitUsage)
+	}
+
+	if code := run([]string{"merge", "feature"}); code != exitConflict {
+		t.Fatalf("conflicting merge: exit %d, want %d", code, exitConflict)
+	}
+
+	if code := run([]string{"merge", "--continue"}); code != exitConflict {
+		t.Errorf("continue with unresolved conflicts: exit %d, want %d", code, exitConflict)
+	}
+
+	mustRun("resolve", "--theirs")
+	mustRun("merge", "--continue")
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "feature\n" {
+		t.Errorf("expected their version after resolve --theirs, got %q", content)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/repository"
+	"github.com/codimo/astral/internal/storage"
+)
+
+func newRepackCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "repack",
+		Short: "Pack loose objects into a delta-compressed pack file",
+		Args:  argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			stats, err := repo.Store().Repack()
+			if err != nil {
+				return err
+			}
+
+			if stats.Objects == 0 {
+				printSuccess("Nothing to pack")
+				return nil
+			}
+
+			printSuccess("Packed %d objects (%d deltas) into %s, %s",
+				stats.Objects, stats.Deltas, stats.Pack[:len("pack-")+7], formatSize(stats.Size))
+			if stats.LooseRemoved > 0 || stats.PacksRemoved > 0 {
+				fmt.Printf("  removed %d loose objects and %d old packs\n", stats.LooseRemoved, stats.PacksRemoved)
+			}
+			return nil
+		},
+	}
+}
+
+func newGCCmd() *cobra.Command {
+	var opts storage.PruneOptions
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete unreachable objects",
+		Long: `Delete unreachable objects.
+
+Objects reachable from a branch, remote-tracking branch, tag, HEAD, an
+in-progress merge, a reflog entry or the operation log are kept. Reflog
+entries and operations expire after 90 days. Unreachable objects are deleted once they are older than the
+grace period; packed objects take the age of their pack, which is rewritten
+without them.`,
+		Args: argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			stats, err := repo.GC(opts)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case stats.Pruned == 0:
+				printSuccess("Nothing to prune")
+			case opts.DryRun:
+				printSuccess("Would remove %d unreachable objects, freeing %s", stats.Pruned, formatSize(stats.Bytes))
+			default:
+				printSuccess("Removed %d unreachable objects, freed %s", stats.Pruned, formatSize(stats.Bytes))
+			}
+			if stats.Recent > 0 {
+				fmt.Printf("  kept %d unreachable objects newer than %s\n", stats.Recent, opts.Grace)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.DryRun, "dry-run", "n", false, "report what would be removed without removing it")
+	cmd.Flags().DurationVar(&opts.Grace, "grace", repository.DefaultGCGrace, "keep unreachable objects newer than this")
+	return cmd
+}
+
+func newFsckCmd() *cobra.Command {
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "fsck",
+		Short: "Verify the integrity of the repository",
+		Long: `Verify the integrity of the repository.
+
+Every object is re-hashed and parsed, references between objects are
+followed, and every ref must point to an existing commit. Corrupt, missing
+and dangling objects are reported. The exit code is non-zero if anything
+other than dangling objects is found.`,
+		Args: argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			report, err := repo.Fsck()
+			if err != nil {
+				return err
+			}
+
+			if asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printFsckReport(report)
+			}
+
+			if !report.OK() {
+				return fmt.Errorf("found %d problems", report.Problems())
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the report as JSON")
+	return cmd
+}
+
+// printFsckReport prints one line per problem followed by a summary
+func printFsckReport(report *repository.FsckReport) {
+	printProblems := func(label string, problems []repository.FsckProblem) {
+		for _, p := range problems {
+			line := label
+			if p.Type != "" {
+				line += " " + p.Type
+			}
+			if p.Ref != "" {
+				line += " " + refColor(p.Ref)
+			}
+			if p.Hash != nil {
+				line += " " + hashColor(p.Hash.String())
+			}
+			if p.Detail != "" {
+				line += ": " + p.Detail
+			}
+			fmt.Println(line)
+		}
+	}
+
+	printProblems(failureMark("corrupt"), report.Corrupt)
+	printProblems(failureMark("missing"), report.Missing)
+	printProblems(failureMark("bad ref"), report.BadRefs)
+	printProblems(dimColor("dangling"), report.Dangling)
+
+	if report.OK() {
+		printSuccess("Checked %d objects, no problems found", report.Objects)
+	} else {
+		printFailure("Checked %d objects, found %d problems", report.Objects, report.Problems())
+	}
+}
+
+// formatSize renders a byte count for humans, e.g. 1536 -> "1.5 KiB"
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
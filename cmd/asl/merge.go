@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func newMergeCmd() *cobra.Command {
+	var opts repository.MergeOptions
+	var abort, cont bool
+	var favor string
+
+	cmd := &cobra.Command{
+		Use:   "merge <branch>...",
+		Short: "Merge one or more branches into the current branch",
+		Long: `Merge one or more branches into the current branch.
+
+Given several branches, they are merged in turn and recorded as one merge
+commit with all of them as parents. If any of them conflicts, nothing is
+changed; merge the branches one at a time instead.
+
+Strategies (--strategy):
+  recursive  merge the changes of both branches (default)
+  ours       record the merge but keep the current branch's files unchanged
+
+Options for the recursive strategy (-X):
+  ours       settle conflicting hunks with the current branch's version
+  theirs     settle conflicting hunks with the merged branch's version
+  union      keep both versions of conflicting hunks, ours first
+
+Hunks that merge cleanly are merged as usual with -X.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if abort && cont {
+				return usageError{errors.New("--abort and --continue cannot be used together")}
+			}
+			if opts.NoFF && opts.FFOnly {
+				return usageError{errors.New("--no-ff and --ff-only cannot be used together")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case abort:
+				if len(args) > 0 {
+					return usageError{errors.New("--abort takes no arguments")}
+				}
+				if err := repo.AbortMerge(); err != nil {
+					return err
+				}
+				printSuccess("Merge aborted")
+				return nil
+
+			case cont:
+				if len(args) > 0 {
+					return usageError{errors.New("--continue takes no arguments")}
+				}
+				if err := repo.ContinueMerge(); err != nil {
+					if errors.Is(err, core.ErrConflictsExist) {
+						printUnresolved(repo)
+					}
+					return err
+				}
+				printSuccess("Merge completed")
+				return nil
+			}
+
+			if len(args) == 0 {
+				return usageError{errors.New("merge requires a branch")}
+			}
+			if opts.Favor, err = merge.ParseFavor(favor); err != nil {
+				return usageError{err}
+			}
+
+			var result *repository.MergeResult
+			if len(args) == 1 {
+				result, err = repo.Merge(args[0], opts)
+			} else {
+				result, err = repo.MergeOctopus(args, opts)
+			}
+			if errors.Is(err, core.ErrInvalidStrategy) {
+				return usageError{err}
+			}
+			if err != nil {
+				return err
+			}
+
+			return printMergeResult(result)
+		},
+	}
+
+	cmd.Flags().BoolVar(&abort, "abort", false, "cancel the merge in progress")
+	cmd.Flags().BoolVar(&cont, "continue", false, "complete the merge after resolving conflicts")
+	cmd.Flags().BoolVar(&opts.NoFF, "no-ff", false, "create a merge commit even when fast-forward is possible")
+	cmd.Flags().BoolVar(&opts.FFOnly, "ff-only", false, "refuse to merge unless fast-forward is possible")
+	cmd.Flags().StringVarP(&opts.Strategy, "strategy", "s", "", "merge strategy: recursive or ours")
+	cmd.Flags().StringVarP(&favor, "strategy-option", "X", "", "settle conflicting hunks: ours, theirs or union")
+	return cmd
+}
+
+func newResolveCmd() *cobra.Command {
+	var ours, theirs bool
+
+	cmd := &cobra.Command{
+		Use:   "resolve [files...]",
+		Short: "Mark conflicted files as resolved",
+		Long: `Mark conflicted files as resolved.
+
+With --ours or --theirs, the named files (or every unresolved conflict when
+no files are given) are replaced with that side's version first.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if ours && theirs {
+				return usageError{errors.New("--ours and --theirs cannot be used together")}
+			}
+			if !ours && !theirs && len(args) == 0 {
+				return usageError{errors.New("specify files to resolve, or use --ours/--theirs")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			side := repository.KeepWorking
+			switch {
+			case ours:
+				side = repository.KeepOurs
+			case theirs:
+				side = repository.KeepTheirs
+			}
+			paths, err := repo.ResolveConflicts(toRepoPaths(repo, args), side)
+			if err != nil {
+				return err
+			}
+
+			for _, path := range paths {
+				printSuccess("Resolved %s", path)
+			}
+			state, err := merge.LoadMergeState(repo.Root)
+			if err != nil {
+				return err
+			}
+			if !state.HasUnresolvedConflicts() {
+				fmt.Println()
+				fmt.Println("All conflicts resolved. Run: asl merge --continue")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&ours, "ours", false, "resolve using our version")
+	cmd.Flags().BoolVar(&theirs, "theirs", false, "resolve using their version")
+	return cmd
+}
+
+// printMergeResult reports the outcome of a merge, returning
+// errMergeConflicts when it stopped on conflicts
+func printMergeResult(result *repository.MergeResult) error {
+	if result.Conflicts {
+		printFailure("Merge conflict detected")
+		fmt.Println()
+		fmt.Println("Conflicted files:")
+		conflicted := append([]string(nil), result.Conflicted...)
+		sort.Strings(conflicted)
+		for _, path := range conflicted {
+			fmt.Printf("  %s %s\n", failureMark("✗"), path)
+		}
+		fmt.Println()
+		fmt.Println("To resolve:")
+		fmt.Println("  1. Fix conflicts in each file")
+		fmt.Println("  2. Run: asl resolve <file>")
+		fmt.Println("  3. Run: asl merge --continue")
+		fmt.Println()
+		fmt.Println("Or abort with: asl merge --abort")
+		return errMergeConflicts
+	}
+
+	if result.MergeCommit == nil || result.FastForward {
+		printSuccess("%s", result.Message)
+	} else {
+		printSuccess("Merge completed successfully")
+	}
+	return nil
+}
+
+// printUnresolved lists the conflicts that still need resolution
+func printUnresolved(repo *repository.Repository) {
+	state, err := merge.LoadMergeState(repo.Root)
+	if err != nil {
+		return
+	}
+
+	fmt.Println("Unresolved conflicts:")
+	for _, c := range state.Conflicts {
+		if !c.Resolved {
+			fmt.Printf("  %s %s (%s)\n", failureMark("✗"), c.Path, c.Type)
+		}
+	}
+}
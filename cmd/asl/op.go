@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/repository"
+)
+
+func newOpCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "op",
+		Short: "Show and undo operations on the repository",
+		Long: `Show and undo operations on the repository.
+
+Every command that changes a branch, tag, remote-tracking branch, HEAD or
+the merge in progress is recorded in the operation log together with the
+state before and after it. Undoing an operation puts all of that back as
+it was and checks out the resulting HEAD, so a merge, switch or amend can
+be undone as a unit. Uncommitted changes are kept as by asl switch.`,
+		Args: argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listOperations(0)
+		},
+	}
+
+	var limit int
+	logCmd := &cobra.Command{
+		Use:   "log",
+		Short: "List operations, newest first",
+		Args:  argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listOperations(limit)
+		},
+	}
+	logCmd.Flags().IntVarP(&limit, "number", "n", 0, "limit the number of operations shown")
+
+	cmd.AddCommand(
+		logCmd,
+		newOpApplyCmd("undo", "Undo the latest operation not yet undone", "Undid", cobra.NoArgs,
+			func(repo *repository.Repository, args []string, opts repository.SwitchOptions) (*repository.Operation, error) {
+				return repo.UndoOperation(opts)
+			}),
+		newOpApplyCmd("redo", "Redo the operation most recently undone", "Redid", cobra.NoArgs,
+			func(repo *repository.Repository, args []string, opts repository.SwitchOptions) (*repository.Operation, error) {
+				return repo.RedoOperation(opts)
+			}),
+		newOpApplyCmd("restore <id>", "Restore the repository to its state after an operation", "Restored the state after", cobra.ExactArgs(1),
+			func(repo *repository.Repository, args []string, opts repository.SwitchOptions) (*repository.Operation, error) {
+				id, err := strconv.Atoi(args[0])
+				if err != nil {
+					return nil, usageError{fmt.Errorf("invalid operation id %q", args[0])}
+				}
+				return repo.RestoreOperation(id, opts)
+			}),
+	)
+	return cmd
+}
+
+// newOpApplyCmd builds an op subcommand that moves the repository to a
+// recorded state
+func newOpApplyCmd(use, short, done string, posArgs cobra.PositionalArgs, apply func(*repository.Repository, []string, repository.SwitchOptions) (*repository.Operation, error)) *cobra.Command {
+	var opts repository.SwitchOptions
+
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  argsValidator(posArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Force && opts.Carry {
+				return usageError{fmt.Errorf("--force and --carry cannot be used together")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			op, err := apply(repo, args, opts)
+			if err != nil {
+				return err
+			}
+			printSuccess("%s operation %s: %s", done, hashColor(strconv.Itoa(op.ID)), op.Description)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "discard uncommitted changes that would be overwritten")
+	cmd.Flags().BoolVar(&opts.Carry, "carry", false, "merge uncommitted changes into the restored files")
+	return cmd
+}
+
+// listOperations prints the operation log, newest first, marking the
+// operations that are currently undone
+func listOperations(limit int) error {
+	repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	ops, err := repo.Operations()
+	if err != nil {
+		return err
+	}
+	_, undone := repository.UndoStacks(ops)
+	isUndone := make(map[int]bool, len(undone))
+	for _, id := range undone {
+		isUndone[id] = true
+	}
+
+	for i, shown := len(ops)-1, 0; i >= 0 && (limit <= 0 || shown < limit); i, shown = i-1, shown+1 {
+		op := ops[i]
+		line := fmt.Sprintf("%s %s %s", hashColor(fmt.Sprintf("%4d", op.ID)), op.Time.Format("2006-01-02 15:04:05"), op.Description)
+		if isUndone[op.ID] {
+			line += warnMark(" (undone)")
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+var (
+	successMark = color.New(color.FgGreen).SprintFunc()
+	failureMark = color.New(color.FgRed).SprintFunc()
+	warnMark    = color.New(color.FgYellow).SprintFunc()
+	hashColor   = color.New(color.FgYellow).SprintFunc()
+	refColor    = color.New(color.FgCyan, color.Bold).SprintFunc()
+	headColor   = color.New(color.FgGreen, color.Bold).SprintFunc()
+	dimColor    = color.New(color.Faint).SprintFunc()
+)
+
+// printSuccess prints a green check-marked message
+func printSuccess(format string, args ...interface{}) {
+	fmt.Printf("%s %s\n", successMark("✓"), fmt.Sprintf(format, args...))
+}
+
+// printFailure prints a red cross-marked message
+func printFailure(format string, args ...interface{}) {
+	fmt.Printf("%s %s\n", failureMark("✗"), fmt.Sprintf(format, args...))
+}
+
+// printWarning prints a yellow warning to stderr
+func printWarning(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "%s %s\n", warnMark("warning:"), fmt.Sprintf(format, args...))
+}
+
+// openRepo opens the repository containing the current working directory
+func openRepo() (*repository.Repository, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := repository.FindRoot(cwd)
+	if err != nil {
+		return nil, err
+	}
+
+	return repository.Open(root)
+}
+
+// resolveCommit resolves a command-line revision to a commit hash.
+// An empty argument resolves to the current commit.
+func resolveCommit(repo *repository.Repository, arg string) (core.Hash, error) {
+	if arg == "" {
+		arg = "HEAD"
+	}
+	return repo.ResolveRevision(arg)
+}
+
+// refLabels returns the branch and tag names pointing at each commit
+func refLabels(repo *repository.Repository) (map[core.Hash][]string, error) {
+	branches, err := repo.ListBranches()
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := repo.GetCurrentBranch()
+
+	labels := make(map[core.Hash][]string)
+	if errors.Is(err, core.ErrDetachedHead) {
+		if head, err := repo.GetCurrentCommit(); err == nil {
+			labels[head] = []string{headColor("HEAD")}
+		}
+	}
+	for _, branch := range branches {
+		hash, err := repo.GetRef("refs/heads/" + branch)
+		if err != nil || hash.IsZero() {
+			continue
+		}
+		label := refColor(branch)
+		if branch == current {
+			label = headColor("HEAD -> " + branch)
+			labels[hash] = append([]string{label}, labels[hash]...)
+			continue
+		}
+		labels[hash] = append(labels[hash], label)
+	}
+
+	tags, err := repo.TagDetails()
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		if !tag.Commit.IsZero() {
+			labels[tag.Commit] = append(labels[tag.Commit], refColor("tag: "+tag.Name))
+		}
+	}
+
+	return labels, nil
+}
+
+// firstLine returns the first line of a commit message
+func firstLine(message string) string {
+	if idx := strings.IndexByte(message, '\n'); idx >= 0 {
+		return message[:idx]
+	}
+	return message
+}
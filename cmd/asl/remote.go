@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/protocol"
+	"github.com/codimo/astral/internal/remote"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func newRemoteCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remote",
+		Short: "Manage remote repositories",
+		Args:  argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listRemotes()
+		},
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "add <name> <url>",
+			Short: "Add a remote",
+			Args:  argsValidator(cobra.ExactArgs(2)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				repo, err := openRepo()
+				if err != nil {
+					return err
+				}
+				if err := remote.AddRemote(repo.Root, args[0], args[1]); err != nil {
+					return err
+				}
+				printSuccess("Added remote %s", refColor(args[0]))
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "list",
+			Short: "List remotes",
+			Args:  argsValidator(cobra.NoArgs),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return listRemotes()
+			},
+		},
+		&cobra.Command{
+			Use:     "remove <name>",
+			Aliases: []string{"rm"},
+			Short:   "Remove a remote",
+			Args:    argsValidator(cobra.ExactArgs(1)),
+			RunE: func(cmd *cobra.Command, args []string) error {
+				repo, err := openRepo()
+				if err != nil {
+					return err
+				}
+				if err := remote.RemoveRemote(repo.Root, args[0]); err != nil {
+					return err
+				}
+				printSuccess("Removed remote %s", args[0])
+				return nil
+			},
+		},
+	)
+
+	return cmd
+}
+
+// listRemotes prints every configured remote as "<name>\t<url>"
+func listRemotes() error {
+	repo, err := openRepo()
+	if err != nil {
+		return err
+	}
+
+	remotes, err := remote.ListRemotes(repo.Root)
+	if err != nil {
+		return err
+	}
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].Name < remotes[j].Name })
+
+	for _, r := range remotes {
+		fmt.Printf("%s\t%s\n", r.Name, r.URL)
+	}
+	return nil
+}
+
+func newCloneCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "clone <url> [directory]",
+		Short: "Clone a remote repository into a new directory",
+		Args:  argsValidator(cobra.RangeArgs(1, 2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := ""
+			if len(args) == 2 {
+				dir = args[1]
+			} else {
+				dir = cloneDirName(args[0])
+				if dir == "" {
+					return usageError{errors.New("cannot derive a directory name from the URL, please specify one")}
+				}
+			}
+
+			fmt.Printf("Cloning into '%s'...\n", dir)
+			repo, err := repository.Clone(args[0], dir)
+			if err != nil {
+				return err
+			}
+
+			if branch, err := repo.GetCurrentBranch(); err == nil {
+				if head, err := repo.GetCurrentCommit(); err == nil && !head.IsZero() {
+					printSuccess("Checked out %s at %s", refColor(branch), hashColor(head.Short()))
+					return nil
+				}
+			}
+			printWarning("you appear to have cloned an empty repository")
+			return nil
+		},
+	}
+}
+
+// cloneDirName derives a directory name from a clone URL,
+// e.g. https://example.com/team/project.git -> project
+func cloneDirName(rawURL string) string {
+	p := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Path != "" {
+		p = u.Path
+	}
+
+	name := path.Base(strings.TrimRight(p, "/"))
+	name = strings.TrimSuffix(name, ".git")
+	name = strings.TrimSuffix(name, ".asl")
+	if name == "" || name == "." || name == "/" {
+		return ""
+	}
+	return filepath.FromSlash(name)
+}
+
+func newFetchCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "fetch [remote]",
+		Short: "Download objects and refs from a remote",
+		Args:  argsValidator(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			remoteName := argOrEmpty(args, 0)
+			updates, err := repo.Fetch(remoteName)
+			if err != nil {
+				return err
+			}
+
+			if len(updates) == 0 {
+				printSuccess("Already up to date")
+				return nil
+			}
+			printRefUpdates(updates)
+			return nil
+		},
+	}
+}
+
+func newPullCmd() *cobra.Command {
+	var opts repository.MergeOptions
+
+	cmd := &cobra.Command{
+		Use:   "pull [remote] [branch]",
+		Short: "Fetch from a remote and merge into the current branch",
+		Args:  argsValidator(cobra.MaximumNArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			result, err := repo.Pull(argOrEmpty(args, 0), argOrEmpty(args, 1), opts)
+			if err != nil {
+				return err
+			}
+			return printMergeResult(result)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.NoFF, "no-ff", false, "create a merge commit even when fast-forward is possible")
+	cmd.Flags().BoolVar(&opts.FFOnly, "ff-only", false, "refuse to merge unless fast-forward is possible")
+	return cmd
+}
+
+func newPushCmd() *cobra.Command {
+	var opts repository.PushOptions
+
+	cmd := &cobra.Command{
+		Use:   "push [remote] [branch]",
+		Short: "Upload local commits to a remote",
+		Args:  argsValidator(cobra.MaximumNArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.All && len(args) == 2 {
+				return usageError{errors.New("--all cannot be combined with a branch name")}
+			}
+			if opts.Tags && (opts.All || len(args) == 2) {
+				return usageError{errors.New("--tags cannot be combined with --all or a branch name")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			updates, err := repo.Push(argOrEmpty(args, 0), argOrEmpty(args, 1), opts)
+			printRefUpdates(updates)
+			if err != nil {
+				return err
+			}
+
+			if len(updates) == 0 {
+				printSuccess("Everything up to date")
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "allow non-fast-forward updates")
+	cmd.Flags().BoolVar(&opts.All, "all", false, "push all branches")
+	cmd.Flags().BoolVar(&opts.Tags, "tags", false, "push all tags instead of branches")
+	cmd.Flags().BoolVarP(&opts.SetUpstream, "set-upstream", "u", false, "track the pushed branch")
+	return cmd
+}
+
+// printRefUpdates prints one line per updated ref
+func printRefUpdates(updates []protocol.RefUpdate) {
+	for _, u := range updates {
+		switch {
+		case u.Old.IsZero():
+			printSuccess("%s %s (new)", refColor(u.Name), hashColor(u.New.Short()))
+		default:
+			printSuccess("%s %s..%s", refColor(u.Name), hashColor(u.Old.Short()), hashColor(u.New.Short()))
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/auth"
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/protocol"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// shutdownTimeout bounds how long asl serve waits for in-flight requests
+const shutdownTimeout = 10 * time.Second
+
+func newServeCmd() *cobra.Command {
+	var addr, certFile, keyFile string
+	var readOnly bool
+
+	cmd := &cobra.Command{
+		Use:   "serve [path]",
+		Short: "Serve repositories over HTTP",
+		Long: `Serve repositories over HTTP.
+
+If path is a repository it is served at the root URL. Otherwise every
+repository directly inside path is served under its own prefix, so
+path/project is reachable at http://<addr>/project.
+
+The server does not authenticate clients: anyone who can reach it can
+push. It listens on 127.0.0.1 unless --addr says otherwise; serve on
+other interfaces only on a trusted network, or with --read-only.`,
+		Args: argsValidator(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if (certFile == "") != (keyFile == "") {
+				return usageError{errors.New("--tls-cert and --tls-key must be given together")}
+			}
+
+			path := argOrEmpty(args, 0)
+			if path == "" {
+				path = "."
+			}
+
+			handler, names, err := newServeHandler(path, readOnly)
+			if err != nil {
+				return err
+			}
+
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				return err
+			}
+
+			scheme := "http"
+			if certFile != "" {
+				scheme = "https"
+			}
+			mode := ""
+			if readOnly {
+				mode = " (read-only)"
+			}
+			for _, name := range names {
+				printSuccess("Serving %s at %s://%s/%s%s", refColor(name), scheme, listener.Addr(), name, mode)
+			}
+			if len(names) == 0 {
+				printSuccess("Serving repository at %s://%s/%s", scheme, listener.Addr(), mode)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return serve(ctx, &http.Server{Handler: handler}, listener, certFile, keyFile)
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "addr", "127.0.0.1:8080", "address to listen on; use :8080 for every interface")
+	cmd.Flags().StringVar(&certFile, "tls-cert", "", "TLS certificate file")
+	cmd.Flags().StringVar(&keyFile, "tls-key", "", "TLS private key file")
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "reject pushes")
+	return cmd
+}
+
+// serve runs srv until ctx is cancelled, then shuts it down gracefully
+func serve(ctx context.Context, srv *http.Server, listener net.Listener, certFile, keyFile string) error {
+	errc := make(chan error, 1)
+	go func() {
+		if certFile != "" {
+			errc <- srv.ServeTLS(listener, certFile, keyFile)
+		} else {
+			errc <- srv.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// newServeHandler builds the HTTP handler for a repository or a directory of
+// repositories. It returns the URL prefixes served, which is empty when a
+// single repository is served at the root.
+func newServeHandler(path string, readOnly bool) (http.Handler, []string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if repo, err := repository.Open(abs); err == nil {
+		return newRepoServer(repo, readOnly), nil, nil
+	}
+
+	entries, err := os.ReadDir(abs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := http.NewServeMux()
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		repo, err := repository.Open(filepath.Join(abs, entry.Name()))
+		if err != nil {
+			continue
+		}
+
+		prefix := "/" + entry.Name()
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, newRepoServer(repo, readOnly)))
+		names = append(names, entry.Name())
+	}
+
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("%w: no repositories found in %s", core.ErrNotARepository, abs)
+	}
+
+	sort.Strings(names)
+	return mux, names, nil
+}
+
+// newRepoServer creates a protocol server for a single repository
+func newRepoServer(repo *repository.Repository, readOnly bool) *protocol.Server {
+	server := protocol.NewServer(repo.Store(), repo, &auth.NoneAuth{})
+	server.SetReadOnly(readOnly)
+	return server
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestServeHandler_DirectoryOfRepositories(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha", "beta"} {
+		if _, err := repository.Init(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler, names, err := newServeHandler(dir, true)
+	if err != nil {
+		t.Fatalf("newServeHandler failed: %v", err)
+	}
+	if len(names) != 2 || names[0] != "alpha" || names[1] != "beta" {
+		t.Fatalf("unexpected repositories served: %v", names)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	for _, name := range names {
+		resp, err := http.Get(ts.URL + "/" + name + "/info/refs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s/info/refs: status %d", name, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/alpha/objects/", "application/json", bytes.NewReader([]byte("[]")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("read-only push: expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeHandler_SingleRepository(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := repository.Init(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	handler, names, err := newServeHandler(dir, false)
+	if err != nil {
+		t.Fatalf("newServeHandler failed: %v", err)
+	}
+	if len(names) != 0 {
+		t.Errorf("single repository should be served at the root, got prefixes %v", names)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/objects/", "application/json", bytes.NewReader([]byte("[]")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("writable push: expected 201, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeHandler_NoRepositories(t *testing.T) {
+	if _, _, err := newServeHandler(t.TempDir(), false); err == nil {
+		t.Error("expected an error when no repositories are found")
+	}
+}
+
+func TestServe_GracefulShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	srv := &http.Server{Handler: http.NotFoundHandler()}
+	go func() { done <- serve(ctx, srv, listener, "", "") }()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("serve returned %v after shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not shut down")
+	}
+}
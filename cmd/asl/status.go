@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/repository"
+)
+
+func newStatusCmd() *cobra.Command {
+	var porcelain, showIgnored bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show repository and merge status",
+		Long: `Show the working directory changes relative to the current commit, and
+the state of any merge in progress.
+
+With --porcelain, print a stable format for scripts and editors:
+
+  # branch <name>          or "# branch (detached)"
+  # head <hash>            or "# head (initial)" before the first commit
+  # merge <branch>         when a merge is in progress
+  <code> <path>            one line per file, sorted by path
+
+where <code> is A (added by the merge), M (modified), D (deleted),
+T (mode changed), ? (untracked), ! (ignored, with --ignored only) or
+U (unresolved merge conflict). Paths containing special characters are
+quoted as Go string literals.`,
+		Args: argsValidator(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			status, err := repo.Status()
+			if err != nil {
+				return err
+			}
+
+			if porcelain {
+				return writeStatusPorcelain(os.Stdout, status, showIgnored)
+			}
+			printStatus(repo, status, showIgnored)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&porcelain, "porcelain", false, "print machine-readable output")
+	cmd.Flags().BoolVar(&showIgnored, "ignored", false, "also show ignored files")
+	return cmd
+}
+
+// printStatus renders a status for people
+func printStatus(repo *repository.Repository, status *repository.Status, showIgnored bool) {
+	if status.Branch != "" {
+		fmt.Printf("On branch %s\n", headColor(status.Branch))
+	} else {
+		fmt.Printf("HEAD detached at %s\n", hashColor(status.Head.Short()))
+	}
+	if status.Head.IsZero() {
+		fmt.Println("No commits yet")
+	}
+
+	if state := status.Merge; state != nil {
+		fmt.Println()
+		fmt.Printf("Merging branch '%s' into current branch\n", state.Branch)
+		fmt.Println(dimColor("  (use \"asl merge --abort\" to cancel merge)"))
+		fmt.Println()
+
+		if state.HasUnresolvedConflicts() {
+			printUnresolved(repo)
+			fmt.Println()
+		}
+		if len(state.AutoMerged) > 0 {
+			fmt.Println("Auto-merged files:")
+			for _, path := range state.AutoMerged {
+				fmt.Printf("  %s %s\n", successMark("●"), path)
+			}
+			fmt.Println()
+		}
+		if state.HasUnresolvedConflicts() {
+			fmt.Println("Next steps:")
+			fmt.Println("  1. Resolve conflicts in each file")
+			fmt.Println("  2. Mark as resolved: asl resolve <file>")
+			fmt.Println("  3. Complete merge: asl merge --continue")
+		} else {
+			fmt.Println("All conflicts resolved. Run: asl merge --continue")
+		}
+	} else if status.Clean() {
+		fmt.Println("nothing to save, working directory clean")
+	}
+
+	changes := make(map[string]string)
+	for _, path := range status.Added {
+		changes[path] = addedColor("added:   ")
+	}
+	for _, path := range status.Modified {
+		changes[path] = warnMark("modified:")
+	}
+	for _, path := range status.ModeChanged {
+		changes[path] = warnMark("mode:    ")
+	}
+	for _, path := range status.Deleted {
+		changes[path] = deletedColor("deleted: ")
+	}
+	if len(changes) > 0 {
+		fmt.Println()
+		fmt.Println("Changes to be saved:")
+		for _, path := range sortedPaths(changes) {
+			fmt.Printf("  %s %s\n", changes[path], path)
+		}
+	}
+
+	printPathList("Untracked files (saved by the next \"asl save\"):", status.Untracked)
+	if showIgnored {
+		printPathList("Ignored files:", status.Ignored)
+	}
+}
+
+// printPathList prints a titled list of paths, if there are any
+func printPathList(title string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Println(title)
+	for _, path := range paths {
+		fmt.Printf("  %s\n", dimColor(path))
+	}
+}
+
+// writeStatusPorcelain writes the --porcelain format described in the
+// status command's help
+func writeStatusPorcelain(w io.Writer, status *repository.Status, showIgnored bool) error {
+	var b strings.Builder
+
+	if status.Branch != "" {
+		fmt.Fprintf(&b, "# branch %s\n", status.Branch)
+	} else {
+		b.WriteString("# branch (detached)\n")
+	}
+	if status.Head.IsZero() {
+		b.WriteString("# head (initial)\n")
+	} else {
+		fmt.Fprintf(&b, "# head %s\n", status.Head)
+	}
+
+	codes := make(map[string]string)
+	for code, paths := range map[string][]string{
+		"A": status.Added,
+		"M": status.Modified,
+		"D": status.Deleted,
+		"T": status.ModeChanged,
+		"?": status.Untracked,
+	} {
+		for _, path := range paths {
+			codes[path] = code
+		}
+	}
+	if showIgnored {
+		for _, path := range status.Ignored {
+			codes[path] = "!"
+		}
+	}
+	if state := status.Merge; state != nil {
+		fmt.Fprintf(&b, "# merge %s\n", state.Branch)
+		for _, c := range state.Conflicts {
+			if !c.Resolved {
+				codes[c.Path] = "U"
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(codes))
+	for path := range codes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s %s\n", codes[path], quotePath(path))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// quotePath quotes paths that would break line-based parsing
+func quotePath(path string) string {
+	for _, r := range path {
+		if r < 0x20 || r == 0x7f || r == '"' || r == '\\' {
+			return strconv.Quote(path)
+		}
+	}
+	return path
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestWriteStatusPorcelain(t *testing.T) {
+	head := core.Hash{0xab}
+	status := &repository.Status{
+		Branch:      "main",
+		Head:        head,
+		Added:       []string{"theirs.txt"},
+		Modified:    []string{"b.txt", "conflict.txt"},
+		Deleted:     []string{"gone.txt"},
+		ModeChanged: []string{"run.sh"},
+		Untracked:   []string{"new file.txt", "odd\nname"},
+		Ignored:     []string{"build/", "debug.log"},
+		Merge: &merge.MergeState{
+			Branch: "feature",
+			Conflicts: []merge.ConflictInfo{
+				{Path: "conflict.txt", Type: "content"},
+				{Path: "done.txt", Type: "content", Resolved: true},
+			},
+		},
+	}
+
+	var out strings.Builder
+	if err := writeStatusPorcelain(&out, status, false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# branch main\n" +
+		"# head " + head.String() + "\n" +
+		"# merge feature\n" +
+		"M b.txt\n" +
+		"U conflict.txt\n" +
+		"D gone.txt\n" +
+		"? new file.txt\n" +
+		"? \"odd\\nname\"\n" +
+		"T run.sh\n" +
+		"A theirs.txt\n"
+	if out.String() != want {
+		t.Errorf("porcelain output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	status = &repository.Status{Ignored: []string{"build/"}}
+	if err := writeStatusPorcelain(&out, status, true); err != nil {
+		t.Fatal(err)
+	}
+	want = "# branch (detached)\n# head (initial)\n! build/\n"
+	if out.String() != want {
+		t.Errorf("porcelain output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
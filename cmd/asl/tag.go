@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func newTagCmd() *cobra.Command {
+	var opts repository.TagOptions
+	var verbose, del, show bool
+
+	cmd := &cobra.Command{
+		Use:   "tag [name [revision]] | --delete <name>... | --show <name>",
+		Short: "Create, list, delete or show tags",
+		Long: `Create, list, delete or show tags.
+
+A tag names a commit permanently, e.g. a release. Without -m the tag is
+lightweight: refs/tags/<name> holds the commit hash. With -m an annotated
+tag object recording the tagger, date and message is stored and the ref
+points at it. Either kind can be used wherever a revision is expected.
+
+Tag names follow the same rules as branch names. An existing tag is only
+replaced with --force.`,
+		Args: argsValidator(cobra.ArbitraryArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if del && show {
+				return usageError{errors.New("--delete and --show cannot be used together")}
+			}
+
+			repo, err := openRepo()
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case del:
+				if len(args) == 0 {
+					return usageError{errors.New("--delete requires a tag name")}
+				}
+				for _, name := range args {
+					hash, err := repo.DeleteTag(name)
+					if err != nil {
+						return err
+					}
+					printSuccess("Deleted tag %s (was %s)", refColor(name), hashColor(hash.Short()))
+				}
+				return nil
+
+			case show:
+				if len(args) != 1 {
+					return usageError{errors.New("--show requires exactly one tag name")}
+				}
+				return showTag(repo, args[0])
+
+			case len(args) > 2:
+				return usageError{errors.New("tag takes a name and at most one revision")}
+
+			case len(args) > 0:
+				target, err := resolveCommit(repo, argOrEmpty(args, 1))
+				if err != nil {
+					if err == core.ErrBranchNotFound {
+						return core.ErrNoCommits
+					}
+					return err
+				}
+				if _, err := repo.CreateTag(args[0], target, opts); err != nil {
+					return err
+				}
+				printSuccess("Tagged %s as %s", hashColor(target.Short()), refColor(args[0]))
+				return nil
+			}
+
+			return listTags(repo, verbose)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.Message, "message", "m", "", "create an annotated tag with this message")
+	cmd.Flags().BoolVarP(&opts.Force, "force", "f", false, "replace an existing tag")
+	cmd.Flags().BoolVarP(&del, "delete", "d", false, "delete tags")
+	cmd.Flags().BoolVar(&show, "show", false, "show a tag's annotation and commit")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "show the commit and message of each tag")
+	return cmd
+}
+
+// listTags prints every tag, with its commit and message when verbose
+func listTags(repo *repository.Repository, verbose bool) error {
+	infos, err := repo.TagDetails()
+	if err != nil {
+		return err
+	}
+
+	width := 0
+	for _, info := range infos {
+		width = max(width, len(info.Name))
+	}
+	for _, info := range infos {
+		if !verbose {
+			fmt.Println(info.Name)
+			continue
+		}
+
+		message := ""
+		if info.Tag != nil {
+			message = firstLine(info.Tag.Message)
+		} else if commit, err := repo.Store().GetCommit(info.Commit); err == nil {
+			message = firstLine(commit.Message)
+		}
+		padding := strings.Repeat(" ", width-len(info.Name))
+		fmt.Printf("%s%s %s %s\n", refColor(info.Name), padding, hashColor(info.Commit.Short()), message)
+	}
+	return nil
+}
+
+// showTag prints a tag's annotation, if it has one, followed by the
+// commit it points at
+func showTag(repo *repository.Repository, name string) error {
+	info, err := repo.GetTag(name)
+	if err != nil {
+		return err
+	}
+
+	if tag := info.Tag; tag != nil {
+		fmt.Printf("%s %s\n", hashColor("tag"), refColor(tag.Name))
+		fmt.Printf("Tagger: %s <%s>\n", tag.Tagger, tag.Email)
+		fmt.Printf("Date:   %s\n", tag.Timestamp.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		fmt.Println()
+		for _, line := range strings.Split(tag.Message, "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+		fmt.Println()
+	}
+
+	if info.Commit.IsZero() {
+		return nil
+	}
+	commit, err := repo.Store().GetCommit(info.Commit)
+	if err != nil {
+		return err
+	}
+	printCommitHeader(info.Commit, commit, "")
+	return nil
+}
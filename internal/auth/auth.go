@@ -1,6 +1,9 @@
 package auth
 
-import "net/http"
+import (
+	"net/http"
+	"os"
+)
 
 type Authenticator interface {
 	Authenticate(*http.Request) error
@@ -33,3 +36,15 @@ func (a *TokenAuth) Authenticate(r *http.Request) error {
 	r.Header.Set("Authorization", "Bearer "+a.Token)
 	return nil
 }
+
+// FromEnv returns an authenticator configured from the environment.
+// ASL_AUTH_TOKEN takes precedence over ASL_AUTH_USERNAME/ASL_AUTH_PASSWORD.
+func FromEnv() Authenticator {
+	if token := os.Getenv("ASL_AUTH_TOKEN"); token != "" {
+		return &TokenAuth{Token: token}
+	}
+	if username := os.Getenv("ASL_AUTH_USERNAME"); username != "" {
+		return &BasicAuth{Username: username, Password: os.Getenv("ASL_AUTH_PASSWORD")}
+	}
+	return &NoneAuth{}
+}
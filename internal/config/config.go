@@ -0,0 +1,295 @@
+// Package config reads and writes Astral's INI-style configuration files
+// and merges them into a single view.
+//
+// A file consists of sections holding "key = value" entries:
+//
+//	[user]
+//		name = Ada Lovelace
+//	[remote "origin"]
+//		url = https://example.com/repo
+//
+// Entries are addressed as section.key, or section.subsection.key for
+// sections with a quoted subsection name. Section and key names are case
+// insensitive; subsection names are not. Values may be quoted to keep
+// leading or trailing spaces and the comment characters # and ;.
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// Scope identifies where a setting comes from. Later scopes override
+// earlier ones.
+type Scope int
+
+const (
+	ScopeSystem Scope = iota // SystemPath, shared by every user
+	ScopeGlobal              // GlobalPath, the user's own settings
+	ScopeRepo                // .asl/config/config in a repository
+	ScopeEnv                 // Environment variables such as ASL_AUTHOR_NAME
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeSystem:
+		return "system"
+	case ScopeGlobal:
+		return "global"
+	case ScopeRepo:
+		return "repo"
+	case ScopeEnv:
+		return "env"
+	}
+	return "unknown"
+}
+
+// envKeys maps environment variables to the settings they override
+var envKeys = []struct{ env, key string }{
+	{"ASL_AUTHOR_NAME", "user.name"},
+	{"ASL_AUTHOR_EMAIL", "user.email"},
+}
+
+// Entry is a single setting
+type Entry struct {
+	Key   string // Canonical key: section and name in lower case
+	Value string
+	Scope Scope
+}
+
+// SystemPath returns the system-wide config file, $ASL_CONFIG_SYSTEM or
+// /etc/aslconfig
+func SystemPath() string {
+	if path := os.Getenv("ASL_CONFIG_SYSTEM"); path != "" {
+		return path
+	}
+	return filepath.Join(string(filepath.Separator), "etc", "aslconfig")
+}
+
+// GlobalPath returns the user's config file, $ASL_CONFIG_GLOBAL or
+// ~/.aslconfig, or "" if no home directory is known
+func GlobalPath() string {
+	if path := os.Getenv("ASL_CONFIG_GLOBAL"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".aslconfig")
+}
+
+// RepoPath returns the config file of the repository at root
+func RepoPath(root string) string {
+	return filepath.Join(root, ".asl", "config", "config")
+}
+
+// Config is the merged view of the system, global and repository files
+// and the environment
+type Config struct {
+	files map[Scope]*File
+	env   []Entry
+}
+
+// Load reads every config file that applies to the repository at root.
+// An empty root loads only the system and global files. Missing files
+// count as empty.
+func Load(root string) (*Config, error) {
+	c := &Config{files: make(map[Scope]*File)}
+
+	paths := map[Scope]string{ScopeSystem: SystemPath(), ScopeGlobal: GlobalPath()}
+	if root != "" {
+		paths[ScopeRepo] = RepoPath(root)
+	}
+	for scope, path := range paths {
+		if path == "" {
+			continue
+		}
+		f, err := ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		c.files[scope] = f
+	}
+
+	for _, e := range envKeys {
+		if value := os.Getenv(e.env); value != "" {
+			c.env = append(c.env, Entry{Key: e.key, Value: value, Scope: ScopeEnv})
+		}
+	}
+	return c, nil
+}
+
+// File returns the file backing a scope, or nil for the environment or a
+// file that does not apply, e.g. the repository file outside a repository
+func (c *Config) File(scope Scope) *File {
+	return c.files[scope]
+}
+
+// Entries returns every setting from the lowest scope to the highest, in
+// file order within each scope
+func (c *Config) Entries() []Entry {
+	var entries []Entry
+	for _, scope := range []Scope{ScopeSystem, ScopeGlobal, ScopeRepo} {
+		if f := c.files[scope]; f != nil {
+			for _, e := range f.Entries() {
+				e.Scope = scope
+				entries = append(entries, e)
+			}
+		}
+	}
+	return append(entries, c.env...)
+}
+
+// Get returns the value of a key from the highest scope that sets it
+func (c *Config) Get(key string) (string, bool) {
+	values := c.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value of a multi-valued key, lowest scope first
+func (c *Config) GetAll(key string) []string {
+	canonical, err := CanonicalKey(key)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, e := range c.Entries() {
+		if e.Key == canonical {
+			values = append(values, e.Value)
+		}
+	}
+	return values
+}
+
+// GetString returns the value of a key, or def if it is not set
+func (c *Config) GetString(key, def string) string {
+	if value, ok := c.Get(key); ok {
+		return value
+	}
+	return def
+}
+
+// GetBool returns a boolean setting, or def if it is not set. true, yes,
+// on and 1 are true; false, no, off, 0 and the empty string are false.
+func (c *Config) GetBool(key string, def bool) (bool, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return def, fmt.Errorf("%w: %s: %q is not a boolean", core.ErrInvalidConfig, key, value)
+}
+
+// GetInt returns an integer setting, or def if it is not set. A k, m or g
+// suffix multiplies the value by 1024, 1024² or 1024³.
+func (c *Config) GetInt(key string, def int) (int, error) {
+	value, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+
+	digits, scale := value, 1
+	if n := len(value); n > 0 {
+		switch value[n-1] {
+		case 'k', 'K':
+			digits, scale = value[:n-1], 1<<10
+		case 'm', 'M':
+			digits, scale = value[:n-1], 1<<20
+		case 'g', 'G':
+			digits, scale = value[:n-1], 1<<30
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(digits))
+	if err != nil {
+		return def, fmt.Errorf("%w: %s: %q is not an integer", core.ErrInvalidConfig, key, value)
+	}
+	return n * scale, nil
+}
+
+// Subsections returns the subsection names of a section, e.g. the remote
+// names for "remote", in the order they first appear
+func (c *Config) Subsections(section string) []string {
+	section = strings.ToLower(section)
+	seen := make(map[string]bool)
+	var names []string
+	for _, e := range c.Entries() {
+		s, sub, _, err := splitKey(e.Key)
+		if err != nil || s != section || sub == "" || seen[sub] {
+			continue
+		}
+		seen[sub] = true
+		names = append(names, sub)
+	}
+	return names
+}
+
+// CanonicalKey validates a key and returns it with the section and name
+// in lower case
+func CanonicalKey(key string) (string, error) {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return "", err
+	}
+	return joinKey(section, subsection, name), nil
+}
+
+// splitKey splits section[.subsection].name, lower-casing the section and
+// name. The subsection is everything between the first and last dot.
+func splitKey(key string) (section, subsection, name string, err error) {
+	first, last := strings.IndexByte(key, '.'), strings.LastIndexByte(key, '.')
+	if first < 0 {
+		return "", "", "", fmt.Errorf("%w: key %q has no section", core.ErrInvalidConfig, key)
+	}
+	section, name = strings.ToLower(key[:first]), strings.ToLower(key[last+1:])
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+	if !validName(section, true) || !validName(name, false) {
+		return "", "", "", fmt.Errorf("%w: invalid key %q", core.ErrInvalidConfig, key)
+	}
+	return section, subsection, name, nil
+}
+
+// joinKey is the inverse of splitKey
+func joinKey(section, subsection, name string) string {
+	if subsection == "" {
+		return section + "." + name
+	}
+	return section + "." + subsection + "." + name
+}
+
+// validName reports whether s is a valid section name (letters, digits
+// and dashes, plus dots when dots is set) or key name (the same, starting
+// with a letter)
+func validName(s string, dots bool) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case (c >= '0' && c <= '9') || c == '-':
+			if !dots && i == 0 {
+				return false
+			}
+		case c == '.' && dots:
+		default:
+			return false
+		}
+	}
+	return true
+}
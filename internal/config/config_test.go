@@ -0,0 +1,272 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+func mustParse(t *testing.T, text string) *File {
+	t.Helper()
+	f, err := Parse(text)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return f
+}
+
+func writeConfig(t *testing.T, path, text string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParse_Values(t *testing.T) {
+	f := mustParse(t, `# leading comment
+[Core]
+	RepositoryFormatVersion = 2
+	bare
+[user]
+	name = Ada Lovelace   ; trailing comment
+	email = "ada@example.com"
+	motto = "  spaced # not a comment  "
+	escaped = a\tb\\c\"d
+[remote "Origin.Main"]
+	url = https://example.com/repo
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+`)
+
+	tests := map[string]string{
+		"core.repositoryformatversion":  "2",
+		"CORE.RepositoryFormatVersion":  "2",
+		"core.bare":                     "true",
+		"user.name":                     "Ada Lovelace",
+		"user.email":                    "ada@example.com",
+		"user.motto":                    "  spaced # not a comment  ",
+		"user.escaped":                  "a\tb\\c\"d",
+		"remote.Origin.Main.url":        "https://example.com/repo",
+		"remote.Origin.Main.fetch":      "+refs/tags/*:refs/tags/*",
+		"REMOTE.Origin.Main.FETCH":      "+refs/tags/*:refs/tags/*",
+		"remote.origin.main.url":        "",
+		"user.missing":                  "",
+		"missing.section.and.key":       "",
+		"remote.Origin.Main.pushurl":    "",
+		"core.repositoryformatversion2": "",
+	}
+	for key, want := range tests {
+		got, ok := f.Get(key)
+		if got != want || ok != (want != "") {
+			t.Errorf("Get(%q) = %q, %v; want %q", key, got, ok, want)
+		}
+	}
+
+	fetch, err := f.GetAll("remote.Origin.Main.fetch")
+	if err != nil || len(fetch) != 2 {
+		t.Errorf("GetAll = %q, %v; want both refspecs", fetch, err)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, text := range []string{
+		"key = value",
+		"[core",
+		"[core] junk",
+		"[bad_name]",
+		`[remote "origin]`,
+		"[core]\n\t1key = x",
+		"[core]\n\tkey value",
+		"[core]\n\tkey = \"unterminated",
+		"[core]\n\tkey = bad\\q",
+		"[core]\n\tkey = trailing\\",
+	} {
+		if _, err := Parse(text); !errors.Is(err, core.ErrInvalidConfig) {
+			t.Errorf("Parse(%q): expected ErrInvalidConfig, got %v", text, err)
+		}
+	}
+}
+
+func TestFile_EditsKeepLayout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	writeConfig(t, path, `# my settings
+[core]
+	repositoryformatversion = 1 # keep me
+
+[user]
+	name = Old
+`)
+
+	f, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, kv := range [][2]string{
+		{"user.name", "New Name"},
+		{"user.email", "new@example.com"},
+		{"core.editor", "vi"},
+		{`branch.feature/"x".merge`, "refs/heads/x"},
+		{"alias.note", " # spaced "},
+	} {
+		if err := f.Set(kv[0], kv[1]); err != nil {
+			t.Fatalf("Set(%q): %v", kv[0], err)
+		}
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# my settings
+[core]
+	repositoryformatversion = 1 # keep me
+	editor = vi
+
+[user]
+	name = New Name
+	email = new@example.com
+[branch "feature/\"x\""]
+	merge = refs/heads/x
+[alias]
+	note = " # spaced "
+`
+	if string(data) != want {
+		t.Errorf("saved config:\n%s\nwant:\n%s", data, want)
+	}
+
+	reread, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reread.Get(`branch.feature/"x".merge`); got != "refs/heads/x" {
+		t.Errorf("subsection with quotes read back as %q", got)
+	}
+	if got, _ := reread.Get("alias.note"); got != " # spaced " {
+		t.Errorf("quoted value read back as %q", got)
+	}
+}
+
+func TestFile_SetAddUnset(t *testing.T) {
+	f := mustParse(t, "[remote \"origin\"]\n\turl = a\n\tfetch = one\n\tfetch = two\n[core]\n\tx = 1\n")
+
+	if err := f.Add("remote.origin.fetch", "three"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := f.GetAll("remote.origin.fetch"); len(values) != 3 || values[2] != "three" {
+		t.Errorf("after Add, fetch = %q", values)
+	}
+
+	// Set collapses a multi-valued key into one value
+	if err := f.Set("remote.origin.fetch", "only"); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := f.GetAll("remote.origin.fetch"); len(values) != 1 || values[0] != "only" {
+		t.Errorf("after Set, fetch = %q", values)
+	}
+
+	if removed, err := f.Unset("remote.origin.missing"); removed || err != nil {
+		t.Errorf("Unset of a missing key = %v, %v", removed, err)
+	}
+	for _, key := range []string{"remote.origin.fetch", "remote.origin.url"} {
+		if removed, err := f.Unset(key); !removed || err != nil {
+			t.Errorf("Unset(%q) = %v, %v", key, removed, err)
+		}
+	}
+	for _, l := range f.lines {
+		if l.kind == lineHeader && l.section == "remote" {
+			t.Error("an emptied section should be removed")
+		}
+	}
+
+	if !f.RemoveSection("CORE", "") || f.RemoveSection("core", "") {
+		t.Error("RemoveSection should report whether the section existed")
+	}
+	if len(f.Entries()) != 0 {
+		t.Errorf("entries left: %+v", f.Entries())
+	}
+
+	for _, key := range []string{"nosection", "core.", ".name", "core.1x", "co re.x"} {
+		if err := f.Set(key, "v"); !errors.Is(err, core.ErrInvalidConfig) {
+			t.Errorf("Set(%q): expected ErrInvalidConfig, got %v", key, err)
+		}
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "repo")
+	system, global := filepath.Join(dir, "system"), filepath.Join(dir, "global")
+	t.Setenv("ASL_CONFIG_SYSTEM", system)
+	t.Setenv("ASL_CONFIG_GLOBAL", global)
+	t.Setenv("ASL_AUTHOR_NAME", "")
+	t.Setenv("ASL_AUTHOR_EMAIL", "")
+
+	writeConfig(t, system, "[user]\n\tname = System\n\temail = system@example.com\n[pack]\n\twindow = 10\n\tbig = 2k\n[remote \"b\"]\n\turl = b\n")
+	writeConfig(t, global, "[user]\n\tname = Global\n[gc]\n\tauto = off\n")
+	writeConfig(t, RepoPath(root), "[user]\n\tname = Repo\n[remote \"a\"]\n\turl = a\n[remote \"b\"]\n\tpushurl = b2\n")
+
+	c, err := Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := c.Get("user.name"); got != "Repo" {
+		t.Errorf("user.name = %q, want the repository's value", got)
+	}
+	if got := c.GetString("user.email", ""); got != "system@example.com" {
+		t.Errorf("user.email = %q, want the system value", got)
+	}
+	if got := c.GetAll("user.name"); len(got) != 3 || got[0] != "System" {
+		t.Errorf("GetAll(user.name) = %q, want every scope, lowest first", got)
+	}
+	if n, err := c.GetInt("pack.window", 0); n != 10 || err != nil {
+		t.Errorf("GetInt(pack.window) = %d, %v", n, err)
+	}
+	if n, err := c.GetInt("pack.big", 0); n != 2048 || err != nil {
+		t.Errorf("GetInt(pack.big) = %d, %v", n, err)
+	}
+	if n, err := c.GetInt("pack.missing", 7); n != 7 || err != nil {
+		t.Errorf("GetInt default = %d, %v", n, err)
+	}
+	if _, err := c.GetInt("user.name", 0); !errors.Is(err, core.ErrInvalidConfig) {
+		t.Errorf("GetInt of a name: expected ErrInvalidConfig, got %v", err)
+	}
+	if b, err := c.GetBool("gc.auto", true); b || err != nil {
+		t.Errorf("GetBool(gc.auto) = %v, %v", b, err)
+	}
+	if _, err := c.GetBool("user.name", false); !errors.Is(err, core.ErrInvalidConfig) {
+		t.Errorf("GetBool of a name: expected ErrInvalidConfig, got %v", err)
+	}
+	if got := c.Subsections("remote"); len(got) != 2 || got[0] != "b" || got[1] != "a" {
+		t.Errorf("Subsections(remote) = %q", got)
+	}
+
+	// The environment overrides every file
+	t.Setenv("ASL_AUTHOR_NAME", "Env")
+	if c, err = Load(root); err != nil {
+		t.Fatal(err)
+	}
+	entries := c.Entries()
+	if last := entries[len(entries)-1]; last.Key != "user.name" || last.Value != "Env" || last.Scope != ScopeEnv {
+		t.Errorf("last entry = %+v, want the environment override", last)
+	}
+	if got, _ := c.Get("user.name"); got != "Env" {
+		t.Errorf("user.name = %q, want the environment's value", got)
+	}
+
+	// Outside a repository only the system and global files apply
+	if c, err = Load(""); err != nil {
+		t.Fatal(err)
+	}
+	if c.File(ScopeRepo) != nil || c.File(ScopeGlobal).Path() != global {
+		t.Error("Load without a repository should skip the repository file")
+	}
+}
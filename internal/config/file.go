@@ -0,0 +1,435 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+type lineKind int
+
+const (
+	lineOther  lineKind = iota // Blank line or comment
+	lineHeader                 // [section "subsection"]
+	lineEntry                  // key = value
+)
+
+// line is one line of a config file. Headers and entries record the
+// section they belong to so edits can find their place.
+type line struct {
+	raw        string
+	kind       lineKind
+	section    string
+	subsection string
+	name       string
+	value      string
+}
+
+// File is a single config file. Edits keep the other lines, including
+// comments and layout, as they were.
+type File struct {
+	path  string
+	lines []line
+}
+
+// ReadFile parses the config file at path. A missing file reads as empty
+// and is created by Save.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &File{path: path}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	f, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	f.path = path
+	return f, nil
+}
+
+// Parse parses config text that is not backed by a file
+func Parse(text string) (*File, error) {
+	f := &File{}
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return f, nil
+	}
+
+	var section, subsection string
+	for i, raw := range strings.Split(text, "\n") {
+		l := line{raw: raw}
+		trimmed := strings.TrimSpace(raw)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+			l.kind, l.section, l.subsection = lineOther, section, subsection
+
+		case trimmed[0] == '[':
+			var err error
+			if section, subsection, err = parseHeader(trimmed); err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", core.ErrInvalidConfig, i+1, err)
+			}
+			l.kind, l.section, l.subsection = lineHeader, section, subsection
+
+		default:
+			if section == "" {
+				return nil, fmt.Errorf("%w: line %d: entry outside a section", core.ErrInvalidConfig, i+1)
+			}
+			name, value, err := parseEntry(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("%w: line %d: %v", core.ErrInvalidConfig, i+1, err)
+			}
+			l.kind, l.section, l.subsection, l.name, l.value = lineEntry, section, subsection, name, value
+		}
+		f.lines = append(f.lines, l)
+	}
+	return f, nil
+}
+
+// parseHeader parses [section] or [section "subsection"], followed by an
+// optional comment
+func parseHeader(s string) (section, subsection string, err error) {
+	end := strings.IndexByte(s, ']')
+	if quote := strings.IndexByte(s, '"'); quote >= 0 && quote < end {
+		// The subsection may itself contain ']'
+		section = strings.TrimSpace(s[1:quote])
+		var rest string
+		if subsection, rest, err = parseQuoted(s[quote+1:]); err != nil {
+			return "", "", err
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return "", "", fmt.Errorf("expected ] after subsection")
+		}
+		s = rest[1:]
+	} else {
+		if end < 0 {
+			return "", "", fmt.Errorf("unterminated section header")
+		}
+		section = strings.TrimSpace(s[1:end])
+		s = s[end+1:]
+	}
+
+	if rest := strings.TrimSpace(s); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return "", "", fmt.Errorf("unexpected %q after section header", rest)
+	}
+	if !validName(section, true) {
+		return "", "", fmt.Errorf("invalid section name %q", section)
+	}
+	return strings.ToLower(section), subsection, nil
+}
+
+// parseQuoted reads a quoted subsection name up to its closing quote,
+// returning the name and what follows the quote
+func parseQuoted(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", "", fmt.Errorf("unterminated subsection name")
+			}
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", fmt.Errorf("unterminated subsection name")
+}
+
+// parseEntry parses "name = value" or a bare "name", which means true
+func parseEntry(s string) (name, value string, err error) {
+	end := strings.IndexAny(s, "= \t#;")
+	if end < 0 {
+		end = len(s)
+	}
+	name = s[:end]
+	if !validName(name, false) {
+		return "", "", fmt.Errorf("invalid key name %q", name)
+	}
+
+	rest := strings.TrimSpace(s[end:])
+	if rest == "" || rest[0] == '#' || rest[0] == ';' {
+		return strings.ToLower(name), "true", nil
+	}
+	if rest[0] != '=' {
+		return "", "", fmt.Errorf("expected = after %q", name)
+	}
+	value, err = parseValue(rest[1:])
+	return strings.ToLower(name), value, err
+}
+
+// parseValue unquotes and unescapes a value, dropping any trailing comment
+// and surrounding whitespace outside quotes
+func parseValue(s string) (string, error) {
+	var b strings.Builder
+	quoted := false
+	keep := 0 // Length of b up to the last quoted or non-space byte
+	s = strings.TrimLeft(s, " \t")
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+			keep = b.Len()
+			continue
+		case !quoted && (c == '#' || c == ';'):
+			return b.String()[:keep], nil
+		case c == '\\':
+			if i+1 == len(s) {
+				return "", fmt.Errorf("value ends with a backslash")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case '\\', '"':
+				c = s[i]
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", s[i])
+			}
+			b.WriteByte(c)
+			keep = b.Len()
+			continue
+		}
+
+		b.WriteByte(c)
+		if quoted || (c != ' ' && c != '\t') {
+			keep = b.Len()
+		}
+	}
+	if quoted {
+		return "", fmt.Errorf("unterminated quote")
+	}
+	return b.String()[:keep], nil
+}
+
+// formatValue quotes and escapes a value so parseValue reads it back
+func formatValue(value string) string {
+	needsQuotes := value != strings.TrimSpace(value) || strings.ContainsAny(value, "#;")
+	var b strings.Builder
+	for _, c := range value {
+		switch c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	if needsQuotes {
+		return `"` + b.String() + `"`
+	}
+	return b.String()
+}
+
+// formatHeader returns the header line of a section
+func formatHeader(section, subsection string) string {
+	if subsection == "" {
+		return "[" + section + "]"
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+	return fmt.Sprintf("[%s \"%s\"]", section, escaped)
+}
+
+// Path returns the file's location
+func (f *File) Path() string {
+	return f.path
+}
+
+// Entries returns every setting in file order. Scope is left zero.
+func (f *File) Entries() []Entry {
+	var entries []Entry
+	for _, l := range f.lines {
+		if l.kind == lineEntry {
+			entries = append(entries, Entry{Key: joinKey(l.section, l.subsection, l.name), Value: l.value})
+		}
+	}
+	return entries
+}
+
+// Get returns the last value of key in this file
+func (f *File) Get(key string) (string, bool) {
+	values, err := f.GetAll(key)
+	if err != nil || len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value of key in this file
+func (f *File) GetAll(key string) ([]string, error) {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return nil, err
+	}
+	var values []string
+	for _, l := range f.lines {
+		if l.kind == lineEntry && l.matches(section, subsection) && l.name == name {
+			values = append(values, l.value)
+		}
+	}
+	return values, nil
+}
+
+// Set sets key to value, replacing every existing value. A new key goes at
+// the end of its section, which is created if needed.
+func (f *File) Set(key, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+
+	last := -1
+	for i, l := range f.lines {
+		if l.kind == lineEntry && l.matches(section, subsection) && l.name == name {
+			last = i
+		}
+	}
+	if last < 0 {
+		f.insert(section, subsection, name, value)
+		return nil
+	}
+
+	f.lines[last].value = value
+	f.lines[last].raw = formatEntry(name, value)
+	f.filter(func(i int, l line) bool {
+		return i == last || l.kind != lineEntry || !l.matches(section, subsection) || l.name != name
+	})
+	return nil
+}
+
+// Add adds another value for a multi-valued key such as remote.*.fetch
+func (f *File) Add(key, value string) error {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return err
+	}
+	f.insert(section, subsection, name, value)
+	return nil
+}
+
+// Unset removes every value of key, reporting whether there were any. A
+// section left without entries is removed as well.
+func (f *File) Unset(key string) (bool, error) {
+	section, subsection, name, err := splitKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	before := len(f.lines)
+	f.filter(func(_ int, l line) bool {
+		return l.kind != lineEntry || !l.matches(section, subsection) || l.name != name
+	})
+	if len(f.lines) == before {
+		return false, nil
+	}
+
+	// Drop the section if nothing but blank lines remain in it
+	empty := true
+	for _, l := range f.lines {
+		if l.kind != lineHeader && l.matches(section, subsection) && strings.TrimSpace(l.raw) != "" {
+			empty = false
+		}
+	}
+	if empty {
+		f.RemoveSection(section, subsection)
+	}
+	return true, nil
+}
+
+// RemoveSection removes a section and everything in it, reporting whether
+// it existed
+func (f *File) RemoveSection(section, subsection string) bool {
+	section = strings.ToLower(section)
+	before := len(f.lines)
+	f.filter(func(_ int, l line) bool {
+		return !l.matches(section, subsection)
+	})
+	return len(f.lines) != before
+}
+
+// Save writes the file, creating its directory if needed
+func (f *File) Save() error {
+	var b strings.Builder
+	for _, l := range f.lines {
+		b.WriteString(l.raw)
+		b.WriteByte('\n')
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// matches reports whether the line belongs to the given section. Blank
+// lines and comments belong to the section they appear in.
+func (l line) matches(section, subsection string) bool {
+	return l.section == section && l.subsection == subsection
+}
+
+// insert adds an entry after the last line of its section, appending the
+// section if the file has none
+func (f *File) insert(section, subsection, name, value string) {
+	entry := line{
+		raw:        formatEntry(name, value),
+		kind:       lineEntry,
+		section:    section,
+		subsection: subsection,
+		name:       name,
+		value:      value,
+	}
+
+	last := -1
+	for i, l := range f.lines {
+		if l.kind != lineOther && l.matches(section, subsection) {
+			last = i
+		}
+	}
+	if last < 0 {
+		header := line{raw: formatHeader(section, subsection), kind: lineHeader, section: section, subsection: subsection}
+		f.lines = append(f.lines, header, entry)
+		return
+	}
+	f.lines = append(f.lines[:last+1], append([]line{entry}, f.lines[last+1:]...)...)
+}
+
+// filter keeps the lines for which keep returns true
+func (f *File) filter(keep func(int, line) bool) {
+	kept := f.lines[:0]
+	for i, l := range f.lines {
+		if keep(i, l) {
+			kept = append(kept, l)
+		}
+	}
+	f.lines = kept
+}
+
+// formatEntry returns the line for an entry
+func formatEntry(name, value string) string {
+	return "\t" + name + " = " + formatValue(value)
+}
@@ -7,22 +7,39 @@ var (
 	ErrNotARepository    = errors.New("not an astral repository")
 	ErrAlreadyRepository = errors.New("already an astral repository")
 	ErrInvalidConfig     = errors.New("invalid configuration")
+	ErrConfigKeyNotFound = errors.New("config key not found")
 
 	// Object errors
 	ErrObjectNotFound = errors.New("object not found")
 	ErrInvalidObject  = errors.New("invalid object format")
 	ErrInvalidHash    = errors.New("invalid hash")
+	ErrCorruptPack    = errors.New("corrupt pack file")
 
 	// Branch errors
-	ErrBranchNotFound                   = errors.New("branch not found")
-	ErrBranchExists                     = errors.New("branch already exists")
+	ErrBranchNotFound    = errors.New("branch not found")
+	ErrBranchExists      = errors.New("branch already exists")
 	ErrInvalidBranchName = errors.New("invalid branch name")
+	ErrDetachedHead      = errors.New("HEAD is detached")
+	ErrBranchNotMerged   = errors.New("branch is not fully merged")
+	ErrBranchCheckedOut  = errors.New("branch is checked out")
+	ErrRefLocked         = errors.New("ref is locked by another process")
+	ErrStaleRef          = errors.New("ref has changed since it was read")
+
+	// Tag errors
+	ErrTagNotFound    = errors.New("tag not found")
+	ErrTagExists      = errors.New("tag already exists")
+	ErrInvalidTagName = errors.New("invalid tag name")
+	ErrInvalidTag     = errors.New("invalid tag")
 
 	// Commit errors
 	ErrNoCommits       = errors.New("no commits yet")
 	ErrInvalidCommit   = errors.New("invalid commit")
 	ErrNothingToCommit = errors.New("nothing to commit")
 
+	// Revision errors
+	ErrUnknownRevision   = errors.New("unknown revision")
+	ErrAmbiguousRevision = errors.New("ambiguous revision")
+
 	// Working directory errors
 	ErrDirtyWorkingDir = errors.New("working directory has uncommitted changes")
 	ErrFileNotFound    = errors.New("file not found")
@@ -33,4 +50,16 @@ var (
 	ErrNoMergeInProgress = errors.New("no merge in progress")
 	ErrConflictsExist    = errors.New("unresolved conflicts exist")
 	ErrInvalidStrategy   = errors.New("invalid merge strategy")
+	ErrOctopusConflict   = errors.New("octopus merge aborted")
+
+	// Remote errors
+	ErrRemoteNotFound  = errors.New("remote not found")
+	ErrNoUpstream      = errors.New("no upstream configured")
+	ErrNonFastForward  = errors.New("non-fast-forward update rejected")
+	ErrDestinationUsed = errors.New("destination path already exists and is not empty")
+
+	// Operation log errors
+	ErrOperationNotFound = errors.New("operation not found")
+	ErrNothingToUndo     = errors.New("nothing to undo")
+	ErrNothingToRedo     = errors.New("nothing to redo")
 )
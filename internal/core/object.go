@@ -3,6 +3,7 @@ package core
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +14,7 @@ const (
 	ObjectTypeBlob   ObjectType = "blob"
 	ObjectTypeTree   ObjectType = "tree"
 	ObjectTypeCommit ObjectType = "commit"
+	ObjectTypeTag    ObjectType = "tag"
 )
 
 // Object represents a generic object in the database
@@ -32,13 +34,40 @@ type Commit struct {
 	Message   string
 }
 
-// TreeEntry represents an entry in a tree object
+// Tag represents an annotated tag object: a named, signed-off pointer to
+// another object, usually a commit
+type Tag struct {
+	Target     Hash
+	TargetType ObjectType
+	Name       string
+	Tagger     string
+	Email      string
+	Timestamp  time.Time
+	Message    string
+}
+
+// Tree entry modes
+const (
+	ModeFile       uint32 = 0100644
+	ModeExecutable uint32 = 0100755
+	ModeDir        uint32 = 0040000
+
+	modeTypeMask uint32 = 0170000
+)
+
+// TreeEntry represents an entry in a tree object. Directory entries
+// (ModeDir) point to subtrees and are named by a single path component.
 type TreeEntry struct {
 	Mode uint32
 	Name string
 	Hash Hash
 }
 
+// IsDir reports whether the entry is a subtree
+func (e TreeEntry) IsDir() bool {
+	return e.Mode&modeTypeMask == ModeDir
+}
+
 // Tree represents a tree object
 type Tree struct {
 	Entries []TreeEntry
@@ -130,6 +159,144 @@ func DecodeCommit(data []byte) (*Commit, error) {
 	return commit, nil
 }
 
+// ValidateCommit checks that data is a well-formed commit: a tree header,
+// any parent headers, an author header, then a blank line and the message.
+// DecodeCommit is more lenient and skips lines it does not understand.
+func ValidateCommit(data []byte) error {
+	header, _, found := bytes.Cut(data, []byte("\n\n"))
+	if !found {
+		return fmt.Errorf("%w: missing blank line before message", ErrInvalidCommit)
+	}
+
+	var seenTree, seenAuthor bool
+	for i, line := range bytes.Split(header, []byte("\n")) {
+		key, value, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			return fmt.Errorf("%w: malformed header line %d", ErrInvalidCommit, i+1)
+		}
+
+		switch string(key) {
+		case "tree":
+			if i != 0 {
+				return fmt.Errorf("%w: tree must be the first header", ErrInvalidCommit)
+			}
+			if _, err := ParseHash(string(value)); err != nil {
+				return fmt.Errorf("%w: invalid tree hash", ErrInvalidCommit)
+			}
+			seenTree = true
+
+		case "parent":
+			if !seenTree || seenAuthor {
+				return fmt.Errorf("%w: parent header out of order", ErrInvalidCommit)
+			}
+			if _, err := ParseHash(string(value)); err != nil {
+				return fmt.Errorf("%w: invalid parent hash", ErrInvalidCommit)
+			}
+
+		case "author":
+			if seenAuthor {
+				return fmt.Errorf("%w: duplicate author header", ErrInvalidCommit)
+			}
+			emailStart := bytes.IndexByte(value, '<')
+			emailEnd := bytes.LastIndexByte(value, '>')
+			if emailStart == -1 || emailEnd < emailStart {
+				return fmt.Errorf("%w: invalid author email", ErrInvalidCommit)
+			}
+			if _, err := strconv.ParseInt(string(bytes.TrimSpace(value[emailEnd+1:])), 10, 64); err != nil {
+				return fmt.Errorf("%w: invalid author timestamp", ErrInvalidCommit)
+			}
+			seenAuthor = true
+
+		default:
+			return fmt.Errorf("%w: unknown header %q", ErrInvalidCommit, key)
+		}
+	}
+
+	if !seenTree {
+		return fmt.Errorf("%w: missing tree header", ErrInvalidCommit)
+	}
+	if !seenAuthor {
+		return fmt.Errorf("%w: missing author header", ErrInvalidCommit)
+	}
+	return nil
+}
+
+// EncodeTag serializes a tag into bytes
+func EncodeTag(t *Tag) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "object %s\n", t.Target.String())
+	fmt.Fprintf(&buf, "type %s\n", t.TargetType)
+	fmt.Fprintf(&buf, "tag %s\n", t.Name)
+	fmt.Fprintf(&buf, "tagger %s <%s> %d\n", t.Tagger, t.Email, t.Timestamp.Unix())
+	fmt.Fprintf(&buf, "\n%s\n", t.Message)
+
+	return buf.Bytes()
+}
+
+// DecodeTag deserializes a tag from bytes. Unlike commits, tags are
+// checked strictly: the object, type, tag and tagger headers must all be
+// present, in that order.
+func DecodeTag(data []byte) (*Tag, error) {
+	header, message, found := bytes.Cut(data, []byte("\n\n"))
+	if !found {
+		return nil, fmt.Errorf("%w: missing blank line before message", ErrInvalidTag)
+	}
+
+	lines := bytes.Split(header, []byte("\n"))
+	keys := []string{"object", "type", "tag", "tagger"}
+	if len(lines) != len(keys) {
+		return nil, fmt.Errorf("%w: expected %d header lines, got %d", ErrInvalidTag, len(keys), len(lines))
+	}
+
+	tag := &Tag{Message: string(bytes.TrimSpace(message))}
+	for i, line := range lines {
+		key, value, ok := bytes.Cut(line, []byte(" "))
+		if !ok || string(key) != keys[i] {
+			return nil, fmt.Errorf("%w: expected %s header on line %d", ErrInvalidTag, keys[i], i+1)
+		}
+
+		switch keys[i] {
+		case "object":
+			hash, err := ParseHash(string(value))
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid object hash", ErrInvalidTag)
+			}
+			tag.Target = hash
+
+		case "type":
+			switch typ := ObjectType(value); typ {
+			case ObjectTypeBlob, ObjectTypeTree, ObjectTypeCommit, ObjectTypeTag:
+				tag.TargetType = typ
+			default:
+				return nil, fmt.Errorf("%w: unknown object type %q", ErrInvalidTag, value)
+			}
+
+		case "tag":
+			if len(value) == 0 {
+				return nil, fmt.Errorf("%w: empty tag name", ErrInvalidTag)
+			}
+			tag.Name = string(value)
+
+		case "tagger":
+			emailStart := bytes.IndexByte(value, '<')
+			emailEnd := bytes.LastIndexByte(value, '>')
+			if emailStart == -1 || emailEnd < emailStart {
+				return nil, fmt.Errorf("%w: invalid tagger email", ErrInvalidTag)
+			}
+			timestamp, err := strconv.ParseInt(string(bytes.TrimSpace(value[emailEnd+1:])), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid tagger timestamp", ErrInvalidTag)
+			}
+			tag.Tagger = string(bytes.TrimSpace(value[:emailStart]))
+			tag.Email = string(value[emailStart+1 : emailEnd])
+			tag.Timestamp = time.Unix(timestamp, 0)
+		}
+	}
+
+	return tag, nil
+}
+
 // EncodeTree serializes a tree into bytes
 func EncodeTree(t *Tree) []byte {
 	var buf bytes.Buffer
@@ -151,8 +318,8 @@ func DecodeTree(data []byte) (*Tree, error) {
 	for len(data) > 0 {
 		// Find null terminator after mode and name
 		nullIdx := bytes.IndexByte(data, 0)
-		if nullIdx == -1 || nullIdx+32 > len(data) {
-			break
+		if nullIdx == -1 || nullIdx+33 > len(data) {
+			return nil, fmt.Errorf("%w: truncated tree entry", ErrInvalidObject)
 		}
 
 		// Parse mode and name
@@ -161,11 +328,13 @@ func DecodeTree(data []byte) (*Tree, error) {
 			return nil, ErrInvalidObject
 		}
 
-		var mode uint32
-		fmt.Sscanf(string(parts[0]), "%o", &mode)
+		mode, err := strconv.ParseUint(string(parts[0]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid tree entry mode %q", ErrInvalidObject, parts[0])
+		}
 
 		entry := TreeEntry{
-			Mode: mode,
+			Mode: uint32(mode),
 			Name: string(parts[1]),
 		}
 
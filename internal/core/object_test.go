@@ -1,6 +1,7 @@
 package core
 
 import (
+	"errors"
 	"testing"
 	"time"
 )
@@ -124,3 +125,106 @@ func TestEmptyTree(t *testing.T) {
 		t.Errorf("expected empty tree, got %d entries", len(decoded.Entries))
 	}
 }
+
+func TestDecodeTree_Truncated(t *testing.T) {
+	tree := &Tree{
+		Entries: []TreeEntry{
+			{Mode: 0100644, Name: "file1.txt", Hash: HashBytes([]byte("content1"))},
+			{Mode: 0100644, Name: "file2.txt", Hash: HashBytes([]byte("content2"))},
+		},
+	}
+	data := EncodeTree(tree)
+
+	for _, cut := range []int{1, 16, 32, 40} {
+		if _, err := DecodeTree(data[:len(data)-cut]); !errors.Is(err, ErrInvalidObject) {
+			t.Errorf("truncated by %d bytes: expected ErrInvalidObject, got %v", cut, err)
+		}
+	}
+}
+
+func TestValidateCommit(t *testing.T) {
+	valid := EncodeCommit(&Commit{
+		Tree:      HashBytes([]byte("tree")),
+		Parents:   []Hash{HashBytes([]byte("parent"))},
+		Author:    "Test User",
+		Email:     "test@example.com",
+		Timestamp: time.Unix(1700000000, 0),
+		Message:   "Message",
+	})
+	if err := ValidateCommit(valid); err != nil {
+		t.Fatalf("valid commit rejected: %v", err)
+	}
+
+	tree := HashBytes([]byte("tree")).String()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no message separator", "tree " + tree + "\nauthor A <a@b> 1\n"},
+		{"missing tree", "author A <a@b> 1\n\nmsg\n"},
+		{"missing author", "tree " + tree + "\n\nmsg\n"},
+		{"bad tree hash", "tree xyz\nauthor A <a@b> 1\n\nmsg\n"},
+		{"bad timestamp", "tree " + tree + "\nauthor A <a@b> soon\n\nmsg\n"},
+		{"unknown header", "tree " + tree + "\nauthor A <a@b> 1\ncolour blue\n\nmsg\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateCommit([]byte(tt.data)); !errors.Is(err, ErrInvalidCommit) {
+				t.Errorf("expected ErrInvalidCommit, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeTag(t *testing.T) {
+	original := &Tag{
+		Target:     HashBytes([]byte("commit")),
+		TargetType: ObjectTypeCommit,
+		Name:       "v1.0",
+		Tagger:     "Test Tagger",
+		Email:      "tagger@example.com",
+		Timestamp:  time.Unix(1700000000, 0),
+		Message:    "Release 1.0\n\nFirst stable release",
+	}
+
+	decoded, err := DecodeTag(EncodeTag(original))
+	if err != nil {
+		t.Fatalf("failed to decode tag: %v", err)
+	}
+	if decoded.Target != original.Target || decoded.TargetType != original.TargetType || decoded.Name != original.Name {
+		t.Errorf("decoded %+v, want %+v", decoded, original)
+	}
+	if decoded.Tagger != original.Tagger || decoded.Email != original.Email || !decoded.Timestamp.Equal(original.Timestamp) {
+		t.Errorf("tagger mismatch: %+v", decoded)
+	}
+	if decoded.Message != original.Message {
+		t.Errorf("message = %q, want %q", decoded.Message, original.Message)
+	}
+}
+
+func TestDecodeTag_Invalid(t *testing.T) {
+	object := HashBytes([]byte("commit")).String()
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no message separator", "object " + object + "\ntype commit\ntag v1\ntagger A <a@b> 1\n"},
+		{"missing tagger", "object " + object + "\ntype commit\ntag v1\n\nmsg\n"},
+		{"headers out of order", "type commit\nobject " + object + "\ntag v1\ntagger A <a@b> 1\n\nmsg\n"},
+		{"bad object hash", "object xyz\ntype commit\ntag v1\ntagger A <a@b> 1\n\nmsg\n"},
+		{"unknown type", "object " + object + "\ntype note\ntag v1\ntagger A <a@b> 1\n\nmsg\n"},
+		{"empty name", "object " + object + "\ntype commit\ntag \ntagger A <a@b> 1\n\nmsg\n"},
+		{"bad timestamp", "object " + object + "\ntype commit\ntag v1\ntagger A <a@b> soon\n\nmsg\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeTag([]byte(tt.data)); !errors.Is(err, ErrInvalidTag) {
+				t.Errorf("expected ErrInvalidTag, got %v", err)
+			}
+		})
+	}
+}
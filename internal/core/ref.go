@@ -0,0 +1,50 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RefLockSuffix marks the lock file that claims a ref while it is updated
+const RefLockSuffix = ".lock"
+
+// CheckRefName reports why name cannot be used below refs/, e.g. as a
+// branch or tag name. Names are slash-separated like file paths.
+func CheckRefName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("name is empty")
+	case name == "HEAD" || name == "@":
+		return errors.New("name is reserved")
+	case strings.HasPrefix(name, "-"):
+		return errors.New("name starts with a dash")
+	case strings.Contains(name, ".."):
+		return errors.New("name contains ..")
+	case strings.Contains(name, "@{"):
+		return errors.New("name contains @{")
+	case strings.HasSuffix(name, "."):
+		return errors.New("name ends with a dot")
+	}
+
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return errors.New("name contains a control character")
+		}
+		if strings.ContainsRune(" ~^:?*[\\", c) {
+			return fmt.Errorf("name contains %q", c)
+		}
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		switch {
+		case component == "":
+			return errors.New("name has an empty component")
+		case strings.HasPrefix(component, "."):
+			return errors.New("name has a component starting with a dot")
+		case strings.HasSuffix(component, RefLockSuffix):
+			return fmt.Errorf("name has a component ending in %s", RefLockSuffix)
+		}
+	}
+	return nil
+}
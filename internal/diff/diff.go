@@ -1,6 +1,7 @@
 package diff
 
 import (
+	"fmt"
 	"strings"
 )
 
@@ -44,6 +45,12 @@ func MyersDiff(oldText, newText string) *Diff {
 	return &Diff{Hunks: hunks}
 }
 
+// Lines returns the complete edit script turning oldLines into newLines,
+// one edit per line
+func Lines(oldLines, newLines []string) []Edit {
+	return myersAlgorithm(oldLines, newLines)
+}
+
 // myersAlgorithm implements the Myers diff algorithm
 func myersAlgorithm(a, b []string) []Edit {
 	n := len(a)
@@ -74,15 +81,14 @@ func myersAlgorithm(a, b []string) []Edit {
 
 	// V array stores furthest reaching D-path
 	v := make([]int, 2*max+1)
-	trace := make([]map[int]int, 0)
+	trace := make([][]int, 0)
 
 	// Find the shortest edit script
 	for d := 0; d <= max; d++ {
-		// Save current V for backtracking
-		vCopy := make(map[int]int)
-		for k := -d; k <= d; k += 2 {
-			vCopy[k] = v[k+max]
-		}
+		// Save current V for backtracking. The whole array is kept because
+		// backtracking reads the diagonals of the previous round (k±1).
+		vCopy := make([]int, len(v))
+		copy(vCopy, v)
 		trace = append(trace, vCopy)
 
 		for k := -d; k <= d; k += 2 {
@@ -107,7 +113,7 @@ func myersAlgorithm(a, b []string) []Edit {
 
 			// Check if we've reached the end
 			if x >= n && y >= m {
-				return backtrack(a, b, trace, d)
+				return backtrack(a, b, trace, d, max)
 			}
 		}
 	}
@@ -117,7 +123,7 @@ func myersAlgorithm(a, b []string) []Edit {
 }
 
 // backtrack reconstructs the edit script from the trace
-func backtrack(a, b []string, trace []map[int]int, d int) []Edit {
+func backtrack(a, b []string, trace [][]int, d, offset int) []Edit {
 	edits := make([]Edit, 0)
 	x := len(a)
 	y := len(b)
@@ -127,13 +133,13 @@ func backtrack(a, b []string, trace []map[int]int, d int) []Edit {
 		k := x - y
 
 		var prevK int
-		if k == -d || (k != d && v[k-1] < v[k+1]) {
+		if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
 			prevK = k + 1
 		} else {
 			prevK = k - 1
 		}
 
-		prevX := v[prevK]
+		prevX := v[prevK+offset]
 		prevY := prevX - prevK
 
 		// Add diagonal edits (equals)
@@ -276,7 +282,11 @@ func splitLines(text string) []string {
 // ApplyHunk applies a hunk to text
 func ApplyHunk(text string, hunk Hunk) (string, error) {
 	lines := splitLines(text)
-	result := make([]string, 0)
+	if hunk.OldStart < 0 || hunk.OldStart+hunk.OldCount > len(lines) {
+		return "", fmt.Errorf("hunk @@ -%d,%d @@ does not apply to %d lines", hunk.OldStart+1, hunk.OldCount, len(lines))
+	}
+
+	result := make([]string, 0, len(lines)+hunk.NewCount-hunk.OldCount)
 
 	// Add lines before hunk
 	result = append(result, lines[:hunk.OldStart]...)
@@ -292,20 +302,24 @@ func ApplyHunk(text string, hunk Hunk) (string, error) {
 	}
 
 	// Add lines after hunk
-	if hunk.OldStart+hunk.OldCount < len(lines) {
-		result = append(result, lines[hunk.OldStart+hunk.OldCount:]...)
-	}
+	result = append(result, lines[hunk.OldStart+hunk.OldCount:]...)
 
-	return strings.Join(result, "\n"), nil
+	joined := strings.Join(result, "\n")
+	if len(result) > 0 && (text == "" || strings.HasSuffix(text, "\n")) {
+		joined += "\n"
+	}
+	return joined, nil
 }
 
 // Patch applies all hunks in a diff
 func Patch(text string, diff *Diff) (string, error) {
 	result := text
 
-	for _, hunk := range diff.Hunks {
+	// Hunk positions refer to the original text, so apply them from the
+	// bottom up to keep earlier offsets valid.
+	for i := len(diff.Hunks) - 1; i >= 0; i-- {
 		var err error
-		result, err = ApplyHunk(result, hunk)
+		result, err = ApplyHunk(result, diff.Hunks[i])
 		if err != nil {
 			return "", err
 		}
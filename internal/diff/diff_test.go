@@ -1,6 +1,7 @@
 package diff
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -169,3 +170,72 @@ func BenchmarkMyersDiff_MediumFile(b *testing.B) {
 		MyersDiff(old, new)
 	}
 }
+
+func TestPatch_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+	}{
+		{"change at start", "a\nb\nc\n", "A\nb\nc\n"},
+		{"change at end", "a\nb\nc\n", "a\nb\nC\n"},
+		{"lines added", "a\nb\n", "x\na\ny\nb\nz\n"},
+		{"everything replaced", "a\nb\nc\n", "x\ny\n"},
+		{"from empty", "", "a\nb\n"},
+		{"to empty", "a\nb\n", ""},
+		{"hunks far apart", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n15\n", "one\n2\n3\n4\n5\n6\n7\nnew\n8\n9\n10\n11\n12\n13\n15\n"},
+		{"without a trailing newline", "a\nb\nc", "a\nB\nc"},
+		{"interleaved", "a\nb\nc\nd\ne\nf\n", "b\nX\nc\nd\nf\nY\n"},
+	}
+	for _, tt := range tests {
+		got, err := Patch(tt.old, MyersDiff(tt.old, tt.new))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.new {
+			t.Errorf("%s: Patch = %q, want %q", tt.name, got, tt.new)
+		}
+	}
+}
+
+func TestLines_ReconstructsBothSides(t *testing.T) {
+	// Long, dissimilar inputs take many rounds, so backtracking reads
+	// diagonals saved rounds earlier
+	var a, b []string
+	for i := 0; i < 40; i++ {
+		a = append(a, string(rune('a'+i%7)))
+		b = append(b, string(rune('a'+i%5)))
+	}
+
+	var oldLines, newLines []string
+	for _, edit := range Lines(a, b) {
+		if edit.Type != EditInsert {
+			oldLines = append(oldLines, edit.Text)
+		}
+		if edit.Type != EditDelete {
+			newLines = append(newLines, edit.Text)
+		}
+	}
+	if strings.Join(oldLines, ",") != strings.Join(a, ",") || strings.Join(newLines, ",") != strings.Join(b, ",") {
+		t.Errorf("edits do not reconstruct the inputs:\n%v\n%v", oldLines, newLines)
+	}
+}
+
+func TestApplyHunk_OutOfRange(t *testing.T) {
+	hunk := Hunk{OldStart: 2, OldCount: 3, NewStart: 2, NewCount: 0}
+	if _, err := ApplyHunk("a\nb\nc\n", hunk); err == nil {
+		t.Error("expected an error for a hunk past the end of the text")
+	}
+}
+
+func TestApplyHunk_TrailingNewline(t *testing.T) {
+	hunk := MyersDiff("a\nb\n", "a\nB\n").Hunks[0]
+	if got, err := ApplyHunk("a\nb\n", hunk); err != nil || got != "a\nB\n" {
+		t.Errorf("ApplyHunk = %q, %v; want %q", got, err, "a\nB\n")
+	}
+
+	hunk = MyersDiff("a\nb", "A\nb").Hunks[0]
+	if got, err := ApplyHunk("a\nb", hunk); err != nil || got != "A\nb" {
+		t.Errorf("ApplyHunk = %q, %v; want %q", got, err, "A\nb")
+	}
+}
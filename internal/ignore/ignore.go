@@ -0,0 +1,227 @@
+// Package ignore matches paths against gitignore-style patterns, as found
+// in .aslignore files, .asl/info/exclude and the global ignore file.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of per-directory ignore files
+const FileName = ".aslignore"
+
+// Pattern is a single ignore rule
+type Pattern struct {
+	base     string   // Directory the pattern was read in, slash-separated, "" for the root
+	segments []string // Glob for each path component; "**" matches any number of them
+	anchored bool     // Matched against the path relative to base rather than the name
+	negate   bool     // Re-includes paths excluded by earlier patterns
+	dirOnly  bool     // Only matches directories
+}
+
+// ParsePattern parses one line of an ignore file read in directory base,
+// given as a slash-separated path relative to the repository root. It
+// returns false for blank lines, comments and invalid globs.
+func ParsePattern(line, base string) (Pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Pattern{}, false
+	}
+
+	p := Pattern{base: strings.Trim(base, "/")}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		// A slash anywhere but at the end ties the pattern to base
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return Pattern{}, false
+	}
+
+	for _, segment := range strings.Split(line, "/") {
+		if segment == "" {
+			continue
+		}
+		if segment == "**" && len(p.segments) > 0 && p.segments[len(p.segments)-1] == "**" {
+			continue
+		}
+		segment = convertNegatedClass(segment)
+		if _, err := path.Match(segment, ""); err != nil {
+			return Pattern{}, false
+		}
+		p.segments = append(p.segments, segment)
+	}
+	return p, true
+}
+
+// Match reports whether the pattern matches a slash-separated path
+// relative to the repository root. It does not consider negation.
+func (p Pattern) Match(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	rel := name
+	if p.base != "" {
+		if !strings.HasPrefix(name, p.base+"/") {
+			return false
+		}
+		rel = name[len(p.base)+1:]
+	}
+
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], path.Base(rel))
+		return ok
+	}
+	return matchSegments(p.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches path components against pattern segments, where
+// "**" matches zero or more components, or one or more at the end
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(rest, parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// trimTrailingSpaces removes trailing spaces unless escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		if end > 1 && line[end-2] == '\\' {
+			return line[:end-2] + " "
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// convertNegatedClass rewrites gitignore's "[!...]" classes into the
+// "[^...]" form understood by path.Match
+func convertNegatedClass(segment string) string {
+	if !strings.Contains(segment, "[!") {
+		return segment
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		if c == '\\' && i+1 < len(segment) {
+			b.WriteByte(c)
+			b.WriteByte(segment[i+1])
+			i++
+			continue
+		}
+		b.WriteByte(c)
+		if c == '[' && i+1 < len(segment) && segment[i+1] == '!' {
+			b.WriteByte('^')
+			i++
+		}
+	}
+	return b.String()
+}
+
+// Matcher holds ignore patterns in increasing order of precedence. When
+// several patterns match a path, the last one decides.
+type Matcher struct {
+	patterns []Pattern
+}
+
+// NewMatcher creates a matcher with no patterns
+func NewMatcher() *Matcher {
+	return &Matcher{}
+}
+
+// Add appends patterns, taking precedence over those already added
+func (m *Matcher) Add(patterns ...Pattern) {
+	m.patterns = append(m.patterns, patterns...)
+}
+
+// AddReader parses ignore rules read in directory base and adds them
+func (m *Matcher) AddReader(r io.Reader, base string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if p, ok := ParsePattern(scanner.Text(), base); ok {
+			m.Add(p)
+		}
+	}
+	return scanner.Err()
+}
+
+// AddFile adds the rules in the ignore file at filename, which applies to
+// directory base. A missing file is not an error.
+func (m *Matcher) AddFile(filename, base string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open ignore file: %w", err)
+	}
+	defer f.Close()
+
+	if err := m.AddReader(f, base); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Match reports whether a slash-separated path relative to the repository
+// root is ignored. Parent directories are not checked: callers walking the
+// tree skip ignored directories, and with them everything inside.
+func (m *Matcher) Match(name string, isDir bool) bool {
+	for i := len(m.patterns) - 1; i >= 0; i-- {
+		if m.patterns[i].Match(name, isDir) {
+			return !m.patterns[i].negate
+		}
+	}
+	return false
+}
+
+// GlobalFile returns the path of the user's global ignore file,
+// $XDG_CONFIG_HOME/astral/ignore or ~/.config/astral/ignore, or "" if no
+// home directory is known
+func GlobalFile() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "astral", "ignore")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "astral", "ignore")
+}
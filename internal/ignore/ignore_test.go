@@ -0,0 +1,152 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestMatcher(t *testing.T, base string, rules ...string) *Matcher {
+	t.Helper()
+	m := NewMatcher()
+	if err := m.AddReader(strings.NewReader(strings.Join(rules, "\n")), base); err != nil {
+		t.Fatalf("AddReader: %v", err)
+	}
+	return m
+}
+
+func TestParsePattern_SkipsBlankAndComments(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "/", "!", "[z-a"} {
+		if _, ok := ParsePattern(line, ""); ok {
+			t.Errorf("ParsePattern(%q) should be skipped", line)
+		}
+	}
+	if _, ok := ParsePattern(`\#notes`, ""); !ok {
+		t.Error("escaped # should be a pattern")
+	}
+}
+
+func TestMatcher_Patterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		// Unanchored patterns match the name at any depth
+		{"*.log", "debug.log", false, true},
+		{"*.log", "logs/debug.log", false, true},
+		{"*.log", "debug.log.txt", false, false},
+		{"node_modules", "web/node_modules", true, true},
+		{"?.txt", "a.txt", false, true},
+		{"?.txt", "ab.txt", false, false},
+		{"[abc].go", "b.go", false, true},
+		{"[!abc].go", "b.go", false, false},
+		{"[!abc].go", "d.go", false, true},
+		{`\#notes`, "#notes", false, true},
+		{`\!important`, "!important", false, true},
+		{`trailing\ `, "trailing ", false, true},
+		{"trailing   ", "trailing", false, true},
+
+		// Directory-only patterns
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "src/build", true, true},
+
+		// A leading or middle slash anchors to the ignore file's directory
+		{"/todo.txt", "todo.txt", false, true},
+		{"/todo.txt", "docs/todo.txt", false, false},
+		{"doc/*.txt", "doc/notes.txt", false, true},
+		{"doc/*.txt", "doc/server/arch.txt", false, false},
+		{"doc/*.txt", "src/doc/notes.txt", false, false},
+
+		// Double asterisks
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"**/foo/bar", "x/foo/bar", false, true},
+		{"abc/**", "abc/x", false, true},
+		{"abc/**", "abc/x/y", false, true},
+		{"abc/**", "abc", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "a/x/y/c", false, false},
+	}
+
+	for _, tt := range tests {
+		m := newTestMatcher(t, "", tt.pattern)
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("pattern %q, path %q (dir=%v): got %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestMatcher_Negation(t *testing.T) {
+	m := newTestMatcher(t, "", "*.log", "!keep.log")
+
+	if !m.Match("debug.log", false) {
+		t.Error("debug.log should be ignored")
+	}
+	if m.Match("keep.log", false) {
+		t.Error("keep.log should be re-included")
+	}
+
+	// The last matching pattern wins
+	m = newTestMatcher(t, "", "!keep.log", "*.log")
+	if !m.Match("keep.log", false) {
+		t.Error("keep.log should be ignored by the later pattern")
+	}
+}
+
+func TestMatcher_Base(t *testing.T) {
+	m := newTestMatcher(t, "", "*.tmp")
+	if err := m.AddReader(strings.NewReader("/out\n!special.tmp\n"), "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"web/out", true, true},
+		{"out", true, false},
+		{"web/src/out", true, false},
+		{"web/special.tmp", false, false},
+		{"special.tmp", false, true},
+		{"web/other.tmp", false, true},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("path %q: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatcher_AddFile(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMatcher()
+
+	if err := m.AddFile(filepath.Join(dir, "missing"), ""); err != nil {
+		t.Fatalf("missing file should be ignored: %v", err)
+	}
+
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, []byte("# build output\r\nbin/\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddFile(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Match("bin", true) {
+		t.Error("bin/ should be ignored")
+	}
+}
+
+func TestGlobalFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/config")
+	if got, want := GlobalFile(), filepath.Join("/config", "astral", "ignore"); got != want {
+		t.Errorf("GlobalFile() = %q, want %q", got, want)
+	}
+}
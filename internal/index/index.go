@@ -0,0 +1,218 @@
+// Package index implements the stat cache stored in .asl/index. For each
+// tracked path it records the file's stat data together with its blob
+// hash, so that files whose stat data has not changed need not be read and
+// re-hashed.
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// version is the current index file format
+const version = 1
+
+// Entry is the cached state of one file
+type Entry struct {
+	Path  string    `json:"path"`
+	Hash  core.Hash `json:"hash"`
+	Mode  uint32    `json:"mode"`
+	Size  int64     `json:"size"`
+	MTime int64     `json:"mtime"` // Nanoseconds since the epoch
+	CTime int64     `json:"ctime"` // Nanoseconds since the epoch, 0 where unsupported
+	Inode uint64    `json:"inode"` // 0 where unsupported
+}
+
+// fileData is the on-disk format
+type fileData struct {
+	Version int     `json:"version"`
+	Entries []Entry `json:"entries"`
+}
+
+// Index is a stat cache loaded from disk. It is safe for concurrent use.
+type Index struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+	dirty   bool
+
+	// racy is the modification time of the index file when it was loaded.
+	// A file modified at or after this time may have changed again within
+	// the same timestamp tick after it was hashed, so its stat data cannot
+	// be trusted.
+	racy int64
+}
+
+// Load reads the index at path. A missing index is empty. The index is
+// only a cache, so one that cannot be parsed or has an unknown version is
+// discarded rather than reported as an error.
+func Load(path string) (*Index, error) {
+	idx := &Index{path: path, entries: make(map[string]Entry)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("failed to open index: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat index: %w", err)
+	}
+
+	var data fileData
+	if err := json.NewDecoder(f).Decode(&data); err != nil || data.Version != version {
+		idx.dirty = true
+		return idx, nil
+	}
+
+	idx.racy = info.ModTime().UnixNano()
+	for _, entry := range data.Entries {
+		idx.entries[entry.Path] = entry
+	}
+	return idx, nil
+}
+
+// Lookup returns the cached hash of the file at path, if its stat data
+// matches the index and it was not modified too close to the last index
+// write to be trusted
+func (idx *Index) Lookup(path string, info os.FileInfo) (core.Hash, bool) {
+	idx.mu.Lock()
+	entry, ok := idx.entries[path]
+	idx.mu.Unlock()
+	if !ok {
+		return core.Hash{}, false
+	}
+
+	current := newEntry(path, info, entry.Hash)
+	if current != entry || entry.MTime >= idx.racy {
+		return core.Hash{}, false
+	}
+	return entry.Hash, true
+}
+
+// Update records the hash of the file at path along with its stat data
+func (idx *Index) Update(path string, info os.FileInfo, hash core.Hash) {
+	entry := newEntry(path, info, hash)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if old, ok := idx.entries[path]; !ok || old != entry {
+		idx.entries[path] = entry
+		idx.dirty = true
+	}
+}
+
+// Remove drops the entry for path, if any
+func (idx *Index) Remove(path string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.entries[path]; ok {
+		delete(idx.entries, path)
+		idx.dirty = true
+	}
+}
+
+// Paths returns the paths of all entries in sorted order
+func (idx *Index) Paths() []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	paths := make([]string, 0, len(idx.entries))
+	for path := range idx.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Retain drops every entry whose path is not in paths
+func (idx *Index) Retain(paths map[string]bool) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for path := range idx.entries {
+		if !paths[path] {
+			delete(idx.entries, path)
+			idx.dirty = true
+		}
+	}
+}
+
+// Len returns the number of entries
+func (idx *Index) Len() int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.entries)
+}
+
+// Save writes the index if it changed. The new index is written to a
+// temporary file and renamed into place, so readers never see a partial
+// index.
+func (idx *Index) Save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if !idx.dirty {
+		return nil
+	}
+
+	data := fileData{Version: version, Entries: make([]Entry, 0, len(idx.entries))}
+	for _, entry := range idx.entries {
+		data.Entries = append(data.Entries, entry)
+	}
+	sort.Slice(data.Entries, func(i, j int) bool {
+		return data.Entries[i].Path < data.Entries[j].Path
+	})
+
+	encoded, err := json.Marshal(&data)
+	if err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(idx.path), "index-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), idx.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to save index: %w", err)
+	}
+
+	idx.dirty = false
+	return nil
+}
+
+// newEntry builds the entry for a file from its stat data
+func newEntry(path string, info os.FileInfo, hash core.Hash) Entry {
+	mode := core.ModeFile
+	if info.Mode()&0111 != 0 {
+		mode = core.ModeExecutable
+	}
+
+	ctime, inode := statExtra(info)
+	return Entry{
+		Path:  path,
+		Hash:  hash,
+		Mode:  mode,
+		Size:  info.Size(),
+		MTime: info.ModTime().UnixNano(),
+		CTime: ctime,
+		Inode: inode,
+	}
+}
@@ -0,0 +1,185 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// writeFile writes a file and sets its modification time
+func writeFile(t *testing.T, path, content string, mtime time.Time) os.FileInfo {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestLoad_MissingAndCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "index")
+
+	idx, err := Load(path)
+	if err != nil {
+		t.Fatalf("missing index: %v", err)
+	}
+	if idx.Len() != 0 {
+		t.Errorf("expected an empty index, got %d entries", idx.Len())
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = Load(path)
+	if err != nil {
+		t.Fatalf("corrupt index should be discarded: %v", err)
+	}
+	if idx.Len() != 0 {
+		t.Errorf("expected an empty index, got %d entries", idx.Len())
+	}
+
+	// The discarded index is rewritten on the next save
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup_ReusesUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "index")
+	file := filepath.Join(dir, "file.txt")
+	hash := core.HashBytes([]byte("content"))
+
+	old := time.Now().Add(-time.Hour)
+	info := writeFile(t, file, "content", old)
+
+	idx, _ := Load(indexPath)
+	idx.Update("file.txt", info, hash)
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := Load(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := idx.Lookup("file.txt", info)
+	if !ok || got != hash {
+		t.Fatalf("expected a cache hit with %s, got %s (hit=%v)", hash.Short(), got.Short(), ok)
+	}
+
+	if _, ok := idx.Lookup("other.txt", info); ok {
+		t.Error("unknown path should miss")
+	}
+
+	// Any change to the stat data is a miss
+	info = writeFile(t, file, "changed content", old)
+	if _, ok := idx.Lookup("file.txt", info); ok {
+		t.Error("size change should miss")
+	}
+	info = writeFile(t, file, "content", old.Add(time.Second))
+	if _, ok := idx.Lookup("file.txt", info); ok {
+		t.Error("mtime change should miss")
+	}
+	if err := os.Chmod(file, 0755); err != nil {
+		t.Fatal(err)
+	}
+	info = writeFile(t, file, "content", old)
+	if _, ok := idx.Lookup("file.txt", info); ok {
+		t.Error("mode change should miss")
+	}
+}
+
+func TestLookup_RacyTimestamps(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "index")
+	file := filepath.Join(dir, "file.txt")
+
+	// A file modified after the index was written cannot be trusted, even
+	// if its stat data matches: it may have changed within the same tick
+	future := time.Now().Add(time.Hour)
+	info := writeFile(t, file, "content", future)
+
+	idx, _ := Load(indexPath)
+	idx.Update("file.txt", info, core.HashBytes([]byte("content")))
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := Load(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Lookup("file.txt", info); ok {
+		t.Error("racily clean entry should miss")
+	}
+
+	// Once the index is written after the file's mtime, it is trusted
+	if err := os.Chtimes(indexPath, future.Add(time.Second), future.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = Load(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := idx.Lookup("file.txt", info); !ok {
+		t.Error("entry older than the index should hit")
+	}
+}
+
+func TestSave_RetainAndAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "index")
+	info := writeFile(t, filepath.Join(dir, "a"), "a", time.Now().Add(-time.Hour))
+
+	idx, _ := Load(indexPath)
+	idx.Update("a", info, core.HashBytes([]byte("a")))
+	idx.Update("b", info, core.HashBytes([]byte("a")))
+	idx.Retain(map[string]bool{"a": true})
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := Load(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Len() != 1 {
+		t.Errorf("expected 1 entry after Retain, got %d", idx.Len())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".tmp" {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+
+	// An unchanged index is not rewritten
+	before, _ := os.Stat(indexPath)
+	old := before.ModTime().Add(-time.Minute)
+	os.Chtimes(indexPath, old, old)
+	if err := idx.Save(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(indexPath)
+	if !after.ModTime().Equal(old) {
+		t.Error("clean index should not be rewritten")
+	}
+}
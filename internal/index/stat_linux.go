@@ -0,0 +1,17 @@
+//go:build linux
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// statExtra returns the change time and inode of a file
+func statExtra(info os.FileInfo) (ctime int64, inode uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return st.Ctim.Nano(), st.Ino
+}
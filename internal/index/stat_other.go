@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package index
+
+import "os"
+
+// statExtra returns zero where the change time and inode are not
+// available; size, modification time and mode are still compared
+func statExtra(info os.FileInfo) (ctime int64, inode uint64) {
+	return 0, 0
+}
@@ -14,20 +14,28 @@ type ConflictType string
 
 const (
 	ConflictContent      ConflictType = "content"
-	ConflictDeleteModify ConflictType = "delete-modify"
+	ConflictDeleteModify ConflictType = "delete-modify" // Deleted by us, modified by them
+	ConflictModifyDelete ConflictType = "modify-delete" // Modified by us, deleted by them
 	ConflictAddAdd       ConflictType = "add-add"
 	ConflictBinary       ConflictType = "binary"
 )
 
-// Conflict represents a merge conflict in a file
+// Conflict represents a merge conflict in a file. For content conflicts,
+// Base, Ours and Theirs hold each version of the conflicting region, every
+// line ending in a newline, and the line ranges locate the region in each
+// version. Ranges are 0-based and exclude their end.
 type Conflict struct {
-	Path      string
-	Type      ConflictType
-	Base      string
-	Ours      string
-	Theirs    string
-	LineStart int
-	LineEnd   int
+	Path       string
+	Type       ConflictType
+	Base       string
+	Ours       string
+	Theirs     string
+	LineStart  int // Region in the base
+	LineEnd    int
+	OurStart   int // Region in our version
+	OurEnd     int
+	TheirStart int // Region in their version
+	TheirEnd   int
 }
 
 // MergeResult represents the result of a three-way merge
@@ -35,6 +43,95 @@ type MergeResult struct {
 	Content     string
 	Conflicts   []Conflict
 	HasConflict bool
+
+	sections       []section // Merged content, split around content conflicts
+	noFinalNewline bool      // The merged content does not end in a newline
+}
+
+// section is a run of merged lines, or a conflict
+type section struct {
+	lines    []string
+	conflict int // Index into Conflicts, or -1 for merged lines
+}
+
+// Markers returns the merged content with each conflict written out by
+// format, e.g. a closure over FormatConflictMarkers. Without content
+// conflicts it returns Content.
+func (m *MergeResult) Markers(format func(Conflict) string) string {
+	if !m.HasConflict || m.sections == nil {
+		return m.Content
+	}
+	return m.join(format, false)
+}
+
+// join writes out the sections, formatting each conflict with format. A
+// missing final newline is left off the last merged line, and off the
+// last conflict if resolved says it was replaced by content rather than
+// markers.
+func (m *MergeResult) join(format func(Conflict) string, resolved bool) string {
+	var b strings.Builder
+	for _, s := range m.sections {
+		if s.conflict >= 0 {
+			b.WriteString(format(m.Conflicts[s.conflict]))
+			continue
+		}
+		b.WriteString(joinLines(s.lines))
+	}
+
+	content := b.String()
+	if m.noFinalNewline && (resolved || len(m.sections[len(m.sections)-1].lines) > 0) {
+		content = strings.TrimSuffix(content, "\n")
+	}
+	return content
+}
+
+// Favor selects how conflicting hunks are settled automatically
+type Favor string
+
+const (
+	FavorNone   Favor = ""       // Leave conflicts for the user
+	FavorOurs   Favor = "ours"   // Take our side of each conflict
+	FavorTheirs Favor = "theirs" // Take their side of each conflict
+	FavorUnion  Favor = "union"  // Take our side followed by theirs
+)
+
+// ParseFavor parses a favor option as given to merge -X
+func ParseFavor(s string) (Favor, error) {
+	switch favor := Favor(s); favor {
+	case FavorNone, FavorOurs, FavorTheirs, FavorUnion:
+		return favor, nil
+	}
+	return FavorNone, fmt.Errorf("%w: unknown option %q", core.ErrInvalidStrategy, s)
+}
+
+// Resolve returns the merged content with every conflict settled in
+// favor of one side or both. Hunks that merged cleanly are kept. It
+// returns false if the conflicts cannot be settled this way: with
+// FavorNone, or for binary files under FavorUnion.
+func (m *MergeResult) Resolve(favor Favor) (string, bool) {
+	if !m.HasConflict {
+		return m.Content, true
+	}
+
+	if m.Conflicts[0].Type == ConflictBinary {
+		switch favor {
+		case FavorOurs:
+			return m.Conflicts[0].Ours, true
+		case FavorTheirs:
+			return m.Conflicts[0].Theirs, true
+		}
+		return "", false
+	}
+
+	switch favor {
+	case FavorOurs:
+		return m.join(func(c Conflict) string { return c.Ours }, true), true
+	case FavorTheirs:
+		return m.join(func(c Conflict) string { return c.Theirs }, true), true
+	case FavorUnion:
+		return m.join(func(c Conflict) string { return c.Ours + c.Theirs }, true), true
+	}
+	return "", false
 }
 
 // ThreeWayMerge performs a three-way merge on file content
@@ -81,214 +178,207 @@ func ThreeWayMerge(base, ours, theirs, path string) *MergeResult {
 	return mergeContent(base, ours, theirs, path)
 }
 
-// mergeContent performs the actual content merging
+// mergeContent merges two edited versions of base line by line, in the
+// manner of diff3. Each side's changes are aligned against the base and
+// grouped into chunks of changes that overlap, directly or through other
+// changes. A chunk changed on one side only, or identically on both,
+// merges cleanly; any other chunk becomes one conflict. Whether the
+// content ends in a newline is merged the same way, and never conflicts.
 func mergeContent(base, ours, theirs, path string) *MergeResult {
 	result := &MergeResult{
 		Conflicts: make([]Conflict, 0),
 	}
+	if endsInNewline(ours) != endsInNewline(base) {
+		result.noFinalNewline = !endsInNewline(ours)
+	} else {
+		result.noFinalNewline = !endsInNewline(theirs)
+	}
 
-	// Compute diffs from base
-	diffOurs := diff.MyersDiff(base, ours)
-	diffTheirs := diff.MyersDiff(base, theirs)
-
-	// Build change maps
-	ourChanges := buildChangeMap(diffOurs)
-	theirChanges := buildChangeMap(diffTheirs)
-
-	// Merge line by line
 	baseLines := splitLines(base)
-	ourLines := splitLines(ours)
-	theirLines := splitLines(theirs)
-
-	merged, conflicts := mergeLinesWithConflicts(
-		baseLines, ourLines, theirLines,
-		ourChanges, theirChanges, path,
-	)
-
-	if len(conflicts) > 0 {
-		result.HasConflict = true
-		result.Conflicts = conflicts
-		result.Content = generateConflictMarkers(merged, conflicts, path)
-	} else {
-		result.Content = strings.Join(merged, "\n")
-		if len(merged) > 0 && !strings.HasSuffix(result.Content, "\n") {
-			result.Content += "\n"
+	ourChanges := changesAgainst(baseLines, splitLines(ours))
+	theirChanges := changesAgainst(baseLines, splitLines(theirs))
+
+	var merged []string
+	pos := 0                     // Base lines before pos are merged
+	ourShift, theirShift := 0, 0 // Line offset of each side from the base at pos
+
+	for len(ourChanges) > 0 || len(theirChanges) > 0 {
+		// Start a chunk at the earliest change, then grow it while either
+		// side has a change overlapping it
+		first := theirChanges
+		if len(theirChanges) == 0 || (len(ourChanges) > 0 && ourChanges[0].before(theirChanges[0])) {
+			first = ourChanges
 		}
+		lo, hi := first[0].start, first[0].end
+		nOurs, nTheirs := 0, 0
+		for grew := true; grew; {
+			grew = false
+			if nOurs < len(ourChanges) && ourChanges[nOurs].overlaps(lo, hi) {
+				hi = max(hi, ourChanges[nOurs].end)
+				nOurs++
+				grew = true
+			}
+			if nTheirs < len(theirChanges) && theirChanges[nTheirs].overlaps(lo, hi) {
+				hi = max(hi, theirChanges[nTheirs].end)
+				nTheirs++
+				grew = true
+			}
+		}
+
+		ourLines := applyChanges(baseLines, lo, hi, ourChanges[:nOurs])
+		theirLines := applyChanges(baseLines, lo, hi, theirChanges[:nTheirs])
+		ourChanges, theirChanges = ourChanges[nOurs:], theirChanges[nTheirs:]
+
+		merged = append(merged, baseLines[pos:lo]...)
+		switch {
+		case nTheirs == 0, equalLines(ourLines, theirLines):
+			merged = append(merged, ourLines...)
+		case nOurs == 0:
+			merged = append(merged, theirLines...)
+		default:
+			result.sections = append(result.sections,
+				section{lines: merged, conflict: -1},
+				section{conflict: len(result.Conflicts)})
+			merged = nil
+			result.Conflicts = append(result.Conflicts, Conflict{
+				Path:       path,
+				Type:       ConflictContent,
+				Base:       joinLines(baseLines[lo:hi]),
+				Ours:       joinLines(ourLines),
+				Theirs:     joinLines(theirLines),
+				LineStart:  lo,
+				LineEnd:    hi,
+				OurStart:   lo + ourShift,
+				OurEnd:     lo + ourShift + len(ourLines),
+				TheirStart: lo + theirShift,
+				TheirEnd:   lo + theirShift + len(theirLines),
+			})
+		}
+
+		ourShift += len(ourLines) - (hi - lo)
+		theirShift += len(theirLines) - (hi - lo)
+		pos = hi
 	}
+	merged = append(merged, baseLines[pos:]...)
 
+	if len(result.Conflicts) == 0 {
+		result.Content = joinLines(merged)
+		if result.noFinalNewline {
+			result.Content = strings.TrimSuffix(result.Content, "\n")
+		}
+		return result
+	}
+	result.sections = append(result.sections, section{lines: merged, conflict: -1})
+	result.HasConflict = true
+	result.Content = result.Markers(defaultMarkers)
 	return result
 }
 
-// ChangeInfo represents a change at a specific line
-type ChangeInfo struct {
-	Type      diff.EditType
-	Content   string
-	BaseStart int
-	BaseEnd   int
+// change replaces the base lines [start, end) with lines. An insertion
+// has start == end.
+type change struct {
+	start, end int
+	lines      []string
 }
 
-// buildChangeMap builds a map of line changes from a diff
-func buildChangeMap(d *diff.Diff) map[int][]ChangeInfo {
-	changes := make(map[int][]ChangeInfo)
-
-	for _, hunk := range d.Hunks {
-		baseIdx := hunk.OldStart
-		for _, edit := range hunk.Edits {
-			switch edit.Type {
-			case diff.EditDelete, diff.EditEqual:
-				info := ChangeInfo{
-					Type:      edit.Type,
-					Content:   edit.Text,
-					BaseStart: baseIdx,
-					BaseEnd:   baseIdx + 1,
-				}
-				changes[baseIdx] = append(changes[baseIdx], info)
-				baseIdx++
-			case diff.EditInsert:
-				// Insert doesn't advance base index
-				info := ChangeInfo{
-					Type:      edit.Type,
-					Content:   edit.Text,
-					BaseStart: baseIdx,
-					BaseEnd:   baseIdx,
-				}
-				changes[baseIdx] = append(changes[baseIdx], info)
-			}
-		}
+// before orders changes by where they start, insertions first
+func (c change) before(other change) bool {
+	if c.start != other.start {
+		return c.start < other.start
 	}
-
-	return changes
+	return c.end < other.end
 }
 
-// mergeLinesWithConflicts merges lines and detects conflicts
-func mergeLinesWithConflicts(
-	base, ours, theirs []string,
-	ourChanges, theirChanges map[int][]ChangeInfo,
-	path string,
-) ([]string, []Conflict) {
-	merged := make([]string, 0)
-	conflicts := make([]Conflict, 0)
-
-	// Simple strategy: go through base line by line
-	for i := 0; i < len(base); i++ {
-		ourEdits := ourChanges[i]
-		theirEdits := theirChanges[i]
-
-		// No changes on either side
-		if len(ourEdits) == 0 && len(theirEdits) == 0 {
-			merged = append(merged, base[i])
-			continue
-		}
-
-		// Only one side changed
-		if len(ourEdits) == 0 {
-			// Only theirs changed
-			for _, edit := range theirEdits {
-				if edit.Type == diff.EditInsert {
-					merged = append(merged, edit.Content)
-				} else if edit.Type == diff.EditEqual {
-					merged = append(merged, base[i])
-				}
-				// DeleteEdit skips the line
-			}
-			continue
-		}
+// overlaps reports whether c touches the same base lines as the region
+// [lo, hi). Changes that only meet at a boundary do not overlap, but two
+// insertions at the same point do, as does an insertion at the start of
+// the other change or inside it.
+func (c change) overlaps(lo, hi int) bool {
+	switch {
+	case c.start < hi && lo < c.end:
+		return true
+	case c.start == c.end && lo == hi:
+		return c.start == lo
+	case c.start == c.end:
+		return lo <= c.start && c.start < hi
+	case lo == hi:
+		return c.start <= lo && lo < c.end
+	}
+	return false
+}
 
-		if len(theirEdits) == 0 {
-			// Only ours changed
-			for _, edit := range ourEdits {
-				if edit.Type == diff.EditInsert {
-					merged = append(merged, edit.Content)
-				} else if edit.Type == diff.EditEqual {
-					merged = append(merged, base[i])
-				}
+// changesAgainst returns the changes that turn base into side, in order
+func changesAgainst(base, side []string) []change {
+	var changes []change
+	var current *change
+	i := 0 // Position in base
+	for _, edit := range diff.Lines(base, side) {
+		if edit.Type == diff.EditEqual {
+			if current != nil {
+				changes = append(changes, *current)
+				current = nil
 			}
+			i++
 			continue
 		}
 
-		// Both sides changed - check if identical
-		if editsIdentical(ourEdits, theirEdits) {
-			// Same changes on both sides - use either
-			for _, edit := range ourEdits {
-				if edit.Type != diff.EditDelete {
-					merged = append(merged, edit.Content)
-				}
-			}
-			continue
+		if current == nil {
+			current = &change{start: i, end: i}
 		}
-
-		// Different changes - conflict!
-		conflict := Conflict{
-			Path:      path,
-			Type:      ConflictContent,
-			Base:      base[i],
-			Ours:      formatEdits(ourEdits),
-			Theirs:    formatEdits(theirEdits),
-			LineStart: i,
-			LineEnd:   i + 1,
+		if edit.Type == diff.EditDelete {
+			i++
+			current.end = i
+		} else {
+			current.lines = append(current.lines, edit.Text)
 		}
-		conflicts = append(conflicts, conflict)
-
-		// Add conflict marker placeholder
-		merged = append(merged, fmt.Sprintf("<<<CONFLICT_%d>>>", len(conflicts)-1))
 	}
+	if current != nil {
+		changes = append(changes, *current)
+	}
+	return changes
+}
 
-	return merged, conflicts
+// applyChanges returns the base lines [lo, hi) with changes applied
+func applyChanges(base []string, lo, hi int, changes []change) []string {
+	lines := make([]string, 0, hi-lo)
+	for _, c := range changes {
+		lines = append(lines, base[lo:c.start]...)
+		lines = append(lines, c.lines...)
+		lo = c.end
+	}
+	return append(lines, base[lo:hi]...)
 }
 
-// editsIdentical checks if two edit lists are identical
-func editsIdentical(a, b []ChangeInfo) bool {
+// equalLines reports whether two line slices are identical
+func equalLines(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
 	for i := range a {
-		if a[i].Type != b[i].Type || a[i].Content != b[i].Content {
+		if a[i] != b[i] {
 			return false
 		}
 	}
 	return true
 }
 
-// formatEdits formats edit list to string
-func formatEdits(edits []ChangeInfo) string {
-	var result strings.Builder
-	for _, edit := range edits {
-		if edit.Type != diff.EditDelete {
-			result.WriteString(edit.Content)
-			result.WriteString("\n")
-		}
+// joinLines joins lines, ending each with a newline
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
 	}
-	s := result.String()
-	return strings.TrimSuffix(s, "\n")
+	return strings.Join(lines, "\n") + "\n"
 }
 
-// generateConflictMarkers creates text with conflict markers
-func generateConflictMarkers(merged []string, conflicts []Conflict, path string) string {
-	var result strings.Builder
-
-	for _, line := range merged {
-		if strings.HasPrefix(line, "<<<CONFLICT_") {
-			// Extract conflict index
-			var idx int
-			fmt.Sscanf(line, "<<<CONFLICT_%d>>>", &idx)
-
-			if idx < len(conflicts) {
-				c := conflicts[idx]
-				result.WriteString("<<<<<<< HEAD (ours)\n")
-				result.WriteString(c.Ours)
-				result.WriteString("\n||||||| BASE\n")
-				result.WriteString(c.Base)
-				result.WriteString("\n=======\n")
-				result.WriteString(c.Theirs)
-				result.WriteString("\n>>>>>>> theirs\n")
-			}
-		} else {
-			result.WriteString(line)
-			result.WriteString("\n")
-		}
-	}
+// endsInNewline reports whether content is empty or its last line ends in
+// a newline
+func endsInNewline(content string) bool {
+	return content == "" || strings.HasSuffix(content, "\n")
+}
 
-	return result.String()
+// defaultMarkers formats a conflict without branch or commit labels
+func defaultMarkers(c Conflict) string {
+	return "<<<<<<< HEAD (ours)\n" + c.Ours + "||||||| BASE\n" + c.Base + "=======\n" + c.Theirs + ">>>>>>> theirs\n"
 }
 
 // generateBinaryConflictMarkers creates markers for binary conflicts
@@ -342,25 +432,21 @@ func isBinary(content []byte) bool {
 	return nonText > sampleSize*30/100
 }
 
-// FormatConflictMarkers creates enhanced conflict markers with context
-func FormatConflictMarkers(conflict Conflict, ourBranch, theirBranch, ourCommit, theirCommit, baseCommit core.Hash) string {
+// FormatConflictMarkers creates enhanced conflict markers labelled with
+// the branch names and commits
+func FormatConflictMarkers(conflict Conflict, ourBranch, theirBranch string, ourCommit, theirCommit, baseCommit core.Hash) string {
 	var result strings.Builder
 
 	// Enhanced header with context
 	result.WriteString(fmt.Sprintf("<<<<<<< HEAD (%s @ %s)\n", ourBranch, ourCommit.Short()))
 	result.WriteString(conflict.Ours)
-	result.WriteString("\n")
 
 	// Show base for 3-way comparison
-	if conflict.Base != "" {
-		result.WriteString(fmt.Sprintf("||||||| BASE (%s)\n", baseCommit.Short()))
-		result.WriteString(conflict.Base)
-		result.WriteString("\n")
-	}
+	result.WriteString(fmt.Sprintf("||||||| BASE (%s)\n", baseCommit.Short()))
+	result.WriteString(conflict.Base)
 
 	result.WriteString("=======\n")
 	result.WriteString(conflict.Theirs)
-	result.WriteString("\n")
 	result.WriteString(fmt.Sprintf(">>>>>>> %s (%s)\n", theirBranch, theirCommit.Short()))
 
 	return result.String()
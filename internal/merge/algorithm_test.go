@@ -1,7 +1,11 @@
 package merge
 
 import (
+	"errors"
+	"strings"
 	"testing"
+
+	"github.com/codimo/astral/internal/core"
 )
 
 func TestThreeWayMerge_NoConflict_BothSidesIdentical(t *testing.T) {
@@ -89,6 +93,207 @@ func TestThreeWayMerge_Conflict_DifferentChanges(t *testing.T) {
 	}
 }
 
+func TestMergeResult_Markers(t *testing.T) {
+	base := "line1\nline2\nline3\n"
+	result := ThreeWayMerge(base, "line1\nours\nline3\n", "line1\ntheirs\nline3\n", "test.txt")
+
+	var ourCommit, theirCommit, baseCommit core.Hash
+	ourCommit[0], theirCommit[0], baseCommit[0] = 1, 2, 3
+	got := result.Markers(func(c Conflict) string {
+		return FormatConflictMarkers(c, "main", "feature", ourCommit, theirCommit, baseCommit)
+	})
+
+	want := "line1\n" +
+		"<<<<<<< HEAD (main @ " + ourCommit.Short() + ")\nours\n" +
+		"||||||| BASE (" + baseCommit.Short() + ")\nline2\n" +
+		"=======\ntheirs\n" +
+		">>>>>>> feature (" + theirCommit.Short() + ")\n" +
+		"line3\n"
+	if got != want {
+		t.Errorf("Markers() =\n%s\nwant:\n%s", got, want)
+	}
+
+	clean := ThreeWayMerge(base, "line1\nours\nline3\n", base, "test.txt")
+	if got := clean.Markers(nil); got != clean.Content {
+		t.Errorf("Markers() of a clean merge = %q, want the content %q", got, clean.Content)
+	}
+}
+
+func TestThreeWayMerge_CleanHunks(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		name         string
+		ours, theirs string
+		want         string
+	}{
+		{"adjacent lines", "A\nb\nc\nd\ne\n", "a\nB\nc\nd\ne\n", "A\nB\nc\nd\ne\n"},
+		{"separate hunks", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n"},
+		{"insert at end", "a\nb\nc\nd\ne\nf\n", "A\nb\nc\nd\ne\n", "A\nb\nc\nd\ne\nf\n"},
+		{"insert at start", "0\na\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "0\na\nb\nc\nd\nE\n"},
+		{"insert after a change", "a\nb\nc\nnew\nd\ne\n", "a\nb\nC\nd\ne\n", "a\nb\nC\nnew\nd\ne\n"},
+		{"delete and change", "a\nd\ne\n", "a\nb\nc\nd\nE\n", "a\nd\nE\n"},
+		{"same change", "a\nX\nc\nd\nY\n", "a\nX\nc\nd\ne\n", "a\nX\nc\nd\nY\n"},
+	}
+	for _, tt := range tests {
+		result := ThreeWayMerge(base, tt.ours, tt.theirs, "test.txt")
+		if result.HasConflict {
+			t.Errorf("%s: unexpected conflict:\n%s", tt.name, result.Content)
+		} else if result.Content != tt.want {
+			t.Errorf("%s: merged %q, want %q", tt.name, result.Content, tt.want)
+		}
+		// Merging is symmetric
+		if swapped := ThreeWayMerge(base, tt.theirs, tt.ours, "test.txt"); swapped.Content != result.Content {
+			t.Errorf("%s: swapping sides gives %q, want %q", tt.name, swapped.Content, result.Content)
+		}
+	}
+}
+
+func TestThreeWayMerge_FinalNewline(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+	}{
+		{"kept missing", "a\nb\nc", "A\nb\nc", "a\nb\nC", "A\nb\nC"},
+		{"removed by one side", "a\nb\n", "a\nb", "A\nb\n", "A\nb"},
+		{"added by one side", "a\nb", "a\nb\n", "A\nb", "A\nb\n"},
+	}
+	for _, tt := range tests {
+		result := ThreeWayMerge(tt.base, tt.ours, tt.theirs, "test.txt")
+		if result.HasConflict || result.Content != tt.want {
+			t.Errorf("%s: merged %q, want %q", tt.name, result.Content, tt.want)
+		}
+		if swapped := ThreeWayMerge(tt.base, tt.theirs, tt.ours, "test.txt"); swapped.Content != tt.want {
+			t.Errorf("%s: swapping sides gives %q, want %q", tt.name, swapped.Content, tt.want)
+		}
+	}
+
+	// Conflict markers keep their lines whole; content after them, or
+	// resolving them, leaves the newline off again
+	result := ThreeWayMerge("a\nb\nc", "a\nB\nc", "a\nX\nc", "test.txt")
+	if !strings.HasSuffix(result.Content, ">>>>>>> theirs\nc") {
+		t.Errorf("content = %q, want it to end without a newline", result.Content)
+	}
+	result = ThreeWayMerge("a\nb", "a\nB", "a\nX", "test.txt")
+	if !strings.HasSuffix(result.Content, ">>>>>>> theirs\n") {
+		t.Errorf("content = %q, want it to end with the closing marker", result.Content)
+	}
+	if got, ok := result.Resolve(FavorOurs); !ok || got != "a\nB" {
+		t.Errorf("Resolve(FavorOurs) = %q, %v; want %q", got, ok, "a\nB")
+	}
+}
+
+func TestThreeWayMerge_ConflictRegions(t *testing.T) {
+	base := "a\nb\nc\nd\ne\nf\ng\n"
+	ours := "a\nB1\nB2\nc\nd\ne\nf\nG\n"
+	theirs := "a\nb\nC\ne\nf\nGG\n"
+
+	// Ours changed b, theirs changed c and d: those do not overlap, so
+	// only the changes to g conflict
+	result := ThreeWayMerge(base, ours, theirs, "test.txt")
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("got %d conflicts, want 1:\n%s", len(result.Conflicts), result.Content)
+	}
+	want := "a\nB1\nB2\nC\ne\nf\n<<<<<<< HEAD (ours)\nG\n"
+	if !strings.HasPrefix(result.Content, want) {
+		t.Errorf("content = %q, want it to start with %q", result.Content, want)
+	}
+
+	// Overlapping deletions and edits form one region
+	result = ThreeWayMerge(base, "a\nX\ne\nf\ng\n", "a\nb\nc\nY\nf\ng\n", "test.txt")
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("got %d conflicts, want 1:\n%s", len(result.Conflicts), result.Content)
+	}
+	c := result.Conflicts[0]
+	if c.Base != "b\nc\nd\ne\n" || c.Ours != "X\ne\n" || c.Theirs != "b\nc\nY\n" {
+		t.Errorf("conflict = base %q, ours %q, theirs %q", c.Base, c.Ours, c.Theirs)
+	}
+	if c.LineStart != 1 || c.LineEnd != 5 || c.OurStart != 1 || c.OurEnd != 3 || c.TheirStart != 1 || c.TheirEnd != 4 {
+		t.Errorf("ranges = base [%d,%d) ours [%d,%d) theirs [%d,%d)",
+			c.LineStart, c.LineEnd, c.OurStart, c.OurEnd, c.TheirStart, c.TheirEnd)
+	}
+	wantContent := "a\n<<<<<<< HEAD (ours)\nX\ne\n||||||| BASE\nb\nc\nd\ne\n=======\nb\nc\nY\n>>>>>>> theirs\nf\ng\n"
+	if result.Content != wantContent {
+		t.Errorf("content =\n%s\nwant:\n%s", result.Content, wantContent)
+	}
+
+	// Different insertions at the same point conflict, with an empty base
+	result = ThreeWayMerge(base, base+"x\n", base+"y\n", "test.txt")
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("got %d conflicts, want 1", len(result.Conflicts))
+	}
+	c = result.Conflicts[0]
+	if c.Base != "" || c.LineStart != 7 || c.LineEnd != 7 || c.OurStart != 7 || c.OurEnd != 8 {
+		t.Errorf("insertion conflict = %+v", c)
+	}
+
+	// So does an insertion where the other side's change starts, whichever
+	// side made it
+	for _, sides := range [][2]string{
+		{"a\nb\nnew\nc\nd\ne\nf\ng\n", "a\nb\nC\nd\ne\nf\ng\n"},
+		{"a\nb\nC\nd\ne\nf\ng\n", "a\nb\nnew\nc\nd\ne\nf\ng\n"},
+	} {
+		result = ThreeWayMerge(base, sides[0], sides[1], "test.txt")
+		if len(result.Conflicts) != 1 {
+			t.Fatalf("got %d conflicts, want 1:\n%s", len(result.Conflicts), result.Content)
+		}
+		if c := result.Conflicts[0]; c.Base != "c\n" || c.LineStart != 2 || c.LineEnd != 3 {
+			t.Errorf("insertion at a change = %+v", c)
+		}
+	}
+}
+
+func TestThreeWayMerge_ConflictRangesAfterShift(t *testing.T) {
+	base := "a\nb\nc\nd\n"
+	// Ours adds two lines at the top and changes d; theirs removes b and
+	// changes d differently
+	result := ThreeWayMerge(base, "x\ny\na\nb\nc\nD1\n", "a\nc\nD2\n", "test.txt")
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("got %d conflicts, want 1:\n%s", len(result.Conflicts), result.Content)
+	}
+	c := result.Conflicts[0]
+	if c.LineStart != 3 || c.OurStart != 5 || c.OurEnd != 6 || c.TheirStart != 2 || c.TheirEnd != 3 {
+		t.Errorf("ranges = base %d ours [%d,%d) theirs [%d,%d)", c.LineStart, c.OurStart, c.OurEnd, c.TheirStart, c.TheirEnd)
+	}
+}
+
+func TestMergeResult_Resolve(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	result := ThreeWayMerge(base, "A\nb\nours\nd\ne\n", "a\nb\ntheirs\nd\nE\n", "test.txt")
+	if !result.HasConflict {
+		t.Fatal("expected a conflict")
+	}
+
+	tests := []struct {
+		favor Favor
+		want  string
+	}{
+		{FavorOurs, "A\nb\nours\nd\nE\n"},
+		{FavorTheirs, "A\nb\ntheirs\nd\nE\n"},
+		{FavorUnion, "A\nb\nours\ntheirs\nd\nE\n"},
+	}
+	for _, tt := range tests {
+		if got, ok := result.Resolve(tt.favor); !ok || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.favor, got, ok, tt.want)
+		}
+	}
+	if _, ok := result.Resolve(FavorNone); ok {
+		t.Error("Resolve(FavorNone) should leave the conflict")
+	}
+
+	binary := ThreeWayMerge("\x00base", "\x00ours", "\x00theirs", "test.bin")
+	if got, ok := binary.Resolve(FavorTheirs); !ok || got != "\x00theirs" {
+		t.Errorf("Resolve(FavorTheirs) of a binary file = %q, %v", got, ok)
+	}
+	if _, ok := binary.Resolve(FavorUnion); ok {
+		t.Error("binary files cannot be merged by union")
+	}
+
+	if _, err := ParseFavor("both"); !errors.Is(err, core.ErrInvalidStrategy) {
+		t.Errorf("ParseFavor(\"both\") error = %v, want ErrInvalidStrategy", err)
+	}
+}
+
 func TestThreeWayMerge_BinaryFile(t *testing.T) {
 	// Binary content with null bytes
 	base := "binary\x00data\x00here"
@@ -1,68 +1,110 @@
 package merge
 
 import (
+	"sort"
+
 	"github.com/codimo/astral/internal/core"
 	"github.com/codimo/astral/internal/storage"
 )
 
-// FindLCA finds the lowest common ancestor of two commits
+// FindLCA finds a lowest common ancestor of two commits. When there are
+// several, see FindMergeBases, it returns the oldest.
 func FindLCA(store *storage.Store, commit1, commit2 core.Hash) (core.Hash, error) {
-	// Build ancestor sets for both commits
-	ancestors1 := make(map[core.Hash]bool)
-	ancestors2 := make(map[core.Hash]bool)
+	bases, err := FindMergeBases(store, commit1, commit2)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	return bases[0], nil
+}
+
+// FindMergeBases finds the best common ancestors of two commits: those
+// that are not an ancestor of another common ancestor. Criss-cross merges
+// can leave more than one. They are ordered oldest first.
+func FindMergeBases(store *storage.Store, commit1, commit2 core.Hash) ([]core.Hash, error) {
+	return FindMergeBasesMany(store, []core.Hash{commit1}, commit2)
+}
+
+// FindMergeBasesMany finds the best common ancestors of other and the
+// commits taken together, as if they had been merged into one commit
+func FindMergeBasesMany(store *storage.Store, commits []core.Hash, other core.Hash) ([]core.Hash, error) {
+	ancestors1 := ancestors(store, commits...)
+	ancestors2 := ancestors(store, other)
+
+	var common []core.Hash
+	for hash := range ancestors2 {
+		if _, ok := ancestors1[hash]; ok {
+			common = append(common, hash)
+		}
+	}
+	if len(common) == 0 {
+		return nil, core.ErrNoCommonAncestor
+	}
 
-	// BFS from commit1
-	queue1 := []core.Hash{commit1}
-	for len(queue1) > 0 {
-		hash := queue1[0]
-		queue1 = queue1[1:]
+	// Every ancestor of a common ancestor is common too, and not the best
+	redundant := make(map[core.Hash]bool)
+	var queue []core.Hash
+	for _, hash := range common {
+		if commit := ancestors1[hash]; commit != nil {
+			queue = append(queue, commit.Parents...)
+		}
+	}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
 
-		if ancestors1[hash] {
+		if hash.IsZero() || redundant[hash] {
 			continue
 		}
-		ancestors1[hash] = true
+		redundant[hash] = true
 
-		commit, err := store.GetCommit(hash)
-		if err != nil {
-			continue // Reached root
+		if commit := ancestors1[hash]; commit != nil {
+			queue = append(queue, commit.Parents...)
 		}
+	}
 
-		for _, parent := range commit.Parents {
-			if !parent.IsZero() {
-				queue1 = append(queue1, parent)
-			}
+	var bases []core.Hash
+	for _, hash := range common {
+		if !redundant[hash] {
+			bases = append(bases, hash)
 		}
 	}
+	sort.Slice(bases, func(i, j int) bool {
+		a, b := ancestors1[bases[i]], ancestors1[bases[j]]
+		if a != nil && b != nil && !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return bases[i].String() < bases[j].String()
+	})
+	return bases, nil
+}
 
-	// BFS from commit2, looking for first common ancestor
-	queue2 := []core.Hash{commit2}
-	for len(queue2) > 0 {
-		hash := queue2[0]
-		queue2 = queue2[1:]
+// ancestors returns commits and all their ancestors. Commits that cannot
+// be read map to nil.
+func ancestors(store *storage.Store, commits ...core.Hash) map[core.Hash]*core.Commit {
+	result := make(map[core.Hash]*core.Commit)
+	queue := append([]core.Hash(nil), commits...)
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
 
-		if ancestors2[hash] {
+		if _, seen := result[hash]; seen {
 			continue
 		}
-		ancestors2[hash] = true
 
-		// Check if this is a common ancestor
-		if ancestors1[hash] {
-			return hash, nil
-		}
-
-		commit, err := store.GetCommit(hash)
+		c, err := store.GetCommit(hash)
 		if err != nil {
-			continue // Reached root
+			result[hash] = nil // Reached root
+			continue
 		}
+		result[hash] = c
 
-		for _, parent := range commit.Parents {
+		for _, parent := range c.Parents {
 			if !parent.IsZero() {
-				queue2 = append(queue2, parent)
+				queue = append(queue, parent)
 			}
 		}
 	}
-
-	return core.Hash{}, core.ErrNoCommonAncestor
+	return result
 }
 
 // IsAncestor checks if ancestor is an ancestor of commit
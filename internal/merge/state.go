@@ -23,9 +23,10 @@ type MergeState struct {
 
 // ConflictInfo represents information about a conflict
 type ConflictInfo struct {
-	Path     string `json:"path"`
-	Type     string `json:"type"` // "content", "delete-modify", "binary"
-	Resolved bool   `json:"resolved"`
+	Path     string       `json:"path"`
+	Type     ConflictType `json:"type"` // ConflictContent, ConflictModifyDelete, ...
+	Resolved bool         `json:"resolved"`
+	Sides    []string     `json:"sides,omitempty"` // Copies of a side written next to the file, e.g. "a.png.theirs"
 }
 
 // SaveMergeState saves state to .asl/MERGE_STATE
@@ -167,14 +167,17 @@ func (c *Client) PushObjects(objs []*core.Object) error {
 	return nil
 }
 
-// UpdateRef updates remote ref
-func (c *Client) UpdateRef(ref string, hash core.Hash) error {
+// UpdateRef points a remote ref at hash if it still points at old. It
+// fails with core.ErrStaleRef if the ref has moved since, and
+// core.ErrRefLocked if the remote is updating it.
+func (c *Client) UpdateRef(ref string, old, hash core.Hash) error {
 	payload := map[string]string{
 		"hash": hash.String(),
+		"old":  old.String(),
 	}
 	data, _ := json.Marshal(payload)
 
-	resp, err := c.doRequest(http.MethodPost, "/refs/heads/"+strings.TrimPrefix(ref, "refs/heads/"), bytes.NewReader(data))
+	resp, err := c.doRequest(http.MethodPost, refPath(ref), bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -182,6 +185,12 @@ func (c *Client) UpdateRef(ref string, hash core.Hash) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		switch resp.StatusCode {
+		case http.StatusConflict:
+			return fmt.Errorf("remote %s: %w", ref, core.ErrStaleRef)
+		case http.StatusLocked:
+			return fmt.Errorf("remote %s: %w", ref, core.ErrRefLocked)
+		}
 		return fmt.Errorf("remote error: %s - %s", resp.Status, string(body))
 	}
 
@@ -190,7 +199,7 @@ func (c *Client) UpdateRef(ref string, hash core.Hash) error {
 
 // GetRef get remote ref
 func (c *Client) GetRef(ref string) (core.Hash, error) {
-	resp, err := c.doRequest(http.MethodGet, "/refs/heads/"+strings.TrimPrefix(ref, "refs/heads/"), nil)
+	resp, err := c.doRequest(http.MethodGet, refPath(ref), nil)
 	if err != nil {
 		return core.Hash{}, err
 	}
@@ -210,3 +219,12 @@ func (c *Client) GetRef(ref string) (core.Hash, error) {
 
 	return core.ParseHash(res["hash"])
 }
+
+// refPath returns the URL path of a ref. Names without a refs/ prefix are
+// taken to be branches.
+func refPath(ref string) string {
+	if strings.HasPrefix(ref, "refs/") {
+		return "/" + ref
+	}
+	return "/refs/heads/" + ref
+}
@@ -3,6 +3,7 @@ package protocol
 import (
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,15 +19,17 @@ type RefStore interface {
 	GetHEAD() (string, error)
 	GetCurrentCommit() (core.Hash, error)
 	ListBranches() ([]string, error)
+	ListTags() ([]string, error)
 	GetRef(ref string) (core.Hash, error)
-	SetRef(ref string, hash core.Hash) error
+	UpdateRef(ref string, expectedOld, newHash core.Hash) error
 }
 
 type Server struct {
-	store *storage.Store
-	refs  RefStore
-	auth  auth.Authenticator
-	mux   *http.ServeMux
+	store    *storage.Store
+	refs     RefStore
+	auth     auth.Authenticator
+	mux      *http.ServeMux
+	readOnly bool
 }
 
 // NewServer creates a new HTTP server
@@ -41,10 +44,17 @@ func NewServer(store *storage.Store, refs RefStore, auth auth.Authenticator) *Se
 	s.mux.HandleFunc("/info/refs", s.handleInfoRefs)
 	s.mux.HandleFunc("/objects/", s.handleObjectRequest) // /objects/{hash} and POST /objects
 	s.mux.HandleFunc("/refs/heads/", s.handleRefRequest) // GET/POST /refs/heads/{branch}
+	s.mux.HandleFunc("/refs/tags/", s.handleRefRequest)  // GET/POST /refs/tags/{tag}
 
 	return s
 }
 
+// SetReadOnly makes the server reject every request that would modify
+// the repository (object uploads and ref updates)
+func (s *Server) SetReadOnly(readOnly bool) {
+	s.readOnly = readOnly
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if s.auth != nil {
 		if err := s.auth.Authenticate(r); err != nil {
@@ -52,6 +62,10 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if s.readOnly && r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Repository is read-only", http.StatusForbidden)
+		return
+	}
 	s.mux.ServeHTTP(w, r)
 }
 
@@ -84,6 +98,17 @@ func (s *Server) handleInfoRefs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// List tags; annotated tags are advertised as the tag object
+	tags, err := s.refs.ListTags()
+	if err == nil {
+		for _, t := range tags {
+			hash, err := s.refs.GetRef("refs/tags/" + t)
+			if err == nil {
+				refs["refs/tags/"+t] = hash.String()
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(refs)
 }
@@ -157,16 +182,16 @@ func (s *Server) handleObjectRequest(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
 
-// handleRefRequest handles /refs/heads/{branch}
+// handleRefRequest handles /refs/heads/{branch} and /refs/tags/{tag}
 func (s *Server) handleRefRequest(w http.ResponseWriter, r *http.Request) {
-	branch := strings.TrimPrefix(r.URL.Path, "/refs/heads/")
-	if branch == "" {
-		http.Error(w, "Missing branch name", http.StatusBadRequest)
+	ref := strings.TrimPrefix(r.URL.Path, "/")
+	if err := checkRef(ref); err != nil {
+		http.Error(w, "Invalid ref name: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	if r.Method == http.MethodGet {
-		hash, err := s.refs.GetRef("refs/heads/" + branch)
+		hash, err := s.refs.GetRef(ref)
 		if err != nil {
 			http.Error(w, "Ref not found", http.StatusNotFound)
 			return
@@ -178,7 +203,8 @@ func (s *Server) handleRefRequest(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodPost {
 		var req struct {
-			Hash string `json:"hash"`
+			Hash string  `json:"hash"`
+			Old  *string `json:"old,omitempty"` // Expected current value
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -192,8 +218,32 @@ func (s *Server) handleRefRequest(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := s.refs.SetRef("refs/heads/"+branch, newHash); err != nil {
-			http.Error(w, "Failed to update ref: "+err.Error(), http.StatusInternalServerError)
+		// Clients that do not say what they expect update the ref from
+		// its current value, and still fail if it changes meanwhile
+		var oldHash core.Hash
+		if req.Old != nil {
+			oldHash, err = core.ParseHash(*req.Old)
+			if err != nil {
+				http.Error(w, "Invalid hash: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		} else {
+			oldHash, err = s.refs.GetRef(ref)
+			if err != nil && !errors.Is(err, core.ErrBranchNotFound) {
+				http.Error(w, "Failed to read ref: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := s.refs.UpdateRef(ref, oldHash, newHash); err != nil {
+			switch {
+			case errors.Is(err, core.ErrStaleRef):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, core.ErrRefLocked):
+				http.Error(w, err.Error(), http.StatusLocked)
+			default:
+				http.Error(w, "Failed to update ref: "+err.Error(), http.StatusInternalServerError)
+			}
 			return
 		}
 
@@ -203,3 +253,14 @@ func (s *Server) handleRefRequest(w http.ResponseWriter, r *http.Request) {
 
 	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 }
+
+// checkRef reports why a client may not read or update ref: it must be a
+// valid branch or tag name below refs/heads/ or refs/tags/
+func checkRef(ref string) error {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/"} {
+		if name, ok := strings.CutPrefix(ref, prefix); ok {
+			return core.CheckRefName(name)
+		}
+	}
+	return errors.New("not a branch or tag")
+}
@@ -1,13 +1,13 @@
 package remote
 
 import (
-	"bufio"
 	"fmt"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/codimo/astral/internal/config"
+	"github.com/codimo/astral/internal/core"
 )
 
 type Remote struct {
@@ -29,7 +29,7 @@ type RemoteURL struct {
 	User     string
 }
 
-// AddRemote adds a new remote to the configuration
+// AddRemote adds a new remote to the repository's configuration
 func AddRemote(repoPath, name, remoteURL string) error {
 	if name == "" {
 		return fmt.Errorf("remote name cannot be empty")
@@ -38,162 +38,131 @@ func AddRemote(repoPath, name, remoteURL string) error {
 		return fmt.Errorf("remote URL cannot be empty")
 	}
 
-	configPath := filepath.Join(repoPath, ".asl", "config", "config")
-
-	// Check if already exists in the config map (we'll read it first)
-	remotes, err := ListRemotes(repoPath)
-	if err == nil {
-		for _, r := range remotes {
-			if r.Name == name {
-				return fmt.Errorf("remote '%s' already exists", name)
-			}
-		}
-	} else {
-		// If ListRemotes fails, it might be because the file doesn't exist or is empty,
-		// but we should proceed with caution/creation if needed.
-		// Ideally checking if the file exists is better.
+	if _, err := GetRemote(repoPath, name); err == nil {
+		return fmt.Errorf("remote '%s' already exists", name)
 	}
 
-	// Append to file
-	f, err := os.OpenFile(configPath, os.O_APPEND|os.O_WRONLY, 0644)
+	f, err := config.ReadFile(config.RepoPath(repoPath))
 	if err != nil {
-		return fmt.Errorf("failed to open config file: %w", err)
+		return err
 	}
-	defer f.Close()
-
-	if _, err := f.WriteString(fmt.Sprintf("\n[remote \"%s\"]\n\turl = %s\n\tfetch = +refs/heads/*:refs/remotes/%s/*\n", name, remoteURL, name)); err != nil {
-		return fmt.Errorf("failed to write to config file: %w", err)
+	section := "remote." + name
+	if err := f.Set(section+".url", remoteURL); err != nil {
+		return err
 	}
-
-	return nil
+	if err := f.Set(section+".fetch", fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", name)); err != nil {
+		return err
+	}
+	return f.Save()
 }
 
-// RemoveRemote removes a remote from the configuration
+// RemoveRemote removes a remote from the repository's configuration
 func RemoveRemote(repoPath, name string) error {
-	configPath := filepath.Join(repoPath, ".asl", "config", "config")
-
-	content, err := os.ReadFile(configPath)
+	f, err := config.ReadFile(config.RepoPath(repoPath))
 	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return err
 	}
+	if !f.RemoveSection("remote", name) {
+		return fmt.Errorf("%w: %s", core.ErrRemoteNotFound, name)
+	}
+	return f.Save()
+}
 
-	lines := strings.Split(string(content), "\n")
-	var newLines []string
-	inSection := false
-	sectionHeader := fmt.Sprintf("[remote \"%s\"]", name)
-
-	found := false
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
+// ListRemotes returns all configured remotes, from every config file, in
+// the order they are first defined
+func ListRemotes(repoPath string) ([]Remote, error) {
+	cfg, err := config.Load(repoPath)
+	if err != nil {
+		return nil, err
+	}
 
-		if trimmed == sectionHeader {
-			inSection = true
-			found = true
+	var result []Remote
+	for _, name := range cfg.Subsections("remote") {
+		remoteURL := cfg.GetString("remote."+name+".url", "")
+		if remoteURL == "" {
 			continue
 		}
+		result = append(result, Remote{
+			Name:     name,
+			URL:      remoteURL,
+			FetchURL: remoteURL,
+			PushURL:  cfg.GetString("remote."+name+".pushurl", remoteURL),
+		})
+	}
+	return result, nil
+}
 
-		if inSection && strings.HasPrefix(trimmed, "[") {
-			inSection = false
-		}
-
-		if !inSection {
-			newLines = append(newLines, line)
-		}
+// GetRemote returns a specific remote by name
+func GetRemote(repoPath, name string) (*Remote, error) {
+	remotes, err := ListRemotes(repoPath)
+	if err != nil {
+		return nil, err
 	}
 
-	if !found {
-		return fmt.Errorf("remote '%s' not found", name)
+	for _, r := range remotes {
+		if r.Name == name {
+			return &r, nil
+		}
 	}
 
-	// Reconstruct file
-	output := strings.Join(newLines, "\n")
-	// Clean up potential multiple newlines
-	// (Simple implementation, might create gaps but functional)
+	return nil, fmt.Errorf("%w: %s", core.ErrRemoteNotFound, name)
+}
 
-	return os.WriteFile(configPath, []byte(output), 0644)
+// Upstream describes the remote branch a local branch tracks
+type Upstream struct {
+	Remote string
+	Merge  string // Ref on the remote, e.g. refs/heads/main
 }
 
-// ListRemotes returns all configured remotes
-func ListRemotes(repoPath string) ([]Remote, error) {
-	configPath := filepath.Join(repoPath, ".asl", "config", "config")
+// TrackingRef returns the local remote-tracking ref for the upstream
+func (u *Upstream) TrackingRef() string {
+	return fmt.Sprintf("refs/remotes/%s/%s", u.Remote, strings.TrimPrefix(u.Merge, "refs/heads/"))
+}
 
-	file, err := os.Open(configPath)
+// SetUpstream records the remote branch that a local branch tracks
+func SetUpstream(repoPath, branch, remoteName, mergeRef string) error {
+	f, err := config.ReadFile(config.RepoPath(repoPath))
 	if err != nil {
-		return nil, fmt.Errorf("failed to open config file: %w", err)
+		return err
 	}
-	defer file.Close()
-
-	remotes := make(map[string]*Remote)
-	scanner := bufio.NewScanner(file)
-
-	var currentRemote *Remote
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
-			continue
-		}
-
-		if strings.HasPrefix(line, "[remote \"") && strings.HasSuffix(line, "\"]") {
-			name := line[9 : len(line)-2]
-			currentRemote = &Remote{Name: name}
-			remotes[name] = currentRemote
-			continue
-		}
-
-		if strings.HasPrefix(line, "[") {
-			currentRemote = nil // Other sections
-			continue
-		}
-
-		if currentRemote != nil {
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-
-				switch key {
-				case "url":
-					currentRemote.URL = value
-					if currentRemote.FetchURL == "" {
-						currentRemote.FetchURL = value
-					}
-					if currentRemote.PushURL == "" {
-						currentRemote.PushURL = value
-					}
-				case "pushurl":
-					currentRemote.PushURL = value
-				}
-			}
-		}
+	f.RemoveSection("branch", branch)
+	if err := f.Set("branch."+branch+".remote", remoteName); err != nil {
+		return err
 	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if err := f.Set("branch."+branch+".merge", mergeRef); err != nil {
+		return err
 	}
+	return f.Save()
+}
 
-	var result []Remote
-	for _, r := range remotes {
-		result = append(result, *r)
+// RemoveUpstream forgets the remote branch a local branch tracks, if any
+func RemoveUpstream(repoPath, branch string) error {
+	f, err := config.ReadFile(config.RepoPath(repoPath))
+	if err != nil {
+		return err
 	}
-
-	return result, nil
+	if !f.RemoveSection("branch", branch) {
+		return nil
+	}
+	return f.Save()
 }
 
-// GetRemote returns a specific remote by name
-func GetRemote(repoPath, name string) (*Remote, error) {
-	remotes, err := ListRemotes(repoPath)
+// GetUpstream returns the remote branch that a local branch tracks
+func GetUpstream(repoPath, branch string) (*Upstream, error) {
+	cfg, err := config.Load(repoPath)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, r := range remotes {
-		if r.Name == name {
-			return &r, nil
-		}
+	upstream := &Upstream{
+		Remote: cfg.GetString("branch."+branch+".remote", ""),
+		Merge:  cfg.GetString("branch."+branch+".merge", ""),
+	}
+	if upstream.Remote == "" || upstream.Merge == "" {
+		return nil, core.ErrNoUpstream
 	}
 
-	return nil, fmt.Errorf("remote '%s' not found", name)
+	return upstream, nil
 }
 
 // ParseURL parses a remote URL into its components
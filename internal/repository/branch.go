@@ -0,0 +1,352 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/remote"
+)
+
+// checkBranchName returns core.ErrInvalidBranchName if name cannot be a
+// branch
+func checkBranchName(name string) error {
+	if err := core.CheckRefName(name); err != nil {
+		return fmt.Errorf("%w %q: %v", core.ErrInvalidBranchName, name, err)
+	}
+	return nil
+}
+
+// checkRefAvailable makes sure a new ref can be created: it must not
+// exist, and no existing ref may be one of its directories or have it as
+// a directory. The ref named except is ignored. Conflicts are reported
+// with the exists error, e.g. core.ErrBranchExists.
+func (r *Repository) checkRefAvailable(ref, except string, exists error) error {
+	refs, err := r.listRefs()
+	if err != nil {
+		return err
+	}
+	for existing := range refs {
+		switch {
+		case existing == except:
+		case existing == ref:
+			_, name, _ := strings.Cut(strings.TrimPrefix(ref, refsDir+"/"), "/")
+			return fmt.Errorf("%w: %s", exists, name)
+		case strings.HasPrefix(ref, existing+"/"), strings.HasPrefix(existing, ref+"/"):
+			return fmt.Errorf("%w: %s conflicts with %s", exists, ref, existing)
+		}
+	}
+	return nil
+}
+
+// refNames returns the names of the refs below dir, e.g. the branches in
+// refs/heads, sorted
+func (r *Repository) refNames(dir string) ([]string, error) {
+	refs, err := r.listRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(refs))
+	for ref := range refs {
+		if name, ok := strings.CutPrefix(ref, dir+"/"); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListBranches returns all branch names, sorted. Names may contain
+// slashes, e.g. feature/login.
+func (r *Repository) ListBranches() ([]string, error) {
+	return r.refNames(headsDir)
+}
+
+// CreateBranch creates a new branch pointing to the current commit
+func (r *Repository) CreateBranch(name string) (err error) {
+	defer r.recordOperation("branch " + name)(&err)
+
+	if err := checkBranchName(name); err != nil {
+		return err
+	}
+	ref := headsDir + "/" + name
+	if err := r.checkRefAvailable(ref, "", core.ErrBranchExists); err != nil {
+		return err
+	}
+
+	// Get current commit; with no commits yet, the branch is empty
+	currentCommit, err := r.GetCurrentCommit()
+	if err != nil && err != core.ErrBranchNotFound {
+		return err
+	}
+
+	tx := r.NewRefTransaction()
+	tx.Update(ref, core.Hash{}, currentCommit, "branch: created from "+r.headName())
+	return tx.Commit()
+}
+
+// DeleteBranch deletes a branch and its reflog, returning the commit it
+// pointed at. Unless force is set, the branch must be merged into HEAD or
+// into its upstream. The current branch cannot be deleted.
+func (r *Repository) DeleteBranch(name string, force bool) (tip core.Hash, err error) {
+	defer r.recordOperation("branch --delete " + name)(&err)
+
+	if err := checkBranchName(name); err != nil {
+		return core.Hash{}, err
+	}
+	ref := headsDir + "/" + name
+	tip, err = r.GetRef(ref)
+	if err != nil {
+		return core.Hash{}, fmt.Errorf("%s: %w", name, err)
+	}
+	if current, err := r.GetCurrentBranch(); err == nil && current == name {
+		return core.Hash{}, fmt.Errorf("%w: %s", core.ErrBranchCheckedOut, name)
+	}
+
+	if !force && !tip.IsZero() {
+		merged, err := r.isMerged(name, tip)
+		if err != nil {
+			return core.Hash{}, err
+		}
+		if !merged {
+			return core.Hash{}, fmt.Errorf("%w: %s", core.ErrBranchNotMerged, name)
+		}
+	}
+
+	tx := r.NewRefTransaction()
+	tx.Delete(ref, tip)
+	if err := tx.Commit(); err != nil {
+		return core.Hash{}, err
+	}
+
+	if err := r.removeReflog(ref); err != nil {
+		return tip, err
+	}
+	return tip, remote.RemoveUpstream(r.Root, name)
+}
+
+// isMerged reports whether a branch's tip is reachable from HEAD or from
+// the branch's upstream
+func (r *Repository) isMerged(name string, tip core.Hash) (bool, error) {
+	var bases []core.Hash
+	if head, err := r.GetCurrentCommit(); err == nil {
+		bases = append(bases, head)
+	}
+	if upstream, err := remote.GetUpstream(r.Root, name); err == nil {
+		if hash, err := r.GetRef(upstream.TrackingRef()); err == nil {
+			bases = append(bases, hash)
+		}
+	}
+
+	for _, base := range bases {
+		merged, err := merge.IsAncestor(r.store, tip, base)
+		if err != nil {
+			return false, err
+		}
+		if merged {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RenameBranch renames a branch, carrying over its reflog and upstream.
+// If it is the current branch, HEAD follows it.
+func (r *Repository) RenameBranch(oldName, newName string) (err error) {
+	defer r.recordOperation("branch --move " + oldName + " " + newName)(&err)
+
+	for _, name := range []string{oldName, newName} {
+		if err := checkBranchName(name); err != nil {
+			return err
+		}
+	}
+	oldRef, newRef := headsDir+"/"+oldName, headsDir+"/"+newName
+	tip, err := r.GetRef(oldRef)
+	if err != nil {
+		return fmt.Errorf("%s: %w", oldName, err)
+	}
+	if oldName == newName {
+		return nil
+	}
+	if err := r.checkRefAvailable(newRef, oldRef, core.ErrBranchExists); err != nil {
+		return err
+	}
+
+	oldLog, err := os.ReadFile(r.reflogPath(oldRef))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read reflog: %w", err)
+	}
+
+	// Renaming a to a/b or back needs a's file out of the way of the
+	// directory, or the directory of the file, so the old ref and its
+	// reflog go first
+	nested := strings.HasPrefix(newRef, oldRef+"/") || strings.HasPrefix(oldRef, newRef+"/")
+
+	message := fmt.Sprintf("branch: renamed %s to %s", oldName, newName)
+	tx := r.NewRefTransaction()
+	tx.Delete(oldRef, tip)
+	if nested {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if err := r.removeReflog(oldRef); err != nil {
+			return err
+		}
+		tx = r.NewRefTransaction()
+	}
+	tx.Update(newRef, core.Hash{}, tip, message)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if err := r.prependReflog(newRef, oldLog); err != nil {
+		return err
+	}
+	if !nested {
+		if err := r.removeReflog(oldRef); err != nil {
+			return err
+		}
+	}
+
+	if head, err := r.GetHEAD(); err == nil && head == oldRef {
+		if err := r.setHEAD(newRef, message); err != nil {
+			return err
+		}
+	}
+
+	if upstream, err := remote.GetUpstream(r.Root, oldName); err == nil {
+		if err := remote.SetUpstream(r.Root, newName, upstream.Remote, upstream.Merge); err != nil {
+			return err
+		}
+		return remote.RemoveUpstream(r.Root, oldName)
+	}
+	return nil
+}
+
+// prependReflog puts entries taken from another ref's reflog before
+// those of ref
+func (r *Repository) prependReflog(ref string, entries []byte) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	log, err := os.ReadFile(r.reflogPath(ref))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read reflog: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.reflogPath(ref)), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+	if err := os.WriteFile(r.reflogPath(ref), append(entries, log...), 0644); err != nil {
+		return fmt.Errorf("failed to write reflog: %w", err)
+	}
+	return nil
+}
+
+// removeReflog deletes the reflog of a deleted ref, if it has one
+func (r *Repository) removeReflog(ref string) error {
+	if err := os.Remove(r.reflogPath(ref)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete reflog: %w", err)
+	}
+	r.removeEmptyLogDirs(filepath.Dir(r.reflogPath(ref)))
+	return nil
+}
+
+// removeEmptyLogDirs removes dir and its parents for as long as they are
+// empty, stopping at the reflog directory of refs/heads and the like
+func (r *Repository) removeEmptyLogDirs(dir string) {
+	stop := filepath.Join(r.AslPath(), logsDir, refsDir)
+	for ; filepath.Dir(dir) != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// BranchInfo describes a branch for verbose listings
+type BranchInfo struct {
+	Name    string
+	Tip     core.Hash
+	Subject string // First line of the tip's message
+	Current bool
+	Base    string // Upstream, or else the current branch, that Ahead and Behind compare against
+	Ahead   int    // Commits on the branch but not on Base
+	Behind  int    // Commits on Base but not on the branch
+}
+
+// BranchDetails describes every branch, sorted by name. Each is compared
+// with its upstream's remote-tracking branch if it has one, and otherwise
+// with the current branch.
+func (r *Repository) BranchDetails() ([]BranchInfo, error) {
+	branches, err := r.ListBranches()
+	if err != nil {
+		return nil, err
+	}
+	current, _ := r.GetCurrentBranch()
+
+	infos := make([]BranchInfo, 0, len(branches))
+	for _, name := range branches {
+		info := BranchInfo{Name: name, Current: name == current}
+		if info.Tip, err = r.GetRef(headsDir + "/" + name); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if !info.Tip.IsZero() {
+			commit, err := r.store.GetCommit(info.Tip)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			info.Subject = subject(commit.Message)
+		}
+
+		var base core.Hash
+		if upstream, err := remote.GetUpstream(r.Root, name); err == nil {
+			if hash, err := r.GetRef(upstream.TrackingRef()); err == nil {
+				info.Base = strings.TrimPrefix(upstream.TrackingRef(), remotesDir+"/")
+				base = hash
+			}
+		}
+		if info.Base == "" && current != "" && !info.Current {
+			if hash, err := r.GetRef(headsDir + "/" + current); err == nil {
+				info.Base = current
+				base = hash
+			}
+		}
+		if info.Base != "" {
+			if info.Ahead, info.Behind, err = r.aheadBehind(info.Tip, base); err != nil {
+				return nil, err
+			}
+		}
+
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// aheadBehind counts the commits reachable from a but not b, and from b
+// but not a
+func (r *Repository) aheadBehind(a, b core.Hash) (ahead, behind int, err error) {
+	fromA, err := r.ancestors([]core.Hash{a})
+	if err != nil {
+		return 0, 0, err
+	}
+	fromB, err := r.ancestors([]core.Hash{b})
+	if err != nil {
+		return 0, 0, err
+	}
+	for hash := range fromA {
+		if !fromB[hash] {
+			ahead++
+		}
+	}
+	for hash := range fromB {
+		if !fromA[hash] {
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
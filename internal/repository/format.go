@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/codimo/astral/internal/config"
+	"github.com/codimo/astral/internal/core"
+)
+
+// formatVersion is the repository format written by this version of Astral
+//
+//	1: flat trees whose entry names are full paths
+//	2: nested trees with directory entries
+const formatVersion = 2
+
+// upgradeFormat migrates a repository created by an older version of
+// Astral to formatVersion. Existing commits are not rewritten, so their
+// hashes stay valid on remotes: flat trees remain readable everywhere, and
+// are replaced by nested trees as new commits are made.
+func upgradeFormat(aslPath string) error {
+	f, err := config.ReadFile(filepath.Join(aslPath, configDir, "config"))
+	if err != nil {
+		return err
+	}
+
+	value, ok := f.Get("core.repositoryformatversion")
+	if !ok {
+		return nil
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("%w: repositoryformatversion %q", core.ErrInvalidConfig, value)
+	}
+
+	switch {
+	case version == formatVersion:
+		return nil
+	case version > formatVersion:
+		return fmt.Errorf("%w: repository format version %d is newer than the supported version %d",
+			core.ErrInvalidConfig, version, formatVersion)
+	}
+
+	if err := f.Set("core.repositoryformatversion", strconv.Itoa(formatVersion)); err != nil {
+		return err
+	}
+	if err := f.Save(); err != nil {
+		return fmt.Errorf("failed to upgrade repository format: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,285 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/storage"
+)
+
+// FsckProblem describes a single problem found by Fsck
+type FsckProblem struct {
+	Hash   *core.Hash `json:"hash,omitempty"`
+	Type   string     `json:"type,omitempty"` // Object type, when known
+	Ref    string     `json:"ref,omitempty"`
+	Detail string     `json:"detail,omitempty"`
+}
+
+// FsckReport is the result of an integrity check
+type FsckReport struct {
+	Objects  int           `json:"objects"`  // Objects checked
+	Corrupt  []FsckProblem `json:"corrupt"`  // Objects that fail to read, re-hash or parse
+	Missing  []FsckProblem `json:"missing"`  // Objects referenced but not in the store
+	Dangling []FsckProblem `json:"dangling"` // Objects nothing refers to
+	BadRefs  []FsckProblem `json:"bad_refs"` // Refs that are malformed or point to a non-commit
+}
+
+// OK reports whether the repository is intact. Dangling objects are
+// harmless leftovers and do not count as problems.
+func (r *FsckReport) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Missing) == 0 && len(r.BadRefs) == 0
+}
+
+// Problems returns the number of problems found
+func (r *FsckReport) Problems() int {
+	return len(r.Corrupt) + len(r.Missing) + len(r.BadRefs)
+}
+
+// fsckObject is what Fsck learns about an object while checking it
+type fsckObject struct {
+	typ  core.ObjectType
+	refs []fsckRef
+}
+
+// fsckRef is a reference from one object to another
+type fsckRef struct {
+	hash core.Hash
+	typ  core.ObjectType // Type the referenced object must have
+	what string          // Describes the reference, e.g. "parent of <commit>"
+}
+
+// Fsck verifies every object in the store and every ref. Objects are
+// re-hashed and parsed, references between objects are followed, and refs,
+// HEAD and any merge in progress must point to existing commits, or to tag
+// objects for refs/tags.
+func (r *Repository) Fsck() (*FsckReport, error) {
+	hashes, err := r.store.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FsckReport{
+		Objects:  len(hashes),
+		Corrupt:  []FsckProblem{},
+		Missing:  []FsckProblem{},
+		Dangling: []FsckProblem{},
+		BadRefs:  []FsckProblem{},
+	}
+
+	objects := make(map[core.Hash]*fsckObject, len(hashes))
+	for _, hash := range hashes {
+		obj, problem := r.fsckObject(hash)
+		if problem != "" {
+			report.Corrupt = append(report.Corrupt, newFsckProblem(hash, obj.typ, problem))
+		}
+		objects[hash] = obj
+	}
+
+	referenced := make(map[core.Hash]bool)
+	missing := make(map[core.Hash]bool)
+	for _, hash := range hashes {
+		for _, ref := range objects[hash].refs {
+			referenced[ref.hash] = true
+
+			target, ok := objects[ref.hash]
+			switch {
+			case !ok:
+				if !missing[ref.hash] {
+					missing[ref.hash] = true
+					report.Missing = append(report.Missing, newFsckProblem(ref.hash, ref.typ, ref.what))
+				}
+			case target.typ != "" && target.typ != ref.typ:
+				detail := fmt.Sprintf("%s is a %s, expected %s", ref.what, target.typ, ref.typ)
+				report.Corrupt = append(report.Corrupt, newFsckProblem(hash, objects[hash].typ, detail))
+			}
+		}
+	}
+
+	roots, err := r.fsckRefs(objects, report)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		referenced[root] = true
+	}
+
+	for _, hash := range hashes {
+		if !referenced[hash] {
+			report.Dangling = append(report.Dangling, newFsckProblem(hash, objects[hash].typ, ""))
+		}
+	}
+
+	sortFsckProblems(report.Corrupt)
+	sortFsckProblems(report.Missing)
+	sortFsckProblems(report.BadRefs)
+	return report, nil
+}
+
+// fsckObject reads, re-hashes and parses a single object, returning what
+// it refers to and a description of any problem
+func (r *Repository) fsckObject(hash core.Hash) (*fsckObject, string) {
+	result := &fsckObject{}
+
+	obj, err := r.store.Get(hash)
+	if err != nil {
+		return result, err.Error()
+	}
+	result.typ = obj.Type
+
+	if actual := storage.HashObject(obj.Type, obj.Data); actual != hash {
+		return result, fmt.Sprintf("hash mismatch: content hashes to %s", actual.Short())
+	}
+
+	switch obj.Type {
+	case core.ObjectTypeCommit:
+		if err := core.ValidateCommit(obj.Data); err != nil {
+			return result, err.Error()
+		}
+		commit, err := core.DecodeCommit(obj.Data)
+		if err != nil {
+			return result, err.Error()
+		}
+
+		result.refs = append(result.refs, fsckRef{commit.Tree, core.ObjectTypeTree, "tree of commit " + hash.Short()})
+		for _, parent := range commit.Parents {
+			result.refs = append(result.refs, fsckRef{parent, core.ObjectTypeCommit, "parent of commit " + hash.Short()})
+		}
+
+	case core.ObjectTypeTree:
+		tree, err := core.DecodeTree(obj.Data)
+		if err != nil {
+			return result, err.Error()
+		}
+
+		seen := make(map[string]bool, len(tree.Entries))
+		for _, entry := range tree.Entries {
+			if problem := checkTreeEntryName(entry.Name); problem != "" {
+				return result, problem
+			}
+			if seen[entry.Name] {
+				return result, fmt.Sprintf("duplicate entry %q", entry.Name)
+			}
+			seen[entry.Name] = true
+
+			what := fmt.Sprintf("%s in tree %s", entry.Name, hash.Short())
+			typ := core.ObjectTypeBlob
+			if entry.IsDir() {
+				typ = core.ObjectTypeTree
+			}
+			result.refs = append(result.refs, fsckRef{entry.Hash, typ, what})
+		}
+
+	case core.ObjectTypeTag:
+		tag, err := core.DecodeTag(obj.Data)
+		if err != nil {
+			return result, err.Error()
+		}
+		result.refs = append(result.refs, fsckRef{tag.Target, tag.TargetType, "target of tag " + hash.Short()})
+
+	case core.ObjectTypeBlob:
+		// Any content is valid
+
+	default:
+		return result, fmt.Sprintf("unknown object type %q", obj.Type)
+	}
+
+	return result, ""
+}
+
+// checkTreeEntryName returns a problem description for names that could
+// escape the working directory when checked out
+func checkTreeEntryName(name string) string {
+	if name == "" {
+		return "empty entry name"
+	}
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || strings.ContainsRune(name, 0) {
+		return fmt.Sprintf("invalid entry name %q", name)
+	}
+	for _, part := range strings.Split(name, "/") {
+		// Case-insensitive file systems would also take .ASL for .asl
+		if part == "" || part == "." || part == ".." || strings.EqualFold(part, aslDir) {
+			return fmt.Sprintf("invalid entry name %q", name)
+		}
+	}
+	return ""
+}
+
+// fsckRefs checks refs, HEAD and merge state, returning the commits and
+// tags they point to
+func (r *Repository) fsckRefs(objects map[core.Hash]*fsckObject, report *FsckReport) ([]core.Hash, error) {
+	var roots []core.Hash
+
+	check := func(name, content string) {
+		hash, err := core.ParseHash(content)
+		if err != nil {
+			report.BadRefs = append(report.BadRefs, FsckProblem{Ref: name, Detail: fmt.Sprintf("invalid content %q", content)})
+			return
+		}
+		if hash.IsZero() {
+			return // Branch created before the first commit
+		}
+
+		roots = append(roots, hash)
+		obj, ok := objects[hash]
+		switch {
+		case !ok:
+			report.BadRefs = append(report.BadRefs, FsckProblem{Hash: &hash, Ref: name, Detail: "points to a missing object"})
+		case obj.typ == core.ObjectTypeTag && strings.HasPrefix(name, tagsDir+"/"):
+			// Annotated tag; fsckObject checks what it points to
+		case obj.typ != "" && obj.typ != core.ObjectTypeCommit:
+			report.BadRefs = append(report.BadRefs, FsckProblem{Hash: &hash, Type: string(obj.typ), Ref: name, Detail: "points to a " + string(obj.typ)})
+		}
+	}
+
+	refs, err := r.listRefs()
+	if err != nil {
+		return nil, err
+	}
+	for name, content := range refs {
+		check(name, content)
+	}
+
+	// A symbolic HEAD may name a branch without commits; only a detached
+	// HEAD must hold a valid commit
+	head, err := r.GetHEAD()
+	if err != nil {
+		report.BadRefs = append(report.BadRefs, FsckProblem{Ref: "HEAD", Detail: err.Error()})
+	} else if !strings.HasPrefix(head, "refs/") {
+		check("HEAD", head)
+	}
+
+	state, err := merge.LoadMergeState(r.Root)
+	switch {
+	case err == nil:
+		for _, commit := range []string{state.BaseCommit, state.OurCommit, state.TheirCommit} {
+			if commit != "" {
+				check("MERGE_STATE", commit)
+			}
+		}
+	case err != core.ErrNoMergeInProgress:
+		report.BadRefs = append(report.BadRefs, FsckProblem{Ref: "MERGE_STATE", Detail: err.Error()})
+	}
+
+	return roots, nil
+}
+
+// newFsckProblem creates a problem about an object
+func newFsckProblem(hash core.Hash, typ core.ObjectType, detail string) FsckProblem {
+	return FsckProblem{Hash: &hash, Type: string(typ), Detail: detail}
+}
+
+// sortFsckProblems orders problems by ref, then hash, for stable output
+func sortFsckProblems(problems []FsckProblem) {
+	key := func(p FsckProblem) string {
+		if p.Hash != nil {
+			return p.Ref + " " + p.Hash.String()
+		}
+		return p.Ref
+	}
+	sort.Slice(problems, func(i, j int) bool {
+		return key(problems[i]) < key(problems[j])
+	})
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/storage"
+)
+
+// DefaultGCGrace is how long unreachable objects survive garbage collection,
+// protecting objects written by operations still in progress
+const DefaultGCGrace = 14 * 24 * time.Hour
+
+// GC deletes loose objects that are unreachable from any ref, HEAD, an
+// in-progress merge, or a reflog entry or operation newer than
+// DefaultReflogExpiry, and drops older reflog entries and operations
+func (r *Repository) GC(opts storage.PruneOptions) (*storage.PruneStats, error) {
+	cutoff := time.Now().Add(-DefaultReflogExpiry)
+	if !opts.DryRun {
+		if _, err := r.ExpireReflogs(cutoff); err != nil {
+			return nil, err
+		}
+		if _, err := r.ExpireOperations(cutoff); err != nil {
+			return nil, err
+		}
+	}
+
+	roots, err := r.gcRoots()
+	if err != nil {
+		return nil, err
+	}
+	logged, err := r.reflogCommits(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, logged...)
+	operated, err := r.operationCommits(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	roots = append(roots, operated...)
+
+	reachable, err := r.store.Reachable(roots)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to prune: %w", err)
+	}
+
+	return r.store.Prune(reachable, opts)
+}
+
+// gcRoots returns the commits that keep objects alive: every ref under
+// refs/ (branches, remote-tracking branches and tags), HEAD, and the
+// commits recorded by an in-progress merge
+func (r *Repository) gcRoots() ([]core.Hash, error) {
+	refs, err := r.listRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []core.Hash
+	for name, content := range refs {
+		hash, err := core.ParseHash(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ref %s: %w", name, err)
+		}
+		roots = append(roots, hash)
+	}
+
+	// A detached HEAD is not covered by refs/
+	if head, err := r.GetHEAD(); err == nil && !strings.HasPrefix(head, "refs/") {
+		if hash, err := core.ParseHash(head); err == nil {
+			roots = append(roots, hash)
+		}
+	}
+
+	if state, err := merge.LoadMergeState(r.Root); err == nil {
+		for _, commit := range []string{state.BaseCommit, state.OurCommit, state.TheirCommit} {
+			if hash, err := core.ParseHash(commit); err == nil {
+				roots = append(roots, hash)
+			}
+		}
+	} else if err != core.ErrNoMergeInProgress {
+		return nil, err
+	}
+
+	return roots, nil
+}
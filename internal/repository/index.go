@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/index"
+	"github.com/codimo/astral/internal/storage"
+)
+
+// indexFile is the stat cache, relative to .asl
+const indexFile = "index"
+
+// loadIndex loads the stat cache
+func (r *Repository) loadIndex() (*index.Index, error) {
+	return index.Load(filepath.Join(r.AslPath(), indexFile))
+}
+
+// hashWorkingFile returns the blob hash and mode of a file in the working
+// directory, reading and hashing it only if the index has no trustworthy
+// entry for it
+func (r *Repository) hashWorkingFile(idx *index.Index, path string) (fileEntry, error) {
+	absPath := filepath.Join(r.Root, filepath.FromSlash(path))
+
+	// Stat before reading, so a change made while reading shows up as a
+	// stat mismatch next time
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fileEntry{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if hash, ok := idx.Lookup(path, info); ok {
+		return fileEntry{Hash: hash, Mode: fileMode(info)}, nil
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return fileEntry{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	hash := storage.HashObject(core.ObjectTypeBlob, data)
+	idx.Update(path, info, hash)
+	return fileEntry{Hash: hash, Mode: fileMode(info)}, nil
+}
+
+// storeWorkingFile is like hashWorkingFile, but also makes sure the blob
+// is in the object store
+func (r *Repository) storeWorkingFile(idx *index.Index, path string) (fileEntry, error) {
+	absPath := filepath.Join(r.Root, filepath.FromSlash(path))
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fileEntry{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if hash, ok := idx.Lookup(path, info); ok && r.store.Exists(hash) {
+		return fileEntry{Hash: hash, Mode: fileMode(info)}, nil
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return fileEntry{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	hash, err := r.store.PutBlob(data)
+	if err != nil {
+		return fileEntry{}, fmt.Errorf("failed to store %s: %w", path, err)
+	}
+	idx.Update(path, info, hash)
+	return fileEntry{Hash: hash, Mode: fileMode(info)}, nil
+}
@@ -1,7 +1,11 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -9,13 +13,29 @@ import (
 	"github.com/codimo/astral/internal/merge"
 )
 
+// Merge strategies
+const (
+	StrategyRecursive = "recursive" // Merge the changes of both sides
+	StrategyOurs      = "ours"      // Keep our tree, recording theirs as merged
+)
+
 // MergeOptions specifies options for a merge operation
 type MergeOptions struct {
-	NoFF     bool   // Force merge commit even if fast-forward
-	FFOnly   bool   // Only merge if fast-forward possible
-	Strategy string // "recursive" (default), "ours", "theirs"
+	NoFF     bool        // Force merge commit even if fast-forward
+	FFOnly   bool        // Only merge if fast-forward possible
+	Strategy string      // StrategyRecursive (default) or StrategyOurs
+	Favor    merge.Favor // How the recursive strategy settles conflicting hunks
 }
 
+// ConflictSide selects the version of a conflicted file to keep
+type ConflictSide int
+
+const (
+	KeepWorking ConflictSide = iota // The working copy, as edited
+	KeepOurs                        // Our version
+	KeepTheirs                      // Their version
+)
+
 // MergeResult represents the result of a merge operation
 type MergeResult struct {
 	FastForward bool
@@ -26,24 +46,35 @@ type MergeResult struct {
 	Conflicted  []string
 }
 
+// validate checks the strategy and its options
+func (o MergeOptions) validate() error {
+	switch o.Strategy {
+	case "", StrategyRecursive:
+	case StrategyOurs:
+		if o.FFOnly {
+			return fmt.Errorf("%w: %s never fast-forwards", core.ErrInvalidStrategy, o.Strategy)
+		}
+	default:
+		return fmt.Errorf("%w: %s", core.ErrInvalidStrategy, o.Strategy)
+	}
+	_, err := merge.ParseFavor(string(o.Favor))
+	return err
+}
+
 // Merge merges the specified branch or ref into the current branch
-func (r *Repository) Merge(refName string, opts MergeOptions) (*MergeResult, error) {
+func (r *Repository) Merge(refName string, opts MergeOptions) (result *MergeResult, err error) {
+	defer r.recordOperation("merge " + refName)(&err)
+
 	// 1. Check if merge already in progress
 	if merge.IsMergeInProgress(r.Root) {
 		return nil, core.ErrMergeInProgress
 	}
-
-	// 2. Resolve ref name to commit hash
-	var theirCommit core.Hash
-	var err error
-
-	// Support full refs (e.g. refs/remotes/...) or short branch names
-	if strings.HasPrefix(refName, "refs/") {
-		theirCommit, err = r.GetRef(refName)
-	} else {
-		theirCommit, err = r.GetRef(fmt.Sprintf("refs/heads/%s", refName))
+	if err := opts.validate(); err != nil {
+		return nil, err
 	}
 
+	// 2. Resolve the branch, ref or other revision to a commit hash
+	theirCommit, err := r.ResolveRevision(refName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve ref %s: %w", refName, err)
 	}
@@ -54,47 +85,153 @@ func (r *Repository) Merge(refName string, opts MergeOptions) (*MergeResult, err
 		return nil, fmt.Errorf("failed to get current commit: %w", err)
 	}
 
-	// 4. Find merge base (LCA)
-	baseCommit, err := merge.FindLCA(r.store, ourCommit, theirCommit)
+	// Nothing to do if their commit is already part of our history
+	upToDate, err := merge.IsAncestor(r.store, theirCommit, ourCommit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find merge base: %w", err)
+		return nil, fmt.Errorf("failed to check ancestry: %w", err)
+	}
+	if upToDate {
+		return &MergeResult{Message: "Already up to date"}, nil
 	}
 
-	// 5. Check if fast-forward possible
+	// 4. Check if fast-forward possible
 	canFF, err := merge.CanFastForward(r.store, ourCommit, theirCommit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check fast-forward: %w", err)
 	}
 
-	// 6. If FFOnly flag and can't FF, error
+	// 5. If FFOnly flag and can't FF, error
 	if opts.FFOnly && !canFF {
 		return nil, fmt.Errorf("cannot fast-forward")
 	}
 
-	// 7. If can FF and not NoFF, do fast-forward
-	if canFF && !opts.NoFF {
-		return r.doFastForward(theirCommit, refName)
+	// 6. If can FF and not NoFF, do fast-forward; the ours strategy always
+	// records a merge
+	if canFF && !opts.NoFF && opts.Strategy != StrategyOurs {
+		return r.doFastForward(ourCommit, theirCommit, refName)
 	}
 
-	// 8. Otherwise, do three-way merge
-	return r.doThreeWayMerge(baseCommit, ourCommit, theirCommit, refName, opts)
+	// 7. Otherwise, do three-way merge
+	return r.doThreeWayMerge(ourCommit, theirCommit, refName, opts)
 }
 
-// doFastForward performs a fast-forward merge
-func (r *Repository) doFastForward(target core.Hash, branch string) (*MergeResult, error) {
-	// Update HEAD to target commit
-	currentBranch, err := r.GetCurrentBranch()
+// MergeOctopus merges several branches or refs into the current branch,
+// recording one merge commit with all of them as parents. Each is merged
+// in turn into the result so far. If any of them needs manual conflict
+// resolution, the whole merge is abandoned and the repository is left as
+// it was. Branches already merged are skipped; if only one remains, it is
+// merged as by Merge.
+func (r *Repository) MergeOctopus(refNames []string, opts MergeOptions) (result *MergeResult, err error) {
+	defer r.recordOperation("merge " + strings.Join(refNames, " "))(&err)
+
+	if merge.IsMergeInProgress(r.Root) {
+		return nil, core.ErrMergeInProgress
+	}
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	ourCommit, err := r.GetCurrentCommit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current commit: %w", err)
+	}
+
+	// Resolve every ref, leaving out those already part of our history
+	var names []string
+	var heads []core.Hash
+	seen := make(map[core.Hash]bool)
+	for _, name := range refNames {
+		commit, err := r.ResolveRevision(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve ref %s: %w", name, err)
+		}
+		upToDate, err := merge.IsAncestor(r.store, commit, ourCommit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check ancestry: %w", err)
+		}
+		if upToDate || seen[commit] {
+			continue
+		}
+		seen[commit] = true
+		names = append(names, name)
+		heads = append(heads, commit)
+	}
+
+	switch len(heads) {
+	case 0:
+		return &MergeResult{Message: "Already up to date"}, nil
+	case 1:
+		return r.Merge(names[0], opts)
+	}
+	if opts.FFOnly {
+		return nil, fmt.Errorf("cannot fast-forward")
+	}
+
+	tree, err := r.commitTreeHash(ourCommit)
 	if err != nil {
 		return nil, err
 	}
+	var autoMerged []string
+	if opts.Strategy != StrategyOurs {
+		// Each branch is merged against its base with everything merged
+		// so far, so the intermediate merges need no commits
+		for i, theirs := range heads {
+			base, err := r.mergeBaseMany(append([]core.Hash{ourCommit}, heads[:i]...), theirs)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find merge base of %s: %w", names[i], err)
+			}
+			baseTree, err := r.commitTreeHash(base)
+			if err != nil {
+				return nil, err
+			}
+			theirTree, err := r.commitTreeHash(theirs)
+			if err != nil {
+				return nil, err
+			}
+			merged, err := r.mergeTrees(baseTree, tree, theirTree, opts.Favor)
+			if err != nil {
+				return nil, err
+			}
+			if len(merged.Conflicts) > 0 {
+				paths := make([]string, len(merged.Conflicts))
+				for j, c := range merged.Conflicts {
+					paths[j] = c.Path
+				}
+				return nil, fmt.Errorf("%w: %s conflicts in %s", core.ErrOctopusConflict, names[i], strings.Join(paths, ", "))
+			}
+			tree = merged.Tree
+			autoMerged = append(autoMerged, merged.AutoMerged...)
+		}
+	}
 
-	ref := fmt.Sprintf("refs/heads/%s", currentBranch)
-	if err := r.SetRef(ref, target); err != nil {
+	ourTree, err := r.commitTreeHash(ourCommit)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.moveWorkingTree(ourTree, tree, SwitchOptions{}); err != nil {
+		return nil, err
+	}
+	mergeCommit, err := r.createMergeCommit(names, append([]core.Hash{ourCommit}, heads...), tree)
+	if err != nil {
 		return nil, err
 	}
 
-	// Update working directory
-	if err := r.Checkout(target); err != nil {
+	return &MergeResult{
+		MergeCommit: &mergeCommit,
+		Message:     fmt.Sprintf("Merged %s into current branch", strings.Join(names, ", ")),
+		AutoMerged:  autoMerged,
+	}, nil
+}
+
+// doFastForward performs a fast-forward merge
+func (r *Repository) doFastForward(ours, target core.Hash, branch string) (*MergeResult, error) {
+	// Update the working directory, refusing to overwrite local changes
+	if err := r.moveWorkingDir(ours, target, SwitchOptions{}); err != nil {
+		return nil, err
+	}
+
+	// Update HEAD to target commit
+	if err := r.advanceHEAD(ours, target, "merge "+branch+": fast-forward"); err != nil {
 		return nil, err
 	}
 
@@ -107,144 +244,44 @@ func (r *Repository) doFastForward(target core.Hash, branch string) (*MergeResul
 }
 
 // doThreeWayMerge performs a three-way merge
-func (r *Repository) doThreeWayMerge(base, ours, theirs core.Hash, theirBranch string, opts MergeOptions) (*MergeResult, error) {
-	// Get trees for base, ours, theirs
-	baseTree, err := r.getCommitTree(base)
-	if err != nil {
-		return nil, err
+func (r *Repository) doThreeWayMerge(ours, theirs core.Hash, theirBranch string, opts MergeOptions) (*MergeResult, error) {
+	if opts.Strategy == StrategyOurs {
+		return r.doOursMerge(ours, theirs, theirBranch)
 	}
 
-	ourTree, err := r.getCommitTree(ours)
+	base, err := r.mergeBase(ours, theirs)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to find merge base: %w", err)
 	}
 
-	theirTree, err := r.getCommitTree(theirs)
+	result, err := r.mergeCommits(base, ours, theirs, opts.Favor)
 	if err != nil {
 		return nil, err
 	}
-
-	// Build file maps
-	baseFiles := buildFileMap(baseTree)
-	ourFiles := buildFileMap(ourTree)
-	theirFiles := buildFileMap(theirTree)
-
-	// Find all affected files
-	allFiles := make(map[string]bool)
-	for name := range baseFiles {
-		allFiles[name] = true
-	}
-	for name := range ourFiles {
-		allFiles[name] = true
-	}
-	for name := range theirFiles {
-		allFiles[name] = true
+	conflicts, autoMerged := result.Conflicts, result.AutoMerged
+	ourTree, err := r.commitTreeHash(ours)
+	if err != nil {
+		return nil, err
 	}
 
-	// Merge each file
-	var conflicts []merge.ConflictInfo
-	var autoMerged []string
-	mergedFiles := make(map[string]core.Hash)
-
-	for filename := range allFiles {
-		baseHash, baseExists := baseFiles[filename]
-		ourHash, ourExists := ourFiles[filename]
-		theirHash, theirExists := theirFiles[filename]
-
-		// Handle different cases
-		if !baseExists && ourExists && !theirExists {
-			// Only in ours
-			mergedFiles[filename] = ourHash
-			autoMerged = append(autoMerged, filename)
-		} else if !baseExists && !ourExists && theirExists {
-			// Only in theirs
-			mergedFiles[filename] = theirHash
-			autoMerged = append(autoMerged, filename)
-		} else if !baseExists && ourExists && theirExists {
-			// Added in both
-			if ourHash == theirHash {
-				// Same content
-				mergedFiles[filename] = ourHash
-				autoMerged = append(autoMerged, filename)
-			} else {
-				// Different content - conflict
-				conflicts = append(conflicts, merge.ConflictInfo{
-					Path:     filename,
-					Type:     "add-add",
-					Resolved: false,
-				})
-			}
-		} else if baseExists && !ourExists && !theirExists {
-			// Deleted in both - OK
-			continue
-		} else if baseExists && !ourExists && theirExists {
-			// Delete-modify conflict
-			if baseHash == theirHash {
-				// They didn't change it, we deleted it
-				continue
-			} else {
-				conflicts = append(conflicts, merge.ConflictInfo{
-					Path:     filename,
-					Type:     "delete-modify",
-					Resolved: false,
-				})
-			}
-		} else if baseExists && ourExists && !theirExists {
-			// Modify-delete conflict
-			if baseHash == ourHash {
-				// We didn't change it, they deleted it
-				continue
-			} else {
-				conflicts = append(conflicts, merge.ConflictInfo{
-					Path:     filename,
-					Type:     "modify-delete",
-					Resolved: false,
-				})
-			}
-		} else if baseExists && ourExists && theirExists {
-			// All three exist
-			if ourHash == theirHash {
-				// Both made same changes
-				mergedFiles[filename] = ourHash
-				autoMerged = append(autoMerged, filename)
-			} else if baseHash == ourHash {
-				// Only they changed it
-				mergedFiles[filename] = theirHash
-				autoMerged = append(autoMerged, filename)
-			} else if baseHash == theirHash {
-				// Only we changed it
-				mergedFiles[filename] = ourHash
-				autoMerged = append(autoMerged, filename)
-			} else {
-				// Both changed it differently - need content merge
-				result, err := r.mergeFileContent(filename, baseHash, ourHash, theirHash)
-				if err != nil {
-					return nil, err
-				}
+	// If conflicts exist, save merge state and return
+	if len(conflicts) > 0 {
+		conflictPaths := make([]string, len(conflicts))
+		for i, c := range conflicts {
+			conflictPaths[i] = c.Path
+		}
 
-				if result.HasConflict {
-					conflicts = append(conflicts, merge.ConflictInfo{
-						Path:     filename,
-						Type:     "content",
-						Resolved: false,
-					})
-				} else {
-					// Store merged content
-					hash, err := r.store.PutBlob([]byte(result.Content))
-					if err != nil {
-						return nil, err
-					}
-					mergedFiles[filename] = hash
-					autoMerged = append(autoMerged, filename)
-				}
+		// Refuse to overwrite local changes, then bring in the clean ones
+		touched := append([]string(nil), conflictPaths...)
+		for _, c := range conflicts {
+			if c.Type != merge.ConflictContent {
+				touched = append(touched, c.Path+sideSuffixes[0], c.Path+sideSuffixes[1])
 			}
 		}
-	}
-
-	// If conflicts exist, save merge state and return
-	if len(conflicts) > 0 {
-		currentBranch, err := r.GetCurrentBranch()
-		if err != nil {
+		if err := r.checkUnmodified(ourTree, touched); err != nil {
+			return nil, err
+		}
+		if err := r.moveWorkingTree(ourTree, result.Tree, SwitchOptions{}); err != nil {
 			return nil, err
 		}
 
@@ -259,20 +296,17 @@ func (r *Repository) doThreeWayMerge(base, ours, theirs core.Hash, theirBranch s
 			AutoMerged:  autoMerged,
 		}
 
-		if err := merge.SaveMergeState(r.Root, state); err != nil {
+		// Write out each conflicted file, then save the state with the
+		// side files they recorded so abort and continue can remove them
+		if err := r.writeConflictMarkers(state.Conflicts, base, ours, theirs, r.headName(), theirBranch); err != nil {
+			r.removeSideFiles(state.Conflicts)
 			return nil, err
 		}
-
-		// Write conflict markers to files
-		if err := r.writeConflictMarkers(conflicts, base, ours, theirs, currentBranch, theirBranch); err != nil {
+		if err := merge.SaveMergeState(r.Root, state); err != nil {
+			r.removeSideFiles(state.Conflicts)
 			return nil, err
 		}
 
-		conflictPaths := make([]string, len(conflicts))
-		for i, c := range conflicts {
-			conflictPaths[i] = c.Path
-		}
-
 		return &MergeResult{
 			FastForward: false,
 			Conflicts:   true,
@@ -282,14 +316,13 @@ func (r *Repository) doThreeWayMerge(base, ours, theirs core.Hash, theirBranch s
 		}, nil
 	}
 
-	// No conflicts - create merge commit
-	mergeCommit, err := r.createMergeCommit(theirBranch, ours, theirs, mergedFiles)
-	if err != nil {
+	// No conflicts - update working directory, refusing to overwrite local
+	// changes, and create merge commit
+	if err := r.moveWorkingTree(ourTree, result.Tree, SwitchOptions{}); err != nil {
 		return nil, err
 	}
-
-	// Update working directory
-	if err := r.Checkout(mergeCommit); err != nil {
+	mergeCommit, err := r.createMergeCommit([]string{theirBranch}, []core.Hash{ours, theirs}, result.Tree)
+	if err != nil {
 		return nil, err
 	}
 
@@ -302,6 +335,228 @@ func (r *Repository) doThreeWayMerge(base, ours, theirs core.Hash, theirBranch s
 	}, nil
 }
 
+// doOursMerge records a merge of their commit that keeps our tree unchanged
+func (r *Repository) doOursMerge(ours, theirs core.Hash, theirBranch string) (*MergeResult, error) {
+	tree, err := r.commitTreeHash(ours)
+	if err != nil {
+		return nil, err
+	}
+	mergeCommit, err := r.createMergeCommit([]string{theirBranch}, []core.Hash{ours, theirs}, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MergeResult{
+		MergeCommit: &mergeCommit,
+		Message:     fmt.Sprintf("Merged %s into current branch, keeping our tree", theirBranch),
+	}, nil
+}
+
+// mergeBase returns the commit to merge two commits against: their best
+// common ancestor or, when they have several, a virtual commit made by
+// merging those recursively
+func (r *Repository) mergeBase(ours, theirs core.Hash) (core.Hash, error) {
+	return r.mergeBaseMany([]core.Hash{ours}, theirs)
+}
+
+// mergeBaseMany is mergeBase for merging theirs into a merge of several
+// commits that has not been made yet
+func (r *Repository) mergeBaseMany(ours []core.Hash, theirs core.Hash) (core.Hash, error) {
+	bases, err := merge.FindMergeBasesMany(r.store, ours, theirs)
+	if err != nil {
+		return core.Hash{}, err
+	}
+
+	base := bases[0]
+	for _, next := range bases[1:] {
+		if base, err = r.virtualMerge(base, next); err != nil {
+			return core.Hash{}, err
+		}
+	}
+	return base, nil
+}
+
+// virtualMerge merges two merge bases into a commit that is on no branch.
+// Conflicts are kept in the merged tree: content conflicts with their
+// markers, binary files as in the bases' own base, and files deleted on
+// one side as modified on the other.
+func (r *Repository) virtualMerge(ours, theirs core.Hash) (core.Hash, error) {
+	base, err := r.mergeBase(ours, theirs)
+	if err != nil {
+		return core.Hash{}, err
+	}
+
+	var trees [3]core.Hash
+	for i, commit := range []core.Hash{base, ours, theirs} {
+		tree, err := r.commitTreeHash(commit)
+		if err != nil {
+			return core.Hash{}, err
+		}
+		trees[i] = tree
+	}
+	result, err := r.mergeTrees(trees[0], trees[1], trees[2], merge.FavorNone)
+	if err != nil {
+		return core.Hash{}, err
+	}
+
+	apply := make(map[string]*fileEntry)
+	for _, c := range result.Conflicts {
+		versions, data, err := r.conflictVersions(trees, c.Path)
+		if err != nil {
+			return core.Hash{}, err
+		}
+		our, their := versions[1], versions[2]
+
+		switch {
+		case our == nil:
+			apply[c.Path] = their
+		case their == nil:
+			// Our version is already in the tree
+		default:
+			merged := merge.ThreeWayMerge(data[0], data[1], data[2], c.Path)
+			if merged.HasConflict && merged.Conflicts[0].Type == merge.ConflictBinary {
+				apply[c.Path] = versions[0] // Nil deletes a file added on both sides
+				continue
+			}
+			hash, err := r.store.PutBlob([]byte(merged.Content))
+			if err != nil {
+				return core.Hash{}, err
+			}
+			apply[c.Path] = &fileEntry{Hash: hash, Mode: our.Mode}
+		}
+	}
+	tree, err := r.updateRootTree(result.Tree, apply)
+	if err != nil {
+		return core.Hash{}, err
+	}
+
+	return r.store.PutCommit(&core.Commit{
+		Tree:      tree,
+		Parents:   []core.Hash{ours, theirs},
+		Author:    r.getAuthorName(),
+		Email:     r.getAuthorEmail(),
+		Timestamp: time.Now(),
+		Message:   "Virtual merge base",
+	})
+}
+
+// treeMerge is the outcome of merging three trees
+type treeMerge struct {
+	Tree       core.Hash // Merged tree; a conflicted path keeps our version, if any
+	Conflicts  []merge.ConflictInfo
+	AutoMerged []string // Paths taken from theirs or merged cleanly
+}
+
+// mergeCommits merges the trees of three commits
+func (r *Repository) mergeCommits(base, ours, theirs core.Hash, favor merge.Favor) (*treeMerge, error) {
+	var trees [3]core.Hash
+	for i, commit := range []core.Hash{base, ours, theirs} {
+		tree, err := r.commitTreeHash(commit)
+		if err != nil {
+			return nil, err
+		}
+		trees[i] = tree
+	}
+	return r.mergeTrees(trees[0], trees[1], trees[2], favor)
+}
+
+// mergeTrees applies the changes between base and theirs to ours. Only
+// paths that changed on their side are examined: unchanged subtrees are
+// skipped by hash, and files changed on both sides are merged by content.
+// Conflicting hunks within a file are settled according to favor.
+func (r *Repository) mergeTrees(base, ours, theirs core.Hash, favor merge.Favor) (*treeMerge, error) {
+	result := &treeMerge{}
+
+	switch {
+	case ours == theirs, base == theirs:
+		result.Tree = ours
+		return result, nil
+	case base == ours:
+		result.Tree = theirs
+		return result, nil
+	}
+
+	ourChanges, err := r.diffTrees(base, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirChanges, err := r.diffTrees(base, theirs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(theirChanges))
+	for path := range theirChanges {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	apply := make(map[string]*fileEntry)
+	for _, path := range paths {
+		their := theirChanges[path]
+		our, changedByUs := ourChanges[path]
+
+		switch {
+		case !changedByUs:
+			// Only they changed it
+			apply[path] = their.New
+			result.AutoMerged = append(result.AutoMerged, path)
+
+		case sameFile(our.New, their.New):
+			// Both made the same change
+			result.AutoMerged = append(result.AutoMerged, path)
+
+		case our.New == nil:
+			result.Conflicts = append(result.Conflicts, merge.ConflictInfo{Path: path, Type: merge.ConflictDeleteModify})
+
+		case their.New == nil:
+			result.Conflicts = append(result.Conflicts, merge.ConflictInfo{Path: path, Type: merge.ConflictModifyDelete})
+
+		case their.Old == nil:
+			result.Conflicts = append(result.Conflicts, merge.ConflictInfo{Path: path, Type: merge.ConflictAddAdd})
+
+		default:
+			// Both changed it differently - need content merge
+			merged, err := r.mergeFileContent(path, their.Old.Hash, our.New.Hash, their.New.Hash)
+			if err != nil {
+				return nil, err
+			}
+			content, ok := merged.Resolve(favor)
+			if !ok {
+				result.Conflicts = append(result.Conflicts, merge.ConflictInfo{Path: path, Type: merged.Conflicts[0].Type})
+				continue
+			}
+
+			hash, err := r.store.PutBlob([]byte(content))
+			if err != nil {
+				return nil, err
+			}
+
+			// Keep a mode change made on either side
+			mode := our.New.Mode
+			if mode == their.Old.Mode {
+				mode = their.New.Mode
+			}
+			apply[path] = &fileEntry{Hash: hash, Mode: mode}
+			result.AutoMerged = append(result.AutoMerged, path)
+		}
+	}
+
+	result.Tree, err = r.updateRootTree(ours, apply)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// sameFile reports whether two optional file entries are identical
+func sameFile(a, b *fileEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 // mergeFileContent performs three-way merge on file content
 func (r *Repository) mergeFileContent(filename string, baseHash, ourHash, theirHash core.Hash) (*merge.MergeResult, error) {
 	// Get file contents
@@ -329,35 +584,16 @@ func (r *Repository) mergeFileContent(filename string, baseHash, ourHash, theirH
 	), nil
 }
 
-// createMergeCommit creates a merge commit with two parents
-func (r *Repository) createMergeCommit(theirBranch string, ourCommit, theirCommit core.Hash, files map[string]core.Hash) (core.Hash, error) {
-	// Build tree from merged files
-	tree := &core.Tree{
-		Entries: make([]core.TreeEntry, 0, len(files)),
-	}
-
-	for filename, hash := range files {
-		tree.Entries = append(tree.Entries, core.TreeEntry{
-			Mode: 0100644,
-			Name: filename,
-			Hash: hash,
-		})
-	}
-
-	// Store tree
-	treeHash, err := r.store.PutTree(tree)
-	if err != nil {
-		return core.Hash{}, err
-	}
-
-	// Create commit with two parents
+// createMergeCommit creates a merge commit of the named branches, whose
+// parents are our commit followed by theirs
+func (r *Repository) createMergeCommit(theirBranches []string, parents []core.Hash, treeHash core.Hash) (core.Hash, error) {
 	commit := &core.Commit{
 		Tree:      treeHash,
-		Parents:   []core.Hash{ourCommit, theirCommit},
+		Parents:   parents,
 		Author:    r.getAuthorName(),
 		Email:     r.getAuthorEmail(),
 		Timestamp: time.Now(),
-		Message:   fmt.Sprintf("Merge branch '%s'", theirBranch),
+		Message:   mergeMessage(theirBranches),
 	}
 
 	commitHash, err := r.store.PutCommit(commit)
@@ -365,47 +601,232 @@ func (r *Repository) createMergeCommit(theirBranch string, ourCommit, theirCommi
 		return core.Hash{}, err
 	}
 
-	// Update branch reference
-	currentBranch, err := r.GetCurrentBranch()
-	if err != nil {
-		return core.Hash{}, err
-	}
-
-	ref := fmt.Sprintf("refs/heads/%s", currentBranch)
-	if err := r.SetRef(ref, commitHash); err != nil {
+	// Update branch reference, or HEAD itself when detached
+	reason := "merge " + strings.Join(theirBranches, " ") + ": merge commit"
+	if err := r.advanceHEAD(parents[0], commitHash, reason); err != nil {
 		return core.Hash{}, err
 	}
 
 	return commitHash, nil
 }
 
-// writeConflictMarkers writes conflict markers to files
+// mergeMessage returns the default message of a merge commit, e.g.
+// "Merge branches 'a', 'b' and 'c'"
+func mergeMessage(branches []string) string {
+	quoted := make([]string, len(branches))
+	for i, branch := range branches {
+		quoted[i] = "'" + branch + "'"
+	}
+	if len(quoted) == 1 {
+		return "Merge branch " + quoted[0]
+	}
+	last := len(quoted) - 1
+	return "Merge branches " + strings.Join(quoted[:last], ", ") + " and " + quoted[last]
+}
+
+// writeConflictMarkers writes each conflicted file into the working
+// directory. Content conflicts get conflict markers labelled with the
+// branch names and commits. A file deleted on one side keeps the other
+// side's version, and a binary file keeps ours. For both, each side's
+// version is also written next to the file as <path>.ours and
+// <path>.theirs, and recorded in the conflict's Sides.
 func (r *Repository) writeConflictMarkers(conflicts []merge.ConflictInfo, base, ours, theirs core.Hash, ourBranch, theirBranch string) error {
-	// For now, just write a simple conflict marker
-	// TODO: Implement proper conflict marker generation
+	var trees [3]core.Hash
+	for i, commit := range []core.Hash{base, ours, theirs} {
+		tree, err := r.commitTreeHash(commit)
+		if err != nil {
+			return err
+		}
+		trees[i] = tree
+	}
+
+	idx, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	for i := range conflicts {
+		c := &conflicts[i]
+		versions, data, err := r.conflictVersions(trees, c.Path)
+		if err != nil {
+			return err
+		}
+		our, their := versions[1], versions[2]
+
+		switch {
+		case our == nil && their == nil:
+			continue
+
+		case our == nil:
+			if err := r.writeWorkingFile(idx, c.Path, pendingWrite{entry: *their}); err != nil {
+				return err
+			}
+
+		case their == nil:
+			// Our version is already in place
+
+		default:
+			result := merge.ThreeWayMerge(data[0], data[1], data[2], c.Path)
+			if !result.HasConflict {
+				continue
+			}
+			if result.Conflicts[0].Type == merge.ConflictBinary {
+				break // Our version is already in place
+			}
+
+			content := result.Markers(func(conflict merge.Conflict) string {
+				return merge.FormatConflictMarkers(conflict, ourBranch, theirBranch, ours, theirs, base)
+			})
+			if err := r.writeWorkingFile(idx, c.Path, pendingWrite{entry: *our, data: []byte(content)}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Write out each side that has the file
+		for j, suffix := range sideSuffixes {
+			version := versions[j+1]
+			if version == nil {
+				continue
+			}
+			side := c.Path + suffix
+			if err := r.writeSideFile(side, data[j+1], version.Mode); err != nil {
+				return err
+			}
+			c.Sides = append(c.Sides, side)
+		}
+	}
+
+	return idx.Save()
+}
+
+// conflictVersions returns the entry and content of a file in each of the
+// base, our and their trees; the entry is nil where the file is missing
+func (r *Repository) conflictVersions(trees [3]core.Hash, path string) ([3]*fileEntry, [3]string, error) {
+	var versions [3]*fileEntry
+	var data [3]string
+	for i, tree := range trees {
+		entry, err := r.lookupFile(tree, path)
+		if errors.Is(err, core.ErrFileNotFound) {
+			continue
+		}
+		if err != nil {
+			return versions, data, err
+		}
+		obj, err := r.store.Get(entry.Hash)
+		if err != nil {
+			return versions, data, fmt.Errorf("%s: %w", path, err)
+		}
+		versions[i], data[i] = &entry, string(obj.Data)
+	}
+	return versions, data, nil
+}
+
+// sideSuffixes name the copies of our and their side of a conflicted file
+var sideSuffixes = [2]string{".ours", ".theirs"}
+
+// writeSideFile writes one side of a conflict to an untracked file
+func (r *Repository) writeSideFile(path, data string, mode uint32) error {
+	absPath := filepath.Join(r.Root, filepath.FromSlash(path))
+	if err := os.WriteFile(absPath, []byte(data), os.FileMode(mode&0777)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
 	return nil
 }
 
-// getCommitTree gets the tree for a commit
-func (r *Repository) getCommitTree(commitHash core.Hash) (*core.Tree, error) {
-	commit, err := r.store.GetCommit(commitHash)
+// removeSideFiles deletes the side files written for conflicts
+func (r *Repository) removeSideFiles(conflicts []merge.ConflictInfo) error {
+	for _, c := range conflicts {
+		for _, side := range c.Sides {
+			err := os.Remove(filepath.Join(r.Root, filepath.FromSlash(side)))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", side, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveConflicts marks conflicted files of the merge in progress as
+// resolved, or every unresolved conflict if paths is empty. With KeepOurs
+// or KeepTheirs each file is first replaced with that side's version, or
+// deleted if that side does not have it. It returns the resolved paths.
+func (r *Repository) ResolveConflicts(paths []string, side ConflictSide) (resolved []string, err error) {
+	defer r.recordOperation("resolve")(&err)
+
+	state, err := merge.LoadMergeState(r.Root)
 	if err != nil {
 		return nil, err
 	}
-	return r.store.GetTree(commit.Tree)
+
+	if len(paths) == 0 {
+		for _, c := range state.Conflicts {
+			if !c.Resolved {
+				paths = append(paths, c.Path)
+			}
+		}
+	}
+	for _, path := range paths {
+		if err := state.MarkResolved(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var source string
+	switch side {
+	case KeepOurs:
+		source = state.OurCommit
+	case KeepTheirs:
+		source = state.TheirCommit
+	}
+	if source != "" {
+		commit, err := core.ParseHash(source)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.checkoutVersions(commit, paths); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := merge.SaveMergeState(r.Root, state); err != nil {
+		return nil, err
+	}
+	return paths, nil
 }
 
-// buildFileMap builds a map of filename -> hash from a tree
-func buildFileMap(tree *core.Tree) map[string]core.Hash {
-	files := make(map[string]core.Hash)
-	for _, entry := range tree.Entries {
-		files[entry.Name] = entry.Hash
+// checkoutVersions writes the version of each file in a commit to the
+// working directory and the index, deleting files the commit does not have
+func (r *Repository) checkoutVersions(commit core.Hash, paths []string) error {
+	tree, err := r.commitTreeHash(commit)
+	if err != nil {
+		return err
 	}
-	return files
+	idx, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		entry, err := r.lookupFile(tree, path)
+		switch {
+		case errors.Is(err, core.ErrFileNotFound):
+			err = r.removeWorkingFile(idx, path)
+		case err == nil:
+			err = r.writeWorkingFile(idx, path, pendingWrite{entry: entry})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return idx.Save()
 }
 
 // AbortMerge cancels an ongoing merge
-func (r *Repository) AbortMerge() error {
+func (r *Repository) AbortMerge() (err error) {
+	defer r.recordOperation("merge --abort")(&err)
+
 	// 1. Load merge state
 	state, err := merge.LoadMergeState(r.Root)
 	if err != nil {
@@ -421,13 +842,18 @@ func (r *Repository) AbortMerge() error {
 	if err := r.Checkout(ourCommit); err != nil {
 		return err
 	}
+	if err := r.removeSideFiles(state.Conflicts); err != nil {
+		return err
+	}
 
 	// 3. Clear merge state
 	return merge.ClearMergeState(r.Root)
 }
 
 // ContinueMerge completes a merge after conflict resolution
-func (r *Repository) ContinueMerge() error {
+func (r *Repository) ContinueMerge() (err error) {
+	defer r.recordOperation("merge --continue")(&err)
+
 	// 1. Load merge state
 	state, err := merge.LoadMergeState(r.Root)
 	if err != nil {
@@ -439,19 +865,18 @@ func (r *Repository) ContinueMerge() error {
 		return err
 	}
 
-	// 3. Get all current files (including resolved conflicts)
-	files, err := r.listAllFiles()
-	if err != nil {
+	// 3. Get all current files (including resolved conflicts), leaving out
+	// the copies written next to conflicted files
+	if err := r.removeSideFiles(state.Conflicts); err != nil {
 		return err
 	}
-
-	// 4. Build tree from current working directory
-	tree, err := r.buildTree(files)
+	files, err := r.listAllFiles()
 	if err != nil {
 		return err
 	}
 
-	treeHash, err := r.store.PutTree(tree)
+	// 4. Build tree from current working directory
+	treeHash, err := r.buildTree(files)
 	if err != nil {
 		return err
 	}
@@ -482,14 +907,8 @@ func (r *Repository) ContinueMerge() error {
 		return err
 	}
 
-	// 7. Update branch reference
-	currentBranch, err := r.GetCurrentBranch()
-	if err != nil {
-		return err
-	}
-
-	ref := fmt.Sprintf("refs/heads/%s", currentBranch)
-	if err := r.SetRef(ref, commitHash); err != nil {
+	// 7. Update branch reference, or HEAD itself when detached
+	if err := r.advanceHEAD(ourCommit, commitHash, "merge "+state.Branch+": continue"); err != nil {
 		return err
 	}
 
@@ -0,0 +1,456 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+)
+
+// opLogFile holds the operation log, one JSON-encoded Operation per line
+const opLogFile = "oplog"
+
+// Snapshot is the state an operation can put back: every ref, HEAD and
+// any merge in progress
+type Snapshot struct {
+	HEAD  string            `json:"head"`
+	Refs  map[string]string `json:"refs"`
+	Merge *merge.MergeState `json:"merge,omitempty"`
+}
+
+// commit returns the commit HEAD points at in the snapshot, zero if its
+// branch has no commits
+func (s *Snapshot) commit() (core.Hash, error) {
+	if content, ok := s.Refs[s.HEAD]; ok {
+		return core.ParseHash(content)
+	}
+	if hash, err := core.ParseHash(s.HEAD); err == nil {
+		return hash, nil
+	}
+	return core.Hash{}, nil
+}
+
+// Operation records a command that changed the repository's refs, HEAD
+// or merge state
+type Operation struct {
+	ID          int       `json:"id"`
+	Time        time.Time `json:"time"`
+	Description string    `json:"description"`
+	Undoes      int       `json:"undoes,omitempty"` // The operation this one undid
+	Redoes      int       `json:"redoes,omitempty"` // The operation this one redid
+	Before      Snapshot  `json:"before"`
+	After       Snapshot  `json:"after"`
+}
+
+// snapshot captures the current refs, HEAD and merge state
+func (r *Repository) snapshot() (*Snapshot, error) {
+	head, err := r.GetHEAD()
+	if err != nil {
+		return nil, err
+	}
+	refs, err := r.listRefs()
+	if err != nil {
+		return nil, err
+	}
+	state, err := merge.LoadMergeState(r.Root)
+	if err != nil && !errors.Is(err, core.ErrNoMergeInProgress) {
+		return nil, err
+	}
+	return &Snapshot{HEAD: head, Refs: refs, Merge: state}, nil
+}
+
+// recordOperation snapshots the repository before an operation. The
+// returned function, deferred with a pointer to the operation's error,
+// appends the operation to the log if it changed anything, even if it
+// failed part way. Operations started by another being recorded are
+// part of it and are not logged separately.
+func (r *Repository) recordOperation(description string) func(*error) {
+	return r.beginOperation(&Operation{Description: description})
+}
+
+// beginOperation is recordOperation for a prepared operation. Successful
+// undos and redos are logged even if they change nothing, so that they
+// still move through the history.
+func (r *Repository) beginOperation(op *Operation) func(*error) {
+	r.opDepth++
+	if r.opDepth > 1 {
+		return func(*error) { r.opDepth-- }
+	}
+
+	before, snapErr := r.snapshot()
+	return func(errp *error) {
+		r.opDepth--
+		if snapErr != nil {
+			return
+		}
+
+		after, err := r.snapshot()
+		if err == nil {
+			forced := *errp == nil && (op.Undoes != 0 || op.Redoes != 0)
+			if !forced && reflect.DeepEqual(before, after) {
+				return
+			}
+			op.Before, op.After = *before, *after
+			err = r.appendOperation(op)
+		}
+		if err != nil && *errp == nil {
+			*errp = fmt.Errorf("failed to record operation: %w", err)
+		}
+	}
+}
+
+// appendOperation numbers an operation and adds it to the log. The log
+// is locked while the number is chosen and the entry written, so two
+// processes cannot record operations with the same ID.
+func (r *Repository) appendOperation(op *Operation) error {
+	logPath := filepath.Join(r.AslPath(), opLogFile)
+	lock, err := acquireLock(logPath)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	ops, err := r.Operations()
+	if err != nil {
+		return err
+	}
+	op.ID = 1
+	if len(ops) > 0 {
+		op.ID = ops[len(ops)-1].ID + 1
+	}
+	op.Time = time.Now()
+
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open operation log: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	return f.Close()
+}
+
+// Operations returns the operation log, oldest first
+func (r *Repository) Operations() ([]Operation, error) {
+	f, err := os.Open(filepath.Join(r.AslPath(), opLogFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+	defer f.Close()
+
+	var ops []Operation
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var op Operation
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			return nil, fmt.Errorf("%w: malformed operation log entry", core.ErrInvalidObject)
+		}
+		ops = append(ops, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+	return ops, nil
+}
+
+// UndoStacks replays the operation log and returns the IDs of the
+// operations UndoOperation and RedoOperation would act on, next last. Any
+// operation other than an undo or redo clears what can be redone.
+func UndoStacks(ops []Operation) (done, undone []int) {
+	for _, op := range ops {
+		switch {
+		case op.Undoes != 0:
+			done = removeID(done, op.Undoes)
+			undone = append(undone, op.Undoes)
+		case op.Redoes != 0:
+			undone = removeID(undone, op.Redoes)
+			done = append(done, op.Redoes)
+		default:
+			done = append(done, op.ID)
+			undone = nil
+		}
+	}
+	return done, undone
+}
+
+// removeID removes the last occurrence of id from ids
+func removeID(ids []int, id int) []int {
+	for i := len(ids) - 1; i >= 0; i-- {
+		if ids[i] == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+// findOperation returns the operation with the given ID
+func findOperation(ops []Operation, id int) (*Operation, error) {
+	for i := range ops {
+		if ops[i].ID == id {
+			return &ops[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", core.ErrOperationNotFound, id)
+}
+
+// UndoOperation puts the repository back to its state before the latest
+// operation that has not been undone, and returns that operation.
+// Undoing again steps further back.
+func (r *Repository) UndoOperation(opts SwitchOptions) (*Operation, error) {
+	ops, err := r.Operations()
+	if err != nil {
+		return nil, err
+	}
+	done, _ := UndoStacks(ops)
+	if len(done) == 0 {
+		return nil, core.ErrNothingToUndo
+	}
+	target, err := findOperation(ops, done[len(done)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{Description: fmt.Sprintf("op undo %d", target.ID), Undoes: target.ID}
+	return target, r.applySnapshot(op, &target.Before, opts)
+}
+
+// RedoOperation reapplies the operation most recently undone, and
+// returns it
+func (r *Repository) RedoOperation(opts SwitchOptions) (*Operation, error) {
+	ops, err := r.Operations()
+	if err != nil {
+		return nil, err
+	}
+	_, undone := UndoStacks(ops)
+	if len(undone) == 0 {
+		return nil, core.ErrNothingToRedo
+	}
+	target, err := findOperation(ops, undone[len(undone)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{Description: fmt.Sprintf("op redo %d", target.ID), Redoes: target.ID}
+	return target, r.applySnapshot(op, &target.After, opts)
+}
+
+// RestoreOperation puts the repository back to its state just after an
+// operation, and returns that operation
+func (r *Repository) RestoreOperation(id int, opts SwitchOptions) (*Operation, error) {
+	ops, err := r.Operations()
+	if err != nil {
+		return nil, err
+	}
+	target, err := findOperation(ops, id)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{Description: fmt.Sprintf("op restore %d", target.ID)}
+	return target, r.applySnapshot(op, &target.After, opts)
+}
+
+// applySnapshot restores a snapshot as the given operation
+func (r *Repository) applySnapshot(op *Operation, s *Snapshot, opts SwitchOptions) (err error) {
+	defer r.beginOperation(op)(&err)
+	return r.restoreSnapshot(s, op.Description, opts)
+}
+
+// restoreSnapshot makes the refs, HEAD and merge state match a snapshot
+// and checks out its HEAD. Leaving a merge in progress discards its
+// changes and side files, as aborting it does; restoring one leaves the working
+// directory as it is. Otherwise uncommitted changes are kept as by
+// Switch. Refs are updated with message in their reflogs.
+func (r *Repository) restoreSnapshot(s *Snapshot, message string, opts SwitchOptions) error {
+	if opts.Force && opts.Carry {
+		return fmt.Errorf("force and carry cannot be combined")
+	}
+
+	current, err := r.GetCurrentCommit()
+	if err != nil && !errors.Is(err, core.ErrBranchNotFound) {
+		return err
+	}
+	target, err := s.commit()
+	if err != nil {
+		return err
+	}
+	tree, err := r.commitTreeHash(target)
+	if err != nil {
+		return fmt.Errorf("%s: %w", target.Short(), err)
+	}
+
+	switch {
+	case s.Merge != nil:
+		// The working directory may hold the merge's changes
+	case merge.IsMergeInProgress(r.Root):
+		state, err := merge.LoadMergeState(r.Root)
+		if err != nil {
+			return err
+		}
+		if err := r.checkoutPaths(tree, []string{""}); err != nil {
+			return err
+		}
+		if err := r.removeSideFiles(state.Conflicts); err != nil {
+			return err
+		}
+	default:
+		if err := r.moveWorkingDir(current, target, opts); err != nil {
+			return err
+		}
+	}
+
+	refs, err := r.listRefs()
+	if err != nil {
+		return err
+	}
+	tx := r.NewRefTransaction()
+	var deleted []string
+	for name, content := range refs {
+		if _, keep := s.Refs[name]; !keep {
+			old, err := core.ParseHash(content)
+			if err != nil {
+				return fmt.Errorf("invalid ref %s: %w", name, err)
+			}
+			tx.Delete(name, old)
+			deleted = append(deleted, name)
+		}
+	}
+	for name, content := range s.Refs {
+		if refs[name] == content {
+			continue
+		}
+		hash, err := core.ParseHash(content)
+		if err != nil {
+			return fmt.Errorf("invalid ref %s: %w", name, err)
+		}
+		old, _ := core.ParseHash(refs[name])
+		tx.Update(name, old, hash, message)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, name := range deleted {
+		if err := r.removeReflog(name); err != nil {
+			return err
+		}
+	}
+
+	if head, err := r.GetHEAD(); err != nil || head != s.HEAD {
+		if err := r.setHEAD(s.HEAD, message); err != nil {
+			return err
+		}
+	}
+
+	if s.Merge != nil {
+		return merge.SaveMergeState(r.Root, s.Merge)
+	}
+	return merge.ClearMergeState(r.Root)
+}
+
+// ExpireOperations drops operations recorded before the cutoff and
+// returns how many were dropped
+func (r *Repository) ExpireOperations(cutoff time.Time) (int, error) {
+	lock, err := acquireLock(filepath.Join(r.AslPath(), opLogFile))
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if lock != nil {
+			lock.release()
+		}
+	}()
+
+	ops, err := r.Operations()
+	if err != nil {
+		return 0, err
+	}
+
+	var kept []byte
+	dropped := 0
+	for i := range ops {
+		if ops[i].Time.Before(cutoff) {
+			dropped++
+			continue
+		}
+		data, err := json.Marshal(&ops[i])
+		if err != nil {
+			return 0, err
+		}
+		kept = append(append(kept, data...), '\n')
+	}
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	if err := lock.write(kept); err != nil {
+		return 0, err
+	}
+	err = lock.commit()
+	lock = nil // Committing drops the lock, even on failure
+	if err != nil {
+		return 0, err
+	}
+	return dropped, nil
+}
+
+// operationCommits returns every commit recorded by operations made at or
+// after the cutoff
+func (r *Repository) operationCommits(cutoff time.Time) ([]core.Hash, error) {
+	ops, err := r.Operations()
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []core.Hash
+	for _, op := range ops {
+		if op.Time.Before(cutoff) {
+			continue
+		}
+		for _, s := range []Snapshot{op.Before, op.After} {
+			for name, content := range s.Refs {
+				hash, err := core.ParseHash(content)
+				if err != nil {
+					return nil, fmt.Errorf("invalid ref %s in operation %d: %w", name, op.ID, err)
+				}
+				commits = append(commits, hash)
+			}
+			if hash, err := core.ParseHash(s.HEAD); err == nil {
+				commits = append(commits, hash)
+			}
+			if s.Merge != nil {
+				for _, commit := range []string{s.Merge.BaseCommit, s.Merge.OurCommit, s.Merge.TheirCommit} {
+					if hash, err := core.ParseHash(commit); err == nil {
+						commits = append(commits, hash)
+					}
+				}
+			}
+		}
+	}
+
+	nonZero := commits[:0]
+	for _, hash := range commits {
+		if !hash.IsZero() {
+			nonZero = append(nonZero, hash)
+		}
+	}
+	return nonZero, nil
+}
@@ -0,0 +1,271 @@
+package repository
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// logsDir holds one reflog per ref, mirroring the ref's path, plus HEAD
+const logsDir = "logs"
+
+// DefaultReflogExpiry is how long reflog entries are kept by garbage
+// collection. Until then, the commits they record stay reachable.
+const DefaultReflogExpiry = 90 * 24 * time.Hour
+
+// ReflogEntry records one update of a ref. Each is stored as a line:
+//
+//	<old> <new> <name> <<email>> <unix time> <zone>\t<message>
+type ReflogEntry struct {
+	Old     core.Hash
+	New     core.Hash
+	Name    string
+	Email   string
+	Time    time.Time
+	Message string
+}
+
+// String formats the entry as a reflog line, without the newline
+func (e *ReflogEntry) String() string {
+	return fmt.Sprintf("%s %s %s <%s> %d %s\t%s", e.Old, e.New, e.Name, e.Email,
+		e.Time.Unix(), e.Time.Format("-0700"), strings.ReplaceAll(e.Message, "\n", " "))
+}
+
+// parseReflogEntry parses a reflog line
+func parseReflogEntry(line string) (*ReflogEntry, error) {
+	header, message, _ := strings.Cut(line, "\t")
+
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("%w: malformed reflog entry", core.ErrInvalidObject)
+	}
+	oldHash, err := core.ParseHash(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad old hash in reflog entry", core.ErrInvalidObject)
+	}
+	newHash, err := core.ParseHash(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad new hash in reflog entry", core.ErrInvalidObject)
+	}
+
+	// The name may contain spaces, so locate the identity by its brackets
+	ident := fields[2]
+	lt, gt := strings.Index(ident, " <"), strings.LastIndex(ident, "> ")
+	if lt < 0 || gt < lt {
+		return nil, fmt.Errorf("%w: bad identity in reflog entry", core.ErrInvalidObject)
+	}
+	stamp := strings.Fields(ident[gt+2:])
+	if len(stamp) != 2 {
+		return nil, fmt.Errorf("%w: bad timestamp in reflog entry", core.ErrInvalidObject)
+	}
+	unix, err := strconv.ParseInt(stamp[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad timestamp in reflog entry", core.ErrInvalidObject)
+	}
+	when := time.Unix(unix, 0)
+	if zone, err := time.Parse("-0700", stamp[1]); err == nil {
+		when = when.In(zone.Location())
+	}
+
+	return &ReflogEntry{
+		Old:     oldHash,
+		New:     newHash,
+		Name:    ident[:lt],
+		Email:   ident[lt+2 : gt],
+		Time:    when,
+		Message: message,
+	}, nil
+}
+
+// subject returns the first line of a commit message, for reflog messages
+func subject(message string) string {
+	line, _, _ := strings.Cut(message, "\n")
+	return line
+}
+
+// reflogPath returns the file holding the reflog of a ref
+func (r *Repository) reflogPath(ref string) string {
+	return filepath.Join(r.AslPath(), logsDir, filepath.FromSlash(ref))
+}
+
+// appendReflog records an update of ref
+func (r *Repository) appendReflog(ref string, oldHash, newHash core.Hash, message string) error {
+	entry := &ReflogEntry{
+		Old:     oldHash,
+		New:     newHash,
+		Name:    r.getAuthorName(),
+		Email:   r.getAuthorEmail(),
+		Time:    time.Now(),
+		Message: message,
+	}
+
+	path := r.reflogPath(ref)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog: %w", err)
+	}
+	if _, err := f.WriteString(entry.String() + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write reflog: %w", err)
+	}
+	return f.Close()
+}
+
+// Reflog returns the recorded updates of a ref, newest first. The name is
+// HEAD, a full ref name, or a short name as accepted by ResolveRevision;
+// a short name that matches no ref is taken as a branch. A ref without a
+// reflog has no entries.
+func (r *Repository) Reflog(name string) ([]ReflogEntry, error) {
+	entries, err := r.readReflog(r.reflogRef(name))
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+// reflogRef returns the full name of the ref whose reflog Reflog reads
+func (r *Repository) reflogRef(name string) string {
+	if name == "" || name == "HEAD" {
+		return "HEAD"
+	}
+	if ref, ok := r.findRef(name); ok {
+		return ref
+	}
+	if strings.HasPrefix(name, "refs/") {
+		return name
+	}
+	return "refs/heads/" + name
+}
+
+// readReflog returns the entries of a reflog, oldest first
+func (r *Repository) readReflog(ref string) ([]ReflogEntry, error) {
+	f, err := os.Open(r.reflogPath(ref))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	defer f.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		entry, err := parseReflogEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ref, err)
+		}
+		entries = append(entries, *entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	return entries, nil
+}
+
+// listReflogs returns the names of every ref with a reflog
+func (r *Repository) listReflogs() ([]string, error) {
+	root := filepath.Join(r.AslPath(), logsDir)
+	var refs []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		refs = append(refs, filepath.ToSlash(name))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reflogs: %w", err)
+	}
+	return refs, nil
+}
+
+// ExpireReflogs drops reflog entries recorded before the cutoff and
+// returns how many were dropped
+func (r *Repository) ExpireReflogs(cutoff time.Time) (int, error) {
+	refs, err := r.listReflogs()
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, ref := range refs {
+		entries, err := r.readReflog(ref)
+		if err != nil {
+			return expired, err
+		}
+
+		var kept strings.Builder
+		dropped := 0
+		for _, entry := range entries {
+			if entry.Time.Before(cutoff) {
+				dropped++
+				continue
+			}
+			kept.WriteString(entry.String() + "\n")
+		}
+		if dropped == 0 {
+			continue
+		}
+
+		if err := os.WriteFile(r.reflogPath(ref), []byte(kept.String()), 0644); err != nil {
+			return expired, fmt.Errorf("failed to write reflog: %w", err)
+		}
+		expired += dropped
+	}
+	return expired, nil
+}
+
+// reflogCommits returns every commit recorded by reflog entries made at or
+// after the cutoff
+func (r *Repository) reflogCommits(cutoff time.Time) ([]core.Hash, error) {
+	refs, err := r.listReflogs()
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []core.Hash
+	for _, ref := range refs {
+		entries, err := r.readReflog(ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.Time.Before(cutoff) {
+				continue
+			}
+			for _, hash := range []core.Hash{entry.Old, entry.New} {
+				if !hash.IsZero() {
+					commits = append(commits, hash)
+				}
+			}
+		}
+	}
+	return commits, nil
+}
@@ -0,0 +1,276 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// lockSuffix marks the lock file that claims a ref while it is updated
+const lockSuffix = core.RefLockSuffix
+
+// lockFile is an exclusive claim on a file, held by creating <path>.lock.
+// New content is written to the lock file and renamed over the original on
+// commit, so readers never see a partial write and a second writer fails
+// instead of interleaving with the first.
+type lockFile struct {
+	path string
+	f    *os.File
+}
+
+// acquireLock creates the lock file for path, failing with
+// core.ErrRefLocked if another process holds it
+func acquireLock(path string) (*lockFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create ref directory: %w", err)
+	}
+	f, err := os.OpenFile(path+lockSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%w: %s exists; if no other asl process is running, remove it", core.ErrRefLocked, path+lockSuffix)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &lockFile{path: path, f: f}, nil
+}
+
+// write sets the content the file will have on commit
+func (l *lockFile) write(data []byte) error {
+	if _, err := l.f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", l.path+lockSuffix, err)
+	}
+	return nil
+}
+
+// commit renames the lock file over the original, releasing the lock
+func (l *lockFile) commit() error {
+	if err := l.f.Close(); err != nil {
+		os.Remove(l.path + lockSuffix)
+		return fmt.Errorf("failed to write %s: %w", l.path+lockSuffix, err)
+	}
+	if err := os.Rename(l.path+lockSuffix, l.path); err != nil {
+		os.Remove(l.path + lockSuffix)
+		return fmt.Errorf("failed to update %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// release drops the lock without changing the original
+func (l *lockFile) release() {
+	l.f.Close()
+	os.Remove(l.path + lockSuffix)
+}
+
+// RefTransaction updates several refs together. Every ref is locked and
+// checked before any is written, so a failed check or a ref locked by
+// another process leaves all of them untouched. If writing one fails,
+// those already written are put back.
+type RefTransaction struct {
+	repo    *Repository
+	updates []refUpdate
+}
+
+// refUpdate is one change queued in a RefTransaction
+type refUpdate struct {
+	ref     string
+	old     core.Hash
+	new     core.Hash
+	verify  bool // Fail unless the ref points at old
+	delete  bool
+	message string
+}
+
+// NewRefTransaction starts an empty ref transaction
+func (r *Repository) NewRefTransaction() *RefTransaction {
+	return &RefTransaction{repo: r}
+}
+
+// Update queues pointing ref at newHash, provided it still points at
+// expectedOld when the transaction commits. A missing ref counts as
+// pointing at the zero hash. "HEAD" stands for the current branch, or HEAD
+// itself when it is detached. The message is recorded in the reflog.
+func (tx *RefTransaction) Update(ref string, expectedOld, newHash core.Hash, message string) {
+	tx.updates = append(tx.updates, refUpdate{ref: ref, old: expectedOld, new: newHash, verify: true, message: message})
+}
+
+// Delete queues removing ref, provided it still points at expectedOld
+// when the transaction commits
+func (tx *RefTransaction) Delete(ref string, expectedOld core.Hash) {
+	tx.updates = append(tx.updates, refUpdate{ref: ref, old: expectedOld, verify: true, delete: true})
+}
+
+// Commit applies the queued updates. It fails with core.ErrStaleRef if a
+// ref no longer has its expected value and core.ErrRefLocked if another
+// process is updating one.
+func (tx *RefTransaction) Commit() error {
+	r := tx.repo
+	head, err := r.GetHEAD()
+	if err != nil {
+		return err
+	}
+
+	updates := make([]refUpdate, len(tx.updates))
+	seen := make(map[string]bool, len(updates))
+	for i, u := range tx.updates {
+		if u.ref == "HEAD" && strings.HasPrefix(head, "refs/") {
+			u.ref = head
+		}
+		u.ref = filepath.ToSlash(u.ref)
+		if seen[u.ref] {
+			return fmt.Errorf("ref %s updated twice in one transaction", u.ref)
+		}
+		seen[u.ref] = true
+		updates[i] = u
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].ref < updates[j].ref })
+
+	// Lock and check every ref before writing any
+	locks := make([]*lockFile, 0, len(updates))
+	defer func() {
+		for _, lock := range locks {
+			if lock != nil {
+				lock.release()
+			}
+		}
+	}()
+	current := make([]core.Hash, len(updates))
+	existed := make([]bool, len(updates))
+	for i, u := range updates {
+		path := filepath.Join(r.AslPath(), filepath.FromSlash(u.ref))
+		lock, err := acquireLock(path)
+		if err != nil {
+			return err
+		}
+		locks = append(locks, lock)
+
+		hash, exists, err := r.readRef(u.ref)
+		if err != nil {
+			return err
+		}
+		if u.delete && !exists {
+			return fmt.Errorf("%s: %w", u.ref, core.ErrBranchNotFound)
+		}
+		if u.verify && hash != u.old {
+			return fmt.Errorf("%w: %s is at %s, expected %s", core.ErrStaleRef, u.ref, hash.Short(), u.old.Short())
+		}
+		current[i], existed[i] = hash, exists
+		if !u.delete {
+			if err := lock.write([]byte(u.new.String() + "\n")); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, u := range updates {
+		if err := r.applyRefUpdate(u, locks[i]); err != nil {
+			if !u.delete {
+				locks[i] = nil // A failed commit drops the lock
+			}
+			return r.rollbackRefs(err, updates[:i], current[:i], existed[:i])
+		}
+		locks[i] = nil
+	}
+	locks = locks[:0]
+
+	// Rewriting a ref with its current value is not worth recording
+	for i, u := range updates {
+		if u.delete || current[i] == u.new {
+			continue
+		}
+		if err := r.appendReflog(u.ref, current[i], u.new, u.message); err != nil {
+			return err
+		}
+		if u.ref != "HEAD" && u.ref == head {
+			if err := r.appendReflog("HEAD", current[i], u.new, u.message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// applyRefUpdate writes a locked update, releasing its lock
+func (r *Repository) applyRefUpdate(u refUpdate, lock *lockFile) error {
+	if !u.delete {
+		return lock.commit()
+	}
+	if err := os.Remove(lock.path); err != nil {
+		return fmt.Errorf("failed to delete ref %s: %w", u.ref, err)
+	}
+	lock.release()
+	r.removeEmptyRefDirs(filepath.Dir(lock.path))
+	return nil
+}
+
+// rollbackRefs puts back refs written by a transaction that then failed
+// with err: each is reset to its old value, or removed if it did not
+// exist. It returns err, noting any ref that could not be put back.
+func (r *Repository) rollbackRefs(err error, updates []refUpdate, old []core.Hash, existed []bool) error {
+	for i := len(updates) - 1; i >= 0; i-- {
+		path := filepath.Join(r.AslPath(), filepath.FromSlash(updates[i].ref))
+		if rerr := r.restoreRef(path, old[i], existed[i]); rerr != nil {
+			err = fmt.Errorf("%w; %s could not be put back: %v", err, updates[i].ref, rerr)
+		}
+	}
+	return err
+}
+
+// restoreRef writes hash to the ref file at path, or removes it if the
+// ref should not exist
+func (r *Repository) restoreRef(path string, hash core.Hash, exists bool) error {
+	lock, err := acquireLock(path)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		err := os.Remove(path)
+		lock.release()
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		r.removeEmptyRefDirs(filepath.Dir(path))
+		return nil
+	}
+	if err := lock.write([]byte(hash.String() + "\n")); err != nil {
+		lock.release()
+		return err
+	}
+	return lock.commit()
+}
+
+// readRef returns the hash a ref points at and whether it exists; a
+// missing ref reads as zero
+func (r *Repository) readRef(ref string) (core.Hash, bool, error) {
+	hash, err := r.GetRef(ref)
+	if err == core.ErrBranchNotFound {
+		return core.Hash{}, false, nil
+	}
+	if err != nil {
+		return core.Hash{}, false, fmt.Errorf("%s: %w", ref, err)
+	}
+	return hash, true, nil
+}
+
+// removeEmptyRefDirs removes dir and its parents for as long as they are
+// empty, stopping at refs/heads, refs/tags and the like
+func (r *Repository) removeEmptyRefDirs(dir string) {
+	stop := filepath.Join(r.AslPath(), refsDir)
+	for ; filepath.Dir(dir) != stop && strings.HasPrefix(dir, stop); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
+
+// UpdateRef points ref at newHash if it still points at expectedOld, and
+// fails with core.ErrStaleRef otherwise. A missing ref counts as pointing
+// at the zero hash.
+func (r *Repository) UpdateRef(ref string, expectedOld, newHash core.Hash) error {
+	tx := r.NewRefTransaction()
+	tx.Update(ref, expectedOld, newHash, "update "+ref)
+	return tx.Commit()
+}
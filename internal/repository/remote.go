@@ -0,0 +1,498 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/codimo/astral/internal/auth"
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/protocol"
+	"github.com/codimo/astral/internal/remote"
+	"github.com/codimo/astral/internal/transfer"
+)
+
+const (
+	// DefaultRemote is the remote name used by clone and when none is given
+	DefaultRemote = "origin"
+
+	remotesDir = "refs/remotes"
+
+	// pushBatchSize bounds the number of objects uploaded per request
+	pushBatchSize = 256
+)
+
+// PushOptions specifies options for a push operation
+type PushOptions struct {
+	Force       bool // Allow non-fast-forward updates
+	All         bool // Push every local branch
+	SetUpstream bool // Record the pushed branch as upstream of the local branch
+	Tags        bool // Push every local tag instead of branches
+}
+
+// Clone creates a new repository at path from the repository at url.
+// It creates remote-tracking refs for every remote branch and checks out
+// the remote's default branch.
+func Clone(url, path string) (*Repository, error) {
+	created, err := prepareCloneDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := clone(url, path)
+	if err != nil {
+		if created {
+			os.RemoveAll(path)
+		} else {
+			os.RemoveAll(filepath.Join(path, aslDir))
+		}
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// prepareCloneDir makes sure path is an empty directory, reporting whether
+// it had to be created
+func prepareCloneDir(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return false, fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(entries) > 0 {
+		return false, fmt.Errorf("%w: %s", core.ErrDestinationUsed, path)
+	}
+	return false, nil
+}
+
+func clone(url, path string) (*Repository, error) {
+	repo, err := Init(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := remote.AddRemote(path, DefaultRemote, url); err != nil {
+		return nil, err
+	}
+
+	client := protocol.NewClient(url, auth.FromEnv())
+	remoteRefs, err := client.ListRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+	remoteRefs = usableRefs(remoteRefs)
+
+	if _, err := repo.fetchRefs(client, DefaultRemote, remoteRefs); err != nil {
+		return nil, err
+	}
+
+	branch, hash := defaultBranch(remoteRefs)
+	if branch == "" {
+		// Empty remote, leave the clone on the default branch with no commits
+		return repo, nil
+	}
+
+	if err := repo.setRef(filepath.Join(headsDir, branch), hash, "clone: from "+url); err != nil {
+		return nil, err
+	}
+	if err := repo.setHEAD(filepath.Join(headsDir, branch), "clone: from "+url); err != nil {
+		return nil, err
+	}
+	if err := remote.SetUpstream(path, branch, DefaultRemote, "refs/heads/"+branch); err != nil {
+		return nil, err
+	}
+	if err := repo.Checkout(hash); err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+// defaultBranch picks the branch a clone should check out: the branch the
+// remote HEAD points at, preferring main and master when several match
+func defaultBranch(refs map[string]core.Hash) (string, core.Hash) {
+	head, hasHead := refs["HEAD"]
+
+	var all, atHead []string
+	for name, hash := range refs {
+		if !strings.HasPrefix(name, "refs/heads/") || hash.IsZero() {
+			continue
+		}
+		branch := strings.TrimPrefix(name, "refs/heads/")
+		all = append(all, branch)
+		if hasHead && hash == head {
+			atHead = append(atHead, branch)
+		}
+	}
+
+	candidates := atHead
+	if len(candidates) == 0 {
+		candidates = all
+	}
+	if len(candidates) == 0 {
+		return "", core.Hash{}
+	}
+
+	sort.Strings(candidates)
+	chosen := candidates[0]
+	for _, preferred := range []string{"master", "main"} {
+		for _, c := range candidates {
+			if c == preferred {
+				chosen = c
+			}
+		}
+	}
+
+	return chosen, refs["refs/heads/"+chosen]
+}
+
+// Fetch downloads missing objects from a remote and updates its
+// remote-tracking refs (refs/remotes/<remote>/*)
+func (r *Repository) Fetch(remoteName string) (updates []protocol.RefUpdate, err error) {
+	if remoteName == "" {
+		remoteName = DefaultRemote
+	}
+	defer r.recordOperation("fetch " + remoteName)(&err)
+
+	rem, err := remote.GetRemote(r.Root, remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	client := protocol.NewClient(rem.FetchURL, auth.FromEnv())
+	remoteRefs, err := client.ListRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	return r.fetchRefs(client, remoteName, usableRefs(remoteRefs))
+}
+
+// usableRefs returns the remote refs that can be copied: HEAD, and
+// branches and tags with valid names. A name like refs/heads/../x would
+// otherwise be written outside refs/.
+func usableRefs(refs map[string]core.Hash) map[string]core.Hash {
+	usable := make(map[string]core.Hash, len(refs))
+	for name, hash := range refs {
+		if name != "HEAD" {
+			short, ok := strings.CutPrefix(name, "refs/heads/")
+			if !ok {
+				short, ok = strings.CutPrefix(name, "refs/tags/")
+			}
+			if !ok || core.CheckRefName(short) != nil {
+				continue
+			}
+		}
+		usable[name] = hash
+	}
+	return usable
+}
+
+// fetchRefs downloads everything reachable from the remote branches and
+// tags, points the remote-tracking refs at the branches and creates the
+// tags that do not exist locally. Local tags are never moved.
+func (r *Repository) fetchRefs(client *protocol.Client, remoteName string, remoteRefs map[string]core.Hash) ([]protocol.RefUpdate, error) {
+	var tips []core.Hash
+	for name, hash := range remoteRefs {
+		if (strings.HasPrefix(name, "refs/heads/") || strings.HasPrefix(name, "refs/tags/")) && !hash.IsZero() {
+			tips = append(tips, hash)
+		}
+	}
+
+	if err := transfer.Fetch(r.store, client, tips); err != nil {
+		return nil, err
+	}
+
+	var updates []protocol.RefUpdate
+	for name, hash := range remoteRefs {
+		if !strings.HasPrefix(name, "refs/heads/") || hash.IsZero() {
+			continue
+		}
+
+		trackingRef := fmt.Sprintf("%s/%s/%s", remotesDir, remoteName, strings.TrimPrefix(name, "refs/heads/"))
+		old, _ := r.GetRef(trackingRef)
+		if old == hash {
+			continue
+		}
+
+		if err := r.setRef(trackingRef, hash, "fetch: from "+remoteName); err != nil {
+			return nil, err
+		}
+		updates = append(updates, protocol.RefUpdate{Name: trackingRef, Old: old, New: hash})
+	}
+
+	for name, hash := range remoteRefs {
+		if !strings.HasPrefix(name, "refs/tags/") || hash.IsZero() {
+			continue
+		}
+		if _, exists, err := r.readRef(name); err != nil || exists {
+			continue
+		}
+		if err := r.checkRefAvailable(name, "", core.ErrTagExists); err != nil {
+			continue
+		}
+
+		tx := r.NewRefTransaction()
+		tx.Update(name, core.Hash{}, hash, "fetch: from "+remoteName)
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		updates = append(updates, protocol.RefUpdate{Name: name, New: hash})
+	}
+
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Name < updates[j].Name })
+	return updates, nil
+}
+
+// Pull fetches from a remote and merges the remote branch into the current
+// branch. Empty remote or branch names fall back to the current branch's
+// upstream, then to origin and the current branch name.
+func (r *Repository) Pull(remoteName, branch string, opts MergeOptions) (result *MergeResult, err error) {
+	defer r.recordOperation(strings.TrimSpace("pull " + remoteName + " " + branch))(&err)
+
+	if merge.IsMergeInProgress(r.Root) {
+		return nil, core.ErrMergeInProgress
+	}
+
+	currentBranch, err := r.GetCurrentBranch()
+	if err != nil {
+		return nil, err
+	}
+
+	if remoteName == "" || branch == "" {
+		if upstream, err := remote.GetUpstream(r.Root, currentBranch); err == nil {
+			if remoteName == "" {
+				remoteName = upstream.Remote
+			}
+			if branch == "" && remoteName == upstream.Remote {
+				branch = strings.TrimPrefix(upstream.Merge, "refs/heads/")
+			}
+		}
+	}
+	if remoteName == "" {
+		remoteName = DefaultRemote
+	}
+	if branch == "" {
+		branch = currentBranch
+	}
+
+	if _, err := r.Fetch(remoteName); err != nil {
+		return nil, err
+	}
+
+	trackingRef := fmt.Sprintf("%s/%s/%s", remotesDir, remoteName, branch)
+	theirs, err := r.GetRef(trackingRef)
+	if err != nil {
+		return nil, fmt.Errorf("remote branch %s/%s: %w", remoteName, branch, err)
+	}
+
+	// Nothing to merge into yet, adopt the remote branch as-is
+	ours, err := r.GetCurrentCommit()
+	if err != nil || ours.IsZero() {
+		if err := r.setRef(filepath.Join(headsDir, currentBranch), theirs, "pull: from "+remoteName); err != nil {
+			return nil, err
+		}
+		if err := r.Checkout(theirs); err != nil {
+			return nil, err
+		}
+		return &MergeResult{
+			FastForward: true,
+			MergeCommit: &theirs,
+			Message:     fmt.Sprintf("Fast-forward to %s/%s", remoteName, branch),
+		}, nil
+	}
+
+	return r.Merge(trackingRef, opts)
+}
+
+// Push uploads a branch (or every branch with opts.All, or every tag with
+// opts.Tags) to a remote. Updates that are not fast-forwards, and tags
+// that would replace a different remote tag, are rejected unless
+// opts.Force is set.
+func (r *Repository) Push(remoteName, branch string, opts PushOptions) (updates []protocol.RefUpdate, err error) {
+	if remoteName == "" {
+		remoteName = DefaultRemote
+	}
+	defer r.recordOperation("push " + remoteName)(&err)
+
+	rem, err := remote.GetRemote(r.Root, remoteName)
+	if err != nil {
+		return nil, err
+	}
+
+	var branches []string
+	switch {
+	case opts.Tags:
+	case opts.All:
+		branches, err = r.ListBranches()
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(branches)
+	case branch != "":
+		branches = []string{branch}
+	default:
+		current, err := r.GetCurrentBranch()
+		if err != nil {
+			return nil, err
+		}
+		branches = []string{current}
+	}
+
+	client := protocol.NewClient(rem.PushURL, auth.FromEnv())
+	remoteRefs, err := client.ListRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	var remoteTips []core.Hash
+	for _, hash := range remoteRefs {
+		remoteTips = append(remoteTips, hash)
+	}
+
+	for _, name := range branches {
+		update, err := r.pushBranch(client, remoteName, name, remoteRefs, remoteTips, opts)
+		if err != nil {
+			return updates, err
+		}
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+
+	if opts.Tags {
+		tagUpdates, err := r.pushTags(client, remoteRefs, remoteTips, opts.Force)
+		updates = append(updates, tagUpdates...)
+		if err != nil {
+			return updates, err
+		}
+	}
+
+	return updates, nil
+}
+
+// pushTags uploads every local tag the remote lacks. A remote tag with a
+// different value is only replaced when force is set.
+func (r *Repository) pushTags(client *protocol.Client, remoteRefs map[string]core.Hash, remoteTips []core.Hash, force bool) ([]protocol.RefUpdate, error) {
+	tags, err := r.ListTags()
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []protocol.RefUpdate
+	for _, name := range tags {
+		ref := tagsDir + "/" + name
+		local, err := r.GetRef(ref)
+		if err != nil {
+			return updates, fmt.Errorf("tag %s: %w", name, err)
+		}
+
+		old := remoteRefs[ref]
+		if old == local {
+			continue
+		}
+		if !old.IsZero() && !force {
+			return updates, fmt.Errorf("%w on the remote: %s (use --force to replace it)", core.ErrTagExists, name)
+		}
+
+		if err := r.uploadObjects(client, local, remoteTips); err != nil {
+			return updates, err
+		}
+		if err := client.UpdateRef(ref, old, local); err != nil {
+			return updates, err
+		}
+		updates = append(updates, protocol.RefUpdate{Name: ref, Old: old, New: local})
+	}
+	return updates, nil
+}
+
+// pushBranch pushes a single branch, returning nil if it was already up to date
+func (r *Repository) pushBranch(client *protocol.Client, remoteName, branch string, remoteRefs map[string]core.Hash, remoteTips []core.Hash, opts PushOptions) (*protocol.RefUpdate, error) {
+	ref := "refs/heads/" + branch
+	local, err := r.GetRef(ref)
+	if err != nil {
+		return nil, fmt.Errorf("branch %s: %w", branch, err)
+	}
+	if local.IsZero() {
+		return nil, fmt.Errorf("branch %s: %w", branch, core.ErrNoCommits)
+	}
+
+	old := remoteRefs[ref]
+	if old != local {
+		if !old.IsZero() && !opts.Force {
+			// We can only prove a fast-forward if we have the remote tip
+			isFF := false
+			if r.store.Exists(old) {
+				isFF, err = merge.IsAncestor(r.store, old, local)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !isFF {
+				return nil, fmt.Errorf("%w: %s (fetch and merge first, or use --force)", core.ErrNonFastForward, branch)
+			}
+		}
+
+		if err := r.uploadObjects(client, local, remoteTips); err != nil {
+			return nil, err
+		}
+
+		if err := client.UpdateRef(ref, old, local); err != nil {
+			return nil, err
+		}
+	}
+
+	trackingRef := fmt.Sprintf("%s/%s/%s", remotesDir, remoteName, branch)
+	if err := r.setRef(trackingRef, local, "push: to "+remoteName); err != nil {
+		return nil, err
+	}
+
+	if opts.SetUpstream {
+		if err := remote.SetUpstream(r.Root, branch, remoteName, ref); err != nil {
+			return nil, err
+		}
+	}
+
+	if old == local {
+		return nil, nil
+	}
+	return &protocol.RefUpdate{Name: ref, Old: old, New: local}, nil
+}
+
+// uploadObjects sends every object reachable from tip that the remote lacks
+func (r *Repository) uploadObjects(client *protocol.Client, tip core.Hash, remoteTips []core.Hash) error {
+	hashes, err := transfer.CalculatePushPack(r.store, []core.Hash{tip}, remoteTips)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]*core.Object, 0, pushBatchSize)
+	for _, hash := range hashes {
+		obj, err := r.store.Get(hash)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, obj)
+
+		if len(batch) == pushBatchSize {
+			if err := client.PushObjects(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if len(batch) > 0 {
+		return client.PushObjects(batch)
+	}
+	return nil
+}
@@ -2,9 +2,12 @@ package repository
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/codimo/astral/internal/config"
 	"github.com/codimo/astral/internal/core"
 	"github.com/codimo/astral/internal/storage"
 )
@@ -14,12 +17,14 @@ const (
 	configDir = "config"
 	refsDir   = "refs"
 	headsDir  = "refs/heads"
+	tagsDir   = "refs/tags"
 )
 
 // Repository represents an Astral repository
 type Repository struct {
-	Root  string
-	store *storage.Store
+	Root    string
+	store   *storage.Store
+	opDepth int // Nesting of operations being recorded in the operation log
 }
 
 // Init initializes a new repository in the given directory
@@ -53,7 +58,7 @@ func Init(path string) (*Repository, error) {
 
 	// Create default config
 	configPath := filepath.Join(aslPath, "config", "config")
-	defaultConfig := []byte("[core]\n\trepositoryformatversion = 1\n")
+	defaultConfig := []byte(fmt.Sprintf("[core]\n\trepositoryformatversion = %d\n", formatVersion))
 	if err := os.WriteFile(configPath, defaultConfig, 0644); err != nil {
 		return nil, fmt.Errorf("failed to create config: %w", err)
 	}
@@ -70,6 +75,10 @@ func Open(path string) (*Repository, error) {
 		return nil, core.ErrNotARepository
 	}
 
+	if err := upgradeFormat(aslPath); err != nil {
+		return nil, err
+	}
+
 	return &Repository{
 		Root:  path,
 		store: storage.NewStore(aslPath),
@@ -107,6 +116,22 @@ func (r *Repository) AslPath() string {
 	return filepath.Join(r.Root, aslDir)
 }
 
+// Config loads the repository's configuration, layered over the system
+// and global files and the environment
+func (r *Repository) Config() (*config.Config, error) {
+	return config.Load(r.Root)
+}
+
+// configValue returns a setting, or "" if it is unset or the
+// configuration cannot be read
+func (r *Repository) configValue(key string) string {
+	cfg, err := r.Config()
+	if err != nil {
+		return ""
+	}
+	return cfg.GetString(key, "")
+}
+
 // GetHEAD returns the current HEAD reference
 func (r *Repository) GetHEAD() (string, error) {
 	headPath := filepath.Join(r.AslPath(), "HEAD")
@@ -127,7 +152,16 @@ func (r *Repository) GetHEAD() (string, error) {
 
 // SetHEAD sets the HEAD reference
 func (r *Repository) SetHEAD(ref string) error {
-	headPath := filepath.Join(r.AslPath(), "HEAD")
+	return r.setHEAD(ref, "set HEAD")
+}
+
+// setHEAD sets the HEAD reference and records the move in HEAD's reflog
+func (r *Repository) setHEAD(ref, message string) error {
+	lock, err := acquireLock(filepath.Join(r.AslPath(), "HEAD"))
+	if err != nil {
+		return err
+	}
+	old, _ := r.GetCurrentCommit()
 
 	var content string
 	if len(ref) > 11 && ref[:11] == "refs/heads/" {
@@ -136,7 +170,16 @@ func (r *Repository) SetHEAD(ref string) error {
 		content = fmt.Sprintf("%s\n", ref)
 	}
 
-	return os.WriteFile(headPath, []byte(content), 0644)
+	if err := lock.write([]byte(content)); err != nil {
+		lock.release()
+		return err
+	}
+	if err := lock.commit(); err != nil {
+		return err
+	}
+
+	current, _ := r.GetCurrentCommit()
+	return r.appendReflog("HEAD", old, current, message)
 }
 
 // GetRef returns the hash that a reference points to
@@ -160,15 +203,15 @@ func (r *Repository) GetRef(ref string) (core.Hash, error) {
 
 // SetRef sets a reference to point to a hash
 func (r *Repository) SetRef(ref string, hash core.Hash) error {
-	refPath := filepath.Join(r.AslPath(), ref)
-
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
-		return fmt.Errorf("failed to create ref directory: %w", err)
-	}
+	return r.setRef(ref, hash, "update "+ref)
+}
 
-	content := fmt.Sprintf("%s\n", hash.String())
-	return os.WriteFile(refPath, []byte(content), 0644)
+// setRef sets a reference, whatever it points at now, and records the
+// update in its reflog, and in HEAD's when it is the current branch
+func (r *Repository) setRef(ref string, hash core.Hash, message string) error {
+	tx := r.NewRefTransaction()
+	tx.updates = append(tx.updates, refUpdate{ref: ref, new: hash, message: message})
+	return tx.Commit()
 }
 
 // GetCurrentBranch returns the name of the current branch
@@ -182,7 +225,26 @@ func (r *Repository) GetCurrentBranch() (string, error) {
 		return ref[11:], nil
 	}
 
-	return "", fmt.Errorf("HEAD is detached")
+	return "", core.ErrDetachedHead
+}
+
+// headName returns the current branch name, or "HEAD" when HEAD is
+// detached, for labelling the current side of an operation
+func (r *Repository) headName() string {
+	if branch, err := r.GetCurrentBranch(); err == nil {
+		return branch
+	}
+	return "HEAD"
+}
+
+// advanceHEAD moves the current branch, or HEAD itself when it is
+// detached, from old to a new commit, recording message in the reflog. It
+// fails with core.ErrStaleRef if another process moved it since old was
+// read.
+func (r *Repository) advanceHEAD(old, hash core.Hash, message string) error {
+	tx := r.NewRefTransaction()
+	tx.Update("HEAD", old, hash, message)
+	return tx.Commit()
 }
 
 // GetCurrentCommit returns the hash of the current commit
@@ -201,64 +263,37 @@ func (r *Repository) GetCurrentCommit() (core.Hash, error) {
 	return core.ParseHash(ref)
 }
 
-// ListBranches returns all branch names
-func (r *Repository) ListBranches() ([]string, error) {
-	headsPath := filepath.Join(r.AslPath(), headsDir)
-
-	entries, err := os.ReadDir(headsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil
+// listRefs returns the trimmed content of every ref under refs/, keyed by
+// name, e.g. "refs/heads/main"
+func (r *Repository) listRefs() (map[string]string, error) {
+	refs := make(map[string]string)
+	aslPath := r.AslPath()
+
+	err := filepath.WalkDir(filepath.Join(aslPath, refsDir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
 		}
-		return nil, fmt.Errorf("failed to read branches: %w", err)
-	}
-
-	branches := make([]string, 0, len(entries))
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			branches = append(branches, entry.Name())
+		if d.IsDir() || strings.HasSuffix(d.Name(), lockSuffix) {
+			return nil
 		}
-	}
-
-	return branches, nil
-}
 
-// CreateBranch creates a new branch pointing to the current commit
-func (r *Repository) CreateBranch(name string) error {
-	// Validate branch name
-	if name == "" || name == "HEAD" {
-		return core.ErrInvalidBranchName
-	}
-
-	// Check if branch already exists
-	ref := filepath.Join(headsDir, name)
-	refPath := filepath.Join(r.AslPath(), ref)
-	if _, err := os.Stat(refPath); err == nil {
-		return core.ErrBranchExists
-	}
-
-	// Get current commit
-	currentCommit, err := r.GetCurrentCommit()
-	if err != nil {
-		if err == core.ErrBranchNotFound {
-			// No commits yet, create empty branch
-			return r.SetRef(ref, core.Hash{})
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
 		}
-		return err
-	}
-
-	return r.SetRef(ref, currentCommit)
-}
-
-// SwitchBranch switches to a different branch
-func (r *Repository) SwitchBranch(name string) error {
-	ref := filepath.Join(headsDir, name)
-	refPath := filepath.Join(r.AslPath(), ref)
-
-	// Check if branch exists
-	if _, err := os.Stat(refPath); os.IsNotExist(err) {
-		return core.ErrBranchNotFound
+		name, err := filepath.Rel(aslPath, path)
+		if err != nil {
+			return err
+		}
+		refs[filepath.ToSlash(name)] = strings.TrimSpace(string(data))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refs: %w", err)
 	}
 
-	return r.SetHEAD(ref)
+	return refs, nil
 }
@@ -0,0 +1,361 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/remote"
+)
+
+// minPrefixLength is the shortest abbreviated hash ResolveRevision accepts
+const minPrefixLength = 4
+
+// ResolveRevision resolves a revision expression to a commit hash. A
+// revision is a base followed by any number of ancestry suffixes:
+//
+//	HEAD, @              the current commit
+//	<hash>               a full hash, or a unique prefix of at least 4 digits
+//	<name>               refs/<name>, refs/tags/<name>, refs/heads/<name>,
+//	                     refs/remotes/<name> or refs/remotes/<name>/HEAD;
+//	                     annotated tags resolve to the commit they tag
+//	<ref>@{N}            the commit ref pointed at N updates ago
+//	<branch>@{upstream}  the branch's upstream, also @{u}; the current
+//	                     branch when the name is omitted
+//	<rev>~N              the Nth first-parent ancestor (~ alone means ~1)
+//	<rev>^N              the Nth parent (^ alone means ^1, ^0 the commit)
+func (r *Repository) ResolveRevision(spec string) (core.Hash, error) {
+	base, suffix := spec, ""
+	if i := strings.IndexAny(spec, "~^"); i >= 0 {
+		base, suffix = spec[:i], spec[i:]
+	}
+
+	hash, err := r.resolveBase(spec, base)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if hash, err = r.peelToCommit(spec, hash); err != nil {
+		return core.Hash{}, err
+	}
+
+	for suffix != "" {
+		op := suffix[0]
+		suffix = suffix[1:]
+
+		n := 1
+		digits := len(suffix) - len(strings.TrimLeft(suffix, "0123456789"))
+		if digits > 0 {
+			n, err = strconv.Atoi(suffix[:digits])
+			if err != nil {
+				return core.Hash{}, fmt.Errorf("%w %q", core.ErrUnknownRevision, spec)
+			}
+			suffix = suffix[digits:]
+		}
+
+		switch op {
+		case '~':
+			for ; n > 0; n-- {
+				if hash, err = r.nthParent(spec, hash, 1); err != nil {
+					return core.Hash{}, err
+				}
+			}
+		case '^':
+			if n == 0 {
+				continue
+			}
+			if hash, err = r.nthParent(spec, hash, n); err != nil {
+				return core.Hash{}, err
+			}
+		default:
+			return core.Hash{}, fmt.Errorf("%w %q", core.ErrUnknownRevision, spec)
+		}
+	}
+	return hash, nil
+}
+
+// resolveBase resolves the part of a revision before any ancestry suffix
+func (r *Repository) resolveBase(spec, base string) (core.Hash, error) {
+	if base == "HEAD" || base == "@" {
+		return r.GetCurrentCommit()
+	}
+
+	if i := strings.Index(base, "@{"); i >= 0 && strings.HasSuffix(base, "}") {
+		name, selector := base[:i], base[i+2:len(base)-1]
+		switch lower := strings.ToLower(selector); {
+		case lower == "upstream" || lower == "u":
+			return r.resolveUpstream(name)
+		case selector != "" && strings.Trim(selector, "0123456789") == "":
+			n, err := strconv.Atoi(selector)
+			if err != nil {
+				return core.Hash{}, fmt.Errorf("%w %q", core.ErrUnknownRevision, spec)
+			}
+			return r.resolveReflog(spec, name, n)
+		}
+		return core.Hash{}, fmt.Errorf("%w %q", core.ErrUnknownRevision, spec)
+	}
+
+	if len(base) == 2*len(core.Hash{}) {
+		if hash, err := core.ParseHash(base); err == nil {
+			return hash, nil
+		}
+	}
+
+	if ref, ok := r.findRef(base); ok {
+		return r.GetRef(ref)
+	}
+
+	if len(base) >= minPrefixLength && isHex(base) {
+		return r.resolvePrefix(spec, strings.ToLower(base))
+	}
+
+	return core.Hash{}, fmt.Errorf("%w %q", core.ErrUnknownRevision, spec)
+}
+
+// peelToCommit resolves annotated tags to the commit they point at and
+// rejects revisions naming trees or blobs
+func (r *Repository) peelToCommit(spec string, hash core.Hash) (core.Hash, error) {
+	if hash.IsZero() {
+		return hash, nil
+	}
+	target, typ, err := r.peel(hash)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if typ != core.ObjectTypeCommit {
+		return core.Hash{}, fmt.Errorf("%w %q: %s is a %s, not a commit", core.ErrUnknownRevision, spec, target.Short(), typ)
+	}
+	return target, nil
+}
+
+// findRef returns the full name of the ref a short name refers to
+func (r *Repository) findRef(name string) (string, bool) {
+	if name == "" {
+		return "", false
+	}
+
+	candidates := []string{name}
+	if !strings.HasPrefix(name, "refs/") {
+		candidates = []string{
+			"refs/" + name,
+			"refs/tags/" + name,
+			"refs/heads/" + name,
+			"refs/remotes/" + name,
+			"refs/remotes/" + name + "/HEAD",
+		}
+	}
+
+	for _, ref := range candidates {
+		info, err := os.Stat(filepath.Join(r.AslPath(), filepath.FromSlash(ref)))
+		if err == nil && info.Mode().IsRegular() {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// resolvePrefix resolves an abbreviated hash to the one commit or tag it
+// matches
+func (r *Repository) resolvePrefix(spec, prefix string) (core.Hash, error) {
+	matches, err := r.store.FindPrefix(prefix)
+	if err != nil {
+		return core.Hash{}, err
+	}
+
+	var commits []core.Hash
+	for _, hash := range matches {
+		obj, err := r.store.Get(hash)
+		if err != nil {
+			return core.Hash{}, err
+		}
+		if obj.Type == core.ObjectTypeCommit || obj.Type == core.ObjectTypeTag {
+			commits = append(commits, hash)
+		}
+	}
+
+	switch len(commits) {
+	case 0:
+		return core.Hash{}, fmt.Errorf("%w %q", core.ErrUnknownRevision, spec)
+	case 1:
+		return commits[0], nil
+	}
+
+	candidates := make([]string, len(commits))
+	for i, hash := range commits {
+		candidates[i] = hash.String()[:min(len(prefix)+4, len(hash.String()))]
+	}
+	return core.Hash{}, fmt.Errorf("%w %q: could be %s", core.ErrAmbiguousRevision, spec, strings.Join(candidates, ", "))
+}
+
+// resolveUpstream resolves the remote-tracking branch a local branch
+// tracks. An empty name or HEAD means the current branch.
+func (r *Repository) resolveUpstream(name string) (core.Hash, error) {
+	branch := strings.TrimPrefix(name, "refs/heads/")
+	if name == "" || name == "HEAD" {
+		current, err := r.GetCurrentBranch()
+		if err != nil {
+			return core.Hash{}, err
+		}
+		branch = current
+	}
+
+	upstream, err := remote.GetUpstream(r.Root, branch)
+	if err != nil {
+		return core.Hash{}, fmt.Errorf("%s: %w", branch, err)
+	}
+	return r.GetRef(upstream.TrackingRef())
+}
+
+// resolveReflog resolves ref@{n}, the value ref had n updates ago. An
+// empty name means HEAD, and @{0} is the current value even without a
+// reflog.
+func (r *Repository) resolveReflog(spec, name string, n int) (core.Hash, error) {
+	ref := r.reflogRef(name)
+	entries, err := r.Reflog(ref)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if n < len(entries) {
+		return entries[n].New, nil
+	}
+	if n == 0 {
+		return r.resolveBase(spec, ref)
+	}
+	return core.Hash{}, fmt.Errorf("%w %q: the reflog of %s has only %d entries", core.ErrUnknownRevision, spec, ref, len(entries))
+}
+
+// nthParent returns the nth parent of a commit, counting from 1
+func (r *Repository) nthParent(spec string, hash core.Hash, n int) (core.Hash, error) {
+	commit, err := r.store.GetCommit(hash)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if n > len(commit.Parents) {
+		return core.Hash{}, fmt.Errorf("%w %q: %s has no parent %d", core.ErrUnknownRevision, spec, hash.Short(), n)
+	}
+	return commit.Parents[n-1], nil
+}
+
+// isHex reports whether s consists only of hex digits
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Range is the set of commits selected by a range expression
+type Range struct {
+	From      core.Hash // Commits reachable from here are excluded, unless Symmetric
+	To        core.Hash
+	Symmetric bool // Select commits reachable from either side but not both
+}
+
+// ResolveRange resolves a range expression. "A..B" selects the commits
+// reachable from B but not from A, "A...B" those reachable from either
+// but not both, and an omitted side means HEAD. A single revision selects
+// its whole history.
+func (r *Repository) ResolveRange(spec string) (*Range, error) {
+	sep, symmetric := "..", false
+	if strings.Contains(spec, "...") {
+		sep, symmetric = "...", true
+	}
+
+	i := strings.Index(spec, sep)
+	if i < 0 {
+		to, err := r.ResolveRevision(spec)
+		if err != nil {
+			return nil, err
+		}
+		return &Range{To: to}, nil
+	}
+
+	from, to := spec[:i], spec[i+len(sep):]
+	if from == "" {
+		from = "HEAD"
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+
+	rng := &Range{Symmetric: symmetric}
+	var err error
+	if rng.From, err = r.ResolveRevision(from); err != nil {
+		return nil, err
+	}
+	if rng.To, err = r.ResolveRevision(to); err != nil {
+		return nil, err
+	}
+	return rng, nil
+}
+
+// RangeCommits lists the commits a range selects, children before their
+// parents
+func (r *Repository) RangeCommits(rng *Range) ([]core.Hash, error) {
+	tips := []core.Hash{rng.To}
+	excluded, err := r.ancestors([]core.Hash{rng.From})
+	if err != nil {
+		return nil, err
+	}
+	if rng.Symmetric {
+		tips = append(tips, rng.From)
+		toAncestors, err := r.ancestors([]core.Hash{rng.To})
+		if err != nil {
+			return nil, err
+		}
+		for hash := range excluded {
+			if !toAncestors[hash] {
+				delete(excluded, hash)
+			}
+		}
+	}
+
+	// Reversed depth-first post-order puts every commit before its parents
+	type frame struct {
+		hash    core.Hash
+		parents []core.Hash
+	}
+	visited := make(map[core.Hash]bool)
+	var order []core.Hash
+	for _, tip := range tips {
+		if tip.IsZero() || excluded[tip] || visited[tip] {
+			continue
+		}
+		visited[tip] = true
+		stack := []*frame{{hash: tip}}
+		commit, err := r.store.GetCommit(tip)
+		if err != nil {
+			return nil, err
+		}
+		stack[0].parents = commit.Parents
+
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if len(top.parents) == 0 {
+				order = append(order, top.hash)
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			parent := top.parents[0]
+			top.parents = top.parents[1:]
+			if parent.IsZero() || excluded[parent] || visited[parent] {
+				continue
+			}
+			visited[parent] = true
+			commit, err := r.store.GetCommit(parent)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, &frame{hash: parent, parents: commit.Parents})
+		}
+	}
+
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order, nil
+}
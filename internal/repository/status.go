@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+)
+
+// Status describes the working directory relative to HEAD. Astral has no
+// staging area, so every untracked file is saved by the next "asl save";
+// Added only holds new files that an in-progress merge brought in.
+type Status struct {
+	Branch string    // Current branch, empty when HEAD is detached
+	Head   core.Hash // Current commit, zero before the first commit
+
+	Added       []string // New files taken from the branch being merged
+	Modified    []string // Tracked files whose content changed
+	Deleted     []string // Tracked files missing from the working directory
+	ModeChanged []string // Tracked files whose content is unchanged but whose mode changed
+	Untracked   []string // New files that are not ignored
+	Ignored     []string // Untracked files matching an ignore rule; directories end in "/"
+
+	Merge *merge.MergeState // Merge in progress, or nil
+}
+
+// Clean reports whether the working directory matches HEAD. Ignored files
+// do not count.
+func (s *Status) Clean() bool {
+	return len(s.Added) == 0 && len(s.Modified) == 0 && len(s.Deleted) == 0 &&
+		len(s.ModeChanged) == 0 && len(s.Untracked) == 0
+}
+
+// Status compares the working directory against HEAD
+func (r *Repository) Status() (*Status, error) {
+	status := &Status{}
+
+	if branch, err := r.GetCurrentBranch(); err == nil {
+		status.Branch = branch
+	}
+	head, err := r.GetCurrentCommit()
+	if err != nil && !errors.Is(err, core.ErrBranchNotFound) {
+		return nil, err
+	}
+	status.Head = head
+
+	state, err := merge.LoadMergeState(r.Root)
+	switch {
+	case err == nil:
+		status.Merge = state
+	case !errors.Is(err, core.ErrNoMergeInProgress):
+		return nil, err
+	}
+
+	treeHash, err := r.commitTreeHash(head)
+	if err != nil {
+		return nil, err
+	}
+	headFiles, err := r.flattenTree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+	mergedFiles, err := r.mergeIncoming(status.Merge)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := r.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := r.walkWorkingDir(func(path string, isDir bool) {
+		if isDir {
+			path += "/"
+		}
+		status.Ignored = append(status.Ignored, path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		seen[file] = true
+
+		old, tracked := headFiles[file]
+		if !tracked {
+			if _, ok := mergedFiles[file]; ok {
+				status.Added = append(status.Added, file)
+			} else {
+				status.Untracked = append(status.Untracked, file)
+			}
+			continue
+		}
+
+		current, err := r.hashWorkingFile(idx, file)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case current.Hash != old.Hash:
+			status.Modified = append(status.Modified, file)
+		case current.Mode != old.Mode:
+			status.ModeChanged = append(status.ModeChanged, file)
+		}
+	}
+
+	tracked := make(map[string]bool, len(headFiles))
+	for path := range headFiles {
+		if !seen[path] {
+			status.Deleted = append(status.Deleted, path)
+			continue
+		}
+		tracked[path] = true
+	}
+
+	// Keep the hashes computed above for next time
+	idx.Retain(tracked)
+	if err := idx.Save(); err != nil {
+		return nil, err
+	}
+
+	for _, paths := range [][]string{status.Added, status.Modified, status.Deleted, status.ModeChanged, status.Untracked, status.Ignored} {
+		sort.Strings(paths)
+	}
+	return status, nil
+}
+
+// mergeIncoming returns the files of the commit being merged in, or nil
+// when no merge is in progress
+func (r *Repository) mergeIncoming(state *merge.MergeState) (map[string]fileEntry, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	theirs, err := core.ParseHash(state.TheirCommit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid merge state: %w", err)
+	}
+	treeHash, err := r.commitTreeHash(theirs)
+	if err != nil {
+		return nil, err
+	}
+	return r.flattenTree(treeHash)
+}
+
+// fileMode returns the tree entry mode for a file in the working directory
+func fileMode(info os.FileInfo) uint32 {
+	if info.Mode()&0111 != 0 {
+		return core.ModeExecutable
+	}
+	return core.ModeFile
+}
@@ -0,0 +1,465 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/index"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/storage"
+)
+
+// SwitchOptions controls what happens to uncommitted changes when the
+// working directory moves to another commit
+type SwitchOptions struct {
+	Force bool // Overwrite uncommitted changes to files that differ between the commits
+	Carry bool // Merge uncommitted changes into files that differ between the commits
+}
+
+// SwitchBranch switches to a different branch, refusing to overwrite
+// uncommitted changes
+func (r *Repository) SwitchBranch(name string) error {
+	return r.Switch(name, SwitchOptions{})
+}
+
+// Switch checks out the commit of a branch and makes it the current
+// branch. Files that differ between the current and target commits are
+// updated, files only in the current commit are deleted, and everything
+// else in the working directory is left alone. If an uncommitted change
+// would be overwritten, Switch fails with core.ErrDirtyWorkingDir without
+// touching anything, unless opts says otherwise.
+func (r *Repository) Switch(name string, opts SwitchOptions) (err error) {
+	defer r.recordOperation("switch " + name)(&err)
+
+	if opts.Force && opts.Carry {
+		return fmt.Errorf("force and carry cannot be combined")
+	}
+
+	if err := checkBranchName(name); err != nil {
+		return err
+	}
+	ref := filepath.Join(headsDir, name)
+	target, err := r.GetRef(ref)
+	if err != nil {
+		return err
+	}
+
+	if merge.IsMergeInProgress(r.Root) {
+		return core.ErrMergeInProgress
+	}
+
+	current, err := r.GetCurrentCommit()
+	if err != nil && !errors.Is(err, core.ErrBranchNotFound) {
+		return err
+	}
+
+	if err := r.moveWorkingDir(current, target, opts); err != nil {
+		return err
+	}
+	return r.setHEAD(ref, fmt.Sprintf("switch: moving from %s to %s", r.headName(), name))
+}
+
+// Detach checks out a commit and points HEAD directly at it rather than
+// at a branch. The working directory is updated as by Switch. Commits
+// saved while detached only move HEAD; create a branch to keep them.
+func (r *Repository) Detach(commit core.Hash, opts SwitchOptions) (err error) {
+	defer r.recordOperation("switch --detach " + commit.Short())(&err)
+
+	if opts.Force && opts.Carry {
+		return fmt.Errorf("force and carry cannot be combined")
+	}
+
+	if _, err := r.store.GetCommit(commit); err != nil {
+		return fmt.Errorf("%s: %w", commit.Short(), err)
+	}
+
+	if merge.IsMergeInProgress(r.Root) {
+		return core.ErrMergeInProgress
+	}
+
+	current, err := r.GetCurrentCommit()
+	if err != nil && !errors.Is(err, core.ErrBranchNotFound) {
+		return err
+	}
+
+	if err := r.moveWorkingDir(current, commit, opts); err != nil {
+		return err
+	}
+	return r.setHEAD(commit.String(), fmt.Sprintf("switch: moving from %s to %s", r.headName(), commit.Short()))
+}
+
+// Unreachable returns the commits in the history of commit that no ref
+// reaches, newest first. It is empty when commit is on a branch, tag or
+// remote-tracking branch.
+func (r *Repository) Unreachable(commit core.Hash) ([]core.Hash, error) {
+	refs, err := r.listRefs()
+	if err != nil {
+		return nil, err
+	}
+
+	var tips []core.Hash
+	for name, content := range refs {
+		hash, err := core.ParseHash(content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ref %s: %w", name, err)
+		}
+		if hash.IsZero() {
+			continue
+		}
+		// Annotated tags keep the commit they point at
+		hash, typ, err := r.peel(hash)
+		if err != nil {
+			return nil, fmt.Errorf("ref %s: %w", name, err)
+		}
+		if typ == core.ObjectTypeCommit {
+			tips = append(tips, hash)
+		}
+	}
+	reachable, err := r.ancestors(tips)
+	if err != nil {
+		return nil, err
+	}
+
+	var lost []core.Hash
+	seen := make(map[core.Hash]bool)
+	queue := []core.Hash{commit}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		if hash.IsZero() || seen[hash] || reachable[hash] {
+			continue
+		}
+		seen[hash] = true
+		lost = append(lost, hash)
+
+		c, err := r.store.GetCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return lost, nil
+}
+
+// ancestors returns every commit reachable from the given commits,
+// including the commits themselves
+func (r *Repository) ancestors(commits []core.Hash) (map[core.Hash]bool, error) {
+	seen := make(map[core.Hash]bool)
+	queue := append([]core.Hash(nil), commits...)
+	for len(queue) > 0 {
+		hash := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if hash.IsZero() || seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		c, err := r.store.GetCommit(hash)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, c.Parents...)
+	}
+	return seen, nil
+}
+
+// pendingWrite is a file moveWorkingDir will write
+type pendingWrite struct {
+	entry fileEntry
+	data  []byte // Content to write instead of the blob, for carried changes
+}
+
+// moveWorkingDir updates the working directory from one commit's tree to
+// another's, touching only the files that differ between them. Either
+// commit may be zero for the empty tree.
+func (r *Repository) moveWorkingDir(from, to core.Hash, opts SwitchOptions) error {
+	fromTree, err := r.commitTreeHash(from)
+	if err != nil {
+		return err
+	}
+	toTree, err := r.commitTreeHash(to)
+	if err != nil {
+		return err
+	}
+	return r.moveWorkingTree(fromTree, toTree, opts)
+}
+
+// moveWorkingTree is moveWorkingDir for trees
+func (r *Repository) moveWorkingTree(fromTree, toTree core.Hash, opts SwitchOptions) error {
+	changes, err := r.diffTrees(fromTree, toTree)
+	if err != nil {
+		return err
+	}
+
+	idx, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	var deletes []string
+	writes := make(map[string]pendingWrite)
+	var conflicts []string
+	var inTheWay []string // Directories where a file is to be written
+
+	for path, change := range changes {
+		local, isDir, err := r.workingFile(idx, path)
+		if err != nil {
+			return err
+		}
+
+		var carried []byte
+		switch {
+		case isDir && change.New != nil:
+			// Fine if the directory goes away with the files deleted here
+			inTheWay = append(inTheWay, path)
+			writes[path] = pendingWrite{entry: *change.New}
+			continue
+		case isDir:
+			// A directory replaced the file we would delete
+			if !opts.Force {
+				conflicts = append(conflicts, path)
+			}
+			continue
+		case sameFile(local, change.New):
+			continue // Already as wanted
+		case sameFile(local, change.Old), opts.Force:
+			// Unmodified, or modifications are to be discarded
+		case opts.Carry && local != nil && change.Old != nil && change.New != nil:
+			data, ok, err := r.carryChange(path, change, *local)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				conflicts = append(conflicts, path)
+				continue
+			}
+			carried = data
+		default:
+			conflicts = append(conflicts, path)
+			continue
+		}
+
+		if change.New == nil {
+			if local != nil {
+				deletes = append(deletes, path)
+			}
+			continue
+		}
+		entry := *change.New
+		if carried != nil && local.Mode != change.Old.Mode {
+			entry.Mode = local.Mode
+		}
+		writes[path] = pendingWrite{entry: entry, data: carried}
+	}
+
+	for _, dir := range inTheWay {
+		emptied, err := r.emptiedBy(dir, deletes)
+		if err != nil {
+			return err
+		}
+		if !emptied {
+			conflicts = append(conflicts, dir)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return fmt.Errorf("%w: %s would be overwritten", core.ErrDirtyWorkingDir, strings.Join(conflicts, ", "))
+	}
+
+	for _, path := range deletes {
+		if err := r.removeWorkingFile(idx, path); err != nil {
+			return err
+		}
+	}
+
+	paths := make([]string, 0, len(writes))
+	for path := range writes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := r.writeWorkingFile(idx, path, writes[path]); err != nil {
+			return err
+		}
+	}
+
+	return idx.Save()
+}
+
+// emptiedBy reports whether deleting paths leaves nothing but directories
+// in dir
+func (r *Repository) emptiedBy(dir string, paths []string) (bool, error) {
+	deleted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		deleted[path] = true
+	}
+
+	emptied := true
+	err := filepath.WalkDir(filepath.Join(r.Root, filepath.FromSlash(dir)), func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Root, path)
+		if err != nil {
+			return err
+		}
+		if !deleted[filepath.ToSlash(rel)] {
+			emptied = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	return emptied, nil
+}
+
+// checkUnmodified fails with core.ErrDirtyWorkingDir if any of paths
+// differs in the working directory from its version in a tree, including
+// existing when the tree does not have it
+func (r *Repository) checkUnmodified(tree core.Hash, paths []string) error {
+	idx, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	var dirty []string
+	for _, path := range paths {
+		local, isDir, err := r.workingFile(idx, path)
+		if err != nil {
+			return err
+		}
+		var committed *fileEntry
+		entry, err := r.lookupFile(tree, path)
+		switch {
+		case err == nil:
+			committed = &entry
+		case !errors.Is(err, core.ErrFileNotFound):
+			return err
+		}
+		if isDir || !sameFile(local, committed) {
+			dirty = append(dirty, path)
+		}
+	}
+
+	if len(dirty) > 0 {
+		sort.Strings(dirty)
+		return fmt.Errorf("%w: %s would be overwritten", core.ErrDirtyWorkingDir, strings.Join(dirty, ", "))
+	}
+	return nil
+}
+
+// workingFile returns the hash and mode of a path in the working
+// directory, nil if it does not exist, or isDir if it is a directory
+func (r *Repository) workingFile(idx *index.Index, path string) (entry *fileEntry, isDir bool, err error) {
+	info, err := os.Lstat(filepath.Join(r.Root, filepath.FromSlash(path)))
+	if err != nil {
+		// A file where a parent directory should be also means no file
+		if os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.IsDir() {
+		return nil, true, nil
+	}
+
+	current, err := r.hashWorkingFile(idx, path)
+	if err != nil {
+		return nil, false, err
+	}
+	return &current, false, nil
+}
+
+// carryChange merges the uncommitted content of a file into the version
+// being checked out. It returns false if the changes overlap.
+func (r *Repository) carryChange(path string, change fileChange, local fileEntry) ([]byte, bool, error) {
+	base, err := r.store.Get(change.Old.Hash)
+	if err != nil {
+		return nil, false, err
+	}
+	theirs, err := r.store.Get(change.New.Hash)
+	if err != nil {
+		return nil, false, err
+	}
+	ours, err := os.ReadFile(filepath.Join(r.Root, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	result := merge.ThreeWayMerge(string(base.Data), string(ours), string(theirs.Data), path)
+	if result.HasConflict {
+		return nil, false, nil
+	}
+	return []byte(result.Content), true, nil
+}
+
+// writeWorkingFile writes a file to the working directory and records it
+// in the index
+func (r *Repository) writeWorkingFile(idx *index.Index, path string, w pendingWrite) error {
+	data := w.data
+	hash := w.entry.Hash
+	if data == nil {
+		obj, err := r.store.Get(w.entry.Hash)
+		if err != nil {
+			return fmt.Errorf("failed to get blob %s: %w", path, err)
+		}
+		data = obj.Data
+	} else {
+		hash = storage.HashObject(core.ObjectTypeBlob, data)
+	}
+
+	absPath := filepath.Join(r.Root, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(w.entry.Mode & 0777)
+	if err := os.WriteFile(absPath, data, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile leaves the mode of an existing file unchanged
+	if err := os.Chmod(absPath, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	idx.Update(path, info, hash)
+	return nil
+}
+
+// removeWorkingFile deletes a file from the working directory and the
+// index, along with any directories left empty
+func (r *Repository) removeWorkingFile(idx *index.Index, path string) error {
+	absPath := filepath.Join(r.Root, filepath.FromSlash(path))
+	if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	idx.Remove(path)
+	r.removeEmptyDirs(filepath.Dir(absPath))
+	return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to the repository root
+// for as long as they are empty
+func (r *Repository) removeEmptyDirs(dir string) {
+	root := filepath.Clean(r.Root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
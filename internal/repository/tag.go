@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// TagOptions specifies how a tag is created
+type TagOptions struct {
+	Message string // Create an annotated tag object with this message; empty for a lightweight tag
+	Force   bool   // Replace an existing tag of the same name
+}
+
+// TagInfo describes a tag
+type TagInfo struct {
+	Name   string
+	Hash   core.Hash // What refs/tags/<name> holds: the tag object, or the commit of a lightweight tag
+	Commit core.Hash // The commit the tag finally points at
+	Tag    *core.Tag // The annotation, or nil for a lightweight tag
+}
+
+// checkTagName returns core.ErrInvalidTagName if name cannot be a tag
+func checkTagName(name string) error {
+	if err := core.CheckRefName(name); err != nil {
+		return fmt.Errorf("%w %q: %v", core.ErrInvalidTagName, name, err)
+	}
+	return nil
+}
+
+// ListTags returns all tag names, sorted
+func (r *Repository) ListTags() ([]string, error) {
+	return r.refNames(tagsDir)
+}
+
+// CreateTag points refs/tags/<name> at target. With a message, an
+// annotated tag object recording the tagger and message is stored and the
+// ref points at it instead. It returns the hash the ref now holds.
+func (r *Repository) CreateTag(name string, target core.Hash, opts TagOptions) (hash core.Hash, err error) {
+	defer r.recordOperation("tag " + name)(&err)
+
+	if err := checkTagName(name); err != nil {
+		return core.Hash{}, err
+	}
+	if target.IsZero() {
+		return core.Hash{}, core.ErrNoCommits
+	}
+	ref := tagsDir + "/" + name
+	if err := r.checkRefAvailable(ref, ref, core.ErrTagExists); err != nil {
+		return core.Hash{}, err
+	}
+
+	old, exists, err := r.readRef(ref)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if exists && !opts.Force {
+		return core.Hash{}, fmt.Errorf("%w: %s", core.ErrTagExists, name)
+	}
+
+	obj, err := r.store.Get(target)
+	if err != nil {
+		return core.Hash{}, fmt.Errorf("%s: %w", target.Short(), err)
+	}
+
+	hash = target
+	if opts.Message != "" {
+		hash, err = r.store.PutTag(&core.Tag{
+			Target:     target,
+			TargetType: obj.Type,
+			Name:       name,
+			Tagger:     r.getAuthorName(),
+			Email:      r.getAuthorEmail(),
+			Timestamp:  time.Now(),
+			Message:    opts.Message,
+		})
+		if err != nil {
+			return core.Hash{}, err
+		}
+	}
+
+	message := "tag: created"
+	if exists {
+		message = "tag: replaced"
+	}
+	tx := r.NewRefTransaction()
+	tx.Update(ref, old, hash, message)
+	if err := tx.Commit(); err != nil {
+		return core.Hash{}, err
+	}
+	return hash, nil
+}
+
+// DeleteTag deletes a tag and its reflog, returning the hash it held.
+// Annotated tag objects stay in the store until garbage collected.
+func (r *Repository) DeleteTag(name string) (hash core.Hash, err error) {
+	defer r.recordOperation("tag --delete " + name)(&err)
+
+	ref := tagsDir + "/" + name
+	hash, exists, err := r.readRef(ref)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	if !exists {
+		return core.Hash{}, fmt.Errorf("%w: %s", core.ErrTagNotFound, name)
+	}
+
+	tx := r.NewRefTransaction()
+	tx.Delete(ref, hash)
+	if err := tx.Commit(); err != nil {
+		return core.Hash{}, err
+	}
+	return hash, r.removeReflog(ref)
+}
+
+// GetTag describes the tag called name
+func (r *Repository) GetTag(name string) (*TagInfo, error) {
+	hash, exists, err := r.readRef(tagsDir + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", core.ErrTagNotFound, name)
+	}
+
+	info := &TagInfo{Name: name, Hash: hash}
+	obj, err := r.store.Get(hash)
+	if err != nil {
+		return nil, fmt.Errorf("tag %s: %w", name, err)
+	}
+	if obj.Type == core.ObjectTypeTag {
+		if info.Tag, err = core.DecodeTag(obj.Data); err != nil {
+			return nil, fmt.Errorf("tag %s: %w", name, err)
+		}
+	}
+
+	target, typ, err := r.peel(hash)
+	if err != nil {
+		return nil, fmt.Errorf("tag %s: %w", name, err)
+	}
+	if typ == core.ObjectTypeCommit {
+		info.Commit = target
+	}
+	return info, nil
+}
+
+// TagDetails describes every tag, sorted by name
+func (r *Repository) TagDetails() ([]TagInfo, error) {
+	names, err := r.ListTags()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]TagInfo, 0, len(names))
+	for _, name := range names {
+		info, err := r.GetTag(name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// peel follows annotated tags from hash to the object they finally point
+// at, returning that object and its type
+func (r *Repository) peel(hash core.Hash) (core.Hash, core.ObjectType, error) {
+	for {
+		obj, err := r.store.Get(hash)
+		if err != nil {
+			return core.Hash{}, "", fmt.Errorf("%s: %w", hash.Short(), err)
+		}
+		if obj.Type != core.ObjectTypeTag {
+			return hash, obj.Type, nil
+		}
+
+		tag, err := core.DecodeTag(obj.Data)
+		if err != nil {
+			return core.Hash{}, "", fmt.Errorf("%s: %w", hash.Short(), err)
+		}
+		hash = tag.Target
+	}
+}
@@ -0,0 +1,360 @@
+package repository
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// Trees are hierarchical: each tree holds the files and subdirectories of
+// one directory, and subdirectories are ModeDir entries pointing to their
+// own trees. Repositories created before format version 2 contain flat
+// trees whose entry names are full slash-separated paths; those are still
+// read everywhere, and are converted to nested trees whenever rewritten.
+
+// fileEntry is a file in a flattened tree
+type fileEntry struct {
+	Hash core.Hash
+	Mode uint32
+}
+
+// fileChange is a file that differs between two trees. Old is nil for an
+// added file and New is nil for a deleted one.
+type fileChange struct {
+	Old *fileEntry
+	New *fileEntry
+}
+
+// readTree returns the entries of a tree. The zero hash is the empty tree.
+// Trees may come from other repositories, so entry names that could
+// escape the working directory or reach into .asl are rejected.
+func (r *Repository) readTree(hash core.Hash) ([]core.TreeEntry, error) {
+	if hash.IsZero() {
+		return nil, nil
+	}
+
+	tree, err := r.store.GetTree(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", hash.Short(), err)
+	}
+	for _, entry := range tree.Entries {
+		if problem := checkTreeEntryName(entry.Name); problem != "" {
+			return nil, fmt.Errorf("%w: tree %s: %s", core.ErrInvalidObject, hash.Short(), problem)
+		}
+	}
+	return tree.Entries, nil
+}
+
+// isLegacyTree reports whether entries come from a flat tree, whose names
+// are full paths
+func isLegacyTree(entries []core.TreeEntry) bool {
+	for _, entry := range entries {
+		if strings.Contains(entry.Name, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// flattenTree returns every file in a tree keyed by slash-separated path
+func (r *Repository) flattenTree(hash core.Hash) (map[string]fileEntry, error) {
+	files := make(map[string]fileEntry)
+	if err := r.flattenInto(hash, "", files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (r *Repository) flattenInto(hash core.Hash, prefix string, files map[string]fileEntry) error {
+	entries, err := r.readTree(hash)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := prefix + entry.Name
+		if entry.IsDir() {
+			if err := r.flattenInto(entry.Hash, path+"/", files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[path] = fileEntry{Hash: entry.Hash, Mode: entry.Mode}
+	}
+	return nil
+}
+
+// lookupFile finds the file at a slash-separated path in a tree
+func (r *Repository) lookupFile(root core.Hash, path string) (fileEntry, error) {
+	hash := root
+	for {
+		entries, err := r.readTree(hash)
+		if err != nil {
+			return fileEntry{}, err
+		}
+
+		var next *core.TreeEntry
+		for i, entry := range entries {
+			// An exact match also finds full-path entries of flat trees
+			if entry.Name == path && !entry.IsDir() {
+				return fileEntry{Hash: entry.Hash, Mode: entry.Mode}, nil
+			}
+			if entry.IsDir() && strings.HasPrefix(path, entry.Name+"/") {
+				next = &entries[i]
+			}
+		}
+
+		if next == nil {
+			return fileEntry{}, core.ErrFileNotFound
+		}
+		path = path[len(next.Name)+1:]
+		hash = next.Hash
+	}
+}
+
+// diffTrees returns the files that differ between two trees. Subtrees
+// with equal hashes are skipped without being read.
+func (r *Repository) diffTrees(oldHash, newHash core.Hash) (map[string]fileChange, error) {
+	changes := make(map[string]fileChange)
+	if err := r.diffInto(oldHash, newHash, "", changes); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func (r *Repository) diffInto(oldHash, newHash core.Hash, prefix string, changes map[string]fileChange) error {
+	if oldHash == newHash {
+		return nil
+	}
+
+	oldEntries, err := r.readTree(oldHash)
+	if err != nil {
+		return err
+	}
+	newEntries, err := r.readTree(newHash)
+	if err != nil {
+		return err
+	}
+
+	// Flat and nested trees name the same file differently, so fall back
+	// to comparing file by file
+	if isLegacyTree(oldEntries) || isLegacyTree(newEntries) {
+		oldFiles := make(map[string]fileEntry)
+		newFiles := make(map[string]fileEntry)
+		if err := r.flattenInto(oldHash, prefix, oldFiles); err != nil {
+			return err
+		}
+		if err := r.flattenInto(newHash, prefix, newFiles); err != nil {
+			return err
+		}
+		diffFileMaps(oldFiles, newFiles, changes)
+		return nil
+	}
+
+	oldByName := entriesByName(oldEntries)
+	newByName := entriesByName(newEntries)
+
+	names := make(map[string]bool, len(oldByName)+len(newByName))
+	for name := range oldByName {
+		names[name] = true
+	}
+	for name := range newByName {
+		names[name] = true
+	}
+
+	for name := range names {
+		path := prefix + name
+		o, inOld := oldByName[name]
+		n, inNew := newByName[name]
+
+		if inOld && inNew && o.Hash == n.Hash && o.Mode == n.Mode {
+			continue
+		}
+		if inOld && inNew && o.IsDir() && n.IsDir() {
+			if err := r.diffInto(o.Hash, n.Hash, path+"/", changes); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Added, deleted or modified, possibly switching between file and
+		// directory
+		var change fileChange
+		if inOld {
+			if o.IsDir() {
+				if err := r.diffInto(o.Hash, core.Hash{}, path+"/", changes); err != nil {
+					return err
+				}
+			} else {
+				change.Old = &fileEntry{Hash: o.Hash, Mode: o.Mode}
+			}
+		}
+		if inNew {
+			if n.IsDir() {
+				if err := r.diffInto(core.Hash{}, n.Hash, path+"/", changes); err != nil {
+					return err
+				}
+			} else {
+				change.New = &fileEntry{Hash: n.Hash, Mode: n.Mode}
+			}
+		}
+		if change.Old != nil || change.New != nil {
+			changes[path] = change
+		}
+	}
+
+	return nil
+}
+
+// diffFileMaps records the differences between two flattened trees
+func diffFileMaps(oldFiles, newFiles map[string]fileEntry, changes map[string]fileChange) {
+	for path, o := range oldFiles {
+		o := o
+		n, exists := newFiles[path]
+		switch {
+		case !exists:
+			changes[path] = fileChange{Old: &o}
+		case n != o:
+			changes[path] = fileChange{Old: &o, New: &n}
+		}
+	}
+	for path, n := range newFiles {
+		n := n
+		if _, exists := oldFiles[path]; !exists {
+			changes[path] = fileChange{New: &n}
+		}
+	}
+}
+
+// entriesByName indexes tree entries by name
+func entriesByName(entries []core.TreeEntry) map[string]core.TreeEntry {
+	byName := make(map[string]core.TreeEntry, len(entries))
+	for _, entry := range entries {
+		byName[entry.Name] = entry
+	}
+	return byName
+}
+
+// writeTree stores the nested trees for a set of files keyed by
+// slash-separated path and returns the root tree hash
+func (r *Repository) writeTree(files map[string]fileEntry) (core.Hash, error) {
+	changes := make(map[string]*fileEntry, len(files))
+	for path, entry := range files {
+		entry := entry
+		changes[path] = &entry
+	}
+	return r.updateRootTree(core.Hash{}, changes)
+}
+
+// updateRootTree applies changes to a root tree like updateTree, but
+// stores an empty result as the empty tree instead of returning the zero
+// hash, since commits always need a tree
+func (r *Repository) updateRootTree(root core.Hash, changes map[string]*fileEntry) (core.Hash, error) {
+	hash, err := r.updateTree(root, changes)
+	if err != nil || !hash.IsZero() {
+		return hash, err
+	}
+	return r.store.PutTree(&core.Tree{Entries: []core.TreeEntry{}})
+}
+
+// updateTree applies changes, keyed by slash-separated path, to the tree
+// at root and stores the result. A nil entry deletes the file. Only the
+// subtrees that contain changes are rewritten, and directories left empty
+// are dropped. It returns the zero hash if the resulting tree is empty.
+func (r *Repository) updateTree(root core.Hash, changes map[string]*fileEntry) (core.Hash, error) {
+	if len(changes) == 0 {
+		return root, nil
+	}
+
+	entries, err := r.readTree(root)
+	if err != nil {
+		return core.Hash{}, err
+	}
+
+	// Flat trees are converted to nested trees as they are rewritten
+	if isLegacyTree(entries) {
+		files, err := r.flattenTree(root)
+		if err != nil {
+			return core.Hash{}, err
+		}
+
+		all := make(map[string]*fileEntry, len(files)+len(changes))
+		for path, entry := range files {
+			entry := entry
+			all[path] = &entry
+		}
+		for path, entry := range changes {
+			all[path] = entry
+		}
+		return r.updateTree(core.Hash{}, all)
+	}
+
+	byName := entriesByName(entries)
+	nested := make(map[string]map[string]*fileEntry)
+	for path, entry := range changes {
+		name, rest, isNested := strings.Cut(path, "/")
+		if isNested {
+			if nested[name] == nil {
+				nested[name] = make(map[string]*fileEntry)
+			}
+			nested[name][rest] = entry
+			continue
+		}
+
+		if entry == nil {
+			delete(byName, name)
+		} else {
+			byName[name] = core.TreeEntry{Mode: entry.Mode, Name: name, Hash: entry.Hash}
+		}
+	}
+
+	for name, subChanges := range nested {
+		var subRoot core.Hash
+		existing, exists := byName[name]
+		if exists && existing.IsDir() {
+			subRoot = existing.Hash
+		}
+
+		hash, err := r.updateTree(subRoot, subChanges)
+		if err != nil {
+			return core.Hash{}, err
+		}
+
+		switch {
+		case !hash.IsZero():
+			byName[name] = core.TreeEntry{Mode: core.ModeDir, Name: name, Hash: hash}
+		case exists && existing.IsDir():
+			delete(byName, name)
+		}
+	}
+
+	if len(byName) == 0 {
+		return core.Hash{}, nil
+	}
+
+	tree := &core.Tree{Entries: make([]core.TreeEntry, 0, len(byName))}
+	for _, entry := range byName {
+		tree.Entries = append(tree.Entries, entry)
+	}
+	sort.Slice(tree.Entries, func(i, j int) bool {
+		return tree.Entries[i].Name < tree.Entries[j].Name
+	})
+
+	return r.store.PutTree(tree)
+}
+
+// commitTreeHash returns the root tree of a commit. The zero hash stands
+// for "no commit" and yields the empty tree.
+func (r *Repository) commitTreeHash(commitHash core.Hash) (core.Hash, error) {
+	if commitHash.IsZero() {
+		return core.Hash{}, nil
+	}
+
+	commit, err := r.store.GetCommit(commitHash)
+	if err != nil {
+		return core.Hash{}, err
+	}
+	return commit.Tree, nil
+}
@@ -1,17 +1,24 @@
 package repository
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/ignore"
 	"golang.org/x/sync/errgroup"
 )
 
 // Save creates a new commit with the specified files and message
-func (r *Repository) Save(files []string, message string) (core.Hash, error) {
+func (r *Repository) Save(files []string, message string) (hash core.Hash, err error) {
+	defer r.recordOperation("save: " + subject(message))(&err)
+
 	if message == "" {
 		return core.Hash{}, fmt.Errorf("commit message cannot be empty")
 	}
@@ -25,14 +32,8 @@ func (r *Repository) Save(files []string, message string) (core.Hash, error) {
 		}
 	}
 
-	// Build tree from files
-	tree, err := r.buildTree(files)
-	if err != nil {
-		return core.Hash{}, err
-	}
-
-	// Store tree
-	treeHash, err := r.store.PutTree(tree)
+	// Build and store trees from files
+	treeHash, err := r.buildTree(files)
 	if err != nil {
 		return core.Hash{}, err
 	}
@@ -64,29 +65,27 @@ func (r *Repository) Save(files []string, message string) (core.Hash, error) {
 		return core.Hash{}, err
 	}
 
-	// Update branch reference
-	branch, err := r.GetCurrentBranch()
-	if err != nil {
-		return core.Hash{}, err
-	}
-
-	ref := filepath.Join(headsDir, branch)
-	if err := r.SetRef(ref, commitHash); err != nil {
+	// Update branch reference, or HEAD itself when detached
+	if err := r.advanceHEAD(parentHash, commitHash, "save: "+subject(message)); err != nil {
 		return core.Hash{}, err
 	}
 
 	return commitHash, nil
 }
 
-// buildTree creates a tree object from the given files
-func (r *Repository) buildTree(files []string) (*core.Tree, error) {
-	tree := &core.Tree{
-		Entries: make([]core.TreeEntry, 0, len(files)),
+// buildTree stores the given files as blobs and nested trees, returning
+// the root tree hash. Files whose stat data matches the index are not
+// read again, and the index is rewritten to cover exactly these files.
+func (r *Repository) buildTree(files []string) (core.Hash, error) {
+	idx, err := r.loadIndex()
+	if err != nil {
+		return core.Hash{}, err
 	}
 
 	// Use goroutines for parallel file hashing
 	type result struct {
-		entry core.TreeEntry
+		path  string
+		entry fileEntry
 		err   error
 	}
 
@@ -94,44 +93,10 @@ func (r *Repository) buildTree(files []string) (*core.Tree, error) {
 	var g errgroup.Group
 
 	for _, file := range files {
-		file := file // capture loop variable
+		file := filepath.ToSlash(filepath.Clean(file)) // capture loop variable
 		g.Go(func() error {
-			// Get absolute path
-			absPath := filepath.Join(r.Root, file)
-
-			// Read file
-			data, err := os.ReadFile(absPath)
-			if err != nil {
-				results <- result{err: fmt.Errorf("failed to read %s: %w", file, err)}
-				return nil
-			}
-
-			// Store blob
-			hash, err := r.store.PutBlob(data)
-			if err != nil {
-				results <- result{err: fmt.Errorf("failed to store %s: %w", file, err)}
-				return nil
-			}
-
-			// Get file mode
-			info, err := os.Stat(absPath)
-			if err != nil {
-				results <- result{err: fmt.Errorf("failed to stat %s: %w", file, err)}
-				return nil
-			}
-
-			mode := uint32(0100644) // regular file
-			if info.Mode()&0111 != 0 {
-				mode = 0100755 // executable
-			}
-
-			results <- result{
-				entry: core.TreeEntry{
-					Mode: mode,
-					Name: file,
-					Hash: hash,
-				},
-			}
+			entry, err := r.storeWorkingFile(idx, file)
+			results <- result{path: file, entry: entry, err: err}
 			return nil
 		})
 	}
@@ -143,33 +108,72 @@ func (r *Repository) buildTree(files []string) (*core.Tree, error) {
 	}()
 
 	// Collect results
+	entries := make(map[string]fileEntry, len(files))
+	var firstErr error
 	for res := range results {
 		if res.err != nil {
-			return nil, res.err
+			if firstErr == nil {
+				firstErr = res.err
+			}
+			continue
 		}
-		tree.Entries = append(tree.Entries, res.entry)
+		entries[res.path] = res.entry
+	}
+	if firstErr != nil {
+		return core.Hash{}, firstErr
 	}
 
-	return tree, nil
+	tracked := make(map[string]bool, len(entries))
+	for path := range entries {
+		tracked[path] = true
+	}
+	idx.Retain(tracked)
+	if err := idx.Save(); err != nil {
+		return core.Hash{}, err
+	}
+
+	return r.writeTree(entries)
 }
 
-// listAllFiles returns all non-ignored files in the repository
+// listAllFiles returns the files in the working directory that belong in
+// the next commit: everything not ignored, plus files in the current
+// commit, which stay tracked even if they match an ignore rule
 func (r *Repository) listAllFiles() ([]string, error) {
-	var files []string
+	return r.walkWorkingDir(nil)
+}
 
-	err := filepath.Walk(r.Root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+// walkWorkingDir returns the files listAllFiles does, and calls ignored,
+// if non-nil, for every untracked file or directory excluded by an ignore
+// rule. The contents of ignored directories are not reported separately.
+func (r *Repository) walkWorkingDir(ignored func(path string, isDir bool)) ([]string, error) {
+	matcher, err := r.ignoreMatcher()
+	if err != nil {
+		return nil, err
+	}
 
-		// Skip .asl directory
-		if info.IsDir() && info.Name() == aslDir {
-			return filepath.SkipDir
+	// Directories holding tracked files are walked even when ignored
+	tracked, err := r.trackedFiles()
+	if err != nil {
+		return nil, err
+	}
+	trackedDirs := make(map[string]bool)
+	for path := range tracked {
+		for i := strings.LastIndex(path, "/"); i > 0; i = strings.LastIndex(path[:i], "/") {
+			trackedDirs[path[:i]] = true
 		}
+	}
 
-		// Skip directories
-		if info.IsDir() {
-			return nil
+	// Ignored directories that are walked for their tracked files
+	ignoredDirs := make(map[string]bool)
+	parentIgnored := func(relPath string) bool {
+		i := strings.LastIndex(relPath, "/")
+		return i > 0 && ignoredDirs[relPath[:i]]
+	}
+
+	var files []string
+	err = filepath.WalkDir(r.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
 
 		// Get relative path
@@ -177,7 +181,34 @@ func (r *Repository) listAllFiles() ([]string, error) {
 		if err != nil {
 			return err
 		}
+		relPath = filepath.ToSlash(relPath)
 
+		if d.IsDir() {
+			if relPath == "." {
+				return matcher.AddFile(filepath.Join(path, ignore.FileName), "")
+			}
+			// Skip .asl directory
+			if d.Name() == aslDir {
+				return filepath.SkipDir
+			}
+			if parentIgnored(relPath) || matcher.Match(relPath, true) {
+				if !trackedDirs[relPath] {
+					if ignored != nil {
+						ignored(relPath, true)
+					}
+					return filepath.SkipDir
+				}
+				ignoredDirs[relPath] = true
+			}
+			return matcher.AddFile(filepath.Join(path, ignore.FileName), relPath)
+		}
+
+		if (parentIgnored(relPath) || matcher.Match(relPath, false)) && !tracked[relPath] {
+			if ignored != nil {
+				ignored(relPath, false)
+			}
+			return nil
+		}
 		files = append(files, relPath)
 		return nil
 	})
@@ -185,8 +216,53 @@ func (r *Repository) listAllFiles() ([]string, error) {
 	return files, err
 }
 
+// ignoreMatcher returns a matcher with the global ignore file and
+// .asl/info/exclude loaded. Per-directory .aslignore files take precedence
+// over both and are added while walking the working directory.
+func (r *Repository) ignoreMatcher() (*ignore.Matcher, error) {
+	matcher := ignore.NewMatcher()
+	if global := ignore.GlobalFile(); global != "" {
+		if err := matcher.AddFile(global, ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := matcher.AddFile(filepath.Join(r.AslPath(), "info", "exclude"), ""); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+// trackedFiles returns the paths of the files in the current commit
+func (r *Repository) trackedFiles() (map[string]bool, error) {
+	head, err := r.GetCurrentCommit()
+	if errors.Is(err, core.ErrBranchNotFound) {
+		// No commits yet, so nothing is tracked
+		return map[string]bool{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	treeHash, err := r.commitTreeHash(head)
+	if err != nil {
+		return nil, err
+	}
+	files, err := r.flattenTree(treeHash)
+	if err != nil {
+		return nil, err
+	}
+
+	tracked := make(map[string]bool, len(files))
+	for path := range files {
+		tracked[path] = true
+	}
+	return tracked, nil
+}
+
 // Undo reverts the last commit but keeps working directory changes
-func (r *Repository) Undo() error {
+func (r *Repository) Undo() (err error) {
+	defer r.recordOperation("undo")(&err)
+
 	// Get current commit
 	currentHash, err := r.GetCurrentCommit()
 	if err != nil {
@@ -199,23 +275,18 @@ func (r *Repository) Undo() error {
 		return err
 	}
 
-	// Update branch to parent
-	branch, err := r.GetCurrentBranch()
-	if err != nil {
-		return err
-	}
-
-	ref := filepath.Join(headsDir, branch)
 	// Move to first parent (or zero hash if no parents)
 	var parentHash core.Hash
 	if len(commit.Parents) > 0 {
 		parentHash = commit.Parents[0]
 	}
-	return r.SetRef(ref, parentHash)
+	return r.advanceHEAD(currentHash, parentHash, "undo: "+subject(commit.Message))
 }
 
 // Amend modifies the last commit
-func (r *Repository) Amend(files []string, message string) (core.Hash, error) {
+func (r *Repository) Amend(files []string, message string) (hash core.Hash, err error) {
+	defer r.recordOperation("amend")(&err)
+
 	// Get current commit
 	currentHash, err := r.GetCurrentCommit()
 	if err != nil {
@@ -241,12 +312,7 @@ func (r *Repository) Amend(files []string, message string) (core.Hash, error) {
 		}
 	}
 
-	tree, err := r.buildTree(files)
-	if err != nil {
-		return core.Hash{}, err
-	}
-
-	treeHash, err := r.store.PutTree(tree)
+	treeHash, err := r.buildTree(files)
 	if err != nil {
 		return core.Hash{}, err
 	}
@@ -266,14 +332,8 @@ func (r *Repository) Amend(files []string, message string) (core.Hash, error) {
 		return core.Hash{}, err
 	}
 
-	// Update branch reference
-	branch, err := r.GetCurrentBranch()
-	if err != nil {
-		return core.Hash{}, err
-	}
-
-	ref := filepath.Join(headsDir, branch)
-	if err := r.SetRef(ref, commitHash); err != nil {
+	// Update branch reference, or HEAD itself when detached
+	if err := r.advanceHEAD(currentHash, commitHash, "amend: "+subject(message)); err != nil {
 		return core.Hash{}, err
 	}
 
@@ -309,9 +369,10 @@ func (r *Repository) GetCommitHistory(startHash core.Hash, limit int) ([]*core.C
 	return commits, hashes, nil
 }
 
-// getAuthorName returns the author name from config or environment
+// getAuthorName returns user.name, which ASL_AUTHOR_NAME overrides, or
+// $USER if it is not configured
 func (r *Repository) getAuthorName() string {
-	if name := os.Getenv("ASL_AUTHOR_NAME"); name != "" {
+	if name := r.configValue("user.name"); name != "" {
 		return name
 	}
 	if name := os.Getenv("USER"); name != "" {
@@ -320,9 +381,10 @@ func (r *Repository) getAuthorName() string {
 	return "Unknown"
 }
 
-// getAuthorEmail returns the author email from config or environment
+// getAuthorEmail returns user.email, which ASL_AUTHOR_EMAIL overrides, or
+// $EMAIL if it is not configured
 func (r *Repository) getAuthorEmail() string {
-	if email := os.Getenv("ASL_AUTHOR_EMAIL"); email != "" {
+	if email := r.configValue("user.email"); email != "" {
 		return email
 	}
 	if email := os.Getenv("EMAIL"); email != "" {
@@ -331,103 +393,213 @@ func (r *Repository) getAuthorEmail() string {
 	return "unknown@localhost"
 }
 
-// Checkout restores files from a commit to the working directory
+// Checkout makes the tracked files in the working directory match a
+// commit exactly, discarding uncommitted changes to them. Files that are
+// tracked, either in HEAD or in the index, but not in the commit are
+// deleted; untracked files are left alone. HEAD is not changed.
 func (r *Repository) Checkout(commitHash core.Hash) error {
 	commit, err := r.store.GetCommit(commitHash)
 	if err != nil {
 		return err
 	}
 
-	tree, err := r.store.GetTree(commit.Tree)
+	return r.checkoutPaths(commit.Tree, []string{""})
+}
+
+// Restore overwrites files or directories in the working directory with
+// their content in a commit, without moving HEAD. Paths are
+// slash-separated and relative to the repository root; "." restores
+// everything. Within a restored directory, tracked files that are not in
+// the commit are deleted. Every path must exist in the commit.
+func (r *Repository) Restore(paths []string, source core.Hash) error {
+	if len(paths) == 0 {
+		return fmt.Errorf("no paths to restore")
+	}
+
+	treeHash, err := r.commitTreeHash(source)
+	if err != nil {
+		return err
+	}
+
+	prefixes := make([]string, 0, len(paths))
+	for _, path := range paths {
+		clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(path)))
+		switch {
+		case clean == ".":
+			clean = ""
+		case filepath.IsAbs(path) || clean == ".." || strings.HasPrefix(clean, "../"):
+			return fmt.Errorf("%s: path is outside the repository", path)
+		}
+		prefixes = append(prefixes, clean)
+	}
+
+	return r.checkoutPaths(treeHash, prefixes)
+}
+
+// checkoutPaths makes the working directory match a tree for every path
+// that equals one of prefixes or lies under it, where "" matches all
+// paths. A prefix that matches nothing in the tree is an error.
+func (r *Repository) checkoutPaths(treeHash core.Hash, prefixes []string) error {
+	files, err := r.flattenTree(treeHash)
 	if err != nil {
 		return err
 	}
 
-	// Restore all files from tree
-	for _, entry := range tree.Entries {
-		obj, err := r.store.Get(entry.Hash)
-		if err != nil {
-			return fmt.Errorf("failed to get blob %s: %w", entry.Name, err)
+	matched := make(map[string]bool, len(prefixes))
+	selected := func(path string) bool {
+		found := false
+		for _, prefix := range prefixes {
+			if prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/") {
+				matched[prefix] = true
+				found = true
+			}
 		}
+		return found
+	}
 
-		if obj.Type != core.ObjectTypeBlob {
-			continue
+	wanted := make(map[string]fileEntry)
+	for path, entry := range files {
+		if selected(path) {
+			wanted[path] = entry
 		}
+	}
+	for _, prefix := range prefixes {
+		if prefix != "" && !matched[prefix] {
+			return fmt.Errorf("%s: %w", prefix, core.ErrFileNotFound)
+		}
+	}
 
-		// Write file
-		filePath := filepath.Join(r.Root, entry.Name)
-		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+	idx, err := r.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	// Delete tracked files that the tree does not have
+	tracked, err := r.trackedFiles()
+	if err != nil {
+		return err
+	}
+	for _, path := range idx.Paths() {
+		tracked[path] = true
+	}
+	for path := range tracked {
+		if _, keep := wanted[path]; keep || !selected(path) {
+			continue
+		}
+		if err := r.removeWorkingFile(idx, path); err != nil {
 			return err
 		}
+	}
 
-		mode := os.FileMode(entry.Mode & 0777)
-		if err := os.WriteFile(filePath, obj.Data, mode); err != nil {
-			return fmt.Errorf("failed to write %s: %w", entry.Name, err)
+	paths := make([]string, 0, len(wanted))
+	for path := range wanted {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		entry := wanted[path]
+		local, isDir, err := r.workingFile(idx, path)
+		if err != nil {
+			return err
+		}
+		if isDir {
+			return fmt.Errorf("cannot check out %s: a directory is in the way", path)
+		}
+		if sameFile(local, &entry) {
+			continue
+		}
+		if err := r.writeWorkingFile(idx, path, pendingWrite{entry: entry}); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	return idx.Save()
 }
 
-// Diff computes the difference between two trees
+// Diff computes the difference between two commits, returning a map of
+// path to "added", "modified" or "deleted". A zero hash stands for an
+// empty tree. Subtrees that are identical in both commits are skipped.
 func (r *Repository) Diff(oldHash, newHash core.Hash) (map[string]string, error) {
-	diff := make(map[string]string)
+	oldTree, err := r.commitTreeHash(oldHash)
+	if err != nil {
+		return nil, err
+	}
+	newTree, err := r.commitTreeHash(newHash)
+	if err != nil {
+		return nil, err
+	}
 
-	var oldTree, newTree *core.Tree
+	changes, err := r.diffTrees(oldTree, newTree)
+	if err != nil {
+		return nil, err
+	}
 
-	if !oldHash.IsZero() {
-		oldCommit, err := r.store.GetCommit(oldHash)
-		if err != nil {
-			return nil, err
-		}
-		oldTree, err = r.store.GetTree(oldCommit.Tree)
-		if err != nil {
-			return nil, err
+	diff := make(map[string]string, len(changes))
+	for path, change := range changes {
+		switch {
+		case change.Old == nil:
+			diff[path] = "added"
+		case change.New == nil:
+			diff[path] = "deleted"
+		default:
+			diff[path] = "modified"
 		}
 	}
 
-	if !newHash.IsZero() {
-		newCommit, err := r.store.GetCommit(newHash)
-		if err != nil {
-			return nil, err
-		}
-		newTree, err = r.store.GetTree(newCommit.Tree)
-		if err != nil {
-			return nil, err
-		}
+	return diff, nil
+}
+
+// DiffWorkingDir compares the working directory against a commit.
+// A zero hash compares against an empty tree.
+func (r *Repository) DiffWorkingDir(commitHash core.Hash) (map[string]string, error) {
+	diff := make(map[string]string)
+
+	treeHash, err := r.commitTreeHash(commitHash)
+	if err != nil {
+		return nil, err
+	}
+	oldFiles, err := r.flattenTree(treeHash)
+	if err != nil {
+		return nil, err
 	}
 
-	// Build maps for comparison
-	oldFiles := make(map[string]core.Hash)
-	newFiles := make(map[string]core.Hash)
+	files, err := r.listAllFiles()
+	if err != nil {
+		return nil, err
+	}
 
-	if oldTree != nil {
-		for _, entry := range oldTree.Entries {
-			oldFiles[entry.Name] = entry.Hash
-		}
+	idx, err := r.loadIndex()
+	if err != nil {
+		return nil, err
 	}
 
-	if newTree != nil {
-		for _, entry := range newTree.Entries {
-			newFiles[entry.Name] = entry.Hash
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		seen[file] = true
+
+		old, exists := oldFiles[file]
+		if !exists {
+			diff[file] = "added"
+			continue
+		}
+
+		current, err := r.hashWorkingFile(idx, file)
+		if err != nil {
+			return nil, err
+		}
+		if current.Hash != old.Hash {
+			diff[file] = "modified"
 		}
 	}
 
-	// Find modified and deleted files
-	for name, oldHash := range oldFiles {
-		newHash, exists := newFiles[name]
-		if !exists {
+	for name := range oldFiles {
+		if !seen[name] {
 			diff[name] = "deleted"
-		} else if oldHash != newHash {
-			diff[name] = "modified"
 		}
 	}
 
-	// Find added files
-	for name := range newFiles {
-		if _, exists := oldFiles[name]; !exists {
-			diff[name] = "added"
-		}
+	if err := idx.Save(); err != nil {
+		return nil, err
 	}
 
 	return diff, nil
@@ -440,20 +612,14 @@ func (r *Repository) GetFileContent(commitHash core.Hash, filename string) ([]by
 		return nil, err
 	}
 
-	tree, err := r.store.GetTree(commit.Tree)
+	entry, err := r.lookupFile(commit.Tree, filepath.ToSlash(filename))
 	if err != nil {
 		return nil, err
 	}
 
-	for _, entry := range tree.Entries {
-		if entry.Name == filename {
-			obj, err := r.store.Get(entry.Hash)
-			if err != nil {
-				return nil, err
-			}
-			return obj.Data, nil
-		}
+	obj, err := r.store.Get(entry.Hash)
+	if err != nil {
+		return nil, err
 	}
-
-	return nil, core.ErrFileNotFound
+	return obj.Data, nil
 }
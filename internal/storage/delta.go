@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// Delta format
+//
+//	uvarint base length | uvarint target length | instructions...
+//
+// Each instruction is either an insert (deltaInsert, uvarint n, n literal
+// bytes) or a copy from the base (deltaCopy, uvarint offset, uvarint n).
+const (
+	deltaInsert byte = 0
+	deltaCopy   byte = 1
+
+	// deltaBlockSize is the granularity at which base content is indexed.
+	// Matches shorter than this are stored as literals.
+	deltaBlockSize = 16
+)
+
+// computeDelta encodes target as a sequence of copies from base and literal
+// inserts. The result is only useful when it is smaller than target.
+func computeDelta(base, target []byte) []byte {
+	index := make(map[string]int, len(base)/deltaBlockSize)
+	for i := 0; i+deltaBlockSize <= len(base); i += deltaBlockSize {
+		key := string(base[i : i+deltaBlockSize])
+		if _, ok := index[key]; !ok {
+			index[key] = i
+		}
+	}
+
+	delta := binary.AppendUvarint(nil, uint64(len(base)))
+	delta = binary.AppendUvarint(delta, uint64(len(target)))
+
+	litStart := 0
+	i := 0
+	for i+deltaBlockSize <= len(target) {
+		off, ok := index[string(target[i:i+deltaBlockSize])]
+		if !ok {
+			i++
+			continue
+		}
+
+		// Extend the match backwards into pending literals, then forwards
+		for off > 0 && i > litStart && base[off-1] == target[i-1] {
+			off--
+			i--
+		}
+		n := 0
+		for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+			n++
+		}
+
+		delta = appendInsert(delta, target[litStart:i])
+		delta = append(delta, deltaCopy)
+		delta = binary.AppendUvarint(delta, uint64(off))
+		delta = binary.AppendUvarint(delta, uint64(n))
+
+		i += n
+		litStart = i
+	}
+
+	return appendInsert(delta, target[litStart:])
+}
+
+// appendInsert appends an insert instruction for literal, if it is non-empty
+func appendInsert(delta, literal []byte) []byte {
+	if len(literal) == 0 {
+		return delta
+	}
+	delta = append(delta, deltaInsert)
+	delta = binary.AppendUvarint(delta, uint64(len(literal)))
+	return append(delta, literal...)
+}
+
+// applyDelta reconstructs the target of a delta computed against base
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseLen, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: bad delta header", core.ErrCorruptPack)
+	}
+	delta = delta[n:]
+	if baseLen != uint64(len(base)) {
+		return nil, fmt.Errorf("%w: delta base is %d bytes, expected %d", core.ErrCorruptPack, len(base), baseLen)
+	}
+
+	targetLen, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, fmt.Errorf("%w: bad delta header", core.ErrCorruptPack)
+	}
+	delta = delta[n:]
+
+	target := make([]byte, 0, targetLen)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch op {
+		case deltaInsert:
+			size, n := binary.Uvarint(delta)
+			if n <= 0 || size > uint64(len(delta)-n) {
+				return nil, fmt.Errorf("%w: truncated delta insert", core.ErrCorruptPack)
+			}
+			target = append(target, delta[n:n+int(size)]...)
+			delta = delta[n+int(size):]
+
+		case deltaCopy:
+			off, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: truncated delta copy", core.ErrCorruptPack)
+			}
+			delta = delta[n:]
+			size, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, fmt.Errorf("%w: truncated delta copy", core.ErrCorruptPack)
+			}
+			delta = delta[n:]
+			if off > uint64(len(base)) || size > uint64(len(base))-off {
+				return nil, fmt.Errorf("%w: delta copy out of range", core.ErrCorruptPack)
+			}
+			target = append(target, base[off:off+size]...)
+
+		default:
+			return nil, fmt.Errorf("%w: unknown delta instruction %d", core.ErrCorruptPack, op)
+		}
+	}
+
+	if uint64(len(target)) != targetLen {
+		return nil, fmt.Errorf("%w: delta produced %d bytes, expected %d", core.ErrCorruptPack, len(target), targetLen)
+	}
+	return target, nil
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+func TestDeltaRoundTrip(t *testing.T) {
+	base := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 20))
+
+	tests := []struct {
+		name   string
+		target []byte
+	}{
+		{"identical", base},
+		{"appended", append(append([]byte{}, base...), "one more line\n"...)},
+		{"prepended", append([]byte("header\n"), base...)},
+		{"edited middle", bytes.Replace(base, []byte("lazy"), []byte("sleepy"), 5)},
+		{"unrelated", []byte("nothing in common with the base at all")},
+		{"empty", []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := computeDelta(base, tt.target)
+			got, err := applyDelta(base, delta)
+			if err != nil {
+				t.Fatalf("applyDelta failed: %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Errorf("round trip mismatch:\n got %q\nwant %q", got, tt.target)
+			}
+		})
+	}
+}
+
+func TestDeltaIsCompact(t *testing.T) {
+	base := []byte(strings.Repeat("line of repeated file content\n", 100))
+	target := append(append([]byte{}, base...), "appended\n"...)
+
+	if delta := computeDelta(base, target); len(delta) > 64 {
+		t.Errorf("expected a small delta for an append, got %d bytes", len(delta))
+	}
+}
+
+func TestApplyDelta_RejectsCorruptInput(t *testing.T) {
+	base := []byte(strings.Repeat("base content ", 10))
+	delta := computeDelta(base, append(append([]byte{}, base...), '!'))
+
+	tests := []struct {
+		name  string
+		base  []byte
+		delta []byte
+	}{
+		{"wrong base", base[1:], delta},
+		{"truncated", base, delta[:len(delta)-1]},
+		{"empty", base, nil},
+		{"copy out of range", base, []byte{byte(len(base)), 5, deltaCopy, 200, 1, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := applyDelta(tt.base, tt.delta); !errors.Is(err, core.ErrCorruptPack) {
+				t.Errorf("expected ErrCorruptPack, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// PruneOptions controls which unreachable objects Prune deletes
+type PruneOptions struct {
+	Grace  time.Duration // Keep unreachable objects written more recently than this
+	DryRun bool          // Report what would be deleted without deleting anything
+}
+
+// PruneStats summarizes a Prune run
+type PruneStats struct {
+	Reachable int   // Objects reachable from the roots
+	Pruned    int   // Unreachable objects deleted, or that would be in a dry run
+	Bytes     int64 // Disk space freed, or that would be in a dry run; packed objects count at their unpacked size
+	Recent    int   // Unreachable objects kept because they are within the grace period
+}
+
+// Reachable marks every object reachable from the given commits or tags:
+// the roots themselves, the objects tags point to, the commits' ancestors,
+// and their trees, subtrees and blobs. It fails if any reachable object is
+// missing, since pruning a broken graph could delete objects that are still
+// needed.
+func (s *Store) Reachable(roots []core.Hash) (map[core.Hash]bool, error) {
+	reachable := make(map[core.Hash]bool)
+	var commits, trees []core.Hash
+
+	for _, root := range roots {
+		if root.IsZero() {
+			continue
+		}
+		hash, typ, err := s.peelTags(root, reachable)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case core.ObjectTypeCommit:
+			commits = append(commits, hash)
+		case core.ObjectTypeTree:
+			trees = append(trees, hash)
+		default:
+			reachable[hash] = true
+		}
+	}
+
+	for len(commits) > 0 || len(trees) > 0 {
+		if n := len(commits); n > 0 {
+			hash := commits[n-1]
+			commits = commits[:n-1]
+			if reachable[hash] {
+				continue
+			}
+
+			commit, err := s.GetCommit(hash)
+			if err != nil {
+				return nil, fmt.Errorf("commit %s: %w", hash.Short(), err)
+			}
+			reachable[hash] = true
+			commits = append(commits, commit.Parents...)
+			trees = append(trees, commit.Tree)
+			continue
+		}
+
+		hash := trees[len(trees)-1]
+		trees = trees[:len(trees)-1]
+		if reachable[hash] {
+			continue
+		}
+
+		tree, err := s.GetTree(hash)
+		if err != nil {
+			return nil, fmt.Errorf("tree %s: %w", hash.Short(), err)
+		}
+		reachable[hash] = true
+
+		for _, entry := range tree.Entries {
+			if reachable[entry.Hash] {
+				continue
+			}
+			if entry.IsDir() {
+				trees = append(trees, entry.Hash)
+				continue
+			}
+			if !s.Exists(entry.Hash) {
+				return nil, fmt.Errorf("blob %s (%s): %w", entry.Hash.Short(), entry.Name, core.ErrObjectNotFound)
+			}
+			reachable[entry.Hash] = true
+		}
+	}
+
+	return reachable, nil
+}
+
+// peelTags follows annotated tags from hash to the object they finally
+// point at, marking each tag reachable, and returns that object's type
+func (s *Store) peelTags(hash core.Hash, reachable map[core.Hash]bool) (core.Hash, core.ObjectType, error) {
+	for {
+		obj, err := s.Get(hash)
+		if err != nil {
+			return core.Hash{}, "", fmt.Errorf("object %s: %w", hash.Short(), err)
+		}
+		if obj.Type != core.ObjectTypeTag || reachable[hash] {
+			return hash, obj.Type, nil
+		}
+
+		tag, err := core.DecodeTag(obj.Data)
+		if err != nil {
+			return core.Hash{}, "", fmt.Errorf("tag %s: %w", hash.Short(), err)
+		}
+		reachable[hash] = true
+		hash = tag.Target
+	}
+}
+
+// Prune deletes objects that are not in reachable and were written longer
+// than the grace period ago. Packed objects count as written when their
+// pack was; packs holding any to delete are repacked without them.
+func (s *Store) Prune(reachable map[core.Hash]bool, opts PruneOptions) (*PruneStats, error) {
+	loose, err := s.looseObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &PruneStats{Reachable: len(reachable)}
+	cutoff := time.Now().Add(-opts.Grace)
+	looseRecent := make(map[core.Hash]bool) // Unreachable loose objects, and whether they are kept
+
+	for _, hash := range loose {
+		if reachable[hash] {
+			continue
+		}
+
+		path := s.objectPath(hash)
+		info, err := os.Stat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to stat object: %w", err)
+		}
+
+		looseRecent[hash] = info.ModTime().After(cutoff)
+		if looseRecent[hash] {
+			stats.Recent++
+			continue
+		}
+
+		stats.Pruned++
+		stats.Bytes += info.Size()
+		if opts.DryRun {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove object %s: %w", hash.Short(), err)
+		}
+		os.Remove(filepath.Dir(path)) // Only succeeds once the fan-out directory is empty
+
+		s.mu.Lock()
+		delete(s.cache, hash)
+		s.mu.Unlock()
+	}
+
+	if err := s.prunePacked(reachable, looseRecent, cutoff, opts, stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// prunePacked is Prune for packed objects, adding to stats. Objects also
+// stored loose were counted with their loose copy, whose fate they share.
+func (s *Store) prunePacked(reachable, looseRecent map[core.Hash]bool, cutoff time.Time, opts PruneOptions, stats *PruneStats) error {
+	packs, err := s.packList()
+	if err != nil {
+		return err
+	}
+
+	drop := make(map[core.Hash]bool)
+	seen := make(map[core.Hash]bool)
+	for _, p := range packs {
+		info, err := os.Stat(p.packPath())
+		if err != nil {
+			return fmt.Errorf("failed to stat pack: %w", err)
+		}
+		recent := info.ModTime().After(cutoff)
+
+		err = p.forEach(func(hash core.Hash, raw []byte) {
+			if reachable[hash] || seen[hash] {
+				return
+			}
+			seen[hash] = true
+			if kept, ok := looseRecent[hash]; ok {
+				if !kept {
+					drop[hash] = true
+				}
+				return
+			}
+			if recent {
+				stats.Recent++
+				return
+			}
+			drop[hash] = true
+			stats.Pruned++
+			stats.Bytes += int64(len(raw))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if len(drop) == 0 || opts.DryRun {
+		return nil
+	}
+
+	if _, err := s.repack(drop); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for hash := range drop {
+		delete(s.cache, hash)
+	}
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// putCommit stores a one-file commit and returns its hash and blob hash
+func putCommit(t *testing.T, store *Store, content string, parents ...core.Hash) (core.Hash, core.Hash) {
+	t.Helper()
+
+	blob, err := store.PutBlob([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := store.PutTree(&core.Tree{Entries: []core.TreeEntry{{Mode: 0100644, Name: "file.txt", Hash: blob}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := store.PutCommit(&core.Commit{
+		Tree:      tree,
+		Parents:   parents,
+		Author:    "Test",
+		Email:     "test@test.com",
+		Timestamp: time.Unix(1700000000, 0),
+		Message:   content,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit, blob
+}
+
+// age backdates a loose object so it falls outside the grace period
+func age(t *testing.T, store *Store, hash core.Hash) {
+	t.Helper()
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(store.objectPath(hash), old, old); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReachable_FollowsParentsTreesAndBlobs(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	first, firstBlob := putCommit(t, store, "v1")
+	second, _ := putCommit(t, store, "v2", first)
+	orphan, _ := putCommit(t, store, "orphan")
+
+	reachable, err := store.Reachable([]core.Hash{second, {}})
+	if err != nil {
+		t.Fatalf("Reachable failed: %v", err)
+	}
+
+	if !reachable[first] || !reachable[firstBlob] {
+		t.Error("ancestors and their blobs should be reachable")
+	}
+	if reachable[orphan] {
+		t.Error("orphaned commit should not be reachable")
+	}
+	// 2 commits, 2 trees, 2 blobs
+	if len(reachable) != 6 {
+		t.Errorf("expected 6 reachable objects, got %d", len(reachable))
+	}
+}
+
+func TestReachable_FollowsTags(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	commit, blob := putCommit(t, store, "release")
+	tag, err := store.PutTag(&core.Tag{
+		Target:     commit,
+		TargetType: core.ObjectTypeCommit,
+		Name:       "v1",
+		Tagger:     "Test",
+		Email:      "test@test.com",
+		Timestamp:  time.Unix(1700000000, 0),
+		Message:    "Version 1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reachable, err := store.Reachable([]core.Hash{tag})
+	if err != nil {
+		t.Fatalf("Reachable failed: %v", err)
+	}
+	if !reachable[tag] || !reachable[commit] || !reachable[blob] {
+		t.Error("a tag, its commit and the commit's blobs should be reachable")
+	}
+	// 1 tag, 1 commit, 1 tree, 1 blob
+	if len(reachable) != 4 {
+		t.Errorf("expected 4 reachable objects, got %d", len(reachable))
+	}
+}
+
+func TestReachable_FailsOnMissingObject(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	commit, blob := putCommit(t, store, "content")
+	if err := os.Remove(store.objectPath(blob)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Reachable([]core.Hash{commit}); !errors.Is(err, core.ErrObjectNotFound) {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestPrune_RespectsGraceAndDryRun(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	kept, _ := putCommit(t, store, "kept")
+	old, oldBlob := putCommit(t, store, "old orphan")
+	recent, _ := putCommit(t, store, "recent orphan")
+
+	// Backdate everything belonging to the old orphan
+	oldCommit, _ := store.GetCommit(old)
+	for _, hash := range []core.Hash{old, oldCommit.Tree, oldBlob} {
+		age(t, store, hash)
+	}
+
+	reachable, err := store.Reachable([]core.Hash{kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := PruneOptions{Grace: time.Hour, DryRun: true}
+	stats, err := store.Prune(reachable, opts)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if stats.Pruned != 3 || stats.Bytes == 0 || stats.Recent != 3 {
+		t.Errorf("unexpected dry-run stats %+v", stats)
+	}
+	if !store.Exists(old) {
+		t.Fatal("dry run should not delete anything")
+	}
+
+	opts.DryRun = false
+	if _, err := store.Prune(reachable, opts); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+
+	if store.Exists(old) || store.Exists(oldBlob) {
+		t.Error("old unreachable objects should be pruned")
+	}
+	if !store.Exists(recent) {
+		t.Error("recent unreachable objects should survive the grace period")
+	}
+	if _, err := store.Get(kept); err != nil {
+		t.Errorf("reachable commit should survive: %v", err)
+	}
+}
+
+func TestPrune_PackedObjects(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	kept, keptBlob := putCommit(t, store, "kept")
+	orphan, orphanBlob := putCommit(t, store, "packed orphan")
+	if _, err := store.Repack(); err != nil {
+		t.Fatal(err)
+	}
+
+	reachable, err := store.Reachable([]core.Hash{kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new pack is within the grace period, like a new loose object
+	opts := PruneOptions{Grace: time.Hour}
+	stats, err := store.Prune(reachable, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pruned != 0 || stats.Recent != 3 || !store.Exists(orphan) {
+		t.Errorf("unexpected stats %+v for a recent pack", stats)
+	}
+
+	packs, err := store.packList()
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("packs = %d, %v; want one", len(packs), err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(packs[0].packPath(), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err = store.Prune(reachable, PruneOptions{Grace: time.Hour, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pruned != 3 || stats.Bytes == 0 || !store.Exists(orphan) {
+		t.Errorf("unexpected dry-run stats %+v", stats)
+	}
+
+	if _, err := store.Prune(reachable, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []core.Hash{orphan, orphanBlob} {
+		if store.Exists(hash) {
+			t.Errorf("packed orphan %s should be pruned", hash.Short())
+		}
+	}
+	for _, hash := range []core.Hash{kept, keptBlob} {
+		if _, err := store.Get(hash); err != nil {
+			t.Errorf("reachable object %s should survive: %v", hash.Short(), err)
+		}
+	}
+
+	// Repacking again does not bring the orphans back
+	if _, err := store.Repack(); err != nil {
+		t.Fatal(err)
+	}
+	if objects, err := store.ListObjects(); err != nil || len(objects) != 3 {
+		t.Errorf("objects = %d, %v; want the three reachable ones", len(objects), err)
+	}
+}
@@ -0,0 +1,682 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/zeebo/blake3"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// Pack files hold many objects in a single file under objects/pack, next to
+// an index that locates each object without scanning the pack:
+//
+//	pack:  "ASLP" | version | count | entry... | checksum
+//	entry: kind | base hash (deltas only) | uvarint payload size | zlib payload
+//	index: "ASLI" | version | count | (hash | offset)... | checksum
+//
+// Version and count are big-endian uint32, offsets big-endian uint64, and
+// index entries are sorted by hash. The checksum is the Blake3 hash of the
+// pack contents before it and names the files: pack-<checksum>.pack/.idx.
+//
+// A full entry's payload is the encoded object ("<type> <data>"); a delta
+// entry's payload is a delta against another object in the same pack.
+const (
+	packMagic   = "ASLP"
+	indexMagic  = "ASLI"
+	packVersion = 1
+
+	packHeaderSize     = 12
+	packIndexEntrySize = 32 + 8
+
+	packEntryFull  byte = 1
+	packEntryDelta byte = 2
+
+	// deltaWindow is how many preceding objects are tried as delta bases
+	deltaWindow = 10
+
+	// maxDeltaDepth bounds delta chains so reading an object stays cheap
+	maxDeltaDepth = 10
+
+	// minDeltaSize skips objects too small to benefit from delta encoding
+	minDeltaSize = 64
+)
+
+// RepackStats summarizes the result of Repack
+type RepackStats struct {
+	Pack         string // Name of the new pack, empty if there was nothing to pack
+	Objects      int    // Objects written to the pack
+	Deltas       int    // Objects stored as deltas
+	Size         int64  // Size of the pack file in bytes
+	LooseRemoved int    // Loose objects moved into the pack
+	PacksRemoved int    // Older packs merged into the new one
+}
+
+// Repack moves every loose object and existing pack into a single new pack,
+// delta-encoding similar objects, then removes what the new pack supersedes
+func (s *Store) Repack() (*RepackStats, error) {
+	return s.repack(nil)
+}
+
+// repack is Repack, leaving out the objects in drop
+func (s *Store) repack(drop map[core.Hash]bool) (*RepackStats, error) {
+	loose, err := s.looseObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	packs, err := s.packList()
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make(map[core.Hash]*packObject, len(loose))
+	for _, hash := range loose {
+		if drop[hash] {
+			continue
+		}
+		raw, err := s.readLoose(hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", hash.Short(), err)
+		}
+		objects[hash] = &packObject{hash: hash, raw: raw}
+	}
+	for _, p := range packs {
+		err := p.forEach(func(hash core.Hash, raw []byte) {
+			if _, ok := objects[hash]; !ok && !drop[hash] {
+				objects[hash] = &packObject{hash: hash, raw: raw}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stats := &RepackStats{}
+	if len(objects) == 0 && (drop == nil || len(packs) == 0) {
+		return stats, nil
+	}
+
+	// Everything may have been dropped, leaving no pack to write
+	var name string
+	if len(objects) > 0 {
+		list := make([]*packObject, 0, len(objects))
+		for _, obj := range objects {
+			list = append(list, obj)
+		}
+		selectDeltas(list)
+
+		var size int64
+		name, size, err = writePack(s.packDir(), list)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Pack = name
+		stats.Objects = len(list)
+		stats.Size = size
+		for _, obj := range list {
+			if obj.base != nil {
+				stats.Deltas++
+			}
+		}
+	}
+
+	s.reloadPacks()
+
+	// Remove the index first so no reader finds an index without its pack
+	for _, p := range packs {
+		if p.name == name {
+			continue
+		}
+		if err := os.Remove(p.indexPath()); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove old pack: %w", err)
+		}
+		if err := os.Remove(p.packPath()); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove old pack: %w", err)
+		}
+		stats.PacksRemoved++
+	}
+
+	for _, hash := range loose {
+		path := s.objectPath(hash)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove loose object: %w", err)
+		}
+		// Fails harmlessly while the fan-out directory still has objects
+		os.Remove(filepath.Dir(path))
+		stats.LooseRemoved++
+	}
+
+	return stats, nil
+}
+
+// ListObjects returns the hashes of every object in the store, loose or
+// packed, in sorted order
+func (s *Store) ListObjects() ([]core.Hash, error) {
+	hashes, err := s.looseObjects()
+	if err != nil {
+		return nil, err
+	}
+
+	packs, err := s.packList()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packs {
+		hashes = append(hashes, p.hashes...)
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	// Drop objects stored both loose and packed
+	unique := hashes[:0]
+	for i, hash := range hashes {
+		if i == 0 || hash != hashes[i-1] {
+			unique = append(unique, hash)
+		}
+	}
+	return unique, nil
+}
+
+// FindPrefix returns the hashes of every object, loose or packed, whose
+// hex form starts with prefix, in sorted order. The prefix must be at
+// least two lowercase hex digits.
+func (s *Store) FindPrefix(prefix string) ([]core.Hash, error) {
+	if len(prefix) < 2 {
+		return nil, fmt.Errorf("%w: prefix %q is too short", core.ErrInvalidHash, prefix)
+	}
+
+	found := make(map[core.Hash]bool)
+
+	entries, err := os.ReadDir(filepath.Join(s.root, "objects", prefix[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix[2:]) {
+			continue
+		}
+		if hash, err := core.ParseHash(prefix[:2] + entry.Name()); err == nil {
+			found[hash] = true
+		}
+	}
+
+	packs, err := s.packList()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range packs {
+		// Hashes sort the same way as their hex form
+		i := sort.Search(len(p.hashes), func(i int) bool {
+			return p.hashes[i].String() >= prefix
+		})
+		for ; i < len(p.hashes) && strings.HasPrefix(p.hashes[i].String(), prefix); i++ {
+			found[p.hashes[i]] = true
+		}
+	}
+
+	hashes := make([]core.Hash, 0, len(found))
+	for hash := range found {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+	return hashes, nil
+}
+
+// looseObjects lists the hashes of all loose objects
+func (s *Store) looseObjects() ([]core.Hash, error) {
+	dir := filepath.Join(s.root, "objects")
+	fanouts, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	var hashes []core.Hash
+	for _, fanout := range fanouts {
+		if !fanout.IsDir() || len(fanout.Name()) != 2 {
+			continue
+		}
+
+		entries, err := os.ReadDir(filepath.Join(dir, fanout.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, entry := range entries {
+			hash, err := core.ParseHash(fanout.Name() + entry.Name())
+			if err != nil {
+				continue
+			}
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// packDir returns the directory holding pack files
+func (s *Store) packDir() string {
+	return filepath.Join(s.root, "objects", "pack")
+}
+
+// packList returns the store's packs, loading their indexes on first use
+func (s *Store) packList() ([]*packFile, error) {
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+
+	if s.packsLoaded {
+		return s.packs, nil
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.packDir(), "pack-*.idx"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep indexes that are already loaded; packs never change once written
+	loaded := make(map[string]*packFile, len(s.packs))
+	for _, p := range s.packs {
+		loaded[p.name] = p
+	}
+
+	packs := make([]*packFile, 0, len(matches))
+	for _, match := range matches {
+		name := strings.TrimSuffix(filepath.Base(match), ".idx")
+		if p, ok := loaded[name]; ok {
+			packs = append(packs, p)
+			continue
+		}
+
+		p, err := openPack(s.packDir(), name)
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, p)
+	}
+
+	s.packs = packs
+	s.packsLoaded = true
+	return packs, nil
+}
+
+// reloadPacks makes the next lookup rescan the pack directory
+func (s *Store) reloadPacks() {
+	s.packMu.Lock()
+	s.packsLoaded = false
+	s.packMu.Unlock()
+}
+
+// inPack reports whether any pack contains the object
+func (s *Store) inPack(hash core.Hash) bool {
+	packs, err := s.packList()
+	if err != nil {
+		return false
+	}
+	for _, p := range packs {
+		if _, ok := p.find(hash); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readPacked reads an encoded object from whichever pack contains it.
+// The pack directory is rescanned once on a miss, in case another process
+// has repacked since the indexes were loaded.
+func (s *Store) readPacked(hash core.Hash) ([]byte, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		if attempt > 0 {
+			s.reloadPacks()
+		}
+
+		packs, err := s.packList()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range packs {
+			if _, ok := p.find(hash); !ok {
+				continue
+			}
+			data, err := p.read(hash)
+			if errors.Is(err, fs.ErrNotExist) {
+				break // Removed by a concurrent repack
+			}
+			return data, err
+		}
+	}
+	return nil, core.ErrObjectNotFound
+}
+
+// packFile is a pack whose index has been loaded into memory
+type packFile struct {
+	dir     string
+	name    string
+	hashes  []core.Hash // Sorted
+	offsets []uint64
+}
+
+func (p *packFile) packPath() string  { return filepath.Join(p.dir, p.name+".pack") }
+func (p *packFile) indexPath() string { return filepath.Join(p.dir, p.name+".idx") }
+
+// openPack loads the index of a pack
+func openPack(dir, name string) (*packFile, error) {
+	p := &packFile{dir: dir, name: name}
+
+	data, err := os.ReadFile(p.indexPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+
+	if len(data) < packHeaderSize+32 || string(data[:4]) != indexMagic {
+		return nil, fmt.Errorf("%w: %s: bad index header", core.ErrCorruptPack, name)
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != packVersion {
+		return nil, fmt.Errorf("%w: %s: unsupported index version %d", core.ErrCorruptPack, name, version)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	if len(data) != packHeaderSize+count*packIndexEntrySize+32 {
+		return nil, fmt.Errorf("%w: %s: index size does not match object count", core.ErrCorruptPack, name)
+	}
+
+	p.hashes = make([]core.Hash, count)
+	p.offsets = make([]uint64, count)
+	entries := data[packHeaderSize:]
+	for i := 0; i < count; i++ {
+		entry := entries[i*packIndexEntrySize:]
+		copy(p.hashes[i][:], entry[:32])
+		p.offsets[i] = binary.BigEndian.Uint64(entry[32:40])
+	}
+
+	return p, nil
+}
+
+// find returns the offset of an object in the pack
+func (p *packFile) find(hash core.Hash) (uint64, bool) {
+	i := sort.Search(len(p.hashes), func(i int) bool {
+		return bytes.Compare(p.hashes[i][:], hash[:]) >= 0
+	})
+	if i < len(p.hashes) && p.hashes[i] == hash {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// read returns the encoded object stored under hash
+func (p *packFile) read(hash core.Hash) ([]byte, error) {
+	offset, ok := p.find(hash)
+	if !ok {
+		return nil, core.ErrObjectNotFound
+	}
+
+	f, err := os.Open(p.packPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+	defer f.Close()
+
+	return p.readEntry(f, offset, 0)
+}
+
+// forEach calls fn with every object in the pack
+func (p *packFile) forEach(fn func(hash core.Hash, raw []byte)) error {
+	f, err := os.Open(p.packPath())
+	if err != nil {
+		return fmt.Errorf("failed to open pack: %w", err)
+	}
+	defer f.Close()
+
+	for i, hash := range p.hashes {
+		raw, err := p.readEntry(f, p.offsets[i], 0)
+		if err != nil {
+			return fmt.Errorf("failed to read object %s from %s: %w", hash.Short(), p.name, err)
+		}
+		fn(hash, raw)
+	}
+	return nil
+}
+
+// readEntry decodes the entry at offset, resolving delta chains
+func (p *packFile) readEntry(f *os.File, offset uint64, depth int) ([]byte, error) {
+	if depth > maxDeltaDepth || offset > math.MaxInt64 {
+		return nil, fmt.Errorf("%w: %s: invalid entry at offset %d", core.ErrCorruptPack, p.name, offset)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, int64(offset), math.MaxInt64-int64(offset)))
+
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", core.ErrCorruptPack, p.name, err)
+	}
+
+	var base core.Hash
+	if kind == packEntryDelta {
+		if _, err := io.ReadFull(r, base[:]); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", core.ErrCorruptPack, p.name, err)
+		}
+	}
+
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", core.ErrCorruptPack, p.name, err)
+	}
+
+	zr, err := zlib.NewReader(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", core.ErrCorruptPack, p.name, err)
+	}
+	defer zr.Close()
+
+	payload, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", core.ErrCorruptPack, p.name, err)
+	}
+
+	switch kind {
+	case packEntryFull:
+		return payload, nil
+
+	case packEntryDelta:
+		baseOffset, ok := p.find(base)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s: missing delta base %s", core.ErrCorruptPack, p.name, base.Short())
+		}
+		baseData, err := p.readEntry(f, baseOffset, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return applyDelta(baseData, payload)
+
+	default:
+		return nil, fmt.Errorf("%w: %s: unknown entry kind %d", core.ErrCorruptPack, p.name, kind)
+	}
+}
+
+// packObject is an object being written to a pack
+type packObject struct {
+	hash  core.Hash
+	raw   []byte      // Encoded object
+	base  *packObject // Delta base, nil when stored whole
+	delta []byte
+	depth int
+}
+
+// rawType returns the type prefix of an encoded object
+func rawType(raw []byte) string {
+	if i := bytes.IndexByte(raw, ' '); i >= 0 {
+		return string(raw[:i])
+	}
+	return ""
+}
+
+// selectDeltas picks a delta base for each object from a window of its
+// predecessors. Objects are grouped by type and sorted by decreasing size,
+// so similar objects end up close together and bases are always written
+// before the deltas that use them.
+func selectDeltas(objects []*packObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		a, b := objects[i], objects[j]
+		if ta, tb := rawType(a.raw), rawType(b.raw); ta != tb {
+			return ta < tb
+		}
+		if len(a.raw) != len(b.raw) {
+			return len(a.raw) > len(b.raw)
+		}
+		return bytes.Compare(a.hash[:], b.hash[:]) < 0
+	})
+
+	for i, obj := range objects {
+		if len(obj.raw) < minDeltaSize {
+			continue
+		}
+
+		typ := rawType(obj.raw)
+		for j := i - 1; j >= 0 && j >= i-deltaWindow; j-- {
+			candidate := objects[j]
+			if rawType(candidate.raw) != typ {
+				break
+			}
+			if candidate.depth >= maxDeltaDepth {
+				continue
+			}
+
+			// Only keep deltas that save at least half the object
+			limit := len(obj.raw) / 2
+			if obj.base != nil {
+				limit = len(obj.delta)
+			}
+
+			delta := computeDelta(candidate.raw, obj.raw)
+			if len(delta) < limit {
+				obj.base = candidate
+				obj.delta = delta
+				obj.depth = candidate.depth + 1
+			}
+		}
+	}
+}
+
+// writePack writes objects to a new pack and its index, returning the
+// pack's name and size. The index is written last, since a pack is only
+// visible to readers once its index exists.
+func writePack(dir string, objects []*packObject) (string, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "tmp-pack-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create pack: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := blake3.New()
+	w := bufio.NewWriter(io.MultiWriter(tmp, hasher))
+
+	header := make([]byte, packHeaderSize)
+	copy(header, packMagic)
+	binary.BigEndian.PutUint32(header[4:], packVersion)
+	binary.BigEndian.PutUint32(header[8:], uint32(len(objects)))
+	w.Write(header)
+
+	offset := uint64(len(header))
+	offsets := make(map[core.Hash]uint64, len(objects))
+	var payload bytes.Buffer
+	for _, obj := range objects {
+		offsets[obj.hash] = offset
+
+		entry := []byte{packEntryFull}
+		data := obj.raw
+		if obj.base != nil {
+			entry = append([]byte{packEntryDelta}, obj.base.hash[:]...)
+			data = obj.delta
+		}
+
+		payload.Reset()
+		zw := zlib.NewWriter(&payload)
+		zw.Write(data)
+		if err := zw.Close(); err != nil {
+			return "", 0, fmt.Errorf("failed to compress object: %w", err)
+		}
+
+		entry = binary.AppendUvarint(entry, uint64(payload.Len()))
+		w.Write(entry)
+		w.Write(payload.Bytes())
+		offset += uint64(len(entry) + payload.Len())
+	}
+
+	if err := w.Flush(); err != nil {
+		return "", 0, fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	var checksum core.Hash
+	copy(checksum[:], hasher.Sum(nil))
+	if _, err := tmp.Write(checksum[:]); err != nil {
+		return "", 0, fmt.Errorf("failed to write pack: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	name := "pack-" + checksum.String()
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name+".pack")); err != nil {
+		return "", 0, fmt.Errorf("failed to install pack: %w", err)
+	}
+
+	if err := writePackIndex(filepath.Join(dir, name+".idx"), offsets, checksum); err != nil {
+		return "", 0, err
+	}
+
+	return name, int64(offset) + int64(len(checksum)), nil
+}
+
+// writePackIndex atomically writes the index for a pack
+func writePackIndex(path string, offsets map[core.Hash]uint64, checksum core.Hash) error {
+	hashes := make([]core.Hash, 0, len(offsets))
+	for hash := range offsets {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	buf := make([]byte, packHeaderSize, packHeaderSize+len(hashes)*packIndexEntrySize+len(checksum))
+	copy(buf, indexMagic)
+	binary.BigEndian.PutUint32(buf[4:], packVersion)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(hashes)))
+	for _, hash := range hashes {
+		buf = append(buf, hash[:]...)
+		buf = binary.BigEndian.AppendUint64(buf, offsets[hash])
+	}
+	buf = append(buf, checksum[:]...)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return fmt.Errorf("failed to write pack index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write pack index: %w", err)
+	}
+	return nil
+}
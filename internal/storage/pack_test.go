@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+// putVersions stores n similar blobs, like successive versions of a file
+func putVersions(t *testing.T, store *Store, n int) []core.Hash {
+	t.Helper()
+	var hashes []core.Hash
+	content := strings.Repeat("some file content that stays the same\n", 50)
+	for i := 0; i < n; i++ {
+		content += fmt.Sprintf("line added in version %d\n", i)
+		hash, err := store.PutBlob([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+func TestRepack_MovesLooseObjectsIntoPack(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(tmpDir)
+
+	hashes := putVersions(t, store, 5)
+	small, err := store.PutBlob([]byte("tiny"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes = append(hashes, small)
+
+	stats, err := store.Repack()
+	if err != nil {
+		t.Fatalf("repack failed: %v", err)
+	}
+
+	if stats.Objects != len(hashes) || stats.LooseRemoved != len(hashes) {
+		t.Errorf("expected %d objects packed and removed, got %+v", len(hashes), stats)
+	}
+	if stats.Deltas == 0 {
+		t.Error("expected similar blobs to be stored as deltas")
+	}
+
+	loose, err := store.looseObjects()
+	if err != nil || len(loose) != 0 {
+		t.Errorf("expected no loose objects after repack, got %d (%v)", len(loose), err)
+	}
+
+	// A fresh store must find everything through the pack index
+	fresh := NewStore(tmpDir)
+	for _, hash := range hashes {
+		if !fresh.Exists(hash) {
+			t.Errorf("object %s missing after repack", hash.Short())
+		}
+		obj, err := fresh.Get(hash)
+		if err != nil {
+			t.Fatalf("get %s failed: %v", hash.Short(), err)
+		}
+		if HashObject(obj.Type, obj.Data) != hash {
+			t.Errorf("object %s has wrong content after repack", hash.Short())
+		}
+	}
+}
+
+func TestRepack_PutDoesNotDuplicatePackedObjects(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(tmpDir)
+
+	hash, err := store.PutBlob([]byte("packed content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Repack(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.PutBlob([]byte("packed content")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.objectPath(hash)); !os.IsNotExist(err) {
+		t.Error("storing a packed object should not write a loose copy")
+	}
+}
+
+func TestRepack_MergesExistingPacks(t *testing.T) {
+	tmpDir := t.TempDir()
+	store := NewStore(tmpDir)
+
+	first := putVersions(t, store, 3)
+	if _, err := store.Repack(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := store.PutBlob([]byte("written after the first repack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := store.Repack()
+	if err != nil {
+		t.Fatalf("second repack failed: %v", err)
+	}
+	if stats.Objects != len(first)+1 || stats.PacksRemoved != 1 || stats.LooseRemoved != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	packs, _ := filepath.Glob(filepath.Join(tmpDir, "objects", "pack", "*.idx"))
+	if len(packs) != 1 {
+		t.Errorf("expected a single pack, found %d", len(packs))
+	}
+
+	for _, hash := range append(first, second) {
+		if _, err := NewStore(tmpDir).Get(hash); err != nil {
+			t.Errorf("get %s failed: %v", hash.Short(), err)
+		}
+	}
+}
+
+func TestRepack_Empty(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	stats, err := store.Repack()
+	if err != nil {
+		t.Fatalf("repack of empty store failed: %v", err)
+	}
+	if stats.Pack != "" || stats.Objects != 0 {
+		t.Errorf("expected nothing to pack, got %+v", stats)
+	}
+}
+
+func TestPack_SeesRepackFromAnotherStore(t *testing.T) {
+	tmpDir := t.TempDir()
+	reader := NewStore(tmpDir)
+	if _, err := reader.Repack(); err != nil { // Loads an empty pack list
+		t.Fatal(err)
+	}
+
+	writer := NewStore(tmpDir)
+	hash, err := writer.PutBlob([]byte("repacked elsewhere"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writer.Repack(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reader.Get(hash); err != nil {
+		t.Errorf("expected reader to rescan packs, got %v", err)
+	}
+}
+
+func TestFindPrefix(t *testing.T) {
+	store := NewStore(t.TempDir())
+
+	hashes := putVersions(t, store, 3)
+	if _, err := store.Repack(); err != nil {
+		t.Fatal(err)
+	}
+	loose, err := store.PutBlob([]byte("loose"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes = append(hashes, loose)
+
+	for _, hash := range hashes {
+		found, err := store.FindPrefix(hash.String()[:8])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0] != hash {
+			t.Errorf("FindPrefix(%s) = %v, want [%s]", hash.String()[:8], found, hash.Short())
+		}
+	}
+
+	// A two-digit prefix matches both loose and packed objects
+	prefix := loose.String()[:2]
+	found, err := store.FindPrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := 0
+	for _, hash := range hashes {
+		if strings.HasPrefix(hash.String(), prefix) {
+			want++
+		}
+	}
+	if len(found) != want {
+		t.Errorf("FindPrefix(%s) found %d objects, want %d", prefix, len(found), want)
+	}
+
+	if found, err := store.FindPrefix("ffffffffffff"); err != nil || len(found) != 0 {
+		t.Errorf("FindPrefix of an unused prefix = %v, %v", found, err)
+	}
+	if _, err := store.FindPrefix("a"); err == nil {
+		t.Error("expected an error for a one-digit prefix")
+	}
+}
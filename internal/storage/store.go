@@ -16,6 +16,10 @@ type Store struct {
 	root  string
 	mu    sync.RWMutex
 	cache map[core.Hash]*core.Object
+
+	packMu      sync.Mutex
+	packs       []*packFile
+	packsLoaded bool
 }
 
 // NewStore creates a new object store
@@ -26,21 +30,32 @@ func NewStore(root string) *Store {
 	}
 }
 
+// encodeObject prefixes object data with its type
+func encodeObject(objType core.ObjectType, data []byte) []byte {
+	obj := make([]byte, 0, len(objType)+1+len(data))
+	obj = append(obj, []byte(string(objType)+" ")...)
+	obj = append(obj, data...)
+	return obj
+}
+
+// HashObject computes the hash an object would be stored under without writing it
+func HashObject(objType core.ObjectType, data []byte) core.Hash {
+	return core.HashBytes(encodeObject(objType, data))
+}
+
 // Put stores an object in the database
 func (s *Store) Put(objType core.ObjectType, data []byte) (core.Hash, error) {
 	// Create object with type prefix
-	var obj []byte
-	obj = append(obj, []byte(string(objType)+" ")...)
-	obj = append(obj, data...)
+	obj := encodeObject(objType, data)
 
 	// Compute hash
 	hash := core.HashBytes(obj)
 
-	// Check if already exists
-	path := s.objectPath(hash)
-	if _, err := os.Stat(path); err == nil {
+	// Check if already exists, loose or packed
+	if s.Exists(hash) {
 		return hash, nil
 	}
+	path := s.objectPath(hash)
 
 	// Ensure directory exists
 	dir := filepath.Dir(path)
@@ -65,7 +80,8 @@ func (s *Store) Put(objType core.ObjectType, data []byte) (core.Hash, error) {
 	return hash, nil
 }
 
-// Get retrieves an object from the database
+// Get retrieves an object from the database, reading loose objects first
+// and falling back to pack files
 func (s *Store) Get(hash core.Hash) (*core.Object, error) {
 	// Check cache first
 	s.mu.RLock()
@@ -76,26 +92,12 @@ func (s *Store) Get(hash core.Hash) (*core.Object, error) {
 	s.mu.RUnlock()
 
 	// Read from disk
-	path := s.objectPath(hash)
-	file, err := os.Open(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, core.ErrObjectNotFound
-		}
-		return nil, fmt.Errorf("failed to open object: %w", err)
-	}
-	defer file.Close()
-
-	// Decompress
-	reader, err := zlib.NewReader(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decompress object: %w", err)
+	data, err := s.readLoose(hash)
+	if err == core.ErrObjectNotFound {
+		data, err = s.readPacked(hash)
 	}
-	defer reader.Close()
-
-	data, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object: %w", err)
+		return nil, err
 	}
 
 	// Parse object type
@@ -125,6 +127,31 @@ func (s *Store) Get(hash core.Hash) (*core.Object, error) {
 	return obj, nil
 }
 
+// readLoose reads and decompresses a loose object file
+func (s *Store) readLoose(hash core.Hash) ([]byte, error) {
+	file, err := os.Open(s.objectPath(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, core.ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	defer file.Close()
+
+	// Decompress
+	reader, err := zlib.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress object: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
 // Exists checks if an object exists in the database
 func (s *Store) Exists(hash core.Hash) bool {
 	s.mu.RLock()
@@ -135,8 +162,10 @@ func (s *Store) Exists(hash core.Hash) bool {
 		return true
 	}
 
-	_, err := os.Stat(s.objectPath(hash))
-	return err == nil
+	if _, err := os.Stat(s.objectPath(hash)); err == nil {
+		return true
+	}
+	return s.inPack(hash)
 }
 
 // objectPath returns the file path for a given hash
@@ -162,6 +191,12 @@ func (s *Store) PutCommit(commit *core.Commit) (core.Hash, error) {
 	return s.Put(core.ObjectTypeCommit, data)
 }
 
+// PutTag stores an annotated tag object
+func (s *Store) PutTag(tag *core.Tag) (core.Hash, error) {
+	data := core.EncodeTag(tag)
+	return s.Put(core.ObjectTypeTag, data)
+}
+
 // GetCommit retrieves and decodes a commit object
 func (s *Store) GetCommit(hash core.Hash) (*core.Commit, error) {
 	obj, err := s.Get(hash)
@@ -189,3 +224,17 @@ func (s *Store) GetTree(hash core.Hash) (*core.Tree, error) {
 
 	return core.DecodeTree(obj.Data)
 }
+
+// GetTag retrieves and decodes an annotated tag object
+func (s *Store) GetTag(hash core.Hash) (*core.Tag, error) {
+	obj, err := s.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.Type != core.ObjectTypeTag {
+		return nil, fmt.Errorf("expected tag, got %s", obj.Type)
+	}
+
+	return core.DecodeTag(obj.Data)
+}
@@ -42,7 +42,10 @@ func Fetch(store *storage.Store, client FetcherClient, remoteTips []core.Hash) e
 			return fmt.Errorf("failed to fetch %s: %w", current, err)
 		}
 
-		// Save
+		// Save, unless the server sent some other object
+		if hash := storage.HashObject(obj.Type, obj.Data); hash != current {
+			return fmt.Errorf("%w: asked for %s, got %s", core.ErrInvalidObject, current, hash)
+		}
 		if _, err := store.Put(obj.Type, obj.Data); err != nil {
 			return fmt.Errorf("failed to save %s: %w", current, err)
 		}
@@ -68,6 +71,13 @@ func Fetch(store *storage.Store, client FetcherClient, remoteTips []core.Hash) e
 				queue = append(queue, entry.Hash)
 			}
 
+		case core.ObjectTypeTag:
+			tag, err := core.DecodeTag(obj.Data)
+			if err != nil {
+				return err
+			}
+			queue = append(queue, tag.Target)
+
 		case core.ObjectTypeBlob:
 			// No children
 		}
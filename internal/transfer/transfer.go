@@ -45,6 +45,12 @@ func CalculatePushPack(store *storage.Store, local []core.Hash, remote []core.Ha
 	haveSet := make(map[core.Hash]bool)
 	for _, h := range remote {
 		haveSet[h] = true
+
+		// The remote also has everything in its tips' trees, so unchanged
+		// subtrees and blobs are skipped by hash
+		if commit, err := store.GetCommit(h); err == nil {
+			markTree(store, commit.Tree, haveSet)
+		}
 	}
 
 	visited := make(map[core.Hash]bool)
@@ -102,6 +108,13 @@ func CalculatePushPack(store *storage.Store, local []core.Hash, remote []core.Ha
 				queue = append(queue, entry.Hash)
 			}
 
+		case core.ObjectTypeTag:
+			tag, err := core.DecodeTag(obj.Data)
+			if err != nil {
+				return nil, err
+			}
+			queue = append(queue, tag.Target)
+
 		case core.ObjectTypeBlob:
 			// No children
 		}
@@ -109,3 +122,25 @@ func CalculatePushPack(store *storage.Store, local []core.Hash, remote []core.Ha
 
 	return result, nil
 }
+
+// markTree adds a tree and everything below it to set. Trees that cannot
+// be read are left out, which only means their contents may be sent again.
+func markTree(store *storage.Store, hash core.Hash, set map[core.Hash]bool) {
+	if set[hash] {
+		return
+	}
+
+	tree, err := store.GetTree(hash)
+	if err != nil {
+		return
+	}
+	set[hash] = true
+
+	for _, entry := range tree.Entries {
+		if entry.IsDir() {
+			markTree(store, entry.Hash, set)
+		} else {
+			set[entry.Hash] = true
+		}
+	}
+}
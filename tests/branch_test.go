@@ -0,0 +1,292 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/remote"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestBranch_HierarchicalNames(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "base\n", "Base")
+
+	for _, name := range []string{"feature/login", "feature/ui/menu", "fix-1.2"} {
+		if err := repo.CreateBranch(name); err != nil {
+			t.Fatalf("CreateBranch(%q) failed: %v", name, err)
+		}
+	}
+	branches, err := repo.ListBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"feature/login", "feature/ui/menu", "fix-1.2", "main"}
+	if len(branches) != len(want) {
+		t.Fatalf("branches = %q, want %q", branches, want)
+	}
+	for i := range want {
+		if branches[i] != want[i] {
+			t.Errorf("branches[%d] = %q, want %q", i, branches[i], want[i])
+		}
+	}
+
+	if err := repo.SwitchBranch("feature/login"); err != nil {
+		t.Fatalf("switching to a nested branch failed: %v", err)
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "feature/login" {
+		t.Errorf("current branch = %q, want feature/login", branch)
+	}
+	if got := mustCommit(t, repo); got != base {
+		t.Errorf("feature/login = %s, want %s", got.Short(), base.Short())
+	}
+
+	for _, name := range []string{
+		"", "HEAD", "-x", "a..b", "a b", "a~1", "a^", "a:b", "a?", "a*", "a[b", `a\b`,
+		"a\tb", "a\x7f", "a@{1}", "a.", "a/", "/a", "a//b", ".a", "a/.b", "a.lock", "a.lock/b",
+	} {
+		if err := repo.CreateBranch(name); !errors.Is(err, core.ErrInvalidBranchName) {
+			t.Errorf("CreateBranch(%q): expected ErrInvalidBranchName, got %v", name, err)
+		}
+	}
+
+	// A branch cannot be both a ref and a directory of refs
+	for _, name := range []string{"feature", "feature/login/x", "main"} {
+		if err := repo.CreateBranch(name); !errors.Is(err, core.ErrBranchExists) {
+			t.Errorf("CreateBranch(%q): expected ErrBranchExists, got %v", name, err)
+		}
+	}
+}
+
+func TestBranch_Delete(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "file.txt", "base\n", "Base")
+	for _, name := range []string{"merged", "topic/wip"} {
+		if err := repo.CreateBranch(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.SwitchBranch("topic/wip"); err != nil {
+		t.Fatal(err)
+	}
+	wip := commitFile(t, repo, "wip.txt", "wip\n", "WIP")
+
+	if _, err := repo.DeleteBranch("topic/wip", true); !errors.Is(err, core.ErrBranchCheckedOut) {
+		t.Errorf("deleting the current branch: expected ErrBranchCheckedOut, got %v", err)
+	}
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.DeleteBranch("topic/wip", false); !errors.Is(err, core.ErrBranchNotMerged) {
+		t.Errorf("deleting an unmerged branch: expected ErrBranchNotMerged, got %v", err)
+	}
+	if _, err := repo.DeleteBranch("merged", false); err != nil {
+		t.Errorf("deleting a merged branch failed: %v", err)
+	}
+
+	// A branch merged into its upstream may go even if HEAD lacks it
+	if err := repo.UpdateRef("refs/remotes/origin/wip", core.Hash{}, wip); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.SetUpstream(repo.Root, "topic/wip", "origin", "refs/heads/wip"); err != nil {
+		t.Fatal(err)
+	}
+	tip, err := repo.DeleteBranch("topic/wip", false)
+	if err != nil {
+		t.Fatalf("deleting a branch merged into its upstream failed: %v", err)
+	}
+	if tip != wip {
+		t.Errorf("DeleteBranch returned %s, want %s", tip.Short(), wip.Short())
+	}
+	if _, err := remote.GetUpstream(repo.Root, "topic/wip"); err == nil {
+		t.Error("the upstream of a deleted branch should be removed")
+	}
+	for _, dir := range []string{filepath.Join("refs", "heads", "topic"), filepath.Join("logs", "refs", "heads", "topic")} {
+		if _, err := os.Stat(filepath.Join(repo.AslPath(), dir)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed once empty", dir)
+		}
+	}
+
+	// Force deletes regardless, and the branch can be recreated afterwards
+	if err := repo.CreateBranch("topic"); err != nil {
+		t.Fatalf("recreating a deleted branch's directory as a branch failed: %v", err)
+	}
+	if _, err := repo.DeleteBranch("missing", true); !errors.Is(err, core.ErrBranchNotFound) {
+		t.Errorf("expected ErrBranchNotFound, got %v", err)
+	}
+}
+
+func TestBranch_Rename(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "file.txt", "base\n", "Base")
+	tip := commitFile(t, repo, "file.txt", "second\n", "Second")
+	if err := remote.SetUpstream(repo.Root, "main", "origin", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.RenameBranch("main", "release/1.0"); err != nil {
+		t.Fatalf("RenameBranch failed: %v", err)
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "release/1.0" {
+		t.Errorf("HEAD should follow the renamed branch, got %q", branch)
+	}
+	if got, _ := repo.GetRef("refs/heads/release/1.0"); got != tip {
+		t.Errorf("release/1.0 = %s, want %s", got.Short(), tip.Short())
+	}
+	if _, err := repo.GetRef("refs/heads/main"); !errors.Is(err, core.ErrBranchNotFound) {
+		t.Errorf("main should be gone, got %v", err)
+	}
+
+	entries, err := repo.Reflog("release/1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].Message != "branch: renamed main to release/1.0" {
+		t.Errorf("reflog of the renamed branch = %+v, want the old entries and the rename", entries)
+	}
+
+	upstream, err := remote.GetUpstream(repo.Root, "release/1.0")
+	if err != nil || upstream.Merge != "refs/heads/main" {
+		t.Errorf("upstream of release/1.0 = %+v, %v", upstream, err)
+	}
+	if _, err := remote.GetUpstream(repo.Root, "main"); err == nil {
+		t.Error("the old branch should have no upstream")
+	}
+
+	if err := repo.CreateBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.RenameBranch("other", "release/1.0"); !errors.Is(err, core.ErrBranchExists) {
+		t.Errorf("renaming onto an existing branch: expected ErrBranchExists, got %v", err)
+	}
+	if err := repo.RenameBranch("other", "bad..name"); !errors.Is(err, core.ErrInvalidBranchName) {
+		t.Errorf("expected ErrInvalidBranchName, got %v", err)
+	}
+}
+
+func TestBranch_RenameIntoOwnDirectory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	tip := commitFile(t, repo, "file.txt", "base\n", "Base")
+
+	// main's file is in the way of main/x's directory, then the other way
+	// round
+	for i, rename := range [][2]string{{"main", "main/x"}, {"main/x", "main"}} {
+		if err := repo.RenameBranch(rename[0], rename[1]); err != nil {
+			t.Fatalf("renaming %s to %s: %v", rename[0], rename[1], err)
+		}
+		if branch, _ := repo.GetCurrentBranch(); branch != rename[1] {
+			t.Errorf("HEAD should follow the renamed branch, got %q", branch)
+		}
+		if got, err := repo.GetRef("refs/heads/" + rename[1]); err != nil || got != tip {
+			t.Errorf("%s = %s, %v; want %s", rename[1], got.Short(), err, tip.Short())
+		}
+		if branches, err := repo.ListBranches(); err != nil || len(branches) != 1 {
+			t.Errorf("branches = %q, %v; want only %s", branches, err, rename[1])
+		}
+
+		entries, err := repo.Reflog(rename[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != i+2 || entries[0].Message != "branch: renamed "+rename[0]+" to "+rename[1] {
+			t.Errorf("reflog of %s = %+v, want the old entries and the rename", rename[1], entries)
+		}
+	}
+}
+
+func TestBranch_Details(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "base\n", "Base")
+	if err := repo.CreateBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "file.txt", "main\n", "Main")
+	if err := repo.SwitchBranch("topic"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "a.txt", "a\n", "Topic A")
+	commitFile(t, repo, "b.txt", "b\n", "Topic B")
+
+	// main tracks origin/main, which is one commit behind it
+	if err := repo.UpdateRef("refs/remotes/origin/main", core.Hash{}, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.SetUpstream(repo.Root, "main", "origin", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := repo.BranchDetails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("got %d branches, want 2", len(infos))
+	}
+
+	main, topic := infos[0], infos[1]
+	if main.Name != "main" || main.Base != "origin/main" || main.Ahead != 1 || main.Behind != 0 || main.Subject != "Main" {
+		t.Errorf("main = %+v, want 1 ahead of origin/main", main)
+	}
+	if !topic.Current || topic.Base != "" || topic.Subject != "Topic B" {
+		t.Errorf("topic = %+v, want the current branch with no base", topic)
+	}
+
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	infos, err = repo.BranchDetails()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topic := infos[1]; topic.Base != "main" || topic.Ahead != 2 || topic.Behind != 1 {
+		t.Errorf("topic = %+v, want 2 ahead of and 1 behind main", topic)
+	}
+}
+
+func TestBranch_ExistingNamesStayInRefsHeads(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	head := commitFile(t, repo, "file.txt", "base\n", "Base")
+	if _, err := repo.CreateTag("v1", head, repository.TagOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	const name = "../tags/v1"
+	if _, err := repo.DeleteBranch(name, true); !errors.Is(err, core.ErrInvalidBranchName) {
+		t.Errorf("delete: err = %v, want ErrInvalidBranchName", err)
+	}
+	if err := repo.RenameBranch(name, "stolen"); !errors.Is(err, core.ErrInvalidBranchName) {
+		t.Errorf("rename: err = %v, want ErrInvalidBranchName", err)
+	}
+	if err := repo.SwitchBranch(name); !errors.Is(err, core.ErrInvalidBranchName) {
+		t.Errorf("switch: err = %v, want ErrInvalidBranchName", err)
+	}
+
+	if got, err := repo.GetRef("refs/tags/v1"); err != nil || got != head {
+		t.Errorf("refs/tags/v1 = %s, %v; want it untouched", got.Short(), err)
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "main" {
+		t.Errorf("current branch = %q, want main", branch)
+	}
+}
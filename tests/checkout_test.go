@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestCheckout_RemovesStaleTrackedFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{"keep.txt": "keep\n"})
+	first, err := repo.Save(nil, "First")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{
+		"keep.txt":       "changed\n",
+		"later.txt":      "later\n",
+		"dir/nested.txt": "nested\n",
+	})
+	if _, err := repo.Save(nil, "Second"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{"untracked.txt": "mine\n"})
+
+	if err := repo.Checkout(first); err != nil {
+		t.Fatalf("Checkout failed: %v", err)
+	}
+
+	want := map[string]string{
+		"keep.txt":       "keep\n",
+		"later.txt":      "",
+		"dir/nested.txt": "",
+		"untracked.txt":  "mine\n",
+	}
+	for name, content := range want {
+		if got := readFile(t, repo, name); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo.Root, "dir")); !os.IsNotExist(err) {
+		t.Error("empty directory dir/ should be removed")
+	}
+}
+
+func TestMerge_FastForwardRemovesDeletedFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{"a.txt": "a\n", "old.txt": "old\n"})
+	if _, err := repo.Save(nil, "Base"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Advance feature by writing its ref directly, so the working directory
+	// still has old.txt when main fast-forwards
+	tree := rootEntries(t, repo, mustCommit(t, repo))
+	delete(tree, "old.txt")
+	feature := commitTree(t, repo, tree, "Remove old.txt")
+	if err := repo.SetRef("refs/heads/feature", feature); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.Merge("feature", repository.MergeOptions{})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if !result.FastForward {
+		t.Fatal("expected a fast-forward merge")
+	}
+	if got := readFile(t, repo, "old.txt"); got != "" {
+		t.Errorf("old.txt should be removed by the fast-forward, got %q", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"a.txt":     "a1\n",
+		"b.txt":     "b1\n",
+		"src/x.go":  "x1\n",
+		"src/y.go":  "y1\n",
+		"docs/r.md": "r1\n",
+	})
+	first, err := repo.Save(nil, "First")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{
+		"a.txt":    "a2\n",
+		"b.txt":    "b2\n",
+		"src/x.go": "x2\n",
+		"src/z.go": "z2\n",
+	})
+	head, err := repo.Save(nil, "Second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Uncommitted edits are discarded for restored paths only
+	writeFiles(t, repo, map[string]string{"a.txt": "dirty\n", "b.txt": "dirty\n"})
+	if err := repo.Restore([]string{"a.txt"}, head); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if got := readFile(t, repo, "a.txt"); got != "a2\n" {
+		t.Errorf("a.txt = %q, want HEAD's version", got)
+	}
+	if got := readFile(t, repo, "b.txt"); got != "dirty\n" {
+		t.Errorf("b.txt = %q, should be untouched", got)
+	}
+
+	// Restoring a directory from an older commit drops files it did not have
+	if err := repo.Restore([]string{"src"}, first); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	want := map[string]string{"src/x.go": "x1\n", "src/y.go": "y1\n", "src/z.go": ""}
+	for name, content := range want {
+		if got := readFile(t, repo, name); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+
+	current, err := repo.GetCurrentCommit()
+	if err != nil || current != head {
+		t.Errorf("Restore moved HEAD to %s", current.Short())
+	}
+
+	if err := repo.Restore([]string{"missing.txt"}, head); !errors.Is(err, core.ErrFileNotFound) {
+		t.Errorf("expected ErrFileNotFound for a missing path, got %v", err)
+	}
+	if err := repo.Restore([]string{"../outside"}, head); err == nil {
+		t.Error("expected an error for a path outside the repository")
+	}
+}
+
+// mustCommit returns the current commit
+func mustCommit(t *testing.T, repo *repository.Repository) core.Hash {
+	t.Helper()
+	head, err := repo.GetCurrentCommit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return head
+}
+
+// commitTree stores a commit with the given root entries on top of HEAD
+// without touching the working directory
+func commitTree(t *testing.T, repo *repository.Repository, entries map[string]core.TreeEntry, message string) core.Hash {
+	t.Helper()
+	tree := &core.Tree{}
+	for _, name := range sortedKeys(entries) {
+		tree.Entries = append(tree.Entries, entries[name])
+	}
+	treeHash, err := repo.Store().PutTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.Store().PutCommit(&core.Commit{
+		Tree:    treeHash,
+		Parents: []core.Hash{mustCommit(t, repo)},
+		Author:  "Test",
+		Email:   "test@example.com",
+		Message: message,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
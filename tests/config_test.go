@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codimo/astral/internal/config"
+	"github.com/codimo/astral/internal/remote"
+)
+
+// isolateConfig points the system and global config files into a fresh
+// directory and clears the identity environment variables
+func isolateConfig(t *testing.T) (system, global string) {
+	t.Helper()
+	dir := t.TempDir()
+	system, global = filepath.Join(dir, "system"), filepath.Join(dir, "global")
+	t.Setenv("ASL_CONFIG_SYSTEM", system)
+	t.Setenv("ASL_CONFIG_GLOBAL", global)
+	t.Setenv("ASL_AUTHOR_NAME", "")
+	t.Setenv("ASL_AUTHOR_EMAIL", "")
+	return system, global
+}
+
+func setConfig(t *testing.T, path, key, value string) {
+	t.Helper()
+	f, err := config.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfig_UserIdentity(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	system, global := isolateConfig(t)
+	t.Setenv("USER", "login")
+	t.Setenv("EMAIL", "")
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	author := func(name string) (string, string) {
+		t.Helper()
+		hash := commitFile(t, repo, "file.txt", name+"\n", name)
+		commit, err := repo.Store().GetCommit(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return commit.Author, commit.Email
+	}
+
+	if name, email := author("Unconfigured"); name != "login" || email != "unknown@localhost" {
+		t.Errorf("without config the author is %q <%s>, want $USER and the default email", name, email)
+	}
+
+	setConfig(t, system, "user.email", "everyone@example.com")
+	setConfig(t, global, "user.name", "Global Name")
+	if name, email := author("Global"); name != "Global Name" || email != "everyone@example.com" {
+		t.Errorf("author = %q <%s>, want the global name and system email", name, email)
+	}
+
+	setConfig(t, config.RepoPath(repo.Root), "user.name", "Repo Name")
+	setConfig(t, config.RepoPath(repo.Root), "user.email", "repo@example.com")
+	if name, email := author("Repo"); name != "Repo Name" || email != "repo@example.com" {
+		t.Errorf("author = %q <%s>, want the repository's identity", name, email)
+	}
+
+	t.Setenv("ASL_AUTHOR_NAME", "Env Name")
+	if name, email := author("Env"); name != "Env Name" || email != "repo@example.com" {
+		t.Errorf("author = %q <%s>, want the environment's name", name, email)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.GetAll("user.name"); len(got) != 3 {
+		t.Errorf("user.name values = %q, want the global, repository and environment ones", got)
+	}
+}
+
+func TestConfig_RemotesKeepOtherSettings(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	_, global := isolateConfig(t)
+	repoPath := createTestRepoDir(t)
+	defer os.RemoveAll(repoPath)
+
+	path := config.RepoPath(repoPath)
+	if err := os.WriteFile(path, []byte("# keep this comment\n[core]\n\trepositoryformatversion = 1\n[user]\n\tname = Me\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := remote.AddRemote(repoPath, "origin", "https://example.com/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.AddRemote(repoPath, "backup", "https://example.com/backup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.SetUpstream(repoPath, "release/1.0", "origin", "refs/heads/release/1.0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.RemoveRemote(repoPath, "origin"); err != nil {
+		t.Fatal(err)
+	}
+	setConfig(t, path, "remote.backup.pushurl", "https://example.com/push")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# keep this comment\n", "\tname = Me\n", "[branch \"release/1.0\"]\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("config lost %q:\n%s", want, data)
+		}
+	}
+
+	// Remotes may also come from the global file
+	setConfig(t, global, "remote.shared.url", "https://example.com/shared")
+	remotes, err := remote.ListRemotes(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remotes) != 2 || remotes[0].Name != "shared" || remotes[1].Name != "backup" {
+		t.Fatalf("remotes = %+v, want shared and backup", remotes)
+	}
+	if remotes[1].PushURL != "https://example.com/push" || remotes[1].FetchURL != "https://example.com/backup" {
+		t.Errorf("backup = %+v, want separate fetch and push URLs", remotes[1])
+	}
+
+	upstream, err := remote.GetUpstream(repoPath, "release/1.0")
+	if err != nil || upstream.Remote != "origin" || upstream.Merge != "refs/heads/release/1.0" {
+		t.Errorf("upstream = %+v, %v", upstream, err)
+	}
+}
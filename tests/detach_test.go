@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestDetach_CheckoutAndCommit(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "one\n", "First")
+	second := commitFile(t, repo, "file.txt", "two\n", "Second")
+
+	if err := repo.Detach(first, repository.SwitchOptions{}); err != nil {
+		t.Fatalf("Detach failed: %v", err)
+	}
+	if got := readFile(t, repo, "file.txt"); got != "one\n" {
+		t.Errorf("file.txt = %q, want the first version", got)
+	}
+	if _, err := repo.GetCurrentBranch(); !errors.Is(err, core.ErrDetachedHead) {
+		t.Errorf("expected ErrDetachedHead, got %v", err)
+	}
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Branch != "" || status.Head != first {
+		t.Errorf("status branch %q head %s, want detached at %s", status.Branch, status.Head.Short(), first.Short())
+	}
+
+	// Saving, amending and undoing move HEAD, not the branch
+	detached := commitFile(t, repo, "file.txt", "detached\n", "Detached work")
+	if mustCommit(t, repo) != detached {
+		t.Fatal("Save did not move the detached HEAD")
+	}
+	amended, err := repo.Amend(nil, "Detached work, amended")
+	if err != nil {
+		t.Fatalf("Amend failed: %v", err)
+	}
+	if mustCommit(t, repo) != amended {
+		t.Error("Amend did not move the detached HEAD")
+	}
+	if main, _ := repo.GetRef("refs/heads/main"); main != second {
+		t.Errorf("main moved to %s while detached", main.Short())
+	}
+
+	lost, err := repo.Unreachable(amended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 1 || lost[0] != amended {
+		t.Errorf("Unreachable = %v, want only the amended commit", lost)
+	}
+
+	// A branch created from the detached HEAD keeps the work
+	if err := repo.CreateBranch("rescue"); err != nil {
+		t.Fatal(err)
+	}
+	if rescue, _ := repo.GetRef("refs/heads/rescue"); rescue != amended {
+		t.Errorf("rescue = %s, want %s", rescue.Short(), amended.Short())
+	}
+	if lost, err := repo.Unreachable(amended); err != nil || len(lost) != 0 {
+		t.Errorf("Unreachable after branching = %v (%v), want none", lost, err)
+	}
+
+	if err := repo.Undo(); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if mustCommit(t, repo) != first {
+		t.Error("Undo did not move the detached HEAD to its parent")
+	}
+
+	// Undo kept the detached edit in the working directory
+	if err := repo.Switch("main", repository.SwitchOptions{Force: true}); err != nil {
+		t.Fatalf("switching back to main: %v", err)
+	}
+	if got := readFile(t, repo, "file.txt"); got != "two\n" {
+		t.Errorf("file.txt = %q after switching back to main", got)
+	}
+}
+
+func TestDetach_MergeFastForwards(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "one\n", "First")
+	second := commitFile(t, repo, "file.txt", "two\n", "Second")
+
+	if err := repo.Detach(first, repository.SwitchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := repo.Merge("main", repository.MergeOptions{})
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if !result.FastForward {
+		t.Fatal("expected a fast-forward merge")
+	}
+	if mustCommit(t, repo) != second {
+		t.Error("fast-forward did not move the detached HEAD")
+	}
+	if _, err := repo.GetCurrentBranch(); !errors.Is(err, core.ErrDetachedHead) {
+		t.Errorf("HEAD should stay detached, got %v", err)
+	}
+}
+
+func TestDetach_UnknownCommit(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "file.txt", "one\n", "First")
+
+	var missing core.Hash
+	missing[0] = 1
+	if err := repo.Detach(missing, repository.SwitchOptions{}); err == nil {
+		t.Error("expected an error detaching at a missing commit")
+	}
+	if _, err := repo.GetCurrentBranch(); err != nil {
+		t.Errorf("HEAD should still be on main, got %v", err)
+	}
+}
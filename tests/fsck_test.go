@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// objectFile returns the path of a loose object
+func objectFile(repo *repository.Repository, hash core.Hash) string {
+	s := hash.String()
+	return filepath.Join(repo.Root, ".asl", "objects", s[:2], s[2:])
+}
+
+// overwriteObject replaces a loose object's content without changing its name
+func overwriteObject(t *testing.T, repo *repository.Repository, hash core.Hash, content string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	w.Write([]byte(content))
+	w.Close()
+	if err := os.WriteFile(objectFile(repo, hash), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFsck_CleanRepository(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "a.txt", "a\n", "First")
+	commitFile(t, repo, "b.txt", "b\n", "Second")
+
+	report, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	if !report.OK() || len(report.Dangling) != 0 {
+		t.Errorf("expected a clean report, got %+v", report)
+	}
+	if report.Objects != 6 { // 2 commits, 2 trees, 2 blobs
+		t.Errorf("expected 6 objects checked, got %d", report.Objects)
+	}
+}
+
+func TestFsck_DetectsCorruptionAndMissingObjects(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "a.txt", "a\n", "First")
+	commitFile(t, repo, "b.txt", "b\n", "Second")
+
+	// Change a.txt's blob so its content no longer matches its hash
+	blobA := core.HashBytes([]byte("blob a\n"))
+	overwriteObject(t, repo, blobA, "blob tampered\n")
+
+	// Remove b.txt's blob
+	blobB := core.HashBytes([]byte("blob b\n"))
+	if err := os.Remove(objectFile(repo, blobB)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Open afresh so nothing is served from the object cache
+	reopened, err := repository.Open(repo.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := reopened.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+
+	if report.OK() {
+		t.Fatal("expected problems to be reported")
+	}
+	if len(report.Corrupt) != 1 || *report.Corrupt[0].Hash != blobA {
+		t.Errorf("expected a.txt's blob to be corrupt, got %+v", report.Corrupt)
+	}
+	if len(report.Missing) != 1 || *report.Missing[0].Hash != blobB || report.Missing[0].Type != "blob" {
+		t.Errorf("expected b.txt's blob to be missing, got %+v", report.Missing)
+	}
+	if len(report.BadRefs) != 0 {
+		t.Errorf("refs are intact, got %+v", report.BadRefs)
+	}
+}
+
+func TestFsck_ReportsBadRefsAndDanglingCommits(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "a.txt", "a\n", "First")
+	undone := commitFile(t, repo, "a.txt", "b\n", "Undone")
+	if err := repo.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	bogus := core.HashBytes([]byte("nothing"))
+	if err := repo.SetRef("refs/heads/broken", bogus); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+
+	if len(report.BadRefs) != 1 || report.BadRefs[0].Ref != "refs/heads/broken" {
+		t.Errorf("expected refs/heads/broken to be reported, got %+v", report.BadRefs)
+	}
+
+	found := false
+	for _, p := range report.Dangling {
+		if *p.Hash == undone && p.Type == string(core.ObjectTypeCommit) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the undone commit to be dangling, got %+v", report.Dangling)
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"objects", "corrupt", "missing", "dangling", "bad_refs"} {
+		if _, ok := decoded[key]; !ok {
+			t.Errorf("JSON report is missing %q", key)
+		}
+	}
+}
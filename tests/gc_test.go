@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/storage"
+)
+
+func TestGC_PrunesUndoneCommits(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "base\n", "Base")
+	undone := commitFile(t, repo, "file.txt", "undone\n", "To be undone")
+	if err := repo.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the working tree's content reachable from another branch
+	commitFile(t, repo, "other.txt", "other\n", "Other")
+
+	// The reflog and operation log keep the undone commit alive until
+	// their entries expire
+	if _, err := repo.GC(storage.PruneOptions{}); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if !repo.Store().Exists(undone) {
+		t.Fatal("commit recorded in the reflog should be kept")
+	}
+	if _, err := repo.ExpireReflogs(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GC(storage.PruneOptions{}); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if !repo.Store().Exists(undone) {
+		t.Fatal("commit recorded in the operation log should be kept")
+	}
+	if _, err := repo.ExpireOperations(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := repo.GC(storage.PruneOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if stats.Pruned == 0 || stats.Bytes == 0 {
+		t.Fatalf("expected the undone commit to be prunable, got %+v", stats)
+	}
+	if !repo.Store().Exists(undone) {
+		t.Fatal("dry run must not delete objects")
+	}
+
+	if _, err := repo.GC(storage.PruneOptions{}); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if repo.Store().Exists(undone) {
+		t.Error("undone commit should be pruned")
+	}
+	if !repo.Store().Exists(base) {
+		t.Error("reachable commit should be kept")
+	}
+}
+
+func TestGC_KeepsRemoteTrackingAndMergeCommits(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "file.txt", "base\n", "Base")
+	tracked := commitFile(t, repo, "file.txt", "tracked\n", "Tracked")
+	if err := repo.SetRef("refs/remotes/origin/main", tracked); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GC(storage.PruneOptions{}); err != nil {
+		t.Fatalf("gc failed: %v", err)
+	}
+	if !repo.Store().Exists(tracked) {
+		t.Error("commit referenced by a remote-tracking branch should be kept")
+	}
+	if _, err := os.Stat(filepath.Join(repo.Root, ".asl", "refs", "remotes", "origin", "main")); err != nil {
+		t.Error("gc must not touch refs")
+	}
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// assertCommitted checks which paths are in a commit
+func assertCommitted(t *testing.T, repo *repository.Repository, commit core.Hash, want map[string]bool) {
+	t.Helper()
+	for path, committed := range want {
+		_, err := repo.GetFileContent(commit, path)
+		switch {
+		case committed && err != nil:
+			t.Errorf("%s should be committed: %v", path, err)
+		case !committed && !errors.Is(err, core.ErrFileNotFound):
+			t.Errorf("%s should not be committed (err: %v)", path, err)
+		}
+	}
+}
+
+func TestSave_RespectsIgnoreFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		".aslignore":            "*.log\n!keep.log\nbuild/\n/secret.env\n",
+		"main.go":               "package main\n",
+		"debug.log":             "noise\n",
+		"keep.log":              "keep\n",
+		"build/out.bin":         "binary\n",
+		"secret.env":            "token\n",
+		"web/secret.env":        "public\n",
+		"web/.aslignore":        "node_modules/\n!debug.log\n",
+		"web/debug.log":         "kept by web/.aslignore\n",
+		"web/node_modules/x.js": "dep\n",
+		"web/app.js":            "app\n",
+		"docs/**/draft.md":      "odd name\n",
+	})
+
+	head, err := repo.Save(nil, "Initial")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	assertCommitted(t, repo, head, map[string]bool{
+		".aslignore":            true,
+		"main.go":               true,
+		"keep.log":              true,
+		"web/.aslignore":        true,
+		"web/secret.env":        true,
+		"web/debug.log":         true,
+		"web/app.js":            true,
+		"debug.log":             false,
+		"build/out.bin":         false,
+		"secret.env":            false,
+		"web/node_modules/x.js": false,
+	})
+}
+
+func TestSave_ExcludeAndGlobalIgnore(t *testing.T) {
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	global := filepath.Join(config, "astral", "ignore")
+	if err := os.MkdirAll(filepath.Dir(global), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(global, []byte("*.swp\n*.bak\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{
+		".asl/info/exclude": "local/\n",
+		".aslignore":        "!wanted.bak\n",
+		"main.go":           "package main\n",
+		"main.go.swp":       "swap\n",
+		"old.bak":           "backup\n",
+		"wanted.bak":        "wanted\n",
+		"local/scratch.txt": "scratch\n",
+	})
+
+	head, err := repo.Save(nil, "Initial")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	assertCommitted(t, repo, head, map[string]bool{
+		"main.go":           true,
+		"wanted.bak":        true,
+		"main.go.swp":       false,
+		"old.bak":           false,
+		"local/scratch.txt": false,
+	})
+}
+
+func TestSave_TrackedFilesStayTracked(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"app.log":        "v1\n",
+		"vendor/lib.go":  "package lib\n",
+		"other.log":      "untracked\n",
+		"vendor/new.txt": "untracked\n",
+	})
+	if _, err := repo.Save([]string{"app.log", "vendor/lib.go"}, "Track logs"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Ignore rules added later do not drop files already committed
+	writeFiles(t, repo, map[string]string{
+		".aslignore":    "*.log\nvendor/\n",
+		"app.log":       "v2\n",
+		"vendor/lib.go": "package lib // v2\n",
+	})
+	head, err := repo.Save(nil, "Ignore logs")
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	assertCommitted(t, repo, head, map[string]bool{
+		"app.log":        true,
+		"vendor/lib.go":  true,
+		"other.log":      false,
+		"vendor/new.txt": false,
+	})
+	content, err := repo.GetFileContent(head, "app.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2\n" {
+		t.Errorf("app.log = %q, want the updated content", content)
+	}
+
+	// Nothing ignored shows up as a working directory change
+	changes, err := repo.DiffWorkingDir(head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+}
@@ -0,0 +1,105 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// indexPaths returns the paths recorded in the stat cache
+func indexPaths(t *testing.T, root string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, ".asl", "index"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index struct {
+		Entries []struct {
+			Path string `json:"path"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range index.Entries {
+		paths = append(paths, e.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestIndex_TracksSavedFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"a.txt":     "a\n",
+		"dir/b.txt": "b\n",
+		"junk.txt":  "junk\n",
+	})
+	if _, err := repo.Save(nil, "Initial"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexPaths(t, repo.Root), []string{"a.txt", "dir/b.txt", "junk.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index paths = %v, want %v", got, want)
+	}
+
+	// Deleted files drop out of the index on the next save
+	if err := os.Remove(filepath.Join(repo.Root, "junk.txt")); err != nil {
+		t.Fatal(err)
+	}
+	head, err := repo.Save(nil, "Remove junk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexPaths(t, repo.Root), []string{"a.txt", "dir/b.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("index paths = %v, want %v", got, want)
+	}
+
+	// Saving again from the cache produces the same tree
+	again, err := repo.Amend(nil, "Remove junk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootEntries(t, repo, again)["dir"].Hash != rootEntries(t, repo, head)["dir"].Hash {
+		t.Error("amending from cached hashes changed the tree")
+	}
+}
+
+func TestIndex_DetectsChangesRightAfterSave(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "file.txt", "aaaa\n", "Initial")
+
+	// Same size, written within the same timestamp tick as the index on
+	// coarse filesystems: the racy check must force a rehash
+	for i, content := range []string{"bbbb\n", "cccc\n"} {
+		writeFiles(t, repo, map[string]string{"file.txt": content})
+		status, err := repo.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(status.Modified, []string{"file.txt"}) {
+			t.Fatalf("round %d: Modified = %v, want [file.txt]", i, status.Modified)
+		}
+	}
+
+	head, err := repo.Save(nil, "Update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := repo.GetFileContent(head, "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "cccc\n" {
+		t.Errorf("saved content = %q, want the latest write", content)
+	}
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestFindMergeBases(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	root := commitFile(t, repo, "file.txt", "root\n", "Root")
+	store := repo.Store()
+
+	// A common ancestor reached first from one side is not necessarily the
+	// best: root is closer to d than b is, but b descends from root
+	a := putCommit(t, repo, "a", root)
+	b := putCommit(t, repo, "b", a)
+	c := putCommit(t, repo, "c", b)
+	e := putCommit(t, repo, "e", b)
+	d := putCommit(t, repo, "d", root, e)
+
+	// Criss-cross: each of x2 and y2 merges both x1 and y1
+	x1 := putCommit(t, repo, "x1", root)
+	y1 := putCommit(t, repo, "y1", root)
+	x2 := putCommit(t, repo, "x2", x1, y1)
+	y2 := putCommit(t, repo, "y2", y1, x1)
+
+	tests := []struct {
+		name   string
+		c1, c2 core.Hash
+		want   []core.Hash
+	}{
+		{"linear", c, a, []core.Hash{a}},
+		{"shortcut to an older ancestor", c, d, []core.Hash{b}},
+		{"shortcut from the other side", d, c, []core.Hash{b}},
+		{"criss-cross", x2, y2, []core.Hash{x1, y1}},
+		{"same commit", c, c, []core.Hash{c}},
+	}
+	for _, tt := range tests {
+		got, err := merge.FindMergeBases(store, tt.c1, tt.c2)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !sameHashSet(got, tt.want) {
+			t.Errorf("%s: bases = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if base, err := merge.FindLCA(store, c, d); err != nil || base != b {
+		t.Errorf("FindLCA = %s, %v; want %s", base.Short(), err, b.Short())
+	}
+
+	// Against several commits at once, as though they were merged
+	if got, err := merge.FindMergeBasesMany(store, []core.Hash{c, x1}, y2); err != nil || !sameHashSet(got, []core.Hash{x1}) {
+		t.Errorf("FindMergeBasesMany = %v, %v; want %s", got, err, x1.Short())
+	}
+
+	orphan := putCommit(t, repo, "orphan")
+	if _, err := merge.FindMergeBases(store, c, orphan); err != core.ErrNoCommonAncestor {
+		t.Errorf("unrelated commits: err = %v, want ErrNoCommonAncestor", err)
+	}
+}
+
+func sameHashSet(a, b []core.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[core.Hash]bool)
+	for _, h := range a {
+		seen[h] = true
+	}
+	for _, h := range b {
+		if !seen[h] {
+			return false
+		}
+	}
+	return true
+}
+
+// crissCross builds a criss-cross history: from a base commit with the
+// given files, branch a changes them to a1 and branch b to b1. Each
+// branch then merges the other's first commit, keeping its own version of
+// any conflict, which leaves both first commits as merge bases of a and
+// b. Branch a is left checked out.
+func crissCross(t *testing.T, repo *repository.Repository, base, a1, b1 map[string]string) {
+	t.Helper()
+	save := func(files map[string]string, message string) {
+		t.Helper()
+		writeFiles(t, repo, files)
+		if _, err := repo.Save(nil, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	switchTo := func(branch string) {
+		t.Helper()
+		if err := repo.SwitchBranch(branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mergeKeepingOurs := func(rev string) {
+		t.Helper()
+		result, err := repo.Merge(rev, repository.MergeOptions{NoFF: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !result.Conflicts {
+			return
+		}
+		if _, err := repo.ResolveConflicts(nil, repository.KeepOurs); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.ContinueMerge(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	save(base, "Base")
+	for _, branch := range []string{"a", "b"} {
+		if err := repo.CreateBranch(branch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	switchTo("a")
+	save(a1, "A1")
+	switchTo("b")
+	save(b1, "B1")
+	mergeKeepingOurs("a")
+	switchTo("a")
+	mergeKeepingOurs("b~1")
+}
+
+func TestMerge_CrissCross(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	// Each branch changes a different file, then changes it again. Against
+	// either first commit alone, one of the files looks changed on both
+	// sides and conflicts.
+	crissCross(t, repo,
+		map[string]string{"f.txt": "base\n", "g.txt": "base\n"},
+		map[string]string{"f.txt": "a1\n"},
+		map[string]string{"g.txt": "b1\n"})
+	commitFile(t, repo, "f.txt", "a2\n", "A2")
+	if err := repo.SwitchBranch("b"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "g.txt", "b2\n", "B2")
+	if err := repo.SwitchBranch("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	head := mustCommit(t, repo)
+	theirs, err := repo.ResolveRevision("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bases, err := merge.FindMergeBases(repo.Store(), head, theirs); err != nil || len(bases) != 2 {
+		t.Fatalf("bases = %v, %v; want two", bases, err)
+	}
+
+	result, err := repo.Merge("b", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Conflicts {
+		t.Fatalf("conflicted = %q, want a clean merge", result.Conflicted)
+	}
+	for path, want := range map[string]string{"f.txt": "a2\n", "g.txt": "b2\n"} {
+		if got := readFile(t, repo, path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestMerge_CrissCrossConflictingBases(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	// The first commits conflict, so the virtual base holds their conflict
+	// markers. Changes elsewhere in the file still merge cleanly.
+	crissCross(t, repo,
+		map[string]string{"f.txt": "top\nmid\nx\nbottom\n"},
+		map[string]string{"f.txt": "top\nmid\na\nbottom\n"},
+		map[string]string{"f.txt": "top\nmid\nb\nbottom\n"})
+	commitFile(t, repo, "f.txt", "TOP\nmid\na\nbottom\n", "A2")
+
+	result, err := repo.Merge("b", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Conflicts {
+		t.Fatal("expected the branches' differing resolutions to conflict")
+	}
+
+	got := readFile(t, repo, "f.txt")
+	if !strings.HasPrefix(got, "TOP\nmid\n<<<<<<< HEAD (a @ ") ||
+		!strings.Contains(got, "=======\nb\n>>>>>>> b (") ||
+		!strings.HasSuffix(got, ")\nbottom\n") {
+		t.Errorf("f.txt =\n%s\nwant one conflict between a and b, with TOP merged", got)
+	}
+
+	// The base is a virtual merge of the two first commits
+	state, err := merge.LoadMergeState(repo.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseHash, err := core.ParseHash(state.BaseCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, err := repo.Store().GetCommit(baseHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(base.Parents) != 2 {
+		t.Errorf("base has %d parents, want the two merge bases", len(base.Parents))
+	}
+}
@@ -1,10 +1,12 @@
 package tests
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/codimo/astral/internal/core"
 	"github.com/codimo/astral/internal/merge"
 	"github.com/codimo/astral/internal/repository"
 )
@@ -271,3 +273,544 @@ func TestMerge_Abort(t *testing.T) {
 		t.Error("HEAD should be at main commit, not initial")
 	}
 }
+
+// TestMerge_ConflictedWorkingTree tests what a conflicted merge leaves in
+// the working directory
+func TestMerge_ConflictedWorkingTree(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	binary := func(b byte) string { return string([]byte{0, 1, 2, b}) }
+	writeFiles(t, repo, map[string]string{
+		"text.txt":    "base\n",
+		"deleted.txt": "base\n",
+		"kept.txt":    "base\n",
+		"image.bin":   binary(0),
+	})
+	base, err := repo.Save(nil, "Base")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{
+		"text.txt":    "feature\n",
+		"deleted.txt": "feature\n",
+		"image.bin":   binary(2),
+		"new.txt":     "clean\n",
+	})
+	if err := os.Remove(filepath.Join(repo.Root, "kept.txt")); err != nil {
+		t.Fatal(err)
+	}
+	theirs, err := repo.Save(nil, "Feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{
+		"text.txt":  "main\n",
+		"kept.txt":  "main\n",
+		"image.bin": binary(1),
+	})
+	if err := os.Remove(filepath.Join(repo.Root, "deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+	ours, err := repo.Save(nil, "Main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.Merge("feature", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Conflicts || len(result.Conflicted) != 4 {
+		t.Fatalf("conflicted = %q, want four conflicts", result.Conflicted)
+	}
+
+	markers := "<<<<<<< HEAD (main @ " + ours.Short() + ")\nmain\n" +
+		"||||||| BASE (" + base.Short() + ")\nbase\n" +
+		"=======\nfeature\n" +
+		">>>>>>> feature (" + theirs.Short() + ")\n"
+	for path, want := range map[string]string{
+		"text.txt":           markers,
+		"new.txt":            "clean\n",   // Merged cleanly
+		"deleted.txt":        "feature\n", // Deleted by us, modified by them...
+		"deleted.txt.theirs": "feature\n", // ...with a copy of their side
+		"deleted.txt.ours":   "",          // ...but none of ours
+		"kept.txt":           "main\n",    // Modified by us, deleted by them
+		"kept.txt.ours":      "main\n",
+		"kept.txt.theirs":    "",
+		"image.bin":          binary(1), // Binary: ours stays in place...
+		"image.bin.ours":     binary(1), // ...and both sides go next to it
+		"image.bin.theirs":   binary(2),
+		"text.txt.ours":      "", // Content conflicts have only markers
+	} {
+		if got := readFile(t, repo, path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	state, err := merge.LoadMergeState(repo.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]merge.ConflictType)
+	for _, c := range state.Conflicts {
+		types[c.Path] = c.Type
+		if err := state.MarkResolved(c.Path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if types["image.bin"] != merge.ConflictBinary || types["text.txt"] != merge.ConflictContent ||
+		types["deleted.txt"] != merge.ConflictDeleteModify || types["kept.txt"] != merge.ConflictModifyDelete {
+		t.Errorf("conflict types = %v", types)
+	}
+	if err := merge.SaveMergeState(repo.Root, state); err != nil {
+		t.Fatal(err)
+	}
+
+	// The copies of each side are not committed
+	writeFiles(t, repo, map[string]string{"text.txt": "resolved\n"})
+	if err := repo.ContinueMerge(); err != nil {
+		t.Fatalf("continue failed: %v", err)
+	}
+	head := mustCommit(t, repo)
+	if got, err := repo.GetFileContent(head, "new.txt"); err != nil || string(got) != "clean\n" {
+		t.Errorf("new.txt in the merge commit = %q, %v", got, err)
+	}
+	for _, side := range []string{"image.bin.ours", "image.bin.theirs", "deleted.txt.theirs", "kept.txt.ours"} {
+		if _, err := repo.GetFileContent(head, side); err == nil {
+			t.Errorf("%s should not be committed", side)
+		}
+		if _, err := os.Stat(filepath.Join(repo.Root, side)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed once the merge is complete", side)
+		}
+	}
+}
+
+func TestMerge_AbortRemovesSideFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{"image.bin": "\x00base", "gone.txt": "base\n"})
+	if _, err := repo.Save(nil, "Base"); err != nil {
+		t.Fatal(err)
+	}
+	topicBranches(t, repo, map[string]map[string]string{"feature": {"image.bin": "\x00feature", "gone.txt": "feature\n"}})
+	writeFiles(t, repo, map[string]string{"image.bin": "\x00main"})
+	if err := os.Remove(filepath.Join(repo.Root, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Save(nil, "Main"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.Merge("feature", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicted) != 2 {
+		t.Fatalf("conflicted = %q, want image.bin and gone.txt", result.Conflicted)
+	}
+	sides := []string{"image.bin.ours", "image.bin.theirs", "gone.txt.theirs"}
+	for _, side := range sides {
+		if _, err := os.Stat(filepath.Join(repo.Root, side)); err != nil {
+			t.Errorf("%s should be written: %v", side, err)
+		}
+	}
+
+	if err := repo.AbortMerge(); err != nil {
+		t.Fatal(err)
+	}
+	for _, side := range sides {
+		if _, err := os.Stat(filepath.Join(repo.Root, side)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed by abort", side)
+		}
+	}
+	if got := readFile(t, repo, "gone.txt"); got != "" {
+		t.Errorf("gone.txt = %q, want it deleted again", got)
+	}
+}
+
+// divergeText commits base to file.txt, then ours on main and theirs on a
+// feature branch, leaving main checked out
+func divergeText(t *testing.T, repo *repository.Repository, base, ours, theirs string) (ourCommit, theirCommit core.Hash) {
+	t.Helper()
+	commitFile(t, repo, "file.txt", base, "Base")
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	theirCommit = commitFile(t, repo, "file.txt", theirs, "Feature")
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	ourCommit = commitFile(t, repo, "file.txt", ours, "Main")
+	return ourCommit, theirCommit
+}
+
+func TestMerge_StrategyOurs(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	ours, theirs := divergeText(t, repo, "base\n", "main\n", "feature\n")
+	commitFile(t, repo, "main-only.txt", "main\n", "Main only")
+	ours = mustCommit(t, repo)
+
+	result, err := repo.Merge("feature", repository.MergeOptions{Strategy: repository.StrategyOurs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Conflicts || result.FastForward || result.MergeCommit == nil {
+		t.Fatalf("result = %+v, want a merge commit", result)
+	}
+
+	commit, err := repo.Store().GetCommit(*result.MergeCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ourCommit, err := repo.Store().GetCommit(ours)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.Tree != ourCommit.Tree {
+		t.Error("the ours strategy should keep our tree")
+	}
+	if len(commit.Parents) != 2 || commit.Parents[0] != ours || commit.Parents[1] != theirs {
+		t.Errorf("parents = %v, want ours then theirs", commit.Parents)
+	}
+	if got := readFile(t, repo, "file.txt"); got != "main\n" {
+		t.Errorf("file.txt = %q, want our version", got)
+	}
+
+	// Even a fast-forward records a merge
+	if err := repo.CreateBranch("ahead"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("ahead"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "file.txt", "ahead\n", "Ahead")
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = repo.Merge("ahead", repository.MergeOptions{Strategy: repository.StrategyOurs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.FastForward || readFile(t, repo, "file.txt") != "main\n" {
+		t.Errorf("result = %+v, want a merge keeping our file", result)
+	}
+
+	if _, err := repo.Merge("feature", repository.MergeOptions{Strategy: "octopus"}); !errors.Is(err, core.ErrInvalidStrategy) {
+		t.Errorf("unknown strategy: err = %v, want ErrInvalidStrategy", err)
+	}
+}
+
+func TestMerge_FavorOptions(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	ours := "A\nb\nours\nd\ne\n"
+	theirs := "a\nb\ntheirs\nd\nE\n"
+
+	tests := []struct {
+		favor merge.Favor
+		want  string
+	}{
+		{merge.FavorOurs, "A\nb\nours\nd\nE\n"},
+		{merge.FavorTheirs, "A\nb\ntheirs\nd\nE\n"},
+		{merge.FavorUnion, "A\nb\nours\ntheirs\nd\nE\n"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.favor), func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			repo := createTestRepo(t)
+			defer os.RemoveAll(repo.Root)
+			divergeText(t, repo, base, ours, theirs)
+
+			result, err := repo.Merge("feature", repository.MergeOptions{Favor: tt.favor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Conflicts || result.MergeCommit == nil {
+				t.Fatalf("result = %+v, want a clean merge", result)
+			}
+			if got := readFile(t, repo, "file.txt"); got != tt.want {
+				t.Errorf("file.txt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMerge_ResolveConflicts(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "gone.txt", "base\n", "Add gone.txt")
+	divergeText(t, repo, "base\n", "main\n", "feature\n")
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(repo.Root, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Save(nil, "Remove gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "gone.txt", "main\n", "Change gone.txt")
+
+	result, err := repo.Merge("feature", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicted) != 2 {
+		t.Fatalf("conflicted = %q, want file.txt and gone.txt", result.Conflicted)
+	}
+
+	if _, err := repo.ResolveConflicts([]string{"other.txt"}, repository.KeepOurs); err == nil {
+		t.Error("resolving a file without a conflict should fail")
+	}
+
+	// Their side deleted gone.txt, and has its own file.txt
+	paths, err := repo.ResolveConflicts(nil, repository.KeepTheirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 {
+		t.Errorf("resolved %q, want every conflict", paths)
+	}
+	if got := readFile(t, repo, "file.txt"); got != "feature\n" {
+		t.Errorf("file.txt = %q, want their version", got)
+	}
+	if _, err := os.Stat(filepath.Join(repo.Root, "gone.txt")); !os.IsNotExist(err) {
+		t.Error("gone.txt should be deleted, as on their side")
+	}
+
+	state, err := merge.LoadMergeState(repo.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.HasUnresolvedConflicts() {
+		t.Error("every conflict should be marked resolved")
+	}
+
+	if err := repo.ContinueMerge(); err != nil {
+		t.Fatal(err)
+	}
+	head := mustCommit(t, repo)
+	if got, err := repo.GetFileContent(head, "file.txt"); err != nil || string(got) != "feature\n" {
+		t.Errorf("file.txt in the merge commit = %q, %v", got, err)
+	}
+}
+
+// topicBranches creates a branch from main for each entry in topics,
+// committing the entry's files to it, and switches back to main
+func topicBranches(t *testing.T, repo *repository.Repository, topics map[string]map[string]string) map[string]core.Hash {
+	t.Helper()
+	tips := make(map[string]core.Hash)
+	for name, files := range topics {
+		if err := repo.CreateBranch(name); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.SwitchBranch(name); err != nil {
+			t.Fatal(err)
+		}
+		writeFiles(t, repo, files)
+		tip, err := repo.Save(nil, "Topic "+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tips[name] = tip
+		if err := repo.SwitchBranch("main"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tips
+}
+
+func TestMerge_Octopus(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := "1\n2\n3\n4\n5\n6\n7\n"
+	commitFile(t, repo, "shared.txt", base, "Base")
+	tips := topicBranches(t, repo, map[string]map[string]string{
+		"a": {"a.txt": "a\n", "shared.txt": "A\n2\n3\n4\n5\n6\n7\n"},
+		"b": {"b.txt": "b\n", "shared.txt": "1\n2\n3\nB\n5\n6\n7\n"},
+		"c": {"c.txt": "c\n", "shared.txt": "1\n2\n3\n4\n5\n6\nC\n"},
+	})
+	ours := commitFile(t, repo, "main.txt", "main\n", "Main")
+
+	// A branch that is already merged is left out
+	result, err := repo.MergeOctopus([]string{"a", "b", "main~1", "c"}, repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Conflicts || result.MergeCommit == nil {
+		t.Fatalf("result = %+v, want a merge commit", result)
+	}
+
+	commit, err := repo.Store().GetCommit(*result.MergeCommit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []core.Hash{ours, tips["a"], tips["b"], tips["c"]}
+	if len(commit.Parents) != len(want) {
+		t.Fatalf("parents = %v, want main, a, b and c", commit.Parents)
+	}
+	for i := range want {
+		if commit.Parents[i] != want[i] {
+			t.Errorf("parent %d = %s, want %s", i, commit.Parents[i].Short(), want[i].Short())
+		}
+	}
+	if commit.Message != "Merge branches 'a', 'b' and 'c'" {
+		t.Errorf("message = %q", commit.Message)
+	}
+
+	for path, want := range map[string]string{
+		"a.txt":      "a\n",
+		"b.txt":      "b\n",
+		"c.txt":      "c\n",
+		"main.txt":   "main\n",
+		"shared.txt": "A\n2\n3\nB\n5\n6\nC\n",
+	} {
+		if got := readFile(t, repo, path); got != want {
+			t.Errorf("%s = %q, want %q", path, got, want)
+		}
+	}
+
+	// Merging one branch after another leaves no commits behind
+	hashes, err := repo.Store().ListObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range hashes {
+		obj, err := repo.Store().Get(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if obj.Type != core.ObjectTypeCommit {
+			continue
+		}
+		if reachable, err := merge.IsAncestor(repo.Store(), hash, *result.MergeCommit); err != nil || !reachable {
+			t.Errorf("commit %s is not part of the merged history", hash.Short())
+		}
+	}
+}
+
+func TestMerge_OctopusConflictAborts(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "shared.txt", "base\n", "Base")
+	topicBranches(t, repo, map[string]map[string]string{
+		"a": {"a.txt": "a\n"},
+		"b": {"shared.txt": "b\n"},
+		"c": {"shared.txt": "c\n"},
+	})
+	head := mustCommit(t, repo)
+
+	_, err := repo.MergeOctopus([]string{"a", "b", "c"}, repository.MergeOptions{})
+	if !errors.Is(err, core.ErrOctopusConflict) {
+		t.Fatalf("err = %v, want ErrOctopusConflict", err)
+	}
+	if mustCommit(t, repo) != head {
+		t.Error("HEAD should not move when the merge is abandoned")
+	}
+	if merge.IsMergeInProgress(repo.Root) {
+		t.Error("no merge should be left in progress")
+	}
+	if got := readFile(t, repo, "a.txt"); got != "" {
+		t.Errorf("a.txt = %q, want the working directory untouched", got)
+	}
+	if got := readFile(t, repo, "shared.txt"); got != "base\n" {
+		t.Errorf("shared.txt = %q, want the working directory untouched", got)
+	}
+
+	// Settling conflicting hunks lets it through
+	result, err := repo.MergeOctopus([]string{"a", "b", "c"}, repository.MergeOptions{Favor: merge.FavorTheirs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, repo, "shared.txt"); got != "c\n" || result.MergeCommit == nil {
+		t.Errorf("shared.txt = %q, want the last branch's version", got)
+	}
+}
+
+func TestMerge_RefusesToOverwriteLocalChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		ours   string // Committed on main after branching; empty to fast-forward
+		edited string // Tracked file changed in the working directory
+	}{
+		{"fast-forward", "", "file.txt"},
+		{"clean merge", "other.txt", "file.txt"},
+		{"conflicted merge", "file.txt", "file.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+			repo := createTestRepo(t)
+			defer os.RemoveAll(repo.Root)
+
+			writeFiles(t, repo, map[string]string{"file.txt": "base\n", "other.txt": "base\n", "kept.txt": "base\n"})
+			if _, err := repo.Save(nil, "Base"); err != nil {
+				t.Fatal(err)
+			}
+			topicBranches(t, repo, map[string]map[string]string{"feature": {"file.txt": "feature\n"}})
+			if tt.ours != "" {
+				commitFile(t, repo, tt.ours, "main\n", "Main")
+			}
+			head := mustCommit(t, repo)
+
+			writeFiles(t, repo, map[string]string{tt.edited: "local\n"})
+			_, err := repo.Merge("feature", repository.MergeOptions{})
+			if !errors.Is(err, core.ErrDirtyWorkingDir) {
+				t.Fatalf("err = %v, want ErrDirtyWorkingDir", err)
+			}
+			if got := readFile(t, repo, tt.edited); got != "local\n" {
+				t.Errorf("%s = %q, want the local change kept", tt.edited, got)
+			}
+			if mustCommit(t, repo) != head || merge.IsMergeInProgress(repo.Root) {
+				t.Error("the merge should not have started")
+			}
+
+			// Local changes to files the merge does not touch are kept
+			writeFiles(t, repo, map[string]string{tt.edited: readFileAt(t, repo, head, tt.edited), "kept.txt": "local\n"})
+			if _, err := repo.Merge("feature", repository.MergeOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, repo, "kept.txt"); got != "local\n" {
+				t.Errorf("kept.txt = %q, want the local change kept", got)
+			}
+		})
+	}
+}
+
+func readFileAt(t *testing.T, repo *repository.Repository, commit core.Hash, path string) string {
+	t.Helper()
+	data, err := repo.GetFileContent(commit, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,280 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestOpLog_UndoRedoMergeAndSwitch(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "base\n", "Base")
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	feature := commitFile(t, repo, "new.txt", "feature\n", "Feature")
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Merge("feature", repository.MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := repo.Operations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var descriptions []string
+	for _, op := range ops {
+		descriptions = append(descriptions, op.Description)
+	}
+	want := []string{"save: Base", "branch feature", "switch feature", "save: Feature", "switch main", "merge feature"}
+	if len(descriptions) != len(want) {
+		t.Fatalf("operations = %q, want %q", descriptions, want)
+	}
+	for i := range want {
+		if descriptions[i] != want[i] || ops[i].ID != i+1 {
+			t.Errorf("operation %d = %d %q, want %d %q", i, ops[i].ID, descriptions[i], i+1, want[i])
+		}
+	}
+
+	// Undoing the merge moves main back and removes the merged file
+	undone, err := repo.UndoOperation(repository.SwitchOptions{})
+	if err != nil {
+		t.Fatalf("UndoOperation failed: %v", err)
+	}
+	if undone.Description != "merge feature" {
+		t.Errorf("undid %q, want the merge", undone.Description)
+	}
+	if mustCommit(t, repo) != base {
+		t.Error("main should be back at the base commit")
+	}
+	if _, err := os.Stat(filepath.Join(repo.Root, "new.txt")); !os.IsNotExist(err) {
+		t.Error("new.txt should be removed with the merge")
+	}
+
+	// Undoing again steps back over the switch
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatalf("second UndoOperation failed: %v", err)
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "feature" {
+		t.Errorf("current branch = %q, want feature", branch)
+	}
+	if got := readFile(t, repo, "new.txt"); got != "feature\n" {
+		t.Errorf("new.txt = %q, want the feature version", got)
+	}
+
+	// Redo reapplies the switch, then the merge
+	for _, wantDesc := range []string{"switch main", "merge feature"} {
+		redone, err := repo.RedoOperation(repository.SwitchOptions{})
+		if err != nil {
+			t.Fatalf("RedoOperation failed: %v", err)
+		}
+		if redone.Description != wantDesc {
+			t.Errorf("redid %q, want %q", redone.Description, wantDesc)
+		}
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "main" || mustCommit(t, repo) != feature {
+		t.Errorf("after redo: on %q at %s, want main at %s", branch, mustCommit(t, repo).Short(), feature.Short())
+	}
+	if _, err := repo.RedoOperation(repository.SwitchOptions{}); !errors.Is(err, core.ErrNothingToRedo) {
+		t.Errorf("expected ErrNothingToRedo, got %v", err)
+	}
+
+	// Restoring the first operation drops the branch created after it
+	if _, err := repo.RestoreOperation(1, repository.SwitchOptions{}); err != nil {
+		t.Fatalf("RestoreOperation failed: %v", err)
+	}
+	if _, err := repo.GetRef("refs/heads/feature"); !errors.Is(err, core.ErrBranchNotFound) {
+		t.Errorf("feature should not exist after restoring operation 1, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo.AslPath(), "logs", "refs", "heads", "feature")); !os.IsNotExist(err) {
+		t.Errorf("feature's reflog should be removed with it, got %v", err)
+	}
+	if mustCommit(t, repo) != base {
+		t.Error("main should be at the base commit")
+	}
+
+	// A restore is itself undoable
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := repo.GetRef("refs/heads/feature"); got != feature {
+		t.Errorf("feature = %s after undoing the restore, want %s", got.Short(), feature.Short())
+	}
+	if entries, err := repo.Reflog("feature"); err != nil || len(entries) != 1 {
+		t.Errorf("feature's reflog = %d entries, %v; want only its recreation", len(entries), err)
+	}
+
+	if _, err := repo.RestoreOperation(99, repository.SwitchOptions{}); !errors.Is(err, core.ErrOperationNotFound) {
+		t.Errorf("expected ErrOperationNotFound, got %v", err)
+	}
+}
+
+func TestOpLog_UndoAmendAndConflictedMerge(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); !errors.Is(err, core.ErrNothingToUndo) {
+		t.Errorf("expected ErrNothingToUndo in a new repository, got %v", err)
+	}
+
+	commitFile(t, repo, "file.txt", "base\n", "Base")
+	original := commitFile(t, repo, "file.txt", "main\n", "Main")
+
+	writeFiles(t, repo, map[string]string{"file.txt": "amended\n"})
+	if _, err := repo.Amend(nil, "Main, amended"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatalf("UndoOperation failed: %v", err)
+	}
+	if mustCommit(t, repo) != original {
+		t.Error("undoing the amend should restore the original commit")
+	}
+	if got := readFile(t, repo, "file.txt"); got != "main\n" {
+		t.Errorf("file.txt = %q, want the original content", got)
+	}
+
+	// Uncommitted changes are protected as by switch
+	if _, err := repo.RedoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{"file.txt": "dirty\n"})
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); !errors.Is(err, core.ErrDirtyWorkingDir) {
+		t.Fatalf("expected ErrDirtyWorkingDir, got %v", err)
+	}
+	if _, err := repo.UndoOperation(repository.SwitchOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undoing a conflicted merge ends it
+	if err := repo.CreateBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "file.txt", "other\n", "Other")
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, "file.txt", "ours\n", "Ours")
+	result, err := repo.Merge("other", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Conflicts {
+		t.Fatal("expected a conflict")
+	}
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatalf("UndoOperation failed: %v", err)
+	}
+	if merge.IsMergeInProgress(repo.Root) {
+		t.Error("undoing the merge should end it")
+	}
+	if got := readFile(t, repo, "file.txt"); got != "ours\n" {
+		t.Errorf("file.txt = %q, want our version", got)
+	}
+
+	// Redoing it brings the merge state back
+	if _, err := repo.RedoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if !merge.IsMergeInProgress(repo.Root) {
+		t.Error("redoing the merge should restore its state")
+	}
+}
+
+func TestOpLog_UndoConflictedMergeRemovesSideFiles(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{"image.bin": "\x00base", "gone.txt": "base\n"})
+	if _, err := repo.Save(nil, "Base"); err != nil {
+		t.Fatal(err)
+	}
+	topicBranches(t, repo, map[string]map[string]string{"feature": {"image.bin": "\x00feature", "gone.txt": "feature\n"}})
+	writeFiles(t, repo, map[string]string{"image.bin": "\x00main"})
+	if err := os.Remove(filepath.Join(repo.Root, "gone.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Save(nil, "Main"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.Merge("feature", repository.MergeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Conflicts {
+		t.Fatal("expected a conflict")
+	}
+	if _, err := repo.UndoOperation(repository.SwitchOptions{}); err != nil {
+		t.Fatalf("UndoOperation failed: %v", err)
+	}
+
+	if merge.IsMergeInProgress(repo.Root) {
+		t.Error("undoing the merge should end it")
+	}
+	for _, side := range []string{"image.bin.ours", "image.bin.theirs", "gone.txt.theirs"} {
+		if _, err := os.Stat(filepath.Join(repo.Root, side)); !os.IsNotExist(err) {
+			t.Errorf("%s should be removed by undo", side)
+		}
+	}
+	if got := readFile(t, repo, "gone.txt"); got != "" {
+		t.Errorf("gone.txt = %q, want it deleted again", got)
+	}
+	if got := readFile(t, repo, "image.bin"); got != "\x00main" {
+		t.Errorf("image.bin = %q, want our version", got)
+	}
+}
+
+func TestOpLog_LockedLog(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	commitFile(t, repo, "file.txt", "base\n", "Base")
+
+	// Another process is recording an operation
+	lockPath := filepath.Join(repo.AslPath(), "oplog"+core.RefLockSuffix)
+	if err := os.WriteFile(lockPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateBranch("feature"); !errors.Is(err, core.ErrRefLocked) {
+		t.Fatalf("expected ErrRefLocked while the log is locked, got %v", err)
+	}
+	if err := os.Remove(lockPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.CreateBranch("other"); err != nil {
+		t.Fatal(err)
+	}
+	ops, err := repo.Operations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, op := range ops {
+		if op.ID != i+1 {
+			t.Errorf("operation %d has ID %d, want IDs in sequence", i, op.ID)
+		}
+	}
+	if last := ops[len(ops)-1]; last.Description != "branch other" {
+		t.Errorf("last operation = %q, want the second branch", last.Description)
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"errors"
 	"net/http/httptest"
 	"os"
 	"testing"
@@ -53,10 +54,15 @@ func TestClient_Integration(t *testing.T) {
 
 	// 3. Test UpdateRef
 	// Create update
-	if err := client.UpdateRef("refs/heads/main", expectedHash); err != nil {
+	if err := client.UpdateRef("refs/heads/main", core.Hash{}, expectedHash); err != nil {
 		t.Fatalf("UpdateRef failed: %v", err)
 	}
 
+	// An update based on a stale value is refused
+	if err := client.UpdateRef("refs/heads/main", core.Hash{}, core.Hash{1}); !errors.Is(err, core.ErrStaleRef) {
+		t.Fatalf("expected ErrStaleRef, got %v", err)
+	}
+
 	// Verify on server
 	serverRef, err := repo.GetRef("refs/heads/main")
 	if err != nil {
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/codimo/astral/internal/auth"
@@ -116,3 +118,47 @@ func TestServer_Objects(t *testing.T) {
 		t.Errorf("Expected type blob, got %s", fetchedObj.Type)
 	}
 }
+
+func TestServer_RejectsInvalidRefNames(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+	head := commitFile(t, repo, "file.txt", "content\n", "Initial commit")
+
+	ts := httptest.NewServer(protocol.NewServer(repo.Store(), repo, &auth.NoneAuth{}))
+	defer ts.Close()
+
+	update := func(ref string) int {
+		t.Helper()
+		body := strings.NewReader(`{"hash": "` + head.String() + `"}`)
+		resp, err := http.Post(ts.URL+"/"+ref, "application/json", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	for _, ref := range []string{
+		"refs/heads/",
+		"refs/heads/a..b",
+		"refs/heads/topic.lock",
+		"refs/heads/.hidden",
+		"refs/heads/a%20b",
+		"refs/heads/%2E%2E/%2E%2E/config",
+		"refs/tags/v1/",
+	} {
+		if code := update(ref); code == http.StatusOK {
+			t.Errorf("update of %s: status %d, want it rejected", ref, code)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo.AslPath(), "config.lock")); !os.IsNotExist(err) {
+		t.Errorf("a ref update reached outside refs/: %v", err)
+	}
+
+	if code := update("refs/heads/topic/x"); code != http.StatusOK {
+		t.Fatalf("update of a valid branch: status %d", code)
+	}
+	if got, err := repo.GetRef("refs/heads/topic/x"); err != nil || got != head {
+		t.Errorf("refs/heads/topic/x = %s, %v; want %s", got.Short(), err, head.Short())
+	}
+}
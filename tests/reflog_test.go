@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+)
+
+func TestReflog_RecordsRefUpdates(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("ASL_AUTHOR_NAME", "Ada Q Lovelace")
+	t.Setenv("ASL_AUTHOR_EMAIL", "ada@example.com")
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "1\n", "First")
+	second := commitFile(t, repo, "file.txt", "2\n", "Second\n\nDetails")
+	amended, err := repo.Amend(nil, "Second, amended")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Undo(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := repo.Reflog("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		old, new core.Hash
+		message  string
+	}{
+		{amended, first, "undo: Second, amended"},
+		{second, amended, "amend: Second, amended"},
+		{first, second, "save: Second"},
+		{core.Hash{}, first, "save: First"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, w := range want {
+		e := entries[i]
+		if e.Old != w.old || e.New != w.new || e.Message != w.message {
+			t.Errorf("entry %d = %s -> %s %q, want %s -> %s %q", i,
+				e.Old.Short(), e.New.Short(), e.Message, w.old.Short(), w.new.Short(), w.message)
+		}
+		if e.Name != "Ada Q Lovelace" || e.Email != "ada@example.com" {
+			t.Errorf("entry %d identity = %q <%s>", i, e.Name, e.Email)
+		}
+		if time.Since(e.Time) > time.Minute {
+			t.Errorf("entry %d time = %v", i, e.Time)
+		}
+	}
+
+	// The amended-away and undone commits can be found again
+	for spec, hash := range map[string]core.Hash{
+		"main@{0}": first,
+		"main@{1}": amended,
+		"main@{2}": second,
+		"HEAD@{2}": second,
+		"@{3}":     first,
+	} {
+		got, err := repo.ResolveRevision(spec)
+		if err != nil || got != hash {
+			t.Errorf("ResolveRevision(%q) = %s (%v), want %s", spec, got.Short(), err, hash.Short())
+		}
+	}
+	if _, err := repo.ResolveRevision("main@{4}"); err == nil {
+		t.Error("expected an error past the end of the reflog")
+	}
+}
+
+func TestReflog_SwitchAndBranches(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "1\n", "Base")
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	work := commitFile(t, repo, "file.txt", "2\n", "Work")
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := repo.Reflog("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, e := range head {
+		messages = append(messages, e.Message)
+	}
+	want := "switch: moving from feature to main|save: Work|switch: moving from main to feature|save: Base"
+	if got := strings.Join(messages, "|"); got != want {
+		t.Errorf("HEAD reflog = %q, want %q", got, want)
+	}
+	if head[0].Old != work || head[0].New != base {
+		t.Errorf("switch entry = %s -> %s, want %s -> %s", head[0].Old.Short(), head[0].New.Short(), work.Short(), base.Short())
+	}
+
+	feature, err := repo.Reflog("feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feature) != 2 || feature[1].Message != "branch: created from main" {
+		t.Errorf("feature reflog = %+v", feature)
+	}
+
+	main, err := repo.Reflog("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(main) != 1 {
+		t.Errorf("switching must not be recorded in the branch's reflog, got %+v", main)
+	}
+
+	if n, err := repo.ExpireReflogs(time.Now().Add(time.Minute)); err != nil || n != 7 {
+		t.Errorf("ExpireReflogs = %d (%v), want 7", n, err)
+	}
+	if head, _ := repo.Reflog("HEAD"); len(head) != 0 {
+		t.Errorf("expected an empty HEAD reflog after expiry, got %d entries", len(head))
+	}
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+)
+
+func TestUpdateRef_CompareAndSwap(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "1\n", "First")
+	second := putCommit(t, repo, "Second", first)
+
+	if err := repo.UpdateRef("refs/heads/topic", core.Hash{}, first); err != nil {
+		t.Fatalf("creating a ref failed: %v", err)
+	}
+	if err := repo.UpdateRef("refs/heads/topic", core.Hash{}, second); !errors.Is(err, core.ErrStaleRef) {
+		t.Errorf("creating an existing ref: expected ErrStaleRef, got %v", err)
+	}
+	if err := repo.UpdateRef("refs/heads/topic", first, second); err != nil {
+		t.Fatalf("UpdateRef failed: %v", err)
+	}
+	if got, _ := repo.GetRef("refs/heads/topic"); got != second {
+		t.Errorf("topic = %s, want %s", got.Short(), second.Short())
+	}
+
+	// HEAD stands for the current branch, or itself when detached
+	if err := repo.UpdateRef("HEAD", first, second); err != nil {
+		t.Fatalf("UpdateRef(HEAD) failed: %v", err)
+	}
+	if got, _ := repo.GetRef("refs/heads/main"); got != second {
+		t.Errorf("main = %s, want %s", got.Short(), second.Short())
+	}
+	if err := repo.Detach(first, repository.SwitchOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateRef("HEAD", first, second); err != nil {
+		t.Fatalf("UpdateRef(HEAD) while detached failed: %v", err)
+	}
+	if head, _ := repo.GetHEAD(); head != second.String() {
+		t.Errorf("HEAD = %q, want the detached %s", head, second.Short())
+	}
+
+	entries, err := repo.Reflog("topic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Old != first || entries[0].New != second {
+		t.Errorf("topic reflog = %+v", entries)
+	}
+}
+
+func TestRefTransaction_AllOrNothing(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "1\n", "First")
+	second := putCommit(t, repo, "Second", first)
+
+	tx := repo.NewRefTransaction()
+	tx.Update("refs/heads/a", core.Hash{}, second, "create a")
+	tx.Update("refs/heads/main", second, second, "stale")
+	if err := tx.Commit(); !errors.Is(err, core.ErrStaleRef) {
+		t.Fatalf("expected ErrStaleRef, got %v", err)
+	}
+	if _, err := repo.GetRef("refs/heads/a"); !errors.Is(err, core.ErrBranchNotFound) {
+		t.Errorf("a must not be created by a failed transaction, got %v", err)
+	}
+
+	tx = repo.NewRefTransaction()
+	tx.Update("refs/heads/a", core.Hash{}, second, "create a")
+	tx.Update("refs/heads/main", first, second, "move main")
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	for _, ref := range []string{"refs/heads/a", "refs/heads/main"} {
+		if got, _ := repo.GetRef(ref); got != second {
+			t.Errorf("%s = %s, want %s", ref, got.Short(), second.Short())
+		}
+	}
+
+	tx = repo.NewRefTransaction()
+	tx.Delete("refs/heads/a", second)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("deleting failed: %v", err)
+	}
+	if _, err := repo.GetRef("refs/heads/a"); !errors.Is(err, core.ErrBranchNotFound) {
+		t.Errorf("a should be deleted, got %v", err)
+	}
+}
+
+func TestRefLock_BlocksConcurrentWriters(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "1\n", "Base")
+
+	// A lock left by another process stops updates but is not a branch
+	lock := filepath.Join(repo.AslPath(), "refs", "heads", "main.lock")
+	if err := os.WriteFile(lock, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{"file.txt": "2\n"})
+	if _, err := repo.Save(nil, "Blocked"); !errors.Is(err, core.ErrRefLocked) {
+		t.Errorf("expected ErrRefLocked, got %v", err)
+	}
+	if got := mustCommit(t, repo); got != base {
+		t.Errorf("main moved to %s while locked", got.Short())
+	}
+	branches, err := repo.ListBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(branches) != 1 || branches[0] != "main" {
+		t.Errorf("branches = %v, want only main", branches)
+	}
+	if err := os.Remove(lock); err != nil {
+		t.Fatal(err)
+	}
+
+	// Of several writers starting from the same value, exactly one wins
+	var wg sync.WaitGroup
+	results := make([]error, 8)
+	for i := range results {
+		hash := putCommit(t, repo, fmt.Sprintf("Writer %d", i), base)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = repo.UpdateRef("refs/heads/race", core.Hash{}, hash)
+		}(i)
+	}
+	wg.Wait()
+
+	won := 0
+	for _, err := range results {
+		switch {
+		case err == nil:
+			won++
+		case !errors.Is(err, core.ErrStaleRef) && !errors.Is(err, core.ErrRefLocked):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d writers succeeded, want 1", won)
+	}
+	if _, err := os.Stat(filepath.Join(repo.AslPath(), "refs", "heads", "race.lock")); !os.IsNotExist(err) {
+		t.Error("the lock file should be removed")
+	}
+}
@@ -0,0 +1,322 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/auth"
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/protocol"
+	"github.com/codimo/astral/internal/remote"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// serveRepo starts an HTTP server for a repository
+func serveRepo(t *testing.T, repo *repository.Repository) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(protocol.NewServer(repo.Store(), repo, &auth.NoneAuth{}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// commitFile writes a file into a repository and saves it
+func commitFile(t *testing.T, repo *repository.Repository, name, content, message string) core.Hash {
+	t.Helper()
+	path := filepath.Join(repo.Root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.Save(nil, message)
+	if err != nil {
+		t.Fatalf("save %q failed: %v", message, err)
+	}
+	return hash
+}
+
+func TestClone_ChecksOutDefaultBranch(t *testing.T) {
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	head := commitFile(t, upstream, "README.md", "hello\n", "Initial commit")
+	ts := serveRepo(t, upstream)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	clone, err := repository.Clone(ts.URL, dir)
+	if err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+
+	branch, err := clone.GetCurrentBranch()
+	if err != nil || branch != "main" {
+		t.Fatalf("expected to be on main, got %q (%v)", branch, err)
+	}
+
+	current, err := clone.GetCurrentCommit()
+	if err != nil || current != head {
+		t.Errorf("expected HEAD at %s, got %s (%v)", head.Short(), current.Short(), err)
+	}
+
+	tracking, err := clone.GetRef("refs/remotes/origin/main")
+	if err != nil || tracking != head {
+		t.Errorf("expected refs/remotes/origin/main at %s, got %s (%v)", head.Short(), tracking.Short(), err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "README.md"))
+	if err != nil || string(content) != "hello\n" {
+		t.Errorf("expected checked out README.md, got %q (%v)", content, err)
+	}
+
+	up, err := remote.GetUpstream(dir, "main")
+	if err != nil {
+		t.Fatalf("expected upstream for main: %v", err)
+	}
+	if up.Remote != "origin" || up.Merge != "refs/heads/main" {
+		t.Errorf("unexpected upstream %+v", up)
+	}
+}
+
+func TestClone_RefusesNonEmptyDirectory(t *testing.T) {
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	ts := serveRepo(t, upstream)
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("x"), 0644)
+
+	if _, err := repository.Clone(ts.URL, dir); !errors.Is(err, core.ErrDestinationUsed) {
+		t.Fatalf("expected ErrDestinationUsed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".asl")); !os.IsNotExist(err) {
+		t.Error("failed clone should not leave a repository behind")
+	}
+}
+
+func TestFetchAndPull(t *testing.T) {
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	commitFile(t, upstream, "file.txt", "v1\n", "First")
+	ts := serveRepo(t, upstream)
+
+	clone, err := repository.Clone(ts.URL, filepath.Join(t.TempDir(), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := commitFile(t, upstream, "file.txt", "v2\n", "Second")
+
+	updates, err := clone.Fetch("")
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if len(updates) != 1 || updates[0].Name != "refs/remotes/origin/main" || updates[0].New != second {
+		t.Errorf("unexpected fetch updates: %+v", updates)
+	}
+
+	// Fetch must not touch the local branch
+	if current, _ := clone.GetCurrentCommit(); current == second {
+		t.Error("fetch should not move the local branch")
+	}
+
+	result, err := clone.Pull("", "", repository.MergeOptions{})
+	if err != nil {
+		t.Fatalf("pull failed: %v", err)
+	}
+	if !result.FastForward {
+		t.Error("expected pull to fast-forward")
+	}
+
+	content, _ := os.ReadFile(filepath.Join(clone.Root, "file.txt"))
+	if string(content) != "v2\n" {
+		t.Errorf("expected pulled content, got %q", content)
+	}
+}
+
+func TestPush_FastForwardAndRejection(t *testing.T) {
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	commitFile(t, upstream, "file.txt", "v1\n", "First")
+	ts := serveRepo(t, upstream)
+
+	clone, err := repository.Clone(ts.URL, filepath.Join(t.TempDir(), "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := commitFile(t, clone, "local.txt", "local\n", "Local work")
+
+	updates, err := clone.Push("", "", repository.PushOptions{})
+	if err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if len(updates) != 1 || updates[0].New != local {
+		t.Errorf("unexpected push updates: %+v", updates)
+	}
+
+	serverHead, err := upstream.GetRef("refs/heads/main")
+	if err != nil || serverHead != local {
+		t.Fatalf("server main should be at pushed commit")
+	}
+	if _, err := upstream.Store().GetCommit(local); err != nil {
+		t.Errorf("pushed commit missing on server: %v", err)
+	}
+
+	// Diverge: the server moves on while the clone commits something else
+	commitFile(t, upstream, "server.txt", "server\n", "Server work")
+	diverged := commitFile(t, clone, "other.txt", "other\n", "Diverging work")
+
+	_, err = clone.Push("", "", repository.PushOptions{})
+	if !errors.Is(err, core.ErrNonFastForward) {
+		t.Fatalf("expected ErrNonFastForward, got %v", err)
+	}
+
+	if _, err := clone.Push("", "", repository.PushOptions{Force: true}); err != nil {
+		t.Fatalf("forced push failed: %v", err)
+	}
+	if serverHead, _ := upstream.GetRef("refs/heads/main"); serverHead != diverged {
+		t.Error("forced push should overwrite the server branch")
+	}
+}
+
+func TestClone_RejectsMaliciousTrees(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	commitFile(t, upstream, "README.md", "hello\n", "Initial commit")
+	ts := serveRepo(t, upstream)
+
+	store := upstream.Store()
+	blob, err := store.PutBlob([]byte("pwned\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subtree, err := store.PutTree(&core.Tree{Entries: []core.TreeEntry{{Mode: core.ModeFile, Name: "escaped.txt", Hash: blob}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range []core.TreeEntry{
+		{Mode: core.ModeDir, Name: "..", Hash: subtree},
+		{Mode: core.ModeDir, Name: ".asl", Hash: subtree},
+		{Mode: core.ModeDir, Name: ".ASL", Hash: subtree},
+		{Mode: core.ModeFile, Name: "../escaped.txt", Hash: blob}, // A flat tree's path
+		{Mode: core.ModeFile, Name: "sub/../../escaped.txt", Hash: blob},
+	} {
+		head := mustCommit(t, upstream)
+		evil := commitTree(t, upstream, map[string]core.TreeEntry{entry.Name: entry}, "Evil")
+		if err := upstream.UpdateRef("refs/heads/main", head, evil); err != nil {
+			t.Fatal(err)
+		}
+
+		parent := t.TempDir()
+		dir := filepath.Join(parent, "clone")
+		_, err := repository.Clone(ts.URL, dir)
+		if !errors.Is(err, core.ErrInvalidObject) {
+			t.Errorf("%q: clone err = %v, want ErrInvalidObject", entry.Name, err)
+		}
+		for _, path := range []string{
+			filepath.Join(parent, "escaped.txt"),
+			filepath.Join(dir, ".asl", "escaped.txt"),
+			filepath.Join(dir, ".ASL", "escaped.txt"),
+		} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("%q: %s was written", entry.Name, path)
+			}
+		}
+
+		if err := upstream.UpdateRef("refs/heads/main", evil, head); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveHostile serves a repository, answering the requests intercept
+// handles itself
+func serveHostile(t *testing.T, repo *repository.Repository, intercept func(w http.ResponseWriter, r *http.Request) bool) *httptest.Server {
+	t.Helper()
+	server := protocol.NewServer(repo.Store(), repo, &auth.NoneAuth{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !intercept(w, r) {
+			server.ServeHTTP(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClone_IgnoresInvalidBranchNames(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	head := commitFile(t, upstream, "README.md", "hello\n", "Initial commit")
+
+	// Relative to a clone's refs/heads and refs/remotes/origin, both climb
+	// out of the clone
+	refs := map[string]string{
+		"HEAD":                            head.String(),
+		"refs/heads/../../../../pwned":    head.String(),
+		"refs/heads/../../../../../pwned": head.String(),
+		"refs/tags/../../../../pwned-tag": head.String(),
+		"refs/heads/topic":                head.String(),
+	}
+	ts := serveHostile(t, upstream, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/info/refs" {
+			return false
+		}
+		json.NewEncoder(w).Encode(refs)
+		return true
+	})
+
+	parent := t.TempDir()
+	repo, err := repository.Clone(ts.URL, filepath.Join(parent, "clone"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"pwned", "pwned-tag"} {
+		if _, err := os.Stat(filepath.Join(parent, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was written outside the clone", name)
+		}
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "topic" {
+		t.Errorf("current branch = %q, want the only valid branch", branch)
+	}
+	if _, err := repo.Fetch(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "pwned")); !os.IsNotExist(err) {
+		t.Error("fetch wrote outside the clone")
+	}
+}
+
+func TestClone_RejectsSubstitutedObjects(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+	commitFile(t, upstream, "README.md", "hello\n", "Initial commit")
+	readme, err := upstream.Store().PutBlob([]byte("hello\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := serveHostile(t, upstream, func(w http.ResponseWriter, r *http.Request) bool {
+		if r.URL.Path != "/objects/"+readme.String() {
+			return false
+		}
+		json.NewEncoder(w).Encode(&core.Object{Type: core.ObjectTypeBlob, Data: []byte("substituted\n")})
+		return true
+	})
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	if _, err := repository.Clone(ts.URL, dir); !errors.Is(err, core.ErrInvalidObject) {
+		t.Errorf("clone err = %v, want ErrInvalidObject", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "README.md")); err == nil {
+		t.Errorf("README.md = %q, want no checkout", got)
+	}
+}
@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/remote"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// putCommit stores a commit with the given parents and the tree of HEAD,
+// without moving any ref
+func putCommit(t *testing.T, repo *repository.Repository, message string, parents ...core.Hash) core.Hash {
+	t.Helper()
+	head, err := repo.Store().GetCommit(mustCommit(t, repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := repo.Store().PutCommit(&core.Commit{
+		Tree:      head.Tree,
+		Parents:   parents,
+		Author:    "Test",
+		Email:     "test@example.com",
+		Timestamp: time.Unix(1700000000, 0),
+		Message:   message,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestResolveRevision(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "1\n", "First")
+	second := commitFile(t, repo, "file.txt", "2\n", "Second")
+	side := putCommit(t, repo, "Side", first)
+	merged := putCommit(t, repo, "Merge", second, side)
+	if err := repo.SetRef("refs/heads/main", merged); err != nil {
+		t.Fatal(err)
+	}
+	for ref, hash := range map[string]core.Hash{
+		"refs/tags/v1":              first,
+		"refs/heads/side":           side,
+		"refs/remotes/origin/main":  second,
+		"refs/remotes/upstream/dev": side,
+	} {
+		if err := repo.SetRef(ref, hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := remote.SetUpstream(repo.Root, "main", "origin", "refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec string
+		want core.Hash
+	}{
+		{"HEAD", merged},
+		{"@", merged},
+		{merged.String(), merged},
+		{merged.Short(), merged},
+		{merged.String()[:4], merged},
+		{"main", merged},
+		{"refs/heads/side", side},
+		{"side", side},
+		{"v1", first},
+		{"tags/v1", first},
+		{"origin/main", second},
+		{"upstream/dev", side},
+		{"HEAD~", second},
+		{"HEAD~1", second},
+		{"HEAD~2", first},
+		{"HEAD^", second},
+		{"HEAD^2", side},
+		{"main^2~1", first},
+		{"HEAD^0", merged},
+		{"HEAD^^", first},
+		{"HEAD@{0}", merged},
+		{"main@{upstream}", second},
+		{"@{u}", second},
+		{"@{u}~1", first},
+	}
+	for _, tt := range tests {
+		got, err := repo.ResolveRevision(tt.spec)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ResolveRevision(%q) = %s, want %s", tt.spec, got.Short(), tt.want.Short())
+		}
+	}
+
+	for _, spec := range []string{"missing", "HEAD~3", "HEAD^3", "HEAD~x", "HEAD@{foo}", "abc", "side@{u}"} {
+		if _, err := repo.ResolveRevision(spec); err == nil {
+			t.Errorf("ResolveRevision(%q) should fail", spec)
+		}
+	}
+	if _, err := repo.ResolveRevision("missing"); !errors.Is(err, core.ErrUnknownRevision) {
+		t.Errorf("expected ErrUnknownRevision, got %v", err)
+	}
+	if _, err := repo.ResolveRevision("side@{u}"); !errors.Is(err, core.ErrNoUpstream) {
+		t.Errorf("expected ErrNoUpstream, got %v", err)
+	}
+}
+
+func TestResolveRevision_AmbiguousPrefix(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "1\n", "Base")
+
+	// Store commits until two share a 4-digit prefix
+	seen := make(map[string]core.Hash)
+	var prefix string
+	for i := 0; prefix == ""; i++ {
+		hash := putCommit(t, repo, fmt.Sprintf("Commit %d", i), base)
+		p := hash.String()[:4]
+		if _, ok := seen[p]; ok {
+			prefix = p
+		}
+		seen[p] = hash
+	}
+
+	if _, err := repo.ResolveRevision(prefix); !errors.Is(err, core.ErrAmbiguousRevision) {
+		t.Errorf("ResolveRevision(%q): expected ErrAmbiguousRevision, got %v", prefix, err)
+	}
+	if got, err := repo.ResolveRevision(seen[prefix].Short()); err != nil || got != seen[prefix] {
+		t.Errorf("a longer prefix should be unique, got %s (%v)", got.Short(), err)
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "1\n", "Base")
+	a1 := putCommit(t, repo, "A1", base)
+	a2 := putCommit(t, repo, "A2", a1)
+	b1 := putCommit(t, repo, "B1", base)
+	if err := repo.SetRef("refs/heads/a", a2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetRef("refs/heads/b", b1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		spec string
+		want []core.Hash
+	}{
+		{"b..a", []core.Hash{a2, a1}},
+		{"a..b", []core.Hash{b1}},
+		{"a...b", []core.Hash{a2, a1, b1}},
+		{"a..a", nil},
+		{"..a", []core.Hash{a2, a1}},
+		{"a", []core.Hash{a2, a1, base}},
+	}
+	for _, tt := range tests {
+		rng, err := repo.ResolveRange(tt.spec)
+		if err != nil {
+			t.Errorf("ResolveRange(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		got, err := repo.RangeCommits(rng)
+		if err != nil {
+			t.Errorf("RangeCommits(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if len(got) == 0 && len(tt.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RangeCommits(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
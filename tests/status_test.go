@@ -0,0 +1,154 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/codimo/astral/internal/merge"
+)
+
+func TestStatus_CleanAndNoCommits(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if !status.Head.IsZero() || status.Branch != "main" || !status.Clean() || status.Merge != nil {
+		t.Errorf("unexpected status for an empty repository: %+v", status)
+	}
+
+	writeFiles(t, repo, map[string]string{"a.txt": "a\n"})
+	if _, err := repo.Save(nil, "Initial"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err = repo.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.Head.IsZero() || !status.Clean() {
+		t.Errorf("expected a clean status after saving: %+v", status)
+	}
+}
+
+func TestStatus_Categories(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"keep.txt":     "keep\n",
+		"modify.txt":   "v1\n",
+		"remove.txt":   "gone soon\n",
+		"run.sh":       "#!/bin/sh\n",
+		"src/main.go":  "package main\n",
+		"src/util.go":  "package main\n",
+		"tracked.log":  "tracked\n",
+		"docs/help.md": "help\n",
+	})
+	if _, err := repo.Save(nil, "Initial"); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, ".aslignore", "*.log\ntmp/\n", "Ignore logs")
+
+	writeFiles(t, repo, map[string]string{
+		"modify.txt":     "v2\n",
+		"src/util.go":    "package main // changed\n",
+		"new.txt":        "new\n",
+		"src/new.go":     "package main\n",
+		"debug.log":      "noise\n",
+		"tmp/cache.bin":  "cache\n",
+		"tracked.log":    "still tracked\n",
+		"docs/guide.md":  "guide\n",
+		"docs/trace.log": "noise\n",
+	})
+	if err := os.Remove(filepath.Join(repo.Root, "remove.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(repo.Root, "run.sh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+
+	want := map[string][]string{
+		"Added":       nil,
+		"Modified":    {"modify.txt", "src/util.go", "tracked.log"},
+		"Deleted":     {"remove.txt"},
+		"ModeChanged": {"run.sh"},
+		"Untracked":   {"docs/guide.md", "new.txt", "src/new.go"},
+		"Ignored":     {"debug.log", "docs/trace.log", "tmp/"},
+	}
+	got := map[string][]string{
+		"Added":       status.Added,
+		"Modified":    status.Modified,
+		"Deleted":     status.Deleted,
+		"ModeChanged": status.ModeChanged,
+		"Untracked":   status.Untracked,
+		"Ignored":     status.Ignored,
+	}
+	for name, paths := range want {
+		if !reflect.DeepEqual(got[name], paths) {
+			t.Errorf("%s = %v, want %v", name, got[name], paths)
+		}
+	}
+	if status.Clean() {
+		t.Error("status should not be clean")
+	}
+}
+
+func TestStatus_MergeInProgress(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "base\n", "Base")
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	theirs := commitFile(t, repo, "feature.txt", "feature\n", "Feature")
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+
+	// feature.txt comes from the branch being merged; scratch.txt does not
+	writeFiles(t, repo, map[string]string{
+		"feature.txt": "feature\n",
+		"scratch.txt": "mine\n",
+	})
+	state := &merge.MergeState{
+		Branch:      "feature",
+		BaseCommit:  base.String(),
+		OurCommit:   base.String(),
+		TheirCommit: theirs.String(),
+		Conflicts:   []merge.ConflictInfo{{Path: "file.txt", Type: "content"}},
+	}
+	if err := merge.SaveMergeState(repo.Root, state); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if status.Merge == nil || status.Merge.Branch != "feature" {
+		t.Fatalf("expected merge state, got %+v", status.Merge)
+	}
+	if !reflect.DeepEqual(status.Added, []string{"feature.txt"}) {
+		t.Errorf("Added = %v, want [feature.txt]", status.Added)
+	}
+	if !reflect.DeepEqual(status.Untracked, []string{"scratch.txt"}) {
+		t.Errorf("Untracked = %v, want [scratch.txt]", status.Untracked)
+	}
+}
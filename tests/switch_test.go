@@ -0,0 +1,274 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/merge"
+	"github.com/codimo/astral/internal/repository"
+)
+
+// readFile returns the content of a working directory file, or "" if it
+// does not exist
+func readFile(t *testing.T, repo *repository.Repository, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(repo.Root, filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+const (
+	sharedBase    = "1\n2\n3\n4\n5\n6\n"
+	sharedFeature = "1\n2\n3\n4\n5\nSIX\n"
+)
+
+// setupSwitchBranches creates main and feature branches that differ in
+// shared.txt, main-only.txt and feature/new.txt, and leaves main checked out
+func setupSwitchBranches(t *testing.T) *repository.Repository {
+	t.Helper()
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	t.Cleanup(func() { os.RemoveAll(repo.Root) })
+
+	writeFiles(t, repo, map[string]string{
+		"shared.txt":     sharedBase,
+		"same.txt":       "same\n",
+		"dir/remove.txt": "only on main\n",
+		"main-only.txt":  "main\n",
+	})
+	if _, err := repo.Save(nil, "Base"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, repo, map[string]string{
+		"shared.txt":      sharedFeature,
+		"feature/new.txt": "new\n",
+	})
+	for _, name := range []string{"main-only.txt", "dir/remove.txt"} {
+		if err := os.Remove(filepath.Join(repo.Root, filepath.FromSlash(name))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.Save(nil, "Feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatalf("switching back to main: %v", err)
+	}
+	return repo
+}
+
+func TestSwitch_UpdatesWorkingDirectory(t *testing.T) {
+	repo := setupSwitchBranches(t)
+
+	// Back on main, the main files are restored
+	if got := readFile(t, repo, "main-only.txt"); got != "main\n" {
+		t.Errorf("main-only.txt = %q after switching to main", got)
+	}
+	if got := readFile(t, repo, "feature/new.txt"); got != "" {
+		t.Errorf("feature/new.txt should be gone on main, got %q", got)
+	}
+
+	// Uncommitted changes to files that are the same on both branches and
+	// untracked files are carried along untouched
+	writeFiles(t, repo, map[string]string{
+		"same.txt":      "edited\n",
+		"untracked.txt": "mine\n",
+	})
+
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatalf("SwitchBranch failed: %v", err)
+	}
+
+	want := map[string]string{
+		"shared.txt":      sharedFeature,
+		"feature/new.txt": "new\n",
+		"main-only.txt":   "",
+		"dir/remove.txt":  "",
+		"same.txt":        "edited\n",
+		"untracked.txt":   "mine\n",
+	}
+	for name, content := range want {
+		if got := readFile(t, repo, name); got != content {
+			t.Errorf("%s = %q, want %q", name, got, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo.Root, "dir")); !os.IsNotExist(err) {
+		t.Error("empty directory dir/ should be removed")
+	}
+
+	branch, err := repo.GetCurrentBranch()
+	if err != nil || branch != "feature" {
+		t.Errorf("current branch = %q (%v), want feature", branch, err)
+	}
+}
+
+func TestSwitch_RefusesToOverwriteChanges(t *testing.T) {
+	repo := setupSwitchBranches(t)
+
+	writeFiles(t, repo, map[string]string{
+		"main-only.txt":   "edited\n",
+		"feature/new.txt": "untracked in the way\n",
+	})
+
+	err := repo.SwitchBranch("feature")
+	if !errors.Is(err, core.ErrDirtyWorkingDir) {
+		t.Fatalf("expected ErrDirtyWorkingDir, got %v", err)
+	}
+
+	// Nothing was touched
+	if got := readFile(t, repo, "shared.txt"); got != sharedBase {
+		t.Errorf("shared.txt changed to %q", got)
+	}
+	if got := readFile(t, repo, "main-only.txt"); got != "edited\n" {
+		t.Errorf("main-only.txt changed to %q", got)
+	}
+	if branch, _ := repo.GetCurrentBranch(); branch != "main" {
+		t.Errorf("current branch = %q, want main", branch)
+	}
+}
+
+func TestSwitch_Force(t *testing.T) {
+	repo := setupSwitchBranches(t)
+
+	writeFiles(t, repo, map[string]string{
+		"shared.txt":      "local edit\n",
+		"feature/new.txt": "untracked in the way\n",
+	})
+
+	if err := repo.Switch("feature", repository.SwitchOptions{Force: true}); err != nil {
+		t.Fatalf("forced switch failed: %v", err)
+	}
+	if got := readFile(t, repo, "shared.txt"); got != sharedFeature {
+		t.Errorf("shared.txt = %q, want the feature version", got)
+	}
+	if got := readFile(t, repo, "feature/new.txt"); got != "new\n" {
+		t.Errorf("feature/new.txt = %q, want the feature version", got)
+	}
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Clean() {
+		t.Errorf("expected a clean working directory, got %+v", status)
+	}
+}
+
+func TestSwitch_Carry(t *testing.T) {
+	repo := setupSwitchBranches(t)
+
+	// Edits that do not overlap the branch's changes are merged in
+	writeFiles(t, repo, map[string]string{"shared.txt": "ONE\n2\n3\n4\n5\n6\n"})
+	if err := repo.Switch("feature", repository.SwitchOptions{Carry: true}); err != nil {
+		t.Fatalf("carry switch failed: %v", err)
+	}
+	if got := readFile(t, repo, "shared.txt"); got != "ONE\n2\n3\n4\n5\nSIX\n" {
+		t.Errorf("shared.txt = %q, want both changes", got)
+	}
+
+	status, err := repo.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Modified) != 1 || status.Modified[0] != "shared.txt" {
+		t.Errorf("carried change should show as modified, got %v", status.Modified)
+	}
+
+	// Overlapping edits are refused
+	writeFiles(t, repo, map[string]string{"shared.txt": "ONE\n2\n3\n4\n5\nsixth\n"})
+	err = repo.Switch("main", repository.SwitchOptions{Carry: true})
+	if !errors.Is(err, core.ErrDirtyWorkingDir) {
+		t.Fatalf("expected ErrDirtyWorkingDir, got %v", err)
+	}
+	if got := readFile(t, repo, "shared.txt"); got != "ONE\n2\n3\n4\n5\nsixth\n" {
+		t.Errorf("shared.txt changed to %q", got)
+	}
+}
+
+func TestSwitch_UnknownBranchAndMerge(t *testing.T) {
+	repo := setupSwitchBranches(t)
+
+	if err := repo.SwitchBranch("missing"); !errors.Is(err, core.ErrBranchNotFound) {
+		t.Errorf("expected ErrBranchNotFound, got %v", err)
+	}
+	if err := repo.Switch("feature", repository.SwitchOptions{Force: true, Carry: true}); err == nil {
+		t.Error("force and carry together should fail")
+	}
+
+	if err := merge.SaveMergeState(repo.Root, &merge.MergeState{Branch: "feature"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("feature"); !errors.Is(err, core.ErrMergeInProgress) {
+		t.Errorf("expected ErrMergeInProgress, got %v", err)
+	}
+}
+
+func TestSwitch_FileReplacesDirectory(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{"a/b": "b\n", "a/c/d": "d\n"})
+	if _, err := repo.Save(nil, "Directory"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateBranch("file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SwitchBranch("file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(repo.Root, "a")); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{"a": "file\n"})
+	if _, err := repo.Save(nil, "File"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directory to file and back, twice
+	for i := 0; i < 2; i++ {
+		if err := repo.SwitchBranch("main"); err != nil {
+			t.Fatalf("file to directory: %v", err)
+		}
+		if got := readFile(t, repo, "a/c/d"); got != "d\n" {
+			t.Errorf("a/c/d = %q after switching to main", got)
+		}
+		if err := repo.SwitchBranch("file"); err != nil {
+			t.Fatalf("directory to file: %v", err)
+		}
+		if got := readFile(t, repo, "a"); got != "file\n" {
+			t.Errorf("a = %q after switching to file", got)
+		}
+	}
+
+	// An untracked file keeps the directory in the way, even when forced
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{"a/untracked": "keep\n"})
+	for _, opts := range []repository.SwitchOptions{{}, {Force: true}} {
+		if err := repo.Switch("file", opts); !errors.Is(err, core.ErrDirtyWorkingDir) {
+			t.Errorf("switch %+v with an untracked file in a/: err = %v, want ErrDirtyWorkingDir", opts, err)
+		}
+	}
+	if got := readFile(t, repo, "a/untracked"); got != "keep\n" {
+		t.Errorf("a/untracked = %q, want it kept", got)
+	}
+}
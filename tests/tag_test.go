@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+	"github.com/codimo/astral/internal/storage"
+)
+
+func TestTag_LightweightAndAnnotated(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	first := commitFile(t, repo, "file.txt", "1\n", "First")
+	second := commitFile(t, repo, "file.txt", "2\n", "Second")
+
+	light, err := repo.CreateTag("v1", first, repository.TagOptions{})
+	if err != nil {
+		t.Fatalf("creating a lightweight tag failed: %v", err)
+	}
+	if light != first {
+		t.Errorf("lightweight tag holds %s, want the commit %s", light.Short(), first.Short())
+	}
+
+	annotated, err := repo.CreateTag("release/v2", second, repository.TagOptions{Message: "Version 2\n\nWith notes"})
+	if err != nil {
+		t.Fatalf("creating an annotated tag failed: %v", err)
+	}
+	obj, err := repo.Store().Get(annotated)
+	if err != nil || obj.Type != core.ObjectTypeTag {
+		t.Fatalf("annotated tag should hold a tag object, got %v (%v)", obj, err)
+	}
+
+	info, err := repo.GetTag("release/v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Tag == nil || info.Tag.Name != "release/v2" || info.Tag.Target != second || info.Tag.TargetType != core.ObjectTypeCommit {
+		t.Errorf("annotation = %+v", info.Tag)
+	}
+	if info.Tag != nil && info.Tag.Message != "Version 2\n\nWith notes" {
+		t.Errorf("message = %q", info.Tag.Message)
+	}
+	if info.Commit != second {
+		t.Errorf("tag commit = %s, want %s", info.Commit.Short(), second.Short())
+	}
+
+	tags, err := repo.ListTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0] != "release/v2" || tags[1] != "v1" {
+		t.Errorf("tags = %q", tags)
+	}
+
+	// Both kinds resolve to their commit
+	for spec, want := range map[string]core.Hash{
+		"v1":                    first,
+		"release/v2":            second,
+		"tags/release/v2":       second,
+		"release/v2~1":          first,
+		annotated.String():      second,
+		annotated.String()[:12]: second,
+	} {
+		got, err := repo.ResolveRevision(spec)
+		if err != nil {
+			t.Errorf("ResolveRevision(%q) failed: %v", spec, err)
+		} else if got != want {
+			t.Errorf("ResolveRevision(%q) = %s, want %s", spec, got.Short(), want.Short())
+		}
+	}
+
+	if _, err := repo.CreateTag("v1", second, repository.TagOptions{}); !errors.Is(err, core.ErrTagExists) {
+		t.Errorf("expected ErrTagExists, got %v", err)
+	}
+	if _, err := repo.CreateTag("v1", second, repository.TagOptions{Force: true}); err != nil {
+		t.Fatalf("replacing a tag with force failed: %v", err)
+	}
+	if got, _ := repo.ResolveRevision("v1"); got != second {
+		t.Errorf("v1 = %s after replacing it, want %s", got.Short(), second.Short())
+	}
+	for _, name := range []string{"bad..name", "v1.lock", "release", "v1/x"} {
+		if _, err := repo.CreateTag(name, first, repository.TagOptions{}); !errors.Is(err, core.ErrInvalidTagName) && !errors.Is(err, core.ErrTagExists) {
+			t.Errorf("CreateTag(%q): expected ErrInvalidTagName or ErrTagExists, got %v", name, err)
+		}
+	}
+
+	if hash, err := repo.DeleteTag("v1"); err != nil || hash != second {
+		t.Errorf("DeleteTag = %s, %v", hash.Short(), err)
+	}
+	if _, err := repo.DeleteTag("v1"); !errors.Is(err, core.ErrTagNotFound) {
+		t.Errorf("expected ErrTagNotFound, got %v", err)
+	}
+	if _, err := repo.ResolveRevision("v1"); !errors.Is(err, core.ErrUnknownRevision) {
+		t.Errorf("a deleted tag should not resolve, got %v", err)
+	}
+
+	report, err := repo.Fsck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("fsck found problems with an annotated tag: %+v", report)
+	}
+}
+
+func TestTag_KeepsCommitsAlive(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	base := commitFile(t, repo, "file.txt", "base\n", "Base")
+	orphan := putCommit(t, repo, "Only tagged", base)
+	tag, err := repo.CreateTag("keep", orphan, repository.TagOptions{Message: "Keep this"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.GC(storage.PruneOptions{}); err != nil {
+		t.Fatalf("GC failed: %v", err)
+	}
+	for _, hash := range []core.Hash{tag, orphan} {
+		if !repo.Store().Exists(hash) {
+			t.Errorf("%s was pruned although a tag refers to it", hash.Short())
+		}
+	}
+
+	lost, err := repo.Unreachable(orphan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lost) != 0 {
+		t.Errorf("a tagged commit should not be unreachable, got %d commits", len(lost))
+	}
+}
+
+func TestTag_FetchAndPush(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	upstream := createTestRepo(t)
+	defer os.RemoveAll(upstream.Root)
+
+	first := commitFile(t, upstream, "file.txt", "1\n", "First")
+	released, err := upstream.CreateTag("v1", first, repository.TagOptions{Message: "Version 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := serveRepo(t, upstream)
+
+	clone, err := repository.Clone(ts.URL, filepath.Join(t.TempDir(), "clone"))
+	if err != nil {
+		t.Fatalf("clone failed: %v", err)
+	}
+	if got, err := clone.GetRef("refs/tags/v1"); err != nil || got != released {
+		t.Fatalf("cloned v1 = %s (%v), want the tag object %s", got.Short(), err, released.Short())
+	}
+	if info, err := clone.GetTag("v1"); err != nil || info.Tag == nil || info.Tag.Message != "Version 1" {
+		t.Errorf("cloned tag = %+v (%v)", info, err)
+	}
+
+	// Fetch adds new remote tags but leaves existing local ones alone
+	second := commitFile(t, upstream, "file.txt", "2\n", "Second")
+	if _, err := upstream.CreateTag("v2", second, repository.TagOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upstream.CreateTag("v1", second, repository.TagOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	updates, err := clone.Fetch("")
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	fetched := make(map[string]core.Hash)
+	for _, u := range updates {
+		fetched[u.Name] = u.New
+	}
+	if fetched["refs/tags/v2"] != second {
+		t.Errorf("fetch updates = %+v, want the new tag v2", updates)
+	}
+	if got, _ := clone.GetRef("refs/tags/v1"); got != released {
+		t.Errorf("fetch moved the local tag v1 to %s", got.Short())
+	}
+
+	// Push uploads local tags, replacing different remote ones only with force
+	if _, err := clone.CreateTag("v3", first, repository.TagOptions{Message: "Version 3"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.Push("", "", repository.PushOptions{Tags: true}); !errors.Is(err, core.ErrTagExists) {
+		t.Fatalf("pushing over a different remote tag: expected ErrTagExists, got %v", err)
+	}
+	if _, err := clone.Push("", "", repository.PushOptions{Tags: true, Force: true}); err != nil {
+		t.Fatalf("push --tags --force failed: %v", err)
+	}
+	for _, name := range []string{"v1", "v2", "v3"} {
+		local, _ := clone.GetRef("refs/tags/" + name)
+		if got, err := upstream.GetRef("refs/tags/" + name); err != nil || got != local {
+			t.Errorf("remote %s = %s (%v), want %s", name, got.Short(), err, local.Short())
+		}
+	}
+	if info, err := upstream.GetTag("v3"); err != nil || info.Commit != first {
+		t.Errorf("pushed tag v3 = %+v (%v), want it to point at %s", info, err, first.Short())
+	}
+}
@@ -0,0 +1,275 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codimo/astral/internal/core"
+	"github.com/codimo/astral/internal/repository"
+	"github.com/codimo/astral/internal/transfer"
+)
+
+// writeFiles writes files relative to the repository root
+func writeFiles(t *testing.T, repo *repository.Repository, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(repo.Root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// rootEntries returns the root tree entries of a commit keyed by name
+func rootEntries(t *testing.T, repo *repository.Repository, commit core.Hash) map[string]core.TreeEntry {
+	t.Helper()
+	c, err := repo.Store().GetCommit(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.Store().GetTree(c.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]core.TreeEntry)
+	for _, e := range tree.Entries {
+		entries[e.Name] = e
+	}
+	return entries
+}
+
+func TestSave_WritesNestedSortedTrees(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"README.md":       "readme\n",
+		"src/pkg/a.go":    "package pkg\n",
+		"src/main.go":     "package main\n",
+		"docs/guide.md":   "guide\n",
+		"docs/api/ref.md": "ref\n",
+	})
+	head, err := repo.Save(nil, "Initial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := repo.Store().GetCommit(head)
+	root, err := repo.Store().GetTree(c.Tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, e := range root.Entries {
+		names = append(names, e.Name)
+		if strings.Contains(e.Name, "/") {
+			t.Errorf("root entry %q should be a single path component", e.Name)
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("tree entries should be sorted, got %v", names)
+	}
+
+	src := rootEntries(t, repo, head)["src"]
+	if !src.IsDir() || src.Mode != core.ModeDir {
+		t.Fatalf("src should be a directory entry, got mode %o", src.Mode)
+	}
+
+	content, err := repo.GetFileContent(head, "src/pkg/a.go")
+	if err != nil || string(content) != "package pkg\n" {
+		t.Errorf("GetFileContent(src/pkg/a.go) = %q, %v", content, err)
+	}
+	if _, err := repo.GetFileContent(head, "src/pkg"); err != core.ErrFileNotFound {
+		t.Errorf("a directory is not a file, got %v", err)
+	}
+}
+
+func TestSave_UnchangedSubtreesKeepTheirHash(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"src/a.go":  "a\n",
+		"docs/x.md": "x\n",
+	})
+	first, err := repo.Save(nil, "First")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, repo, map[string]string{"src/a.go": "a2\n"})
+	second, err := repo.Save(nil, "Second")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, after := rootEntries(t, repo, first), rootEntries(t, repo, second)
+	if before["docs"].Hash != after["docs"].Hash {
+		t.Error("unchanged docs/ subtree should keep its hash")
+	}
+	if before["src"].Hash == after["src"].Hash {
+		t.Error("changed src/ subtree should get a new hash")
+	}
+
+	diff, err := repo.Diff(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff["src/a.go"] != "modified" {
+		t.Errorf("expected only src/a.go modified, got %v", diff)
+	}
+
+	// Only the new commit, root tree, src/ tree and blob need to be pushed
+	objects, err := transfer.CalculatePushPack(repo.Store(), []core.Hash{second}, []core.Hash{first})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 4 {
+		t.Errorf("expected 4 objects to push, got %d", len(objects))
+	}
+}
+
+func TestMerge_NestedDirectories(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	writeFiles(t, repo, map[string]string{
+		"src/a.go":  "a\n",
+		"docs/x.md": "x\n",
+	})
+	if _, err := repo.Save(nil, "Base"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CreateBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, repo, map[string]string{"src/b.go": "b\n"})
+	if _, err := repo.Save(nil, "Main adds src/b.go"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SwitchBranch("feature"); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, repo, map[string]string{"docs/y.md": "y\n", "src/a.go": "a feature\n"})
+	if _, err := repo.Save([]string{"src/a.go", "docs/x.md", "docs/y.md"}, "Feature work"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.SwitchBranch("main"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := repo.Merge("feature", repository.MergeOptions{})
+	if err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if result.Conflicts || result.MergeCommit == nil {
+		t.Fatalf("expected a clean merge, got %+v", result)
+	}
+
+	for path, want := range map[string]string{
+		"src/a.go":  "a feature\n",
+		"src/b.go":  "b\n",
+		"docs/x.md": "x\n",
+		"docs/y.md": "y\n",
+	} {
+		got, err := repo.GetFileContent(*result.MergeCommit, path)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q (%v), want %q", path, got, err, want)
+		}
+	}
+}
+
+func TestLegacyFlatTrees_RemainReadableAndAreUpgraded(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	// Build a commit the way format version 1 did: one flat tree
+	store := repo.Store()
+	blobA, _ := store.PutBlob([]byte("a\n"))
+	blobB, _ := store.PutBlob([]byte("b\n"))
+	flat, err := store.PutTree(&core.Tree{Entries: []core.TreeEntry{
+		{Mode: core.ModeFile, Name: "src/pkg/a.go", Hash: blobA},
+		{Mode: core.ModeFile, Name: "README.md", Hash: blobB},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := store.PutCommit(&core.Commit{
+		Tree: flat, Author: "Old", Email: "old@example.com",
+		Timestamp: time.Unix(1600000000, 0), Message: "Legacy commit",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetRef("refs/heads/main", legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath := filepath.Join(repo.Root, ".asl", "config", "config")
+	if err := os.WriteFile(configPath, []byte("[core]\n\trepositoryformatversion = 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err = repository.Open(repo.Root)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	config, _ := os.ReadFile(configPath)
+	if !strings.Contains(string(config), "repositoryformatversion = 2") {
+		t.Errorf("expected format version to be upgraded, got %q", config)
+	}
+
+	if content, err := repo.GetFileContent(legacy, "src/pkg/a.go"); err != nil || string(content) != "a\n" {
+		t.Errorf("legacy GetFileContent = %q, %v", content, err)
+	}
+
+	if err := repo.Checkout(legacy); err != nil {
+		t.Fatalf("checkout of legacy commit failed: %v", err)
+	}
+	writeFiles(t, repo, map[string]string{"src/pkg/a.go": "a2\n"})
+	next, err := repo.Save(nil, "First nested commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if src := rootEntries(t, repo, next)["src"]; !src.IsDir() {
+		t.Error("new commits should use nested trees")
+	}
+
+	diff, err := repo.Diff(legacy, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 1 || diff["src/pkg/a.go"] != "modified" {
+		t.Errorf("expected only src/pkg/a.go modified across formats, got %v", diff)
+	}
+
+	report, err := repo.Fsck()
+	if err != nil || !report.OK() {
+		t.Errorf("fsck should accept both tree formats: %+v (%v)", report, err)
+	}
+}
+
+func TestOpen_RejectsNewerFormat(t *testing.T) {
+	repo := createTestRepo(t)
+	defer os.RemoveAll(repo.Root)
+
+	configPath := filepath.Join(repo.Root, ".asl", "config", "config")
+	if err := os.WriteFile(configPath, []byte("[core]\n\trepositoryformatversion = 99\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.Open(repo.Root); err == nil {
+		t.Error("expected an error for an unsupported repository format")
+	}
+}